@@ -1,11 +1,18 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/toolkits/pkg/runner"
 	"github.com/urfave/cli/v2"
+	"github.com/whoisfisher/mykubespray/pkg/db"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
 	"github.com/whoisfisher/mykubespray/pkg/server"
+	"github.com/whoisfisher/mykubespray/pkg/service"
+	"github.com/whoisfisher/mykubespray/pkg/utils"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 var VERSION = "not specified"
@@ -42,6 +49,107 @@ func NewServerCmd() *cli.Command {
 	}
 }
 
+// initStorage reads the configuration and opens the database for commands that work on the inventory.
+func initStorage(conf string) error {
+	s := server.Server{ConfigFile: filepath.Join("pkg", "conf", "config.yaml")}
+	if conf != "" {
+		s.ConfigFile = conf
+	}
+	if _, err := server.NewPhaseRunner(&s, "logger", "migrate", "db").Run(); err != nil {
+		return err
+	}
+	if db.DB == nil {
+		return db.ErrNotConfigured
+	}
+	return nil
+}
+
+func NewInventoryCmd() *cli.Command {
+	confFlag := &cli.StringFlag{
+		Name:    "conf",
+		Aliases: []string{"c"},
+		Usage:   "Specify configuration file",
+	}
+	return &cli.Command{
+		Name:  "inventory",
+		Usage: "Import and export Ansible/kubespray inventories",
+		Subcommands: []*cli.Command{
+			{
+				Name:  "import",
+				Usage: "Import the hosts and groups of an Ansible YAML or INI inventory",
+				Flags: []cli.Flag{
+					confFlag,
+					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "Inventory file", Required: true},
+					&cli.StringFlag{Name: "format", Usage: "yaml or ini, detected when omitted"},
+				},
+				Action: func(context *cli.Context) error {
+					data, err := os.ReadFile(context.String("file"))
+					if err != nil {
+						return err
+					}
+					inventory, err := utils.ParseAnsibleInventory(data, context.String("format"))
+					if err != nil {
+						return err
+					}
+					if err := initStorage(context.String("conf")); err != nil {
+						return err
+					}
+					defer db.Close()
+					hosts, err := service.NewInventoryService().ImportAnsibleInventory(*inventory)
+					for _, host := range hosts {
+						fmt.Printf("%s\t%s\t%s\n", host.Name, host.Address, strings.Join(host.Groups, ","))
+					}
+					return err
+				},
+			},
+			{
+				Name:  "export",
+				Usage: "Export a KubekeyConf JSON file as kubespray hosts.yaml and offline.yml",
+				Flags: []cli.Flag{
+					confFlag,
+					&cli.StringFlag{Name: "file", Aliases: []string{"f"}, Usage: "KubekeyConf JSON file", Required: true},
+					&cli.StringFlag{Name: "out", Aliases: []string{"o"}, Usage: "Output directory", Value: "."},
+					&cli.BoolFlag{Name: "include-secrets", Usage: "Write passwords into the inventory"},
+				},
+				Action: func(context *cli.Context) error {
+					data, err := os.ReadFile(context.String("file"))
+					if err != nil {
+						return err
+					}
+					var conf entity.KubesprayExportConf
+					if err := json.Unmarshal(data, &conf); err != nil {
+						return err
+					}
+					conf.IncludeSecrets = context.Bool("include-secrets")
+					if !conf.HostSelection.Empty() {
+						if err := initStorage(context.String("conf")); err != nil {
+							return err
+						}
+						defer db.Close()
+						hosts, err := service.NewInventoryService().ResolveHosts(conf.HostSelection)
+						if err != nil {
+							return err
+						}
+						conf.Hosts = append(conf.Hosts, hosts...)
+					}
+					inventory, err := service.NewKubekeyService().ExportKubesprayInventory(conf)
+					if err != nil {
+						return err
+					}
+					out := context.String("out")
+					if err := os.MkdirAll(out, 0755); err != nil {
+						return err
+					}
+					if err := os.WriteFile(filepath.Join(out, "hosts.yaml"), []byte(inventory.HostsYAML), 0600); err != nil {
+						return err
+					}
+					return os.WriteFile(filepath.Join(out, "offline.yml"), []byte(inventory.OfflineYAML), 0600)
+				},
+			},
+		},
+	}
+}
+
 func main() {
 	app := cli.NewApp()
 	app.Name = "cluster-utils"
@@ -49,9 +157,11 @@ func main() {
 	app.Usage = "cluster-utils"
 	app.Commands = []*cli.Command{
 		NewServerCmd(),
+		NewInventoryCmd(),
 	}
 	err := app.Run(os.Args)
 	if err != nil {
-		return
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 }
@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS `rdev_inventory_host` (
+  `id` int unsigned NOT NULL AUTO_INCREMENT,
+  `name` varchar(255) NOT NULL,
+  `address` varchar(255) NOT NULL,
+  `internal_address` varchar(255) NOT NULL DEFAULT '',
+  `port` int NOT NULL DEFAULT 22,
+  `user` varchar(64) NOT NULL DEFAULT '',
+  `password` text,
+  `private_key` text,
+  `passphrase` text,
+  `certificate` text,
+  `become_method` varchar(16) NOT NULL DEFAULT '',
+  `become_user` varchar(64) NOT NULL DEFAULT '',
+  `become_password` text,
+  `arch` varchar(32) NOT NULL DEFAULT '',
+  `created_at` datetime DEFAULT NULL,
+  `updated_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uix_inventory_host_name` (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `rdev_inventory_host_label` (
+  `id` int unsigned NOT NULL AUTO_INCREMENT,
+  `host_id` int unsigned NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `value` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uix_inventory_host_label` (`host_id`, `name`),
+  KEY `idx_inventory_host_label_name` (`name`, `value`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `rdev_inventory_host_group` (
+  `id` int unsigned NOT NULL AUTO_INCREMENT,
+  `host_id` int unsigned NOT NULL,
+  `name` varchar(255) NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uix_inventory_host_group` (`host_id`, `name`),
+  KEY `idx_inventory_host_group_name` (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
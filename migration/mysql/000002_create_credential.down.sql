@@ -0,0 +1,5 @@
+ALTER TABLE `rdev_inventory_host`
+  DROP COLUMN `credential_id`,
+  DROP COLUMN `become_credential_id`;
+
+DROP TABLE IF EXISTS `rdev_credential`;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS `rdev_credential` (
+  `id` int unsigned NOT NULL AUTO_INCREMENT,
+  `name` varchar(255) NOT NULL,
+  `kind` varchar(64) NOT NULL,
+  `username` varchar(255) NOT NULL DEFAULT '',
+  `description` varchar(1024) NOT NULL DEFAULT '',
+  `ciphertext` text,
+  `key_version` int NOT NULL DEFAULT 0,
+  `created_at` datetime DEFAULT NULL,
+  `updated_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uix_credential_name` (`name`),
+  KEY `idx_credential_key_version` (`key_version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+ALTER TABLE `rdev_inventory_host`
+  ADD COLUMN `credential_id` int unsigned NOT NULL DEFAULT 0,
+  ADD COLUMN `become_credential_id` int unsigned NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS `rdev_cluster_node`;
+DROP TABLE IF EXISTS `rdev_cluster`;
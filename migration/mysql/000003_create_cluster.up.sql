@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS `rdev_cluster` (
+  `id` int unsigned NOT NULL AUTO_INCREMENT,
+  `name` varchar(255) NOT NULL,
+  `kubernetes_version` varchar(64) NOT NULL DEFAULT '',
+  `vip_server` varchar(255) NOT NULL DEFAULT '',
+  `status` varchar(32) NOT NULL DEFAULT '',
+  `message` text,
+  `config_data` mediumtext,
+  `kubeconfig_data` mediumtext,
+  `key_version` int NOT NULL DEFAULT 0,
+  `created_at` datetime DEFAULT NULL,
+  `updated_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uix_cluster_name` (`name`),
+  KEY `idx_cluster_key_version` (`key_version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS `rdev_cluster_node` (
+  `id` int unsigned NOT NULL AUTO_INCREMENT,
+  `cluster_id` int unsigned NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `address` varchar(255) NOT NULL DEFAULT '',
+  `internal_address` varchar(255) NOT NULL DEFAULT '',
+  `control_plane` tinyint(1) NOT NULL DEFAULT 0,
+  `etcd` tinyint(1) NOT NULL DEFAULT 0,
+  `worker` tinyint(1) NOT NULL DEFAULT 0,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `uix_cluster_node_name` (`cluster_id`, `name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS `rdev_operation_log`;
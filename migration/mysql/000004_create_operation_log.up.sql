@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS `rdev_operation_log` (
+  `id` int unsigned NOT NULL AUTO_INCREMENT,
+  `user` varchar(255) NOT NULL DEFAULT '',
+  `client_ip` varchar(64) NOT NULL DEFAULT '',
+  `method` varchar(16) NOT NULL DEFAULT '',
+  `route` varchar(255) NOT NULL DEFAULT '',
+  `path` text,
+  `module` varchar(64) NOT NULL DEFAULT '',
+  `description` varchar(255) NOT NULL DEFAULT '',
+  `body` mediumtext,
+  `status` int NOT NULL DEFAULT 0,
+  `duration_ms` bigint NOT NULL DEFAULT 0,
+  `created_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`),
+  KEY `idx_operation_log_created_at` (`created_at`),
+  KEY `idx_operation_log_user` (`user`),
+  KEY `idx_operation_log_module` (`module`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
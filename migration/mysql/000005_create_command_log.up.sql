@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS `rdev_command_log` (
+  `id` int unsigned NOT NULL AUTO_INCREMENT,
+  `record_id` varchar(64) NOT NULL DEFAULT '',
+  `kind` varchar(16) NOT NULL DEFAULT '',
+  `host` varchar(255) NOT NULL DEFAULT '',
+  `user` varchar(255) NOT NULL DEFAULT '',
+  `command` text,
+  `exit_code` int NOT NULL DEFAULT 0,
+  `error` text,
+  `start_time` datetime DEFAULT NULL,
+  `end_time` datetime DEFAULT NULL,
+  `duration_ms` bigint NOT NULL DEFAULT 0,
+  `output_size` bigint NOT NULL DEFAULT 0,
+  `output_digest` varchar(64) NOT NULL DEFAULT '',
+  `cast_file` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `idx_command_log_record_id` (`record_id`),
+  KEY `idx_command_log_start_time` (`start_time`),
+  KEY `idx_command_log_host` (`host`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
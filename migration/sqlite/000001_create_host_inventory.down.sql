@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS `rdev_inventory_host_group`;
+DROP TABLE IF EXISTS `rdev_inventory_host_label`;
+DROP TABLE IF EXISTS `rdev_inventory_host`;
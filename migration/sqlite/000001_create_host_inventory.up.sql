@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS `rdev_inventory_host` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `name` varchar(255) NOT NULL,
+  `address` varchar(255) NOT NULL,
+  `internal_address` varchar(255) NOT NULL DEFAULT '',
+  `port` int NOT NULL DEFAULT 22,
+  `user` varchar(64) NOT NULL DEFAULT '',
+  `password` text,
+  `private_key` text,
+  `passphrase` text,
+  `certificate` text,
+  `become_method` varchar(16) NOT NULL DEFAULT '',
+  `become_user` varchar(64) NOT NULL DEFAULT '',
+  `become_password` text,
+  `arch` varchar(32) NOT NULL DEFAULT '',
+  `created_at` datetime DEFAULT NULL,
+  `updated_at` datetime DEFAULT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `uix_inventory_host_name` ON `rdev_inventory_host` (`name`);
+
+CREATE TABLE IF NOT EXISTS `rdev_inventory_host_label` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `host_id` integer NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `value` varchar(255) NOT NULL DEFAULT ''
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `uix_inventory_host_label` ON `rdev_inventory_host_label` (`host_id`, `name`);
+CREATE INDEX IF NOT EXISTS `idx_inventory_host_label_name` ON `rdev_inventory_host_label` (`name`, `value`);
+
+CREATE TABLE IF NOT EXISTS `rdev_inventory_host_group` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `host_id` integer NOT NULL,
+  `name` varchar(255) NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `uix_inventory_host_group` ON `rdev_inventory_host_group` (`host_id`, `name`);
+CREATE INDEX IF NOT EXISTS `idx_inventory_host_group_name` ON `rdev_inventory_host_group` (`name`);
@@ -0,0 +1,4 @@
+ALTER TABLE `rdev_inventory_host` DROP COLUMN `credential_id`;
+ALTER TABLE `rdev_inventory_host` DROP COLUMN `become_credential_id`;
+
+DROP TABLE IF EXISTS `rdev_credential`;
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS `rdev_credential` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `name` varchar(255) NOT NULL,
+  `kind` varchar(64) NOT NULL,
+  `username` varchar(255) NOT NULL DEFAULT '',
+  `description` varchar(1024) NOT NULL DEFAULT '',
+  `ciphertext` text,
+  `key_version` int NOT NULL DEFAULT 0,
+  `created_at` datetime DEFAULT NULL,
+  `updated_at` datetime DEFAULT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `uix_credential_name` ON `rdev_credential` (`name`);
+CREATE INDEX IF NOT EXISTS `idx_credential_key_version` ON `rdev_credential` (`key_version`);
+
+ALTER TABLE `rdev_inventory_host` ADD COLUMN `credential_id` integer NOT NULL DEFAULT 0;
+ALTER TABLE `rdev_inventory_host` ADD COLUMN `become_credential_id` integer NOT NULL DEFAULT 0;
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS `rdev_cluster` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `name` varchar(255) NOT NULL,
+  `kubernetes_version` varchar(64) NOT NULL DEFAULT '',
+  `vip_server` varchar(255) NOT NULL DEFAULT '',
+  `status` varchar(32) NOT NULL DEFAULT '',
+  `message` text,
+  `config_data` text,
+  `kubeconfig_data` text,
+  `key_version` int NOT NULL DEFAULT 0,
+  `created_at` datetime DEFAULT NULL,
+  `updated_at` datetime DEFAULT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `uix_cluster_name` ON `rdev_cluster` (`name`);
+CREATE INDEX IF NOT EXISTS `idx_cluster_key_version` ON `rdev_cluster` (`key_version`);
+
+CREATE TABLE IF NOT EXISTS `rdev_cluster_node` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `cluster_id` integer NOT NULL,
+  `name` varchar(255) NOT NULL,
+  `address` varchar(255) NOT NULL DEFAULT '',
+  `internal_address` varchar(255) NOT NULL DEFAULT '',
+  `control_plane` boolean NOT NULL DEFAULT 0,
+  `etcd` boolean NOT NULL DEFAULT 0,
+  `worker` boolean NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `uix_cluster_node_name` ON `rdev_cluster_node` (`cluster_id`, `name`);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS `rdev_operation_log` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `user` varchar(255) NOT NULL DEFAULT '',
+  `client_ip` varchar(64) NOT NULL DEFAULT '',
+  `method` varchar(16) NOT NULL DEFAULT '',
+  `route` varchar(255) NOT NULL DEFAULT '',
+  `path` text,
+  `module` varchar(64) NOT NULL DEFAULT '',
+  `description` varchar(255) NOT NULL DEFAULT '',
+  `body` text,
+  `status` int NOT NULL DEFAULT 0,
+  `duration_ms` bigint NOT NULL DEFAULT 0,
+  `created_at` datetime DEFAULT NULL
+);
+CREATE INDEX IF NOT EXISTS `idx_operation_log_created_at` ON `rdev_operation_log` (`created_at`);
+CREATE INDEX IF NOT EXISTS `idx_operation_log_user` ON `rdev_operation_log` (`user`);
+CREATE INDEX IF NOT EXISTS `idx_operation_log_module` ON `rdev_operation_log` (`module`);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS `rdev_command_log`;
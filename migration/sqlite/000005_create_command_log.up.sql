@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS `rdev_command_log` (
+  `id` integer PRIMARY KEY AUTOINCREMENT,
+  `record_id` varchar(64) NOT NULL DEFAULT '',
+  `kind` varchar(16) NOT NULL DEFAULT '',
+  `host` varchar(255) NOT NULL DEFAULT '',
+  `user` varchar(255) NOT NULL DEFAULT '',
+  `command` text,
+  `exit_code` int NOT NULL DEFAULT 0,
+  `error` text,
+  `start_time` datetime DEFAULT NULL,
+  `end_time` datetime DEFAULT NULL,
+  `duration_ms` bigint NOT NULL DEFAULT 0,
+  `output_size` bigint NOT NULL DEFAULT 0,
+  `output_digest` varchar(64) NOT NULL DEFAULT '',
+  `cast_file` varchar(255) NOT NULL DEFAULT ''
+);
+CREATE UNIQUE INDEX IF NOT EXISTS `idx_command_log_record_id` ON `rdev_command_log` (`record_id`);
+CREATE INDEX IF NOT EXISTS `idx_command_log_start_time` ON `rdev_command_log` (`start_time`);
+CREATE INDEX IF NOT EXISTS `idx_command_log_host` ON `rdev_command_log` (`host`);
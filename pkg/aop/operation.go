@@ -1,71 +1,197 @@
 package aop
 
 import (
+	"bytes"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"github.com/toolkits/pkg/errorx"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/jwt"
 	"github.com/whoisfisher/mykubespray/pkg/logger"
-	"gorm.io/gorm"
+	"github.com/whoisfisher/mykubespray/pkg/service"
+	"github.com/whoisfisher/mykubespray/pkg/utils"
+	"io"
+	"net/http"
+	"strings"
 	"time"
 )
 
-type OperationLog struct {
-	gorm.Model
-	User         string `json:"user" gorm:"index"`
-	Timestamp    int64  `json:"timestamp"`
-	Resource     string `json:"resource"`
-	Endpoint     string `json:"endpoint"`
-	Module       string `json:"module"`
-	ResourceType string `json:"resource_type"`
-	Description  string `json:"description"`
-}
-
+// RouteDescription documents a route for the operation log, Path is the gin route pattern.
 type RouteDescription struct {
+	Method      string
 	Path        string
+	Module      string
 	Description string
 }
 
 var routeDescriptions = []RouteDescription{
-	{Path: "/api/v1/users", Description: "Endpoint for managing users."},
-	{Path: "/api/v1/orders", Description: "Endpoint for managing orders."},
-	// 添加更多描述
+	{Method: http.MethodGet, Path: "/api/ws/v1/cluster/create", Module: "cluster", Description: "Create a cluster"},
+	{Method: http.MethodGet, Path: "/api/ws/v1/cluster/delete", Module: "cluster", Description: "Delete a cluster"},
+	{Method: http.MethodGet, Path: "/api/ws/v1/cluster/nodes/add", Module: "cluster", Description: "Add nodes to a cluster"},
+	{Method: http.MethodGet, Path: "/api/ws/v1/cluster/node/delete", Module: "cluster", Description: "Delete a node from a cluster"},
+	{Method: http.MethodGet, Path: "/api/ws/v1/terminal", Module: "terminal", Description: "Open a terminal"},
+	{Method: http.MethodPost, Path: "/api/v1/keepalived/configure", Module: "loadbalancer", Description: "Configure keepalived"},
+	{Method: http.MethodPost, Path: "/api/v1/haproxy/configure", Module: "loadbalancer", Description: "Configure haproxy"},
+	{Method: http.MethodPost, Path: "/api/v1/apiserver/configure", Module: "loadbalancer", Description: "Configure the apiserver"},
+	{Method: http.MethodPost, Path: "/api/v1/server/mount", Module: "server", Description: "Mount a disk"},
+	{Method: http.MethodPost, Path: "/api/v1/server/hosts", Module: "server", Description: "Add /etc/hosts entries"},
+	{Method: http.MethodPost, Path: "/api/v1/server/cert/copy", Module: "server", Description: "Copy a file"},
+	{Method: http.MethodPost, Path: "/api/v1/server/cert/copyparallel", Module: "server", Description: "Copy a file to hosts"},
+	{Method: http.MethodPost, Path: "/api/v1/server/dir/syncparallel", Module: "server", Description: "Sync a directory to hosts"},
+	{Method: http.MethodPost, Path: "/api/v1/server/file/fetchparallel", Module: "server", Description: "Fetch files from hosts"},
+	{Method: http.MethodPost, Path: "/api/v1/server/file/download", Module: "server", Description: "Download files"},
+	{Method: http.MethodPost, Path: "/api/v1/server/file/copyremote", Module: "server", Description: "Copy a file between hosts"},
+	{Method: http.MethodPost, Path: "/api/v1/server/file/ensureparallel", Module: "server", Description: "Ensure a file on hosts"},
+	{Method: http.MethodPost, Path: "/api/v1/server/hostsparallel", Module: "server", Description: "Add /etc/hosts entries to hosts"},
+	{Method: http.MethodPost, Path: "/api/v1/server/dnsparallel", Module: "server", Description: "Add DNS servers to hosts"},
+	{Method: http.MethodPost, Path: "/api/v1/server/execmdparallel", Module: "server", Description: "Execute a command on hosts"},
+	{Method: http.MethodPost, Path: "/api/v1/keycloak/group", Module: "keycloak", Description: "Create a group"},
+	{Method: http.MethodPost, Path: "/api/v1/keycloak/user", Module: "keycloak", Description: "Query a user"},
+	{Method: http.MethodPost, Path: "/api/v1/kubernetes/apply", Module: "kubernetes", Description: "Apply manifests"},
+	{Method: http.MethodPost, Path: "/api/v1/kubernetes/helm/repo", Module: "kubernetes", Description: "Add a helm repository"},
+	{Method: http.MethodPost, Path: "/api/v1/kubernetes/helm/chart", Module: "kubernetes", Description: "Install a helm chart"},
+	{Method: http.MethodGet, Path: "/api/v1/knownhosts", Module: "knownhosts", Description: "List known hosts"},
+	{Method: http.MethodPost, Path: "/api/v1/knownhosts/accept", Module: "knownhosts", Description: "Accept a host key"},
+	{Method: http.MethodPost, Path: "/api/v1/knownhosts/revoke", Module: "knownhosts", Description: "Revoke a host key"},
+	{Method: http.MethodGet, Path: "/api/v1/pool/stats", Module: "pool", Description: "Show SSH pool statistics"},
+	{Method: http.MethodGet, Path: "/api/v1/hosts", Module: "inventory", Description: "List hosts"},
+	{Method: http.MethodPost, Path: "/api/v1/hosts", Module: "inventory", Description: "Create a host"},
+	{Method: http.MethodPost, Path: "/api/v1/hosts/import", Module: "inventory", Description: "Import hosts"},
+	{Method: http.MethodGet, Path: "/api/v1/hosts/:id", Module: "inventory", Description: "Get a host"},
+	{Method: http.MethodGet, Path: "/api/v1/hosts/:id/facts", Module: "inventory", Description: "Get host facts"},
+	{Method: http.MethodPut, Path: "/api/v1/hosts/:id", Module: "inventory", Description: "Update a host"},
+	{Method: http.MethodDelete, Path: "/api/v1/hosts/:id", Module: "inventory", Description: "Delete a host"},
+	{Method: http.MethodPost, Path: "/api/v1/kubekey/inventory/export", Module: "inventory", Description: "Export a kubespray inventory"},
+	{Method: http.MethodGet, Path: "/api/v1/clusters", Module: "cluster", Description: "List clusters"},
+	{Method: http.MethodGet, Path: "/api/v1/clusters/:id", Module: "cluster", Description: "Get a cluster"},
+	{Method: http.MethodGet, Path: "/api/v1/clusters/:id/kubeconfig", Module: "cluster", Description: "Download a cluster kubeconfig"},
+	{Method: http.MethodDelete, Path: "/api/v1/clusters/:id", Module: "cluster", Description: "Forget a cluster"},
+	{Method: http.MethodGet, Path: "/api/v1/credentials", Module: "credential", Description: "List credentials"},
+	{Method: http.MethodPost, Path: "/api/v1/credentials", Module: "credential", Description: "Create a credential"},
+	{Method: http.MethodPost, Path: "/api/v1/credentials/rotate", Module: "credential", Description: "Rotate the master key"},
+	{Method: http.MethodGet, Path: "/api/v1/credentials/:id", Module: "credential", Description: "Get a credential"},
+	{Method: http.MethodPut, Path: "/api/v1/credentials/:id", Module: "credential", Description: "Update a credential"},
+	{Method: http.MethodDelete, Path: "/api/v1/credentials/:id", Module: "credential", Description: "Delete a credential"},
+	{Method: http.MethodGet, Path: "/api/v1/audit", Module: "audit", Description: "List operation logs"},
+	{Method: http.MethodGet, Path: "/api/v1/audit/commands", Module: "audit", Description: "List command records"},
+	{Method: http.MethodGet, Path: "/api/v1/audit/commands/:id/cast", Module: "audit", Description: "Download a session recording"},
 }
 
-func getDescription(path string) string {
-	for _, route := range routeDescriptions {
-		if route.Path == path {
-			return route.Description
+// describeRoute returns the module and description of a route, routes that are not documented
+// are grouped by the first segment after the API prefix.
+func describeRoute(method, route string) (string, string) {
+	for _, description := range routeDescriptions {
+		if description.Method == method && description.Path == route {
+			return description.Module, description.Description
 		}
 	}
-	return "No description available for this endpoint."
+	path := route
+	for _, prefix := range []string{"/api/ws/v1/", "/api/v1/"} {
+		if strings.HasPrefix(path, prefix) {
+			path = strings.TrimPrefix(path, prefix)
+			break
+		}
+	}
+	module, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	return module, ""
 }
 
+// LogRequest stores an operation log of every API request, see audit.operation_log.
 func LogRequest() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// Capture start time
+		if !viper.GetBool("audit.operation_log") || c.Request.Method == http.MethodOptions {
+			c.Next()
+			return
+		}
+		route := c.FullPath()
+		for _, skip := range viper.GetStringSlice("audit.skip_routes") {
+			if route == skip {
+				c.Next()
+				return
+			}
+		}
 		startTime := time.Now()
+		var body string
+		if c.Request.Body != nil && c.Request.Body != http.NoBody && c.Request.Method != http.MethodGet {
+			body = peekBody(c)
+		}
 
+		defer func() {
+			// handlers report errors by panicking with errorx.PageError, record them before Recovery answers
+			status := c.Writer.Status()
+			panicked := recover()
+			if panicked != nil {
+				status = http.StatusInternalServerError
+				if pageError, ok := panicked.(errorx.PageError); ok {
+					status = pageError.Code
+				}
+			}
+			recordOperation(c, route, body, status, startTime)
+			if panicked != nil {
+				panic(panicked)
+			}
+		}()
 		c.Next()
+	}
+}
+
+// defaultMaxBodySize applies when audit.max_body_size is not set.
+const defaultMaxBodySize = 64 * 1024
 
-		endTime := time.Now()
+// peekBody returns the redacted start of the request body, the handler still reads the whole body.
+func peekBody(c *gin.Context) string {
+	contentType := c.ContentType()
+	if utils.BinaryContentType(contentType) {
+		return fmt.Sprintf("[%d bytes of %s]", c.Request.ContentLength, contentType)
+	}
+	limit := viper.GetInt("audit.max_body_size")
+	if limit <= 0 {
+		limit = defaultMaxBodySize
+	}
+	// one byte more than logged tells RedactBody the body was cut
+	data, err := io.ReadAll(io.LimitReader(c.Request.Body, int64(limit)+1))
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to read request body of %s: %s", c.Request.URL.Path, err.Error())
+	}
+	c.Request.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), c.Request.Body), c.Request.Body}
+	return utils.RedactBody(contentType, data, limit)
+}
 
-		description := getDescription(c.Request.URL.Path)
+func recordOperation(c *gin.Context, route, body string, status int, startTime time.Time) {
+	if route == "" {
+		route = c.Request.URL.Path
+	}
+	user := jwt.ExtractUsername(c.Request)
+	if user == "" {
+		user = "anonymous"
+	}
+	module, description := describeRoute(c.Request.Method, route)
+	operation := entity.OperationLog{
+		User:        user,
+		ClientIP:    c.ClientIP(),
+		Method:      c.Request.Method,
+		Route:       route,
+		Path:        requestPath(c.Request),
+		Module:      module,
+		Description: description,
+		Body:        body,
+		Status:      status,
+		DurationMs:  time.Since(startTime).Milliseconds(),
+		CreatedAt:   startTime,
+	}
+	if err := service.NewAuditService().RecordOperation(operation); err != nil {
+		logger.GetLogger().Errorf("Failed to log operation: %s", err.Error())
+	}
+}
 
-		// Log details
-		logEntry := OperationLog{
-			User:         "example_user", // 在实际应用中，你可能需要从请求上下文中获取用户信息
-			Timestamp:    endTime.Unix(),
-			Resource:     c.Request.URL.Path,
-			Endpoint:     c.Request.RequestURI,
-			Module:       "example_module", // 根据实际需要设置模块
-			ResourceType: c.Request.Method,
-			Description:  description,
-			Model: gorm.Model{
-				CreatedAt: startTime,
-			},
-		}
-		logger.GetLogger().Infof("%v", logEntry)
-		//// Save to database
-		//if err := db.Create(&logEntry).Error; err != nil {
-		//	logger.GetLogger().Printf("Failed to log operation: %v", err)
-		//}
+// requestPath is the path and query of the request, websocket routes send the bearer token as a query parameter.
+func requestPath(request *http.Request) string {
+	if request.URL.RawQuery == "" {
+		return request.URL.Path
 	}
+	return request.URL.Path + "?" + utils.RedactQuery(request.URL.RawQuery)
 }
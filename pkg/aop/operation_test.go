@@ -0,0 +1,33 @@
+package aop
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPeekBodyKeepsRequestBody(t *testing.T) {
+	viper.Set("audit.max_body_size", 16)
+	defer viper.Set("audit.max_body_size", nil)
+	body := `{"password":"s3cret","data":"` + strings.Repeat("x", 64) + `"}`
+	request := httptest.NewRequest(http.MethodPost, "/api/ws/v1/terminal?cols=80&token=eyJhbGci", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = request
+
+	logged := peekBody(c)
+	if strings.Contains(logged, "s3cret") || !strings.HasSuffix(logged, "...(truncated)") {
+		t.Fatalf("unexpected logged body %q", logged)
+	}
+	read, err := io.ReadAll(c.Request.Body)
+	if err != nil || string(read) != body {
+		t.Fatalf("handler got %q, %v", read, err)
+	}
+	if path := requestPath(request); strings.Contains(path, "eyJhbGci") || !strings.HasPrefix(path, "/api/ws/v1/terminal?cols=80&token=") {
+		t.Fatalf("token stored in path %q", path)
+	}
+}
@@ -0,0 +1,110 @@
+package controller
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/toolkits/pkg/ginx"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"github.com/whoisfisher/mykubespray/pkg/service"
+	"github.com/whoisfisher/mykubespray/pkg/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AuditController struct {
+	Ctx          context.Context
+	auditService service.AuditService
+}
+
+func NewAuditController() *AuditController {
+	return &AuditController{
+		auditService: service.NewAuditService(),
+	}
+}
+
+var auditController AuditController
+
+func init() {
+	auditController = *NewAuditController()
+}
+
+// ListCommandRecords filters by the host, user, since, until (RFC 3339) and limit query parameters.
+func ListCommandRecords(ctx *gin.Context) {
+	query := utils.AuditQuery{Host: ctx.Query("host"), User: ctx.Query("user")}
+	var err error
+	if since := ctx.Query("since"); since != "" {
+		if query.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			logger.GetLogger().Errorf("Invalid since: %s", err.Error())
+			ginx.Dangerous(err)
+		}
+	}
+	if until := ctx.Query("until"); until != "" {
+		if query.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			logger.GetLogger().Errorf("Invalid until: %s", err.Error())
+			ginx.Dangerous(err)
+		}
+	}
+	if limit := ctx.Query("limit"); limit != "" {
+		if query.Limit, err = strconv.Atoi(limit); err != nil {
+			logger.GetLogger().Errorf("Invalid limit: %s", err.Error())
+			ginx.Dangerous(err)
+		}
+	}
+	records, err := auditController.auditService.ListCommands(query)
+	if err != nil {
+		logger.GetLogger().Errorf("List command records failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	ginx.NewRender(ctx).Data(records, nil)
+}
+
+// ListOperationLogs filters by the user, module, method, route, status, since and until (RFC 3339)
+// query parameters and pages with page and page_size.
+func ListOperationLogs(ctx *gin.Context) {
+	query := entity.OperationLogQuery{
+		User:   ctx.Query("user"),
+		Module: ctx.Query("module"),
+		Method: strings.ToUpper(ctx.Query("method")),
+		Route:  ctx.Query("route"),
+	}
+	var err error
+	if since := ctx.Query("since"); since != "" {
+		if query.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			logger.GetLogger().Errorf("Invalid since: %s", err.Error())
+			ginx.Bomb(http.StatusBadRequest, "invalid since: %s", err.Error())
+		}
+	}
+	if until := ctx.Query("until"); until != "" {
+		if query.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			logger.GetLogger().Errorf("Invalid until: %s", err.Error())
+			ginx.Bomb(http.StatusBadRequest, "invalid until: %s", err.Error())
+		}
+	}
+	for name, value := range map[string]*int{"status": &query.Status, "page": &query.Page, "page_size": &query.PageSize} {
+		if param := ctx.Query(name); param != "" {
+			if *value, err = strconv.Atoi(param); err != nil {
+				logger.GetLogger().Errorf("Invalid %s: %s", name, err.Error())
+				ginx.Bomb(http.StatusBadRequest, "invalid %s: %s", name, err.Error())
+			}
+		}
+	}
+	page, err := auditController.auditService.ListOperations(query)
+	if err != nil {
+		logger.GetLogger().Errorf("List operation logs failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	ginx.NewRender(ctx).Data(page, nil)
+}
+
+// DownloadCast serves the asciinema recording of a streamed or interactive session.
+func DownloadCast(ctx *gin.Context) {
+	file, err := auditController.auditService.CastFile(ctx.Param("id"))
+	if err != nil {
+		logger.GetLogger().Errorf("Get session recording failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	ctx.FileAttachment(file, ctx.Param("id")+".cast")
+}
@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/toolkits/pkg/ginx"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"github.com/whoisfisher/mykubespray/pkg/service"
+	"net/http"
+	"strconv"
+)
+
+type ClusterController struct {
+	Ctx            context.Context
+	clusterService service.ClusterService
+}
+
+func NewClusterController() *ClusterController {
+	return &ClusterController{
+		clusterService: service.NewClusterService(),
+	}
+}
+
+var clusterController ClusterController
+
+func init() {
+	clusterController = *NewClusterController()
+}
+
+func clusterID(ctx *gin.Context) uint {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ginx.Bomb(http.StatusBadRequest, "invalid cluster id %q", ctx.Param("id"))
+	}
+	return uint(id)
+}
+
+func dangerousCluster(err error) {
+	if errors.Is(err, service.ErrClusterNotFound) {
+		ginx.Bomb(http.StatusNotFound, err.Error())
+	}
+	ginx.Dangerous(err)
+}
+
+// ListClusters returns the recorded clusters and their nodes without their configuration.
+func ListClusters(ctx *gin.Context) {
+	clusters, err := clusterController.clusterService.ListClusters()
+	if err != nil {
+		logger.GetLogger().Errorf("List clusters failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	ginx.NewRender(ctx).Data(clusters, nil)
+}
+
+// GetCluster returns the cluster with its masked configuration.
+func GetCluster(ctx *gin.Context) {
+	cluster, err := clusterController.clusterService.GetCluster(clusterID(ctx))
+	if err != nil {
+		logger.GetLogger().Errorf("Get cluster failed: %s", err.Error())
+		dangerousCluster(err)
+	}
+	masked := cluster.Config.Masked()
+	cluster.Config = &masked
+	ginx.NewRender(ctx).Data(cluster, nil)
+}
+
+// GetClusterKubeconfig returns the admin kubeconfig of the cluster.
+func GetClusterKubeconfig(ctx *gin.Context) {
+	kubeconfig, err := clusterController.clusterService.ClusterKubeconfig(clusterID(ctx))
+	if err != nil {
+		logger.GetLogger().Errorf("Get cluster kubeconfig failed: %s", err.Error())
+		dangerousCluster(err)
+	}
+	ginx.NewRender(ctx).Data(kubeconfig, nil)
+}
+
+// DeleteClusterRecord forgets the cluster without tearing it down, /cluster/delete removes the cluster itself.
+func DeleteClusterRecord(ctx *gin.Context) {
+	err := clusterController.clusterService.DeleteCluster(clusterID(ctx))
+	if err != nil {
+		logger.GetLogger().Errorf("Delete cluster failed: %s", err.Error())
+		dangerousCluster(err)
+	}
+	ginx.NewRender(ctx).Data("Delete cluster success", nil)
+}
+
+// applyK8sConfig fills the kubeconfig of a request from its cluster or its kubeconfig credential.
+func applyK8sConfig(conf *entity.K8sConfig) {
+	if conf.ClusterID != 0 {
+		kubeconfig, err := clusterController.clusterService.ClusterKubeconfig(conf.ClusterID)
+		if err != nil {
+			logger.GetLogger().Errorf("Get cluster kubeconfig failed: %s", err.Error())
+			dangerousCluster(err)
+		}
+		conf.Kubeconfig, conf.KubeconfigPath = kubeconfig, ""
+		return
+	}
+	applyKubeconfigCredential(conf)
+}
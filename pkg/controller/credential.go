@@ -0,0 +1,157 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/toolkits/pkg/ginx"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"github.com/whoisfisher/mykubespray/pkg/service"
+	"net/http"
+	"strconv"
+)
+
+type CredentialController struct {
+	Ctx               context.Context
+	credentialService service.CredentialService
+}
+
+func NewCredentialController() *CredentialController {
+	return &CredentialController{
+		credentialService: service.NewCredentialService(),
+	}
+}
+
+var credentialController CredentialController
+
+func init() {
+	credentialController = *NewCredentialController()
+}
+
+func credentialID(ctx *gin.Context) uint {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ginx.Bomb(http.StatusBadRequest, "invalid credential id %q", ctx.Param("id"))
+	}
+	return uint(id)
+}
+
+func dangerousCredential(err error) {
+	if errors.Is(err, service.ErrCredentialNotFound) {
+		ginx.Bomb(http.StatusNotFound, err.Error())
+	}
+	ginx.Dangerous(err)
+}
+
+// ListCredentials filters by the kind query parameter, secrets are never returned.
+func ListCredentials(ctx *gin.Context) {
+	credentials, err := credentialController.credentialService.ListCredentials(ctx.Query("kind"))
+	if err != nil {
+		logger.GetLogger().Errorf("List credentials failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	ginx.NewRender(ctx).Data(credentials, nil)
+}
+
+func GetCredential(ctx *gin.Context) {
+	credential, err := credentialController.credentialService.GetCredential(credentialID(ctx))
+	if err != nil {
+		logger.GetLogger().Errorf("Get credential failed: %s", err.Error())
+		dangerousCredential(err)
+	}
+	ginx.NewRender(ctx).Data(credential, nil)
+}
+
+func CreateCredential(ctx *gin.Context) {
+	var credential entity.Credential
+	if err := ctx.ShouldBind(&credential); err != nil {
+		logger.GetLogger().Errorf("Credential bind failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	created, err := credentialController.credentialService.CreateCredential(credential)
+	if err != nil {
+		logger.GetLogger().Errorf("Create credential failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	ginx.NewRender(ctx).Data(created, nil)
+}
+
+func UpdateCredential(ctx *gin.Context) {
+	id := credentialID(ctx)
+	var credential entity.Credential
+	if err := ctx.ShouldBind(&credential); err != nil {
+		logger.GetLogger().Errorf("Credential bind failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	updated, err := credentialController.credentialService.UpdateCredential(id, credential)
+	if err != nil {
+		logger.GetLogger().Errorf("Update credential failed: %s", err.Error())
+		dangerousCredential(err)
+	}
+	ginx.NewRender(ctx).Data(updated, nil)
+}
+
+func DeleteCredential(ctx *gin.Context) {
+	err := credentialController.credentialService.DeleteCredential(credentialID(ctx))
+	if err != nil {
+		logger.GetLogger().Errorf("Delete credential failed: %s", err.Error())
+		dangerousCredential(err)
+	}
+	ginx.NewRender(ctx).Data("Delete credential success", nil)
+}
+
+// RotateCredentials reloads the master keys and re-encrypts the stored secrets with the newest one.
+func RotateCredentials(ctx *gin.Context) {
+	rotation, err := credentialController.credentialService.RotateCredentials()
+	if err != nil {
+		logger.GetLogger().Errorf("Rotate credentials failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	ginx.NewRender(ctx).Data(rotation, nil)
+}
+
+func revealCredential(id uint, kind string) (*entity.Credential, error) {
+	credential, err := credentialController.credentialService.RevealCredential(id, kind)
+	if err != nil {
+		logger.GetLogger().Errorf("Reveal credential failed: %s", err.Error())
+	}
+	return credential, err
+}
+
+// applyRegistryCredential fills the registry user and password from its referenced credential.
+func applyRegistryCredential(registry *entity.Registry) error {
+	if registry == nil || registry.CredentialID == 0 {
+		return nil
+	}
+	credential, err := revealCredential(registry.CredentialID, entity.CredentialRegistry)
+	if err != nil {
+		return err
+	}
+	registry.User, registry.Password = credential.Username, credential.Secret
+	return nil
+}
+
+// applyKubeconfigCredential fills the kubeconfig of a cluster from its referenced credential.
+func applyKubeconfigCredential(conf *entity.K8sConfig) {
+	if conf.CredentialID == 0 {
+		return
+	}
+	credential, err := revealCredential(conf.CredentialID, entity.CredentialKubeconfig)
+	if err != nil {
+		dangerousCredential(err)
+	}
+	conf.Kubeconfig, conf.KubeconfigPath = credential.Secret, ""
+}
+
+// applyKeycloakCredential fills the client secret from its referenced credential.
+func applyKeycloakCredential(conf *entity.BaseKeycloakConf) {
+	if conf.CredentialID == 0 {
+		return
+	}
+	credential, err := revealCredential(conf.CredentialID, entity.CredentialKeycloakSecret)
+	if err != nil {
+		dangerousCredential(err)
+	}
+	conf.ClientSecret = credential.Secret
+}
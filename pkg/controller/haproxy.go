@@ -32,6 +32,7 @@ func ConfigureHaproxy(ctx *gin.Context) {
 		logger.GetLogger().Errorf("haproxyconf bind failed: %s", err.Error())
 		ginx.Dangerous(err)
 	}
+	haproxyDTO.Host = resolveHost(haproxyDTO.HostID, haproxyDTO.Host)
 	err := haproxyController.haproxyService.Configure(haproxyDTO)
 	if err != nil {
 		logger.GetLogger().Errorf("Configure haproxy failed: %s", err.Error())
@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/toolkits/pkg/ginx"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"github.com/whoisfisher/mykubespray/pkg/service"
+	"github.com/whoisfisher/mykubespray/pkg/utils"
+	"net/http"
+	"strconv"
+)
+
+type InventoryController struct {
+	Ctx              context.Context
+	inventoryService service.InventoryService
+}
+
+func NewInventoryController() *InventoryController {
+	return &InventoryController{
+		inventoryService: service.NewInventoryService(),
+	}
+}
+
+var inventoryController InventoryController
+
+func init() {
+	inventoryController = *NewInventoryController()
+}
+
+func hostID(ctx *gin.Context) uint {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ginx.Bomb(http.StatusBadRequest, "invalid host id %q", ctx.Param("id"))
+	}
+	return uint(id)
+}
+
+func dangerousInventory(err error) {
+	if errors.Is(err, service.ErrHostNotFound) {
+		ginx.Bomb(http.StatusNotFound, err.Error())
+	}
+	ginx.Dangerous(err)
+}
+
+// ListInventoryHosts filters by the selector, group and name query parameters.
+func ListInventoryHosts(ctx *gin.Context) {
+	query := entity.InventoryQuery{Selector: ctx.Query("selector"), Group: ctx.Query("group"), Name: ctx.Query("name")}
+	hosts, err := inventoryController.inventoryService.ListHosts(query)
+	if err != nil {
+		logger.GetLogger().Errorf("List inventory hosts failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	masked := make([]entity.InventoryHost, 0, len(hosts))
+	for _, host := range hosts {
+		masked = append(masked, host.Masked())
+	}
+	ginx.NewRender(ctx).Data(masked, nil)
+}
+
+func GetInventoryHost(ctx *gin.Context) {
+	host, err := inventoryController.inventoryService.GetHost(hostID(ctx))
+	if err != nil {
+		logger.GetLogger().Errorf("Get inventory host failed: %s", err.Error())
+		dangerousInventory(err)
+	}
+	ginx.NewRender(ctx).Data(host.Masked(), nil)
+}
+
+func CreateInventoryHost(ctx *gin.Context) {
+	var inventoryHost entity.InventoryHost
+	if err := ctx.ShouldBind(&inventoryHost); err != nil {
+		logger.GetLogger().Errorf("InventoryHost bind failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	host, err := inventoryController.inventoryService.CreateHost(inventoryHost)
+	if err != nil {
+		logger.GetLogger().Errorf("Create inventory host failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	ginx.NewRender(ctx).Data(host.Masked(), nil)
+}
+
+func UpdateInventoryHost(ctx *gin.Context) {
+	id := hostID(ctx)
+	var inventoryHost entity.InventoryHost
+	if err := ctx.ShouldBind(&inventoryHost); err != nil {
+		logger.GetLogger().Errorf("InventoryHost bind failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	host, err := inventoryController.inventoryService.UpdateHost(id, inventoryHost)
+	if err != nil {
+		logger.GetLogger().Errorf("Update inventory host failed: %s", err.Error())
+		dangerousInventory(err)
+	}
+	ginx.NewRender(ctx).Data(host.Masked(), nil)
+}
+
+func DeleteInventoryHost(ctx *gin.Context) {
+	err := inventoryController.inventoryService.DeleteHost(hostID(ctx))
+	if err != nil {
+		logger.GetLogger().Errorf("Delete inventory host failed: %s", err.Error())
+		dangerousInventory(err)
+	}
+	ginx.NewRender(ctx).Data("Delete inventory host success", nil)
+}
+
+// GetInventoryHostFacts returns the facts of the host from the cache, refresh=true gathers them again.
+func GetInventoryHostFacts(ctx *gin.Context) {
+	refresh, _ := strconv.ParseBool(ctx.Query("refresh"))
+	facts, err := inventoryController.inventoryService.HostFacts(hostID(ctx), refresh)
+	if err != nil {
+		logger.GetLogger().Errorf("Get inventory host facts failed: %s", err.Error())
+		dangerousInventory(err)
+	}
+	ginx.NewRender(ctx).Data(facts, nil)
+}
+
+// ImportInventoryHosts reads an Ansible YAML or INI inventory from the request body into the inventory,
+// the format query parameter is yaml or ini and detected when it is omitted.
+func ImportInventoryHosts(ctx *gin.Context) {
+	data, err := ctx.GetRawData()
+	if err != nil {
+		logger.GetLogger().Errorf("Read inventory failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	inventory, err := utils.ParseAnsibleInventory(data, ctx.Query("format"))
+	if err != nil {
+		logger.GetLogger().Errorf("Parse inventory failed: %s", err.Error())
+		ginx.Bomb(http.StatusBadRequest, err.Error())
+	}
+	hosts, err := inventoryController.inventoryService.ImportAnsibleInventory(*inventory)
+	if err != nil {
+		logger.GetLogger().Errorf("Import inventory failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	masked := make([]entity.InventoryHost, 0, len(hosts))
+	for _, host := range hosts {
+		masked = append(masked, host.Masked())
+	}
+	ginx.NewRender(ctx).Data(masked, nil)
+}
+
+// resolveHosts adds the inventory hosts picked by selection to the inline hosts of a request.
+func resolveHosts(selection entity.HostSelection, hosts []entity.Host) []entity.Host {
+	if selection.Empty() {
+		return hosts
+	}
+	resolved, err := inventoryController.inventoryService.ResolveHosts(selection)
+	if err != nil {
+		logger.GetLogger().Errorf("Resolve inventory hosts failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	return append(append([]entity.Host{}, hosts...), resolved...)
+}
+
+// resolveHost returns the inventory host id, or host when id is 0.
+func resolveHost(id uint, host entity.Host) entity.Host {
+	if id == 0 {
+		return host
+	}
+	resolved, err := inventoryController.inventoryService.ResolveHosts(entity.HostSelection{HostIDs: []uint{id}})
+	if err != nil {
+		logger.GetLogger().Errorf("Resolve inventory host failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	return resolved[0]
+}
@@ -32,6 +32,7 @@ func ConfigureKeepalived(ctx *gin.Context) {
 		logger.GetLogger().Errorf("KeepalivedConf bind failed: %s", err.Error())
 		ginx.Dangerous(err)
 	}
+	keepalivedDTO.Host = resolveHost(keepalivedDTO.HostID, keepalivedDTO.Host)
 	err := keepalivedController.keepalivedService.Configure(keepalivedDTO)
 	if err != nil {
 		logger.GetLogger().Errorf("Configure keepalived failed: %s", err.Error())
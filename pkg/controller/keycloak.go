@@ -32,6 +32,7 @@ func CreateGroup(ctx *gin.Context) {
 		logger.GetLogger().Errorf("GroupConf bind failed: %s", err.Error())
 		ginx.Dangerous(err)
 	}
+	applyKeycloakCredential(&groupConf.BaseKeycloakConf)
 	err := keycloakController.keycloakService.CreateGroup(groupConf)
 	if err != nil {
 		logger.GetLogger().Errorf("Create group failed: %s", err.Error())
@@ -46,6 +47,7 @@ func QueryUserByName(ctx *gin.Context) {
 		logger.GetLogger().Errorf("GroupConf bind failed: %s", err.Error())
 		ginx.Dangerous(err)
 	}
+	applyKeycloakCredential(&userConf.BaseKeycloakConf)
 	data, err := keycloakController.keycloakService.QueryUserByName(userConf)
 	if err != nil {
 		logger.GetLogger().Errorf("Query User failed: %s", err.Error())
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/toolkits/pkg/ginx"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"github.com/whoisfisher/mykubespray/pkg/service"
+)
+
+type KnownHostsController struct {
+	Ctx               context.Context
+	knownHostsService service.KnownHostsService
+}
+
+func NewKnownHostsController() *KnownHostsController {
+	return &KnownHostsController{
+		knownHostsService: service.NewKnownHostsService(),
+	}
+}
+
+var knownHostsController KnownHostsController
+
+func init() {
+	knownHostsController = *NewKnownHostsController()
+}
+
+func ListKnownHosts(ctx *gin.Context) {
+	ginx.NewRender(ctx).Data(knownHostsController.knownHostsService.List(), nil)
+}
+
+func AcceptKnownHost(ctx *gin.Context) {
+	var knownHostConf entity.KnownHostConf
+	if err := ctx.ShouldBind(&knownHostConf); err != nil {
+		logger.GetLogger().Errorf("KnownHostConf bind failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	err := knownHostsController.knownHostsService.Accept(knownHostConf)
+	if err != nil {
+		logger.GetLogger().Errorf("Accept host key failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	ginx.NewRender(ctx).Data("Accept host key success", nil)
+}
+
+func RevokeKnownHost(ctx *gin.Context) {
+	var knownHostConf entity.KnownHostConf
+	if err := ctx.ShouldBind(&knownHostConf); err != nil {
+		logger.GetLogger().Errorf("KnownHostConf bind failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	err := knownHostsController.knownHostsService.Revoke(knownHostConf)
+	if err != nil {
+		logger.GetLogger().Errorf("Revoke host key failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	ginx.NewRender(ctx).Data("Revoke host key success", nil)
+}
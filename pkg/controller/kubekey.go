@@ -4,6 +4,7 @@ import (
 	"context"
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
+	"github.com/toolkits/pkg/ginx"
 	"github.com/whoisfisher/mykubespray/pkg/aop"
 	"github.com/whoisfisher/mykubespray/pkg/entity"
 	"github.com/whoisfisher/mykubespray/pkg/logger"
@@ -28,110 +29,109 @@ func init() {
 	kubekeyController = *NewKubekeyController()
 }
 
-func CreateCluster(ctx *gin.Context) {
+type kubekeyAction func(ctx context.Context, conf entity.KubekeyConf, logChan chan utils.LogEntry) error
+
+// serveKubekey streams the logs of action to the websocket and cancels it once the client disconnects.
+func serveKubekey(ctx *gin.Context, action kubekeyAction) {
 	var conf entity.KubekeyConf
 	ws, err := aop.UpGrader.Upgrade(ctx.Writer, ctx.Request, nil)
 	if err != nil {
 		logger.GetLogger().Errorf("Create websocket channel failed: %s", err.Error())
-		ws.WriteMessage(websocket.TextMessage, []byte(err.Error()))
+		return
 	}
+	defer ws.Close()
 	err = ws.ReadJSON(&conf)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to read kkconf info: %s", err.Error())
 		ws.WriteMessage(websocket.TextMessage, []byte(err.Error()))
+		return
 	}
-	logChan := make(chan utils.LogEntry)
-	go func() {
-		for logEntry := range logChan {
-			if logEntry.IsError {
-				logger.GetLogger().Errorf("%s\n", logEntry.Message)
-				ws.WriteMessage(websocket.TextMessage, []byte(logEntry.Message))
-			} else {
-				logger.GetLogger().Infof("%s\n", logEntry.Message)
-				ws.WriteMessage(websocket.TextMessage, []byte(logEntry.Message))
-			}
+	if !conf.HostSelection.Empty() {
+		hosts, err := inventoryController.inventoryService.ResolveHosts(conf.HostSelection)
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to resolve inventory hosts: %s", err.Error())
+			ws.WriteMessage(websocket.TextMessage, []byte(err.Error()))
+			return
 		}
-	}()
-	kubekeyController.kubekeyService.CreateCluster(conf, logChan)
-}
-
-func DeleteCluster(ctx *gin.Context) {
-	var conf entity.KubekeyConf
-	ws, err := aop.UpGrader.Upgrade(ctx.Writer, ctx.Request, nil)
-	if err != nil {
-		logger.GetLogger().Errorf("Create websocket channel failed: %s", err.Error())
-		ws.WriteMessage(websocket.TextMessage, []byte(err.Error()))
+		conf.Hosts = append(conf.Hosts, hosts...)
 	}
-	err = ws.ReadJSON(&conf)
-	if err != nil {
-		logger.GetLogger().Errorf("Failed to read kkconf info: %s", err.Error())
-		ws.WriteMessage(websocket.TextMessage, []byte(err.Error()))
+	registries := []*entity.Registry{&conf.Registry}
+	for i := range conf.Hosts {
+		registries = append(registries, conf.Hosts[i].Registry)
 	}
-	logChan := make(chan utils.LogEntry)
+	for _, registry := range registries {
+		if err := applyRegistryCredential(registry); err != nil {
+			ws.WriteMessage(websocket.TextMessage, []byte(err.Error()))
+			return
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(ctx.Request.Context())
+	defer cancel()
 	go func() {
-		for logEntry := range logChan {
-			if logEntry.IsError {
-				logger.GetLogger().Errorf("%s\n", logEntry.Message)
-				ws.WriteMessage(websocket.TextMessage, []byte(logEntry.Message))
-			} else {
-				logger.GetLogger().Infof("%s\n", logEntry.Message)
-				ws.WriteMessage(websocket.TextMessage, []byte(logEntry.Message))
+		for {
+			if _, _, err := ws.ReadMessage(); err != nil {
+				logger.GetLogger().Infof("Websocket closed, stopping kubekey command: %s", err.Error())
+				cancel()
+				return
 			}
 		}
 	}()
-	kubekeyController.kubekeyService.DeleteCluster(conf, logChan)
-}
 
-func AddNodeToCluster(ctx *gin.Context) {
-	var conf entity.KubekeyConf
-	ws, err := aop.UpGrader.Upgrade(ctx.Writer, ctx.Request, nil)
-	if err != nil {
-		logger.GetLogger().Errorf("Create websocket channel failed: %s", err.Error())
-		ws.WriteMessage(websocket.TextMessage, []byte(err.Error()))
-	}
-	err = ws.ReadJSON(&conf)
-	if err != nil {
-		logger.GetLogger().Errorf("Failed to read kkconf info: %s", err.Error())
-		ws.WriteMessage(websocket.TextMessage, []byte(err.Error()))
-	}
 	logChan := make(chan utils.LogEntry)
+	done := make(chan struct{})
 	go func() {
+		defer close(done)
 		for logEntry := range logChan {
 			if logEntry.IsError {
 				logger.GetLogger().Errorf("%s\n", logEntry.Message)
-				ws.WriteMessage(websocket.TextMessage, []byte(logEntry.Message))
 			} else {
 				logger.GetLogger().Infof("%s\n", logEntry.Message)
-				ws.WriteMessage(websocket.TextMessage, []byte(logEntry.Message))
+			}
+			if err := ws.WriteMessage(websocket.TextMessage, []byte(logEntry.Message)); err != nil {
+				cancel()
 			}
 		}
 	}()
-	kubekeyController.kubekeyService.AddNodeToCluster(conf, logChan)
+	err = action(runCtx, conf, logChan)
+	close(logChan)
+	<-done
+	if err != nil {
+		logger.GetLogger().Errorf("Kubekey command failed: %s", err.Error())
+		ws.WriteMessage(websocket.TextMessage, []byte(err.Error()))
+	}
+}
+
+func CreateCluster(ctx *gin.Context) {
+	serveKubekey(ctx, kubekeyController.kubekeyService.CreateCluster)
+}
+
+func DeleteCluster(ctx *gin.Context) {
+	serveKubekey(ctx, kubekeyController.kubekeyService.DeleteCluster)
+}
+
+func AddNodeToCluster(ctx *gin.Context) {
+	serveKubekey(ctx, kubekeyController.kubekeyService.AddNodeToCluster)
 }
 
 func DeleteNodeFromCluster(ctx *gin.Context) {
-	var conf entity.KubekeyConf
-	ws, err := aop.UpGrader.Upgrade(ctx.Writer, ctx.Request, nil)
-	if err != nil {
-		logger.GetLogger().Errorf("Create websocket channel failed: %s", err.Error())
-		ws.WriteMessage(websocket.TextMessage, []byte(err.Error()))
+	serveKubekey(ctx, kubekeyController.kubekeyService.DeleteNodeFromCluster)
+}
+
+// ExportKubesprayInventory renders a cluster as a kubespray hosts.yaml and offline.yml.
+func ExportKubesprayInventory(ctx *gin.Context) {
+	var conf entity.KubesprayExportConf
+	if err := ctx.ShouldBind(&conf); err != nil {
+		logger.GetLogger().Errorf("KubesprayExportConf bind failed: %s", err.Error())
+		ginx.Dangerous(err)
 	}
-	err = ws.ReadJSON(&conf)
+	conf.Hosts = resolveHosts(conf.HostSelection, conf.Hosts)
+	if err := applyRegistryCredential(&conf.Registry); err != nil {
+		dangerousCredential(err)
+	}
+	inventory, err := kubekeyController.kubekeyService.ExportKubesprayInventory(conf)
 	if err != nil {
-		logger.GetLogger().Errorf("Failed to read kkconf info: %s", err.Error())
-		ws.WriteMessage(websocket.TextMessage, []byte(err.Error()))
+		ginx.Dangerous(err)
 	}
-	logChan := make(chan utils.LogEntry)
-	go func() {
-		for logEntry := range logChan {
-			if logEntry.IsError {
-				logger.GetLogger().Errorf("%s\n", logEntry.Message)
-				ws.WriteMessage(websocket.TextMessage, []byte(logEntry.Message))
-			} else {
-				logger.GetLogger().Infof("%s\n", logEntry.Message)
-				ws.WriteMessage(websocket.TextMessage, []byte(logEntry.Message))
-			}
-		}
-	}()
-	kubekeyController.kubekeyService.DeleteNodeFromCluster(conf, logChan)
+	ginx.NewRender(ctx).Data(inventory, nil)
 }
@@ -33,6 +33,7 @@ func ApplyYAMLs(ctx *gin.Context) {
 		logger.GetLogger().Errorf("KubernetesFilesConf bind failed: %s", err.Error())
 		ginx.Dangerous(err)
 	}
+	applyK8sConfig(&kubernetesConf.K8sConfig)
 	results, err := kubernetesController.kubernetesService.ApplyYAMLs(kubernetesConf)
 	if !results.OverallSuccess || err != nil {
 		err := errors.New("Apply yaml failed")
@@ -48,6 +49,14 @@ func AddRepo(ctx *gin.Context) {
 		logger.GetLogger().Errorf("HelmRepository bind failed: %s", err.Error())
 		ginx.Dangerous(err)
 	}
+	applyK8sConfig(&helmRepository.K8sConfig)
+	if helmRepository.RepoCredentialID != 0 {
+		credential, err := revealCredential(helmRepository.RepoCredentialID, entity.CredentialRegistry)
+		if err != nil {
+			dangerousCredential(err)
+		}
+		helmRepository.Username, helmRepository.Password = credential.Username, credential.Secret
+	}
 	err := kubernetesController.kubernetesService.AddRepo(helmRepository)
 	if err != nil {
 		ginx.Dangerous(err)
@@ -61,6 +70,7 @@ func InstallChart(ctx *gin.Context) {
 		logger.GetLogger().Errorf("HelmChartInfo bind failed: %s", err.Error())
 		ginx.Dangerous(err)
 	}
+	applyK8sConfig(&helmChartInfo.K8sConfig)
 	data, err := kubernetesController.kubernetesService.InstallChart(helmChartInfo)
 	if err != nil {
 		ginx.Dangerous(err)
@@ -2,11 +2,16 @@ package controller
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/toolkits/pkg/ginx"
 	"github.com/whoisfisher/mykubespray/pkg/entity"
 	"github.com/whoisfisher/mykubespray/pkg/logger"
 	"github.com/whoisfisher/mykubespray/pkg/service"
+	"github.com/whoisfisher/mykubespray/pkg/utils"
+	"net/http"
+	"time"
 )
 
 type PoolController struct {
@@ -32,7 +37,12 @@ func AddDNSParallel(ctx *gin.Context) {
 		logger.GetLogger().Errorf("AddDNSParallel bind failed: %s", err.Error())
 		ginx.Dangerous(err)
 	}
-	err := poolController.poolService.AddDNS(addDNSParallel.DNS, addDNSParallel.Hosts)
+	addDNSParallel.Hosts = resolveHosts(addDNSParallel.HostSelection, addDNSParallel.Hosts)
+	if err := utils.ValidateParallelStrategy(addDNSParallel.ParallelStrategy); err != nil {
+		logger.GetLogger().Errorf("AddDNSParallel strategy invalid: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	err := poolController.poolService.AddDNS(addDNSParallel.DNS, addDNSParallel.Hosts, addDNSParallel.ParallelStrategy)
 	if err != nil {
 		logger.GetLogger().Errorf("Add /etc/resolv.conf failed: %s", err.Error())
 		ginx.Dangerous(err)
@@ -46,7 +56,12 @@ func AddHostsParallel(ctx *gin.Context) {
 		logger.GetLogger().Errorf("AddHostsParallel bind failed: %s", err.Error())
 		ginx.Dangerous(err)
 	}
-	err := poolController.poolService.AddHosts(addHostsParallel.Record, addHostsParallel.Hosts)
+	addHostsParallel.Hosts = resolveHosts(addHostsParallel.HostSelection, addHostsParallel.Hosts)
+	if err := utils.ValidateParallelStrategy(addHostsParallel.ParallelStrategy); err != nil {
+		logger.GetLogger().Errorf("AddHostsParallel strategy invalid: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	err := poolController.poolService.AddHosts(addHostsParallel.Record, addHostsParallel.Hosts, addHostsParallel.ParallelStrategy)
 	if err != nil {
 		logger.GetLogger().Errorf("Add /etc/hosts failed: %s", err.Error())
 		ginx.Dangerous(err)
@@ -60,7 +75,17 @@ func CopyFileParallel(ctx *gin.Context) {
 		logger.GetLogger().Errorf("CopyFileParallel bind failed: %s", err.Error())
 		ginx.Dangerous(err)
 	}
-	err := poolController.poolService.CopyFile(copyFileParallel.SrcFile, copyFileParallel.DestFile, copyFileParallel.Hosts)
+	copyFileParallel.Hosts = resolveHosts(copyFileParallel.HostSelection, copyFileParallel.Hosts)
+	if err := utils.ValidateParallelStrategy(copyFileParallel.ParallelStrategy); err != nil {
+		logger.GetLogger().Errorf("CopyFileParallel strategy invalid: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	opts, err := utils.NewTransferOptions(copyFileParallel.Mode, copyFileParallel.Owner, copyFileParallel.Group)
+	if err != nil {
+		logger.GetLogger().Errorf("CopyFileParallel options invalid: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	err = poolController.poolService.CopyFile(copyFileParallel.SrcFile, copyFileParallel.DestFile, opts, copyFileParallel.Hosts, copyFileParallel.ParallelStrategy)
 	if err != nil {
 		logger.GetLogger().Errorf("Copy keycloak certificate failed: %s", err.Error())
 		ginx.Dangerous(err)
@@ -68,16 +93,143 @@ func CopyFileParallel(ctx *gin.Context) {
 	ginx.NewRender(ctx).Data("Copy keycloak certificate success", nil)
 }
 
+func SyncDirectoryParallel(ctx *gin.Context) {
+	var syncDirectoryParallel entity.SyncDirectoryParallel
+	if err := ctx.ShouldBind(&syncDirectoryParallel); err != nil {
+		logger.GetLogger().Errorf("SyncDirectoryParallel bind failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	syncDirectoryParallel.Hosts = resolveHosts(syncDirectoryParallel.HostSelection, syncDirectoryParallel.Hosts)
+	if err := utils.ValidateParallelStrategy(syncDirectoryParallel.ParallelStrategy); err != nil {
+		logger.GetLogger().Errorf("SyncDirectoryParallel strategy invalid: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	opts := utils.SyncOptions{
+		Delete:   syncDirectoryParallel.Delete,
+		Transfer: utils.TransferOptions{Owner: syncDirectoryParallel.Owner, Group: syncDirectoryParallel.Group},
+	}
+	result, err := poolController.poolService.SyncDirectory(syncDirectoryParallel.SrcDir, syncDirectoryParallel.DestDir, opts, syncDirectoryParallel.Hosts, syncDirectoryParallel.ParallelStrategy)
+	if err != nil {
+		logger.GetLogger().Errorf("Sync directory failed: %s", err.Error())
+	}
+	ginx.NewRender(ctx).Data(result, nil)
+}
+
+func FetchFilesParallel(ctx *gin.Context) {
+	var fetchFilesParallel entity.FetchFilesParallel
+	if err := ctx.ShouldBind(&fetchFilesParallel); err != nil {
+		logger.GetLogger().Errorf("FetchFilesParallel bind failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	fetchFilesParallel.Hosts = resolveHosts(fetchFilesParallel.HostSelection, fetchFilesParallel.Hosts)
+	if err := utils.ValidateParallelStrategy(fetchFilesParallel.ParallelStrategy); err != nil {
+		logger.GetLogger().Errorf("FetchFilesParallel strategy invalid: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	if fetchFilesParallel.LocalDir == "" {
+		ginx.Dangerous(errors.New("local directory is required"))
+	}
+	result, err := poolController.poolService.FetchFiles(fetchFilesParallel.RemoteFiles, fetchFilesParallel.LocalDir, fetchFilesParallel.Hosts, fetchFilesParallel.ParallelStrategy)
+	if err != nil {
+		logger.GetLogger().Errorf("Fetch files failed: %s", err.Error())
+	}
+	ginx.NewRender(ctx).Data(result, nil)
+}
+
+// EnsureFileParallel writes a managed file on every host and reports which hosts changed.
+func EnsureFileParallel(ctx *gin.Context) {
+	var ensureFileParallel entity.EnsureFileParallel
+	if err := ctx.ShouldBind(&ensureFileParallel); err != nil {
+		logger.GetLogger().Errorf("EnsureFileParallel bind failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	ensureFileParallel.Hosts = resolveHosts(ensureFileParallel.HostSelection, ensureFileParallel.Hosts)
+	if err := utils.ValidateParallelStrategy(ensureFileParallel.ParallelStrategy); err != nil {
+		logger.GetLogger().Errorf("EnsureFileParallel strategy invalid: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	mode, err := utils.ParseFileMode(ensureFileParallel.Mode)
+	if err != nil {
+		logger.GetLogger().Errorf("EnsureFileParallel mode invalid: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	file := utils.ManagedFile{
+		Path:      ensureFileParallel.Path,
+		Content:   []byte(ensureFileParallel.Content),
+		Mode:      mode,
+		Owner:     ensureFileParallel.Owner,
+		Group:     ensureFileParallel.Group,
+		Backup:    ensureFileParallel.Backup,
+		BackupDir: ensureFileParallel.BackupDir,
+		Validate:  ensureFileParallel.Validate,
+	}
+	result, err := poolController.poolService.EnsureFile(file, ensureFileParallel.Hosts, ensureFileParallel.ParallelStrategy)
+	if err != nil {
+		logger.GetLogger().Errorf("Ensure file failed: %s", err.Error())
+	}
+	ginx.NewRender(ctx).Data(result, nil)
+}
+
+// DownloadFiles streams a tar.gz of the requested files with one directory per host.
+func DownloadFiles(ctx *gin.Context) {
+	var downloadFiles entity.DownloadFiles
+	if err := ctx.ShouldBind(&downloadFiles); err != nil {
+		logger.GetLogger().Errorf("DownloadFiles bind failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	downloadFiles.Hosts = resolveHosts(downloadFiles.HostSelection, downloadFiles.Hosts)
+	if len(downloadFiles.Hosts) == 0 || len(downloadFiles.RemoteFiles) == 0 {
+		ginx.Dangerous(errors.New("hosts and remote files are required"))
+	}
+	ctx.Header("Content-Type", "application/gzip")
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=files-%s.tar.gz", time.Now().Format("20060102150405")))
+	ctx.Status(http.StatusOK)
+	if err := poolController.poolService.DownloadFiles(ctx.Writer, downloadFiles.RemoteFiles, downloadFiles.Hosts); err != nil {
+		// the archive lists the failed hosts in errors.txt.
+		logger.GetLogger().Errorf("Download files failed: %s", err.Error())
+	}
+}
+
+func CopyRemoteToRemote(ctx *gin.Context) {
+	var copyRemoteToRemote entity.CopyRemoteToRemote
+	if err := ctx.ShouldBind(&copyRemoteToRemote); err != nil {
+		logger.GetLogger().Errorf("CopyRemoteToRemote bind failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	copyRemoteToRemote.SrcHost = resolveHost(copyRemoteToRemote.SrcHostID, copyRemoteToRemote.SrcHost)
+	copyRemoteToRemote.DestHost = resolveHost(copyRemoteToRemote.DestHostID, copyRemoteToRemote.DestHost)
+	opts, err := utils.NewTransferOptions(copyRemoteToRemote.Mode, copyRemoteToRemote.Owner, copyRemoteToRemote.Group)
+	if err != nil {
+		logger.GetLogger().Errorf("CopyRemoteToRemote options invalid: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	err = poolController.poolService.CopyRemoteToRemote(copyRemoteToRemote.SrcHost, copyRemoteToRemote.SrcFile, copyRemoteToRemote.DestHost, copyRemoteToRemote.DestFile, opts)
+	if err != nil {
+		logger.GetLogger().Errorf("Copy remote file failed: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	ginx.NewRender(ctx).Data("Copy remote file success", nil)
+}
+
 func ExecuteCommandParallel(ctx *gin.Context) {
 	var commandParallel entity.CommandParallel
 	if err := ctx.ShouldBind(&commandParallel); err != nil {
 		logger.GetLogger().Errorf("CommandParallel bind failed: %s", err.Error())
 		ginx.Dangerous(err)
 	}
-	err := poolController.poolService.ExecuteCommand(commandParallel.Command, commandParallel.Hosts)
+	commandParallel.Hosts = resolveHosts(commandParallel.HostSelection, commandParallel.Hosts)
+	if err := utils.ValidateParallelStrategy(commandParallel.ParallelStrategy); err != nil {
+		logger.GetLogger().Errorf("CommandParallel strategy invalid: %s", err.Error())
+		ginx.Dangerous(err)
+	}
+	result, err := poolController.poolService.ExecuteCommand(commandParallel.Command, commandParallel.Hosts, commandParallel.ParallelStrategy)
 	if err != nil {
 		logger.GetLogger().Errorf("Execute command failed: %s", err.Error())
-		ginx.Dangerous(err)
 	}
-	ginx.NewRender(ctx).Data("Execute command success", nil)
+	// Render the per host results even on failure so callers can see the output of each machine.
+	ginx.NewRender(ctx).Data(result, nil)
+}
+
+func PoolStats(ctx *gin.Context) {
+	ginx.NewRender(ctx).Data(poolController.poolService.Stats(), nil)
 }
@@ -0,0 +1,144 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/whoisfisher/mykubespray/pkg/aop"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"github.com/whoisfisher/mykubespray/pkg/service"
+	"sync"
+	"time"
+)
+
+type TerminalController struct {
+	Ctx             context.Context
+	terminalService service.TerminalService
+}
+
+func NewTerminalController() *TerminalController {
+	return &TerminalController{
+		terminalService: service.NewTerminalService(),
+	}
+}
+
+var terminalController TerminalController
+
+func init() {
+	terminalController = *NewTerminalController()
+}
+
+// terminalSocket serializes writes, shell output and notices come from different goroutines.
+type terminalSocket struct {
+	mutex sync.Mutex
+	ws    *websocket.Conn
+}
+
+func (socket *terminalSocket) write(messageType int, data []byte) error {
+	socket.mutex.Lock()
+	defer socket.mutex.Unlock()
+	return socket.ws.WriteMessage(messageType, data)
+}
+
+// close sends reason to the browser and closes the websocket.
+func (socket *terminalSocket) close(reason string) {
+	socket.mutex.Lock()
+	defer socket.mutex.Unlock()
+	message := websocket.FormatCloseMessage(websocket.CloseNormalClosure, reason)
+	socket.ws.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+	socket.ws.Close()
+}
+
+// OpenTerminal serves an interactive shell, shell output is sent as binary messages and
+// the browser sends entity.TerminalMessage JSON after the initial entity.TerminalConf.
+func OpenTerminal(ctx *gin.Context) {
+	ws, err := aop.UpGrader.Upgrade(ctx.Writer, ctx.Request, nil)
+	if err != nil {
+		logger.GetLogger().Errorf("Create websocket channel failed: %s", err.Error())
+		return
+	}
+	socket := &terminalSocket{ws: ws}
+	var conf entity.TerminalConf
+	if err := ws.ReadJSON(&conf); err != nil {
+		logger.GetLogger().Errorf("Failed to read terminal conf: %s", err.Error())
+		socket.close(err.Error())
+		return
+	}
+	if conf.HostID != 0 {
+		hosts, err := inventoryController.inventoryService.ResolveHosts(entity.HostSelection{HostIDs: []uint{conf.HostID}})
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to resolve inventory host: %s", err.Error())
+			socket.close(err.Error())
+			return
+		}
+		conf.Host = hosts[0]
+	}
+	terminal, err := terminalController.terminalService.Open(conf)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to open terminal on %s: %s", conf.Host.Address, err.Error())
+		socket.close(fmt.Sprintf("failed to open terminal: %s", err.Error()))
+		return
+	}
+	defer terminal.Close()
+	logger.GetLogger().Infof("Terminal opened on %s from %s", conf.Host.Address, ctx.ClientIP())
+
+	var idle *time.Timer
+	idleTimeout := terminalController.terminalService.IdleTimeout()
+	if idleTimeout > 0 {
+		idle = time.AfterFunc(idleTimeout, func() {
+			logger.GetLogger().Infof("Terminal on %s idle for %s, closing", conf.Host.Address, idleTimeout)
+			socket.close("idle timeout")
+			terminal.Close()
+		})
+		defer idle.Stop()
+	}
+
+	go func() {
+		buffer := make([]byte, 32*1024)
+		for {
+			n, err := terminal.Read(buffer)
+			if n > 0 {
+				if writeErr := socket.write(websocket.BinaryMessage, buffer[:n]); writeErr != nil {
+					terminal.Close()
+					return
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		<-terminal.Done()
+		reason := "session closed"
+		if err := terminal.Err(); err != nil {
+			reason = fmt.Sprintf("session closed: %s", err.Error())
+		}
+		socket.close(reason)
+	}()
+
+	for {
+		var message entity.TerminalMessage
+		if err := ws.ReadJSON(&message); err != nil {
+			logger.GetLogger().Infof("Terminal on %s closed: %s", conf.Host.Address, err.Error())
+			return
+		}
+		switch message.Type {
+		case entity.TerminalInput:
+			if idle != nil {
+				idle.Reset(idleTimeout)
+			}
+			if _, err := terminal.Write([]byte(message.Data)); err != nil {
+				logger.GetLogger().Errorf("Failed to write to terminal on %s: %s", conf.Host.Address, err.Error())
+				return
+			}
+		case entity.TerminalResize:
+			if err := terminal.Resize(message.Cols, message.Rows); err != nil {
+				logger.GetLogger().Warnf("Failed to resize terminal on %s: %s", conf.Host.Address, err.Error())
+			}
+		case entity.TerminalPing:
+		default:
+			logger.GetLogger().Warnf("Unknown terminal message type %q", message.Type)
+		}
+	}
+}
@@ -1,45 +1,104 @@
 package db
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/mysql"
 	"github.com/whoisfisher/mykubespray/pkg/logger"
-	"github.com/whoisfisher/mykubespray/pkg/utils"
+	_ "modernc.org/sqlite"
+	"net/url"
+	"os"
+	"path/filepath"
 	"time"
 )
 
 var DB *gorm.DB
 
+// ErrNotConfigured is returned by GetDB when no database is configured.
+var ErrNotConfigured = errors.New("database is not configured")
+
 const phaseName = "db"
 
+// Supported drivers, SQLite is embedded and needs no external service.
+const (
+	DriverMySQL  = "mysql"
+	DriverSQLite = "sqlite"
+)
+
 type InitDBPhase struct {
-	Host         string
-	Port         int
-	Name         string
-	User         string
-	Password     string
+	// Driver is mysql or sqlite, mysql when empty.
+	Driver string
+	Host   string
+	Port   int
+	Name   string
+	User   string
+	// Password of the MySQL user.
+	Password string
+	// Path is the SQLite database file.
+	Path string
+	// Location is the time zone of MySQL DATETIME values, Local when empty.
+	Location     string
 	MaxOpenConns int
 	MaxIdleConns int
 }
 
-func (i *InitDBPhase) Init() error {
-	aesPasswd, er1 := utils.StringEncrypt(i.Password)
-	if er1 != nil {
-		logger.GetLogger().Errorf("Failed to encrypt password: %s", er1.Error())
-		return er1
+// MySQLDSN returns the go-sql-driver DSN of a MySQL database.
+func MySQLDSN(user, password, host string, port int, name, location string) string {
+	if location == "" {
+		location = "Local"
 	}
-	p, err := utils.StringDecrypt(aesPasswd)
-	if err != nil {
-		logger.GetLogger().Errorf("Failed to decrypt password: %s", err.Error())
-		return err
+	return fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8mb4&parseTime=true&loc=%s",
+		user,
+		password,
+		host,
+		port,
+		name,
+		url.QueryEscape(location))
+}
+
+// SQLiteDSN returns the DSN of a SQLite database file, waiting for locks instead of failing with SQLITE_BUSY.
+func SQLiteDSN(path string) string {
+	return path + "?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)"
+}
+
+// PrepareSQLitePath creates the directory of a SQLite database file.
+func PrepareSQLitePath(path string) error {
+	if path == "" {
+		return errors.New("db.path is required for the sqlite driver")
+	}
+	return os.MkdirAll(filepath.Dir(path), 0755)
+}
+
+func (i *InitDBPhase) open() (*gorm.DB, error) {
+	switch i.Driver {
+	case "", DriverMySQL:
+		return gorm.Open("mysql", MySQLDSN(i.User, i.Password, i.Host, i.Port, i.Name, i.Location))
+	case DriverSQLite:
+		if err := PrepareSQLitePath(i.Path); err != nil {
+			return nil, err
+		}
+		sqlDB, err := sql.Open("sqlite", SQLiteDSN(i.Path))
+		if err != nil {
+			return nil, err
+		}
+		// gorm's sqlite3 dialect works with any database/sql driver, the pure Go one needs no cgo.
+		database, err := gorm.Open("sqlite3", sqlDB)
+		if err != nil {
+			sqlDB.Close()
+			return nil, err
+		}
+		// a single writer avoids SQLITE_BUSY between pooled connections.
+		i.MaxOpenConns, i.MaxIdleConns = 1, 1
+		return database, nil
+	default:
+		return nil, fmt.Errorf("unsupported database driver %q", i.Driver)
 	}
-	url := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?charset=utf8&parseTime=true&loc=Asia%%2FShanghai",
-		i.User,
-		p,
-		i.Host,
-		i.Port,
-		i.Name)
-	db, err := gorm.Open("mysql", url)
+}
+
+func (i *InitDBPhase) Init() error {
+	db, err := i.open()
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to open database connection: %s", err.Error())
 		return err
@@ -60,3 +119,24 @@ func (i *InitDBPhase) Init() error {
 func (i *InitDBPhase) PhaseName() string {
 	return phaseName
 }
+
+// Cleanup closes the connection opened by Init.
+func (i *InitDBPhase) Cleanup() {
+	Close()
+}
+
+// GetDB returns the shared connection, APIs that need persistence fail with ErrNotConfigured without one.
+func GetDB() (*gorm.DB, error) {
+	if DB == nil {
+		return nil, ErrNotConfigured
+	}
+	return DB, nil
+}
+
+// Close closes the shared connection.
+func Close() {
+	if DB != nil {
+		DB.Close()
+		DB = nil
+	}
+}
@@ -0,0 +1,20 @@
+package entity
+
+// AnsibleInventory is an Ansible inventory reduced to its hosts and the members of each group,
+// members of child groups are included in their parents.
+type AnsibleInventory struct {
+	Hosts  []Host              `json:"hosts"`
+	Groups map[string][]string `json:"groups"`
+}
+
+// KubesprayExportConf is the cluster to export, secrets are left out of the inventory unless IncludeSecrets is set.
+type KubesprayExportConf struct {
+	KubekeyConf
+	IncludeSecrets bool
+}
+
+// KubesprayInventory is a kubespray hosts.yaml and the offline.yml variables of a cluster.
+type KubesprayInventory struct {
+	HostsYAML   string `json:"hosts_yaml"`
+	OfflineYAML string `json:"offline_yml"`
+}
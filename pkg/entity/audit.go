@@ -0,0 +1,58 @@
+package entity
+
+import "time"
+
+// OperationLog is the audit entry of an API request, Body is the request body with its secrets redacted.
+type OperationLog struct {
+	ID          uint      `json:"id" gorm:"primary_key"`
+	User        string    `json:"user"`
+	ClientIP    string    `json:"client_ip" gorm:"column:client_ip"`
+	Method      string    `json:"method"`
+	Route       string    `json:"route"`
+	Path        string    `json:"path" gorm:"type:text"`
+	Module      string    `json:"module"`
+	Description string    `json:"description"`
+	Body        string    `json:"body" gorm:"type:text"`
+	Status      int       `json:"status"`
+	DurationMs  int64     `json:"duration_ms"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// OperationLogQuery filters operation logs, empty fields match every entry. Pages start at 1.
+type OperationLogQuery struct {
+	User     string
+	Module   string
+	Method   string
+	Route    string
+	Status   int
+	Since    time.Time
+	Until    time.Time
+	Page     int
+	PageSize int
+}
+
+// OperationLogPage is a page of operation logs, newest first, and the number of entries matching the query.
+type OperationLogPage struct {
+	Total    int            `json:"total"`
+	Page     int            `json:"page"`
+	PageSize int            `json:"page_size"`
+	Items    []OperationLog `json:"items"`
+}
+
+// CommandLog is the stored audit record of a remote execution, RecordID is the id the executor gave it.
+type CommandLog struct {
+	ID           uint      `json:"-" gorm:"primary_key"`
+	RecordID     string    `json:"id" gorm:"column:record_id"`
+	Kind         string    `json:"kind"`
+	Host         string    `json:"host"`
+	User         string    `json:"user"`
+	Command      string    `json:"command" gorm:"type:text"`
+	ExitCode     int       `json:"exit_code"`
+	Error        string    `json:"error,omitempty" gorm:"type:text"`
+	StartTime    time.Time `json:"start_time"`
+	EndTime      time.Time `json:"end_time"`
+	DurationMs   int64     `json:"duration_ms"`
+	OutputSize   int64     `json:"output_size"`
+	OutputDigest string    `json:"output_digest"`
+	CastFile     string    `json:"cast_file,omitempty"`
+}
@@ -0,0 +1,86 @@
+package entity
+
+import (
+	"strings"
+	"time"
+)
+
+// Cluster statuses, a cluster is recorded as creating before kk runs.
+const (
+	ClusterCreating = "creating"
+	ClusterRunning  = "running"
+	ClusterFailed   = "failed"
+)
+
+// Cluster is a cluster created by kubekey. Its KubekeyConf and admin kubeconfig contain secrets,
+// they are stored sealed by the vault and Config is returned masked.
+type Cluster struct {
+	ID                uint   `json:"id" gorm:"primary_key"`
+	Name              string `json:"name" gorm:"not null;unique"`
+	KubernetesVersion string `json:"kubernetes_version"`
+	VIPServer         string `json:"vip_server" gorm:"column:vip_server"`
+	Status            string `json:"status"`
+	// Message is the error of the last failed operation.
+	Message        string        `json:"message"`
+	Config         *KubekeyConf  `json:"config,omitempty" gorm:"-"`
+	Nodes          []ClusterNode `json:"nodes" gorm:"-"`
+	HasKubeconfig  bool          `json:"has_kubeconfig" gorm:"-"`
+	ConfigData     string        `json:"-" gorm:"type:text"`
+	KubeconfigData string        `json:"-" gorm:"type:text"`
+	KeyVersion     int           `json:"key_version"`
+	CreatedAt      time.Time     `json:"created_at"`
+	UpdatedAt      time.Time     `json:"updated_at"`
+}
+
+// ClusterNode is a member of a cluster and its roles.
+type ClusterNode struct {
+	ID              uint   `json:"-" gorm:"primary_key"`
+	ClusterID       uint   `json:"-"`
+	Name            string `json:"name"`
+	Address         string `json:"address"`
+	InternalAddress string `json:"internal_address"`
+	ControlPlane    bool   `json:"control_plane"`
+	Etcd            bool   `json:"etcd"`
+	Worker          bool   `json:"worker"`
+}
+
+// Masked returns a copy of the configuration with its secrets replaced by MaskedCredential.
+func (conf KubekeyConf) Masked() KubekeyConf {
+	conf.Hosts = append([]Host{}, conf.Hosts...)
+	for i := range conf.Hosts {
+		conf.Hosts[i] = conf.Hosts[i].Masked()
+	}
+	conf.Registry = conf.Registry.Masked()
+	return conf
+}
+
+// Masked returns a copy of the host with its secrets, and those of its registry and jump hosts, masked.
+func (host Host) Masked() Host {
+	for _, secret := range []*string{&host.Password, &host.Passphrase, &host.BecomePassword} {
+		if *secret != "" {
+			*secret = MaskedCredential
+		}
+	}
+	// PrivateKey may be a path on this server, only PEM content is a secret.
+	if host.PrivateKey != "" && strings.Contains(host.PrivateKey, "PRIVATE KEY") {
+		host.PrivateKey = MaskedCredential
+	}
+	if host.Registry != nil {
+		registry := host.Registry.Masked()
+		host.Registry = &registry
+	}
+	host.ProxyJump = append([]Host{}, host.ProxyJump...)
+	for i := range host.ProxyJump {
+		host.ProxyJump[i] = host.ProxyJump[i].Masked()
+	}
+	host.AuthMethods = nil
+	return host
+}
+
+// Masked returns a copy of the registry with its password masked.
+func (registry Registry) Masked() Registry {
+	if registry.Password != "" {
+		registry.Password = MaskedCredential
+	}
+	return registry
+}
@@ -0,0 +1,42 @@
+package entity
+
+import "time"
+
+// Credential kinds, they decide where a referenced credential is applied.
+const (
+	CredentialPassword       = "password"
+	CredentialPrivateKey     = "private_key"
+	CredentialRegistry       = "registry"
+	CredentialKubeconfig     = "kubeconfig"
+	CredentialKeycloakSecret = "keycloak_client_secret"
+)
+
+// Credential is a secret kept in the vault. Secret and Passphrase are only accepted on writes and never returned,
+// they are stored sealed in Ciphertext under the master key version KeyVersion.
+type Credential struct {
+	ID          uint      `json:"id" gorm:"primary_key"`
+	Name        string    `json:"name" gorm:"not null;unique"`
+	Kind        string    `json:"kind" gorm:"not null"`
+	Username    string    `json:"username"`
+	Description string    `json:"description"`
+	Secret      string    `json:"secret,omitempty" gorm:"-"`
+	Passphrase  string    `json:"passphrase,omitempty" gorm:"-"`
+	Ciphertext  string    `json:"-" gorm:"type:text"`
+	KeyVersion  int       `json:"key_version"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// CredentialSecret is the sealed part of a Credential.
+type CredentialSecret struct {
+	Secret     string `json:"secret"`
+	Passphrase string `json:"passphrase,omitempty"`
+}
+
+// CredentialRotation reports the values re-encrypted under the current master key.
+type CredentialRotation struct {
+	KeyVersion  int `json:"key_version"`
+	Credentials int `json:"credentials"`
+	Hosts       int `json:"hosts"`
+	Clusters    int `json:"clusters"`
+}
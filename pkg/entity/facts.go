@@ -0,0 +1,73 @@
+package entity
+
+import "time"
+
+// HostFacts describes the operating system and hardware of a host, gathered in a single SSH round trip.
+type HostFacts struct {
+	Hostname   string        `json:"hostname"`
+	OS         OSFacts       `json:"os"`
+	Kernel     KernelFacts   `json:"kernel"`
+	Arch       string        `json:"arch"`
+	CPU        CPUFacts      `json:"cpu"`
+	Memory     MemoryFacts   `json:"memory"`
+	Disks      []DiskFacts   `json:"disks"`
+	NICs       []NICFacts    `json:"nics"`
+	TimeSync   TimeSyncFacts `json:"time_sync"`
+	GatheredAt time.Time     `json:"gathered_at"`
+}
+
+// OSFacts comes from /etc/os-release.
+type OSFacts struct {
+	ID         string `json:"id"`
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	PrettyName string `json:"pretty_name"`
+}
+
+type KernelFacts struct {
+	Release string `json:"release"`
+	Version string `json:"version"`
+}
+
+// CPUFacts counts physical sockets and cores and the logical processors (threads) of /proc/cpuinfo.
+type CPUFacts struct {
+	Model   string `json:"model"`
+	Sockets int    `json:"sockets"`
+	Cores   int    `json:"cores"`
+	Threads int    `json:"threads"`
+}
+
+type MemoryFacts struct {
+	TotalBytes     uint64 `json:"total_bytes"`
+	AvailableBytes uint64 `json:"available_bytes"`
+	SwapTotalBytes uint64 `json:"swap_total_bytes"`
+}
+
+// DiskFacts is a block device of lsblk, partitions and logical volumes are its children.
+type DiskFacts struct {
+	Name       string      `json:"name"`
+	Type       string      `json:"type"`
+	SizeBytes  uint64      `json:"size_bytes"`
+	Model      string      `json:"model,omitempty"`
+	Rotational bool        `json:"rotational"`
+	MountPoint string      `json:"mount_point,omitempty"`
+	Children   []DiskFacts `json:"children,omitempty"`
+}
+
+// NICFacts is a network interface, SpeedMbps is 0 when the link speed is unknown.
+type NICFacts struct {
+	Name      string   `json:"name"`
+	MAC       string   `json:"mac"`
+	MTU       int      `json:"mtu"`
+	State     string   `json:"state"`
+	SpeedMbps int      `json:"speed_mbps"`
+	Addresses []string `json:"addresses"`
+}
+
+// TimeSyncFacts is the clock state reported by timedatectl, Available is false on hosts without it.
+type TimeSyncFacts struct {
+	Available    bool   `json:"available"`
+	Synchronized bool   `json:"synchronized"`
+	NTP          bool   `json:"ntp"`
+	Timezone     string `json:"timezone"`
+}
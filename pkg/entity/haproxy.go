@@ -4,4 +4,6 @@ type HaproxyConf struct {
 	Servers    []string
 	StrServers string
 	Host       Host
+	// HostID takes Host from the inventory.
+	HostID uint
 }
@@ -11,6 +11,9 @@ type HelmRepository struct {
 	KeyFile               string `json:"key_file"`
 	CAFile                string `json:"ca_file"`
 	InsecureSkipTlsVerify bool   `json:"insecure_skip_tls_verify"`
+	// RepoCredentialID references a registry credential that provides Username and Password,
+	// the embedded K8sConfig.CredentialID is the kubeconfig of the cluster.
+	RepoCredentialID uint `json:"repo_credential_id"`
 }
 
 type HelmChartInfo struct {
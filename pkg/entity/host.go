@@ -11,7 +11,26 @@ type Host struct {
 	Port            int32
 	Arch            string
 	Registry        *Registry
-	PrivateKey      string
-	AuthMethods     []ssh.AuthMethod
-	IsDeleted       bool
+	// PrivateKey is the PEM content of the key or a path to a key file on this server.
+	PrivateKey string
+	// Passphrase decrypts an encrypted PrivateKey.
+	Passphrase string
+	// Certificate is an OpenSSH user certificate for PrivateKey, in authorized_keys format or as a path.
+	Certificate string
+	// UseAgent also offers the keys of the ssh-agent listening on AgentSocket, SSH_AUTH_SOCK when empty.
+	UseAgent    bool
+	AgentSocket string
+	AuthMethods []ssh.AuthMethod
+	IsDeleted   bool
+	// BecomeMethod is sudo, su or none, sudo is used when empty.
+	BecomeMethod string
+	// BecomeUser defaults to root.
+	BecomeUser string
+	// BecomePassword defaults to Password.
+	BecomePassword string
+	// ProxyJump lists the bastion hosts to go through, in order, each with its own credentials.
+	ProxyJump []Host
+	// InventoryID is the inventory host the settings were resolved from, a recorded cluster keeps
+	// only this reference and resolves the credentials again when it is used.
+	InventoryID uint
 }
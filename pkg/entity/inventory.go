@@ -0,0 +1,100 @@
+package entity
+
+import "time"
+
+// InventoryHost is a host stored in the inventory, its secrets are encrypted by the vault and never returned by the API.
+type InventoryHost struct {
+	ID              uint   `json:"id" gorm:"primary_key"`
+	Name            string `json:"name" gorm:"not null;unique"`
+	Address         string `json:"address" gorm:"not null"`
+	InternalAddress string `json:"internal_address"`
+	Port            int32  `json:"port"`
+	User            string `json:"user"`
+	Password        string `json:"password,omitempty"`
+	PrivateKey      string `json:"private_key,omitempty" gorm:"type:text"`
+	Passphrase      string `json:"passphrase,omitempty"`
+	Certificate     string `json:"certificate,omitempty" gorm:"type:text"`
+	BecomeMethod    string `json:"become_method"`
+	BecomeUser      string `json:"become_user"`
+	BecomePassword  string `json:"become_password,omitempty"`
+	Arch            string `json:"arch"`
+	// CredentialID references a password or private_key credential used instead of the inline secrets.
+	CredentialID uint `json:"credential_id"`
+	// BecomeCredentialID references a password credential used as BecomePassword.
+	BecomeCredentialID uint              `json:"become_credential_id"`
+	Labels             map[string]string `json:"labels" gorm:"-"`
+	Groups             []string          `json:"groups" gorm:"-"`
+	CreatedAt          time.Time         `json:"created_at"`
+	UpdatedAt          time.Time         `json:"updated_at"`
+}
+
+type InventoryHostLabel struct {
+	ID     uint `gorm:"primary_key"`
+	HostID uint
+	Name   string
+	Value  string
+}
+
+type InventoryHostGroup struct {
+	ID     uint `gorm:"primary_key"`
+	HostID uint
+	Name   string
+}
+
+// MaskedCredential stands for a stored secret in responses, sending it back keeps the stored value.
+const MaskedCredential = "******"
+
+// Masked returns a copy of the host with its secrets replaced by MaskedCredential.
+func (host InventoryHost) Masked() InventoryHost {
+	for _, secret := range []*string{&host.Password, &host.PrivateKey, &host.Passphrase, &host.BecomePassword} {
+		if *secret != "" {
+			*secret = MaskedCredential
+		}
+	}
+	return host
+}
+
+// ToHost returns the connection settings of the inventory host.
+func (host InventoryHost) ToHost() Host {
+	port := host.Port
+	if port == 0 {
+		port = 22
+	}
+	return Host{
+		Name:            host.Name,
+		Address:         host.Address,
+		InternalAddress: host.InternalAddress,
+		User:            host.User,
+		Password:        host.Password,
+		Port:            port,
+		Arch:            host.Arch,
+		PrivateKey:      host.PrivateKey,
+		Passphrase:      host.Passphrase,
+		Certificate:     host.Certificate,
+		BecomeMethod:    host.BecomeMethod,
+		BecomeUser:      host.BecomeUser,
+		BecomePassword:  host.BecomePassword,
+		InventoryID:     host.ID,
+	}
+}
+
+// InventoryQuery filters inventory hosts, empty fields match every host.
+type InventoryQuery struct {
+	// Selector is a label selector such as "env=prod,role in (master,etcd),!deprecated".
+	Selector string
+	Group    string
+	Name     string
+}
+
+// HostSelection picks inventory hosts instead of sending their credentials with the request.
+// HostIDs and the hosts matching Selector and Group are combined, inline hosts are kept as well.
+type HostSelection struct {
+	HostIDs  []uint
+	Selector string
+	Group    string
+}
+
+// Empty reports whether no inventory hosts are selected.
+func (selection HostSelection) Empty() bool {
+	return len(selection.HostIDs) == 0 && selection.Selector == "" && selection.Group == ""
+}
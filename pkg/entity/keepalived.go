@@ -11,4 +11,6 @@ type KeepalivedConf struct {
 	StrPeers string
 	VIP      string
 	Host     Host
+	// HostID takes Host from the inventory.
+	HostID uint
 }
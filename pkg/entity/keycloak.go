@@ -13,6 +13,8 @@ type BaseKeycloakConf struct {
 	RedirectURI  string
 	DeviceCode   string
 	GrantType    string
+	// CredentialID references a keycloak_client_secret credential used instead of ClientSecret.
+	CredentialID uint
 }
 
 type GroupConf struct {
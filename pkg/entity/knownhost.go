@@ -0,0 +1,21 @@
+package entity
+
+import "time"
+
+type KnownHost struct {
+	Name        string
+	Address     string
+	Port        int32
+	KeyType     string
+	Fingerprint string
+	PublicKey   string
+	Status      string
+	FirstSeen   time.Time
+	LastSeen    time.Time
+}
+
+type KnownHostConf struct {
+	Address     string
+	Port        int32
+	Fingerprint string
+}
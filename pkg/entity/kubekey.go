@@ -1,6 +1,8 @@
 package entity
 
 type KubekeyConf struct {
+	// ClusterID refers to a recorded cluster, its stored configuration is used and the request only adds to it.
+	ClusterID         uint
 	ClusterName       string
 	Hosts             []Host
 	Etcds             []string
@@ -18,6 +20,8 @@ type KubekeyConf struct {
 	KKPath            string
 	TaichuPackagePath string
 	KubernetesVersion string
+	// HostSelection adds inventory hosts to Hosts.
+	HostSelection
 }
 
 type KubekeyTemplate struct {
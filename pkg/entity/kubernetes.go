@@ -6,6 +6,12 @@ type K8sConfig struct {
 	ApiServer      string
 	Token          string
 	Cacert         string
+	// CredentialID references a kubeconfig credential used instead of Kubeconfig.
+	CredentialID uint
+	// ClusterID uses the admin kubeconfig of a recorded cluster instead of Kubeconfig.
+	ClusterID uint
+	// SSHTunnel, when set, reaches the API server through an SSH local-forward on this host.
+	SSHTunnel *Host
 }
 
 type KubernetesFilesConf struct {
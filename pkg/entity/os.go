@@ -21,6 +21,9 @@ type CertConf struct {
 	Host     Host
 	CertPath string
 	DestPath string
+	Mode     string
+	Owner    string
+	Group    string
 }
 
 type Record struct {
@@ -31,4 +34,7 @@ type Record struct {
 type FileSrcDest struct {
 	SrcFile  string
 	DestFile string
+	Mode     string
+	Owner    string
+	Group    string
 }
@@ -1,22 +1,102 @@
 package entity
 
+// ParallelStrategy controls how an operation rolls over many hosts.
+type ParallelStrategy struct {
+	// Concurrency limits how many hosts run at once, 0 means no limit.
+	Concurrency int
+	// BatchSize runs hosts in serial batches such as "5" or "10%", empty runs a single batch.
+	BatchSize string
+	// MaxFailPercentage aborts the remaining batches once more than this percentage of hosts failed, 0 disables it.
+	MaxFailPercentage int
+	// StopOnFirstFailure starts no further hosts after the first failure.
+	StopOnFirstFailure bool
+}
+
 type AddHostsParallel struct {
+	ParallelStrategy
+	HostSelection
 	Hosts  []Host
 	Record Record
 }
 
 type AddDNSParallel struct {
+	ParallelStrategy
+	HostSelection
 	Hosts []Host
 	DNS   string
 }
 
 type CopyFileParallel struct {
+	ParallelStrategy
+	HostSelection
 	Hosts    []Host
 	SrcFile  string
 	DestFile string
+	Mode     string
+	Owner    string
+	Group    string
+}
+
+type SyncDirectoryParallel struct {
+	ParallelStrategy
+	HostSelection
+	Hosts   []Host
+	SrcDir  string
+	DestDir string
+	// Delete removes remote files that do not exist in SrcDir.
+	Delete bool
+	Owner  string
+	Group  string
+}
+
+type FetchFilesParallel struct {
+	ParallelStrategy
+	HostSelection
+	Hosts []Host
+	// RemoteFiles are absolute files or directories, directories are fetched recursively.
+	RemoteFiles []string
+	// LocalDir receives one subdirectory per host.
+	LocalDir string
+}
+
+type EnsureFileParallel struct {
+	ParallelStrategy
+	HostSelection
+	Hosts   []Host
+	Path    string
+	Content string
+	Mode    string
+	Owner   string
+	Group   string
+	// Backup keeps the previous version next to Path, or in BackupDir when set.
+	Backup    bool
+	BackupDir string
+	// Validate checks the new file before it is activated, %s is replaced by its path.
+	Validate string
+}
+
+type DownloadFiles struct {
+	HostSelection
+	Hosts       []Host
+	RemoteFiles []string
+}
+
+type CopyRemoteToRemote struct {
+	SrcHost  Host
+	SrcFile  string
+	DestHost Host
+	DestFile string
+	Mode     string
+	Owner    string
+	Group    string
+	// SrcHostID and DestHostID take the hosts from the inventory.
+	SrcHostID  uint
+	DestHostID uint
 }
 
 type CommandParallel struct {
+	ParallelStrategy
+	HostSelection
 	Hosts   []Host
 	Command string
 }
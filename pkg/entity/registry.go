@@ -12,4 +12,6 @@ type Registry struct {
 	PlainHttp          bool
 	InsecureRegistries []string
 	NodeName           string
+	// CredentialID references a registry credential that provides User and Password.
+	CredentialID uint
 }
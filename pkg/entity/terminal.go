@@ -0,0 +1,24 @@
+package entity
+
+const (
+	TerminalInput  = "input"
+	TerminalResize = "resize"
+	TerminalPing   = "ping"
+)
+
+// TerminalConf is the first message of a terminal websocket.
+type TerminalConf struct {
+	Host Host
+	// HostID takes Host from the inventory.
+	HostID uint
+	Cols   int
+	Rows   int
+}
+
+// TerminalMessage is sent by the browser, Data carries keystrokes and Cols/Rows the new size.
+type TerminalMessage struct {
+	Type string
+	Data string
+	Cols int
+	Rows int
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt"
+	"github.com/gorilla/websocket"
 	"github.com/spf13/viper"
 	"github.com/whoisfisher/mykubespray/pkg/entity"
 	"net/http"
@@ -31,11 +32,19 @@ func ExtractToken(r *http.Request) string {
 		return tok[7:]
 	}
 
+	// browsers cannot set headers on websockets, so they pass the token as a query parameter.
+	if websocket.IsWebSocketUpgrade(r) {
+		return r.URL.Query().Get("token")
+	}
+
 	return ""
 }
 
 func ExtractTokenMetadata(r *http.Request) (*entity.KubekeyConf, error) {
 	signKey := viper.GetString("jwt.secret")
+	if signKey == "" {
+		return nil, errors.New("jwt secret is not configured")
+	}
 	token, err := VerifyToken(signKey, ExtractToken(r))
 	if err != nil {
 		return nil, err
@@ -58,3 +67,29 @@ func ExtractTokenMetadata(r *http.Request) (*entity.KubekeyConf, error) {
 
 	return nil, errors.New("token is invalid")
 }
+
+// usernameClaims are tried in order to name the user of a token.
+var usernameClaims = []string{"preferred_username", "username", "name", "sub"}
+
+// ExtractUsername returns the user of a valid token in the request, empty without one.
+func ExtractUsername(r *http.Request) string {
+	signKey := viper.GetString("jwt.secret")
+	tokenString := ExtractToken(r)
+	if signKey == "" || tokenString == "" {
+		return ""
+	}
+	token, err := VerifyToken(signKey, tokenString)
+	if err != nil || !token.Valid {
+		return ""
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	for _, claim := range usernameClaims {
+		if username, ok := claims[claim].(string); ok && username != "" {
+			return username
+		}
+	}
+	return ""
+}
@@ -4,8 +4,13 @@ import (
 	"errors"
 	"fmt"
 	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/mysql"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/whoisfisher/mykubespray/pkg/db"
 	"github.com/whoisfisher/mykubespray/pkg/logger"
 	"github.com/whoisfisher/mykubespray/pkg/utils"
+	"path/filepath"
 )
 
 const (
@@ -27,36 +32,57 @@ var migrationDirs = []string{
 }
 
 type InitMigrateDBPhase struct {
+	// Driver is mysql or sqlite, mysql when empty. Each driver has its own copy of the migrations in a subdirectory.
+	Driver   string
 	Host     string
 	Port     int
 	Name     string
 	User     string
 	Password string
+	// Path is the SQLite database file.
+	Path string
+	// Location is the time zone of MySQL DATETIME values, Local when empty.
+	Location string
+	// Dir overrides the migration directories that are searched.
+	Dir string
 }
 
-func (i *InitMigrateDBPhase) Init() error {
-	aesPasswd, er1 := utils.StringEncrypt(i.Password)
-	if er1 != nil {
-		return er1
+func (i *InitMigrateDBPhase) databaseURL() (string, error) {
+	switch i.Driver {
+	case "", db.DriverMySQL:
+		return "mysql://" + db.MySQLDSN(i.User, i.Password, i.Host, i.Port, i.Name, i.Location) + "&multiStatements=true", nil
+	case db.DriverSQLite:
+		if err := db.PrepareSQLitePath(i.Path); err != nil {
+			return "", err
+		}
+		return "sqlite://" + db.SQLiteDSN(i.Path), nil
+	default:
+		return "", fmt.Errorf("unsupported database driver %q", i.Driver)
 	}
-	p, err := utils.StringDecrypt(aesPasswd)
+}
+
+func (i *InitMigrateDBPhase) Init() error {
+	url, err := i.databaseURL()
 	if err != nil {
 		return err
 	}
-	url := fmt.Sprintf("mysql://%s:%s@tcp(%s:%d)/%s?charset=utf8&parseTime=true&loc=Asia%%2FShanghai",
-		i.User,
-		p,
-		i.Host,
-		i.Port,
-		i.Name)
+	driver := i.Driver
+	if driver == "" {
+		driver = db.DriverMySQL
+	}
+	dirs := migrationDirs
+	if i.Dir != "" {
+		dirs = []string{i.Dir}
+	}
 	var path string
-	for _, d := range migrationDirs {
-		if utils.Exists(d) {
-			path = d
+	for _, d := range dirs {
+		if utils.Exists(filepath.Join(d, driver)) {
+			path = filepath.Join(d, driver)
+			break
 		}
 	}
 	if path == "" {
-		return fmt.Errorf("can not find migration in [%s,%s]", localMigrationDir, releaseMigrationDir)
+		return fmt.Errorf("can not find %s migration in %v", driver, dirs)
 	}
 	filePath := fmt.Sprintf("file://%s", path)
 	m, err := migrate.New(
@@ -74,11 +100,6 @@ func (i *InitMigrateDBPhase) Init() error {
 		}
 		return err
 	}
-	_, err = utils.StringEncrypt(DefaultPassword)
-	if err != nil {
-		logger.GetLogger().Errorf("Failed to init default user: %s", err.Error())
-		return fmt.Errorf("can not init default user")
-	}
 	//if !(v > 0) {
 	//	if err := db.DB.Model(&model.User{}).Where("name = ?", "admin").Updates(map[string]interface{}{"Password": dp}).Error; err != nil {
 	//		logger.Log.Errorf("Failed to  update default use: %s", err.Error())
@@ -42,6 +42,7 @@ func configWebsocketRouter(rg *gin.RouterGroup) {
 	rg.GET("/cluster/delete", controller.DeleteCluster)
 	rg.GET("/cluster/nodes/add", controller.AddNodeToCluster)
 	rg.GET("/cluster/node/delete", controller.DeleteNodeFromCluster)
+	rg.GET("/terminal", aop.Auth(), controller.OpenTerminal)
 }
 
 func configHttpRouter(rg *gin.RouterGroup, version string) {
@@ -64,6 +65,11 @@ func configHttpRouter(rg *gin.RouterGroup, version string) {
 	rg.POST("/server/hosts", controller.AddHosts)
 	rg.POST("/server/cert/copy", controller.CopyFile)
 	rg.POST("/server/cert/copyparallel", controller.CopyFileParallel)
+	rg.POST("/server/dir/syncparallel", controller.SyncDirectoryParallel)
+	rg.POST("/server/file/fetchparallel", controller.FetchFilesParallel)
+	rg.POST("/server/file/download", controller.DownloadFiles)
+	rg.POST("/server/file/copyremote", controller.CopyRemoteToRemote)
+	rg.POST("/server/file/ensureparallel", controller.EnsureFileParallel)
 	rg.POST("/server/hostsparallel", controller.AddHostsParallel)
 	rg.POST("/server/dnsparallel", controller.AddDNSParallel)
 	rg.POST("/server/execmdparallel", controller.ExecuteCommandParallel)
@@ -72,4 +78,29 @@ func configHttpRouter(rg *gin.RouterGroup, version string) {
 	rg.POST("/kubernetes/apply", controller.ApplyYAMLs)
 	rg.POST("/kubernetes/helm/repo", controller.AddRepo)
 	rg.POST("/kubernetes/helm/chart", controller.InstallChart)
+	rg.GET("/knownhosts", controller.ListKnownHosts)
+	rg.POST("/knownhosts/accept", controller.AcceptKnownHost)
+	rg.POST("/knownhosts/revoke", controller.RevokeKnownHost)
+	rg.GET("/pool/stats", controller.PoolStats)
+	rg.GET("/hosts", controller.ListInventoryHosts)
+	rg.POST("/hosts", controller.CreateInventoryHost)
+	rg.POST("/hosts/import", controller.ImportInventoryHosts)
+	rg.GET("/hosts/:id", controller.GetInventoryHost)
+	rg.GET("/hosts/:id/facts", controller.GetInventoryHostFacts)
+	rg.PUT("/hosts/:id", controller.UpdateInventoryHost)
+	rg.DELETE("/hosts/:id", controller.DeleteInventoryHost)
+	rg.POST("/kubekey/inventory/export", controller.ExportKubesprayInventory)
+	rg.GET("/clusters", controller.ListClusters)
+	rg.GET("/clusters/:id", controller.GetCluster)
+	rg.GET("/clusters/:id/kubeconfig", controller.GetClusterKubeconfig)
+	rg.DELETE("/clusters/:id", controller.DeleteClusterRecord)
+	rg.GET("/credentials", controller.ListCredentials)
+	rg.POST("/credentials", controller.CreateCredential)
+	rg.POST("/credentials/rotate", controller.RotateCredentials)
+	rg.GET("/credentials/:id", controller.GetCredential)
+	rg.PUT("/credentials/:id", controller.UpdateCredential)
+	rg.DELETE("/credentials/:id", controller.DeleteCredential)
+	rg.GET("/audit", controller.ListOperationLogs)
+	rg.GET("/audit/commands", controller.ListCommandRecords)
+	rg.GET("/audit/commands/:id/cast", controller.DownloadCast)
 }
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"time"
+)
+
+// Phase is a startup step such as db.InitDBPhase, phases run in order and stop the startup when they fail.
+type Phase interface {
+	Init() error
+	PhaseName() string
+}
+
+// Cleaner is a phase holding resources, cleanups run in the reverse order of the phases on shutdown.
+type Cleaner interface {
+	Cleanup()
+}
+
+// PhaseFactory builds a phase once the phases before it ran and the configuration is read,
+// it returns nil when the phase has nothing to do.
+type PhaseFactory func(server *Server) Phase
+
+// ConfigPhase reads the configuration, it always runs first.
+const ConfigPhase = "config"
+
+// DefaultPhases run after ConfigPhase when startup.phases is not configured.
+var DefaultPhases = []string{"logger", "migrate", "db", "pools", "schedulers", "http"}
+
+var phaseFactories = map[string]PhaseFactory{}
+
+// RegisterPhase makes a phase available to startup.phases.
+func RegisterPhase(name string, factory PhaseFactory) {
+	phaseFactories[name] = factory
+}
+
+type PhaseRunner struct {
+	Server *Server
+	// Phases run after ConfigPhase, startup.phases or DefaultPhases when empty.
+	Phases   []string
+	cleanups Functions
+}
+
+func NewPhaseRunner(server *Server, phases ...string) *PhaseRunner {
+	return &PhaseRunner{Server: server, Phases: phases}
+}
+
+// Run runs the phases and returns the cleanup of those that ran. When a phase that is not listed in
+// startup.optional fails, the phases that already ran are cleaned up and its error is returned.
+func (runner *PhaseRunner) Run() (func(), error) {
+	if err := runner.run(ConfigPhase, false); err != nil {
+		return runner.cleanups.Ret(), err
+	}
+	phases := runner.Phases
+	if len(phases) == 0 {
+		phases = viper.GetStringSlice("startup.phases")
+	}
+	if len(phases) == 0 {
+		phases = DefaultPhases
+	}
+	optional := map[string]bool{}
+	for _, name := range viper.GetStringSlice("startup.optional") {
+		optional[name] = true
+	}
+	for _, name := range phases {
+		if name == ConfigPhase {
+			continue
+		}
+		if err := runner.run(name, optional[name]); err != nil {
+			runner.cleanups.Ret()()
+			return func() {}, err
+		}
+	}
+	return runner.cleanups.Ret(), nil
+}
+
+func (runner *PhaseRunner) run(name string, optional bool) error {
+	factory, exists := phaseFactories[name]
+	if !exists {
+		return fmt.Errorf("unknown startup phase %q", name)
+	}
+	start := time.Now()
+	phase := factory(runner.Server)
+	if phase == nil {
+		logger.GetLogger().Infof("Startup phase %s skipped", name)
+		return nil
+	}
+	if err := phase.Init(); err != nil {
+		if optional {
+			logger.GetLogger().Warnf("Optional startup phase %s failed after %s: %s", name, time.Since(start), err.Error())
+			return nil
+		}
+		logger.GetLogger().Errorf("Startup phase %s failed after %s: %s", name, time.Since(start), err.Error())
+		return fmt.Errorf("startup phase %s: %w", name, err)
+	}
+	if cleaner, ok := phase.(Cleaner); ok && !withoutCleanup(phase) {
+		runner.cleanups.Add(func() {
+			start := time.Now()
+			cleaner.Cleanup()
+			logger.GetLogger().Infof("Cleaned up startup phase %s in %s", name, time.Since(start))
+		})
+	}
+	logger.GetLogger().Infof("Startup phase %s done in %s", name, time.Since(start))
+	return nil
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"errors"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPhaseRunner(t *testing.T) {
+	config := filepath.Join(t.TempDir(), "config.yaml")
+	data := "startup:\n  phases: [first, broken, second]\n  optional: [broken]\n"
+	if err := os.WriteFile(config, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer viper.Reset()
+
+	var events []string
+	phase := func(name string, err error) PhaseFactory {
+		return func(server *Server) Phase {
+			return &funcPhase{
+				name:    name,
+				init:    func() error { events = append(events, "init "+name); return err },
+				cleanup: func() { events = append(events, "cleanup "+name) },
+			}
+		}
+	}
+	RegisterPhase("first", phase("first", nil))
+	RegisterPhase("broken", phase("broken", errors.New("unavailable")))
+	RegisterPhase("second", phase("second", nil))
+	RegisterPhase("skipped", func(server *Server) Phase { return nil })
+
+	cleanup, err := NewPhaseRunner(&Server{ConfigFile: config}).Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup()
+	expected := []string{"init first", "init broken", "init second", "cleanup second", "cleanup first"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("unexpected phases %q", events)
+	}
+
+	events = nil
+	if _, err := NewPhaseRunner(&Server{ConfigFile: config}, "first", "skipped", "broken", "second").Run(); err != nil {
+		t.Fatalf("expected startup.optional to apply to explicit phases: %v", err)
+	}
+	events = nil
+	viper.Set("startup.optional", []string{})
+	if _, err := NewPhaseRunner(&Server{ConfigFile: config}, "first", "broken", "second").Run(); err == nil {
+		t.Fatal("expected a required phase to fail the startup")
+	}
+	if expected := []string{"init first", "init broken", "cleanup first"}; !reflect.DeepEqual(events, expected) {
+		t.Fatalf("expected the phases that ran to be cleaned up, got %q", events)
+	}
+	if _, err := NewPhaseRunner(&Server{ConfigFile: config}, "missing").Run(); err == nil {
+		t.Fatal("expected an unknown phase to fail")
+	}
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"github.com/spf13/viper"
+	"github.com/whoisfisher/mykubespray/pkg/db"
+	"github.com/whoisfisher/mykubespray/pkg/httpx"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"github.com/whoisfisher/mykubespray/pkg/migrate"
+	"github.com/whoisfisher/mykubespray/pkg/router"
+	"github.com/whoisfisher/mykubespray/pkg/service"
+	"github.com/whoisfisher/mykubespray/pkg/utils"
+	"net/http"
+)
+
+func init() {
+	RegisterPhase(ConfigPhase, func(server *Server) Phase {
+		return &funcPhase{name: ConfigPhase, init: server.ReadConfig}
+	})
+	RegisterPhase("logger", func(server *Server) Phase {
+		return &funcPhase{name: "logger", init: logger.Init}
+	})
+	RegisterPhase("migrate", newMigratePhase)
+	RegisterPhase("db", newDBPhase)
+	RegisterPhase("pools", func(server *Server) Phase {
+		return &funcPhase{
+			name: "pools",
+			init: func() error {
+				utils.GetAuditLog().SetSink(service.NewAuditService().RecordCommand)
+				utils.GetSSHExecutorPool()
+				return nil
+			},
+			cleanup: func() { utils.GetSSHExecutorPool().Close() },
+		}
+	})
+	RegisterPhase("schedulers", func(server *Server) Phase {
+		return NewScheduler(scheduledJobs()...)
+	})
+	RegisterPhase("http", newHTTPPhase)
+}
+
+// funcPhase adapts plain functions to a Phase.
+type funcPhase struct {
+	name    string
+	init    func() error
+	cleanup func()
+}
+
+func (phase *funcPhase) Init() error {
+	return phase.init()
+}
+
+func (phase *funcPhase) PhaseName() string {
+	return phase.name
+}
+
+// Cleanup is only registered when the phase set a cleanup function.
+func (phase *funcPhase) Cleanup() {
+	phase.cleanup()
+}
+
+// withoutCleanup reports whether phase is a funcPhase that set no cleanup function.
+func withoutCleanup(phase Phase) bool {
+	adapted, ok := phase.(*funcPhase)
+	return ok && adapted.cleanup == nil
+}
+
+// dbConfigured reports whether db.driver can be used, the mysql driver needs db.host.
+func dbConfigured() bool {
+	driver := viper.GetString("db.driver")
+	return !((driver == "" || driver == db.DriverMySQL) && viper.GetString("db.host") == "")
+}
+
+func newMigratePhase(server *Server) Phase {
+	if !dbConfigured() {
+		return nil
+	}
+	return &migrate.InitMigrateDBPhase{
+		Driver:   viper.GetString("db.driver"),
+		Host:     viper.GetString("db.host"),
+		Port:     viper.GetInt("db.port"),
+		Name:     viper.GetString("db.name"),
+		User:     viper.GetString("db.user"),
+		Password: viper.GetString("db.password"),
+		Path:     viper.GetString("db.path"),
+		Location: viper.GetString("db.location"),
+	}
+}
+
+// newDBPhase opens the database selected by db.driver. The sqlite driver needs only db.path,
+// the mysql driver is skipped when db.host is not configured.
+func newDBPhase(server *Server) Phase {
+	if !dbConfigured() {
+		logger.GetLogger().Warn("db.host is not configured, persistent APIs such as the host inventory are disabled")
+		return nil
+	}
+	return &db.InitDBPhase{
+		Driver:       viper.GetString("db.driver"),
+		Host:         viper.GetString("db.host"),
+		Port:         viper.GetInt("db.port"),
+		Name:         viper.GetString("db.name"),
+		User:         viper.GetString("db.user"),
+		Password:     viper.GetString("db.password"),
+		Path:         viper.GetString("db.path"),
+		Location:     viper.GetString("db.location"),
+		MaxOpenConns: viper.GetInt("db.max_open_conns"),
+		MaxIdleConns: viper.GetInt("db.max_idle_conns"),
+	}
+}
+
+func newHTTPPhase(server *Server) Phase {
+	phase := &funcPhase{name: "http"}
+	phase.init = func() error {
+		route := router.New(server.Version)
+		go func() {
+			err := http.ListenAndServe(":6060", nil)
+			if err != nil {
+				logger.GetLogger().Errorf("Failed to bind 6060 debug info: %s", err.Error())
+				return
+			}
+		}()
+		phase.cleanup = httpx.Init(route)
+		return nil
+	}
+	return phase
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"github.com/spf13/viper"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"github.com/whoisfisher/mykubespray/pkg/service"
+	"github.com/whoisfisher/mykubespray/pkg/utils"
+	"sync"
+	"time"
+)
+
+// ScheduledJob runs every Interval, a job without an interval is disabled.
+type ScheduledJob struct {
+	Name     string
+	Interval time.Duration
+	Run      func() error
+}
+
+// Scheduler is the schedulers phase, it runs its jobs in the background until it is cleaned up.
+type Scheduler struct {
+	jobs []ScheduledJob
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewScheduler(jobs ...ScheduledJob) *Scheduler {
+	return &Scheduler{jobs: jobs, done: make(chan struct{})}
+}
+
+// scheduledJobs returns the jobs of the schedulers phase.
+func scheduledJobs() []ScheduledJob {
+	return []ScheduledJob{
+		{Name: "prune-facts", Interval: viper.GetDuration("schedulers.facts_prune_interval"), Run: func() error {
+			utils.PruneFacts()
+			return nil
+		}},
+		{Name: "prune-operation-logs", Interval: viper.GetDuration("schedulers.operation_log_prune_interval"), Run: pruneOperationLogs},
+		{Name: "prune-command-logs", Interval: viper.GetDuration("schedulers.command_log_prune_interval"), Run: pruneCommandLogs},
+	}
+}
+
+func (scheduler *Scheduler) Init() error {
+	for _, job := range scheduler.jobs {
+		if job.Interval <= 0 {
+			logger.GetLogger().Infof("Scheduled job %s is disabled", job.Name)
+			continue
+		}
+		scheduler.wg.Add(1)
+		go scheduler.loop(job)
+	}
+	return nil
+}
+
+func (scheduler *Scheduler) PhaseName() string {
+	return "schedulers"
+}
+
+func (scheduler *Scheduler) loop(job ScheduledJob) {
+	defer scheduler.wg.Done()
+	ticker := time.NewTicker(job.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-scheduler.done:
+			return
+		case <-ticker.C:
+			if err := job.Run(); err != nil {
+				logger.GetLogger().Errorf("Scheduled job %s failed: %s", job.Name, err.Error())
+			}
+		}
+	}
+}
+
+// Cleanup stops the jobs and waits for running ones to return.
+func (scheduler *Scheduler) Cleanup() {
+	close(scheduler.done)
+	scheduler.wg.Wait()
+}
+
+func pruneOperationLogs() error {
+	retention := viper.GetDuration("audit.operation_retention")
+	if retention <= 0 {
+		return nil
+	}
+	pruned, err := service.NewAuditService().PruneOperations(time.Now().Add(-retention))
+	if err != nil {
+		return err
+	}
+	if pruned > 0 {
+		logger.GetLogger().Infof("Pruned %d operation logs older than %s", pruned, retention)
+	}
+	return nil
+}
+
+func pruneCommandLogs() error {
+	retention := viper.GetDuration("audit.command_retention")
+	if retention <= 0 {
+		return nil
+	}
+	pruned, err := service.NewAuditService().PruneCommands(time.Now().Add(-retention))
+	if err != nil {
+		return err
+	}
+	if pruned > 0 {
+		logger.GetLogger().Infof("Pruned %d command records older than %s", pruned, retention)
+	}
+	return nil
+}
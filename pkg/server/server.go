@@ -1,13 +1,8 @@
 package server
 
 import (
-	"context"
 	"fmt"
 	"github.com/spf13/viper"
-	"github.com/whoisfisher/mykubespray/pkg/httpx"
-	"github.com/whoisfisher/mykubespray/pkg/logger"
-	"github.com/whoisfisher/mykubespray/pkg/router"
-	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -46,11 +41,9 @@ func Run(options ...ServerOption) {
 	for _, option := range options {
 		option(&server)
 	}
-	server.ReadConfig()
-	logger.Init()
 	cleanFunc, err := server.initialize()
 	if err != nil {
-		fmt.Println("server init fail")
+		fmt.Println("server init fail:", err)
 		os.Exit(code)
 	}
 EXIT:
@@ -83,21 +76,9 @@ func (server Server) ReadConfig() error {
 
 }
 
+// initialize runs the startup phases, see PhaseRunner.
 func (server Server) initialize() (func(), error) {
-	fns := Functions{}
-	_, cancel := context.WithCancel(context.Background())
-	fns.Add(cancel)
-	route := router.New(server.Version)
-	go func() {
-		err := http.ListenAndServe(":6060", nil)
-		if err != nil {
-			logger.GetLogger().Errorf("Failed to bind 6060 debug info: %s", err.Error())
-			return
-		}
-	}()
-	httpClean := httpx.Init(route)
-	fns.Add(httpClean)
-	return fns.Ret(), nil
+	return NewPhaseRunner(&server).Run()
 }
 
 type Functions struct {
@@ -108,9 +89,10 @@ func (fs *Functions) Add(f func()) {
 	fs.List = append(fs.List, f)
 }
 
+// Ret returns a function calling the added functions in reverse order.
 func (fs *Functions) Ret() func() {
 	return func() {
-		for i := 0; i < len(fs.List); i++ {
+		for i := len(fs.List) - 1; i >= 0; i-- {
 			fs.List[i]()
 		}
 	}
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"errors"
+	"github.com/jinzhu/gorm"
+	"github.com/whoisfisher/mykubespray/pkg/db"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"github.com/whoisfisher/mykubespray/pkg/utils"
+	"time"
+)
+
+const (
+	defaultOperationPageSize = 20
+	maxOperationPageSize     = 500
+)
+
+type AuditService interface {
+	RecordCommand(record utils.CommandRecord) error
+	ListCommands(query utils.AuditQuery) ([]utils.CommandRecord, error)
+	PruneCommands(before time.Time) (int64, error)
+	CastFile(id string) (string, error)
+	RecordOperation(operation entity.OperationLog) error
+	ListOperations(query entity.OperationLogQuery) (*entity.OperationLogPage, error)
+	PruneOperations(before time.Time) (int64, error)
+}
+
+type auditService struct {
+}
+
+func NewAuditService() auditService {
+	return auditService{}
+}
+
+// RecordCommand stores a command record, it is dropped when no database is configured.
+func (as auditService) RecordCommand(record utils.CommandRecord) error {
+	database, err := db.GetDB()
+	if errors.Is(err, db.ErrNotConfigured) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	command := toCommandLog(record)
+	if err := database.Create(&command).Error; err != nil {
+		logger.GetLogger().Errorf("Failed to record command %s on %s: %s", record.ID, record.Host, err.Error())
+		return err
+	}
+	return nil
+}
+
+// ListCommands returns the matching command records newest first, from the database when one is configured
+// and otherwise from the records kept in memory.
+func (as auditService) ListCommands(query utils.AuditQuery) ([]utils.CommandRecord, error) {
+	database, err := db.GetDB()
+	if errors.Is(err, db.ErrNotConfigured) {
+		return utils.GetAuditLog().Query(query), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	scope := database.Model(&entity.CommandLog{})
+	for column, value := range map[string]string{"host": query.Host, "user": query.User} {
+		if value != "" {
+			scope = scope.Where("`"+column+"` = ?", value)
+		}
+	}
+	if !query.Since.IsZero() {
+		scope = scope.Where("start_time >= ?", query.Since)
+	}
+	if !query.Until.IsZero() {
+		scope = scope.Where("start_time <= ?", query.Until)
+	}
+	if query.Limit > 0 {
+		scope = scope.Limit(query.Limit)
+	}
+	var commands []entity.CommandLog
+	if err := scope.Order("start_time desc, id desc").Find(&commands).Error; err != nil {
+		logger.GetLogger().Errorf("Failed to list command records: %s", err.Error())
+		return nil, err
+	}
+	records := make([]utils.CommandRecord, 0, len(commands))
+	for _, command := range commands {
+		records = append(records, toCommandRecord(command))
+	}
+	return records, nil
+}
+
+// PruneCommands deletes the stored command records started before before.
+func (as auditService) PruneCommands(before time.Time) (int64, error) {
+	database, err := db.GetDB()
+	if errors.Is(err, db.ErrNotConfigured) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	result := database.Where("start_time < ?", before).Delete(&entity.CommandLog{})
+	return result.RowsAffected, result.Error
+}
+
+// getCommand looks record id up in memory and then in the database.
+func (as auditService) getCommand(id string) (utils.CommandRecord, error) {
+	if record, ok := utils.GetAuditLog().Get(id); ok {
+		return record, nil
+	}
+	database, err := db.GetDB()
+	if errors.Is(err, db.ErrNotConfigured) {
+		return utils.CommandRecord{}, errors.New("audit record not found")
+	}
+	if err != nil {
+		return utils.CommandRecord{}, err
+	}
+	var command entity.CommandLog
+	if err := database.Where("record_id = ?", id).First(&command).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return utils.CommandRecord{}, errors.New("audit record not found")
+		}
+		return utils.CommandRecord{}, err
+	}
+	return toCommandRecord(command), nil
+}
+
+// CastFile returns the path of the session recording of record id.
+func (as auditService) CastFile(id string) (string, error) {
+	record, err := as.getCommand(id)
+	if err != nil {
+		return "", err
+	}
+	if record.CastFile == "" {
+		return "", errors.New("no session recording for this record")
+	}
+	return utils.CastPath(record.CastFile), nil
+}
+
+// RecordOperation stores an operation log, it is dropped when no database is configured.
+func (as auditService) RecordOperation(operation entity.OperationLog) error {
+	database, err := db.GetDB()
+	if errors.Is(err, db.ErrNotConfigured) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	operation.ID = 0
+	if err := database.Create(&operation).Error; err != nil {
+		logger.GetLogger().Errorf("Failed to record operation %s %s: %s", operation.Method, operation.Path, err.Error())
+		return err
+	}
+	return nil
+}
+
+// ListOperations returns a page of the operation logs matching query, newest first.
+func (as auditService) ListOperations(query entity.OperationLogQuery) (*entity.OperationLogPage, error) {
+	database, err := db.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	if query.Page < 1 {
+		query.Page = 1
+	}
+	if query.PageSize < 1 {
+		query.PageSize = defaultOperationPageSize
+	}
+	if query.PageSize > maxOperationPageSize {
+		query.PageSize = maxOperationPageSize
+	}
+	scope := database.Model(&entity.OperationLog{})
+	for column, value := range map[string]string{"user": query.User, "module": query.Module, "method": query.Method, "route": query.Route} {
+		if value != "" {
+			scope = scope.Where("`"+column+"` = ?", value)
+		}
+	}
+	if query.Status != 0 {
+		scope = scope.Where("status = ?", query.Status)
+	}
+	if !query.Since.IsZero() {
+		scope = scope.Where("created_at >= ?", query.Since)
+	}
+	if !query.Until.IsZero() {
+		scope = scope.Where("created_at <= ?", query.Until)
+	}
+	page := &entity.OperationLogPage{Page: query.Page, PageSize: query.PageSize, Items: []entity.OperationLog{}}
+	if err := scope.Count(&page.Total).Error; err != nil {
+		logger.GetLogger().Errorf("Failed to count operation logs: %s", err.Error())
+		return nil, err
+	}
+	err = scope.Order("created_at desc, id desc").Offset((query.Page - 1) * query.PageSize).Limit(query.PageSize).Find(&page.Items).Error
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to list operation logs: %s", err.Error())
+		return nil, err
+	}
+	return page, nil
+}
+
+// PruneOperations deletes the operation logs created before before.
+func (as auditService) PruneOperations(before time.Time) (int64, error) {
+	database, err := db.GetDB()
+	if errors.Is(err, db.ErrNotConfigured) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	result := database.Where("created_at < ?", before).Delete(&entity.OperationLog{})
+	return result.RowsAffected, result.Error
+}
+
+func toCommandLog(record utils.CommandRecord) entity.CommandLog {
+	return entity.CommandLog{
+		RecordID:     record.ID,
+		Kind:         record.Kind,
+		Host:         record.Host,
+		User:         record.User,
+		Command:      record.Command,
+		ExitCode:     record.ExitCode,
+		Error:        record.Error,
+		StartTime:    record.StartTime,
+		EndTime:      record.EndTime,
+		DurationMs:   record.DurationMs,
+		OutputSize:   record.OutputSize,
+		OutputDigest: record.OutputDigest,
+		CastFile:     record.CastFile,
+	}
+}
+
+func toCommandRecord(command entity.CommandLog) utils.CommandRecord {
+	return utils.CommandRecord{
+		ID:           command.RecordID,
+		Kind:         command.Kind,
+		Host:         command.Host,
+		User:         command.User,
+		Command:      command.Command,
+		ExitCode:     command.ExitCode,
+		Error:        command.Error,
+		StartTime:    command.StartTime,
+		EndTime:      command.EndTime,
+		DurationMs:   command.DurationMs,
+		OutputSize:   command.OutputSize,
+		OutputDigest: command.OutputDigest,
+		CastFile:     command.CastFile,
+	}
+}
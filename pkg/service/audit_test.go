@@ -0,0 +1,96 @@
+package service
+
+import (
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/utils"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOperationLogs(t *testing.T) {
+	newTestDB(t)
+	audit := NewAuditService()
+	start := time.Now().Add(-2 * time.Hour)
+	for i, operation := range []entity.OperationLog{
+		{User: "alice", Method: "POST", Route: "/api/v1/hosts", Module: "inventory", Status: 200},
+		{User: "bob", Method: "DELETE", Route: "/api/v1/hosts/:id", Module: "inventory", Status: 404},
+		{User: "alice", Method: "POST", Route: "/api/v1/credentials", Module: "credential", Status: 200},
+	} {
+		operation.CreatedAt = start.Add(time.Duration(i) * time.Hour)
+		if err := audit.RecordOperation(operation); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	page, err := audit.ListOperations(entity.OperationLogQuery{User: "alice", PageSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 2 || len(page.Items) != 1 || page.Items[0].Module != "credential" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	page, err = audit.ListOperations(entity.OperationLogQuery{User: "alice", Page: 2, PageSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Items[0].Route != "/api/v1/hosts" {
+		t.Fatalf("unexpected second page %+v", page)
+	}
+	page, err = audit.ListOperations(entity.OperationLogQuery{Module: "inventory", Status: 404, Since: start.Add(30 * time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || page.Items[0].User != "bob" || page.PageSize != defaultOperationPageSize {
+		t.Fatalf("unexpected filtered page %+v", page)
+	}
+
+	pruned, err := audit.PruneOperations(start.Add(90 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 2 {
+		t.Fatalf("expected 2 pruned operation logs, got %d", pruned)
+	}
+}
+
+func TestCommandLogs(t *testing.T) {
+	newTestDB(t)
+	audit := NewAuditService()
+	start := time.Now().Add(-2 * time.Hour).Truncate(time.Second)
+	for i, record := range []utils.CommandRecord{
+		{ID: "cmd-1", Kind: utils.AuditKindCommand, Host: "10.0.0.1", User: "root", Command: "uptime"},
+		{ID: "cmd-2", Kind: utils.AuditKindTerminal, Host: "10.0.0.2", User: "ops", Command: "interactive shell", CastFile: "cmd-2.cast"},
+	} {
+		record.StartTime = start.Add(time.Duration(i) * time.Hour)
+		if err := audit.RecordCommand(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records, err := audit.ListCommands(utils.AuditQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].ID != "cmd-2" || !records[1].StartTime.Equal(start) {
+		t.Fatalf("expected the stored records newest first, got %+v", records)
+	}
+	records, err = audit.ListCommands(utils.AuditQuery{Host: "10.0.0.1", Until: start.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Command != "uptime" {
+		t.Fatalf("unexpected filtered records %+v", records)
+	}
+	if file, err := audit.CastFile("cmd-2"); err != nil || filepath.Base(file) != "cmd-2.cast" {
+		t.Fatalf("expected the cast of a stored record, got %q, %v", file, err)
+	}
+
+	pruned, err := audit.PruneCommands(start.Add(30 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pruned != 1 {
+		t.Fatalf("expected 1 pruned command record, got %d", pruned)
+	}
+}
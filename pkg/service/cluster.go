@@ -0,0 +1,449 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"github.com/whoisfisher/mykubespray/pkg/db"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"github.com/whoisfisher/mykubespray/pkg/utils/vault"
+	"strings"
+)
+
+var ErrClusterNotFound = errors.New("cluster not found")
+
+type ClusterService interface {
+	ListClusters() ([]entity.Cluster, error)
+	GetCluster(id uint) (*entity.Cluster, error)
+	DeleteCluster(id uint) error
+	ClusterKubeconfig(id uint) (string, error)
+	ResolveClusterConf(conf entity.KubekeyConf) (entity.KubekeyConf, error)
+	RecordCluster(conf entity.KubekeyConf, status string, cause error) (*entity.Cluster, error)
+	SetClusterKubeconfig(id uint, kubeconfig string) error
+	ForgetCluster(conf entity.KubekeyConf) error
+}
+
+type clusterService struct {
+	inventoryService  InventoryService
+	credentialService CredentialService
+}
+
+func NewClusterService() clusterService {
+	return clusterService{
+		inventoryService:  NewInventoryService(),
+		credentialService: NewCredentialService(),
+	}
+}
+
+func (cs clusterService) ListClusters() ([]entity.Cluster, error) {
+	database, err := db.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	clusters := []entity.Cluster{}
+	if err := database.Order("name").Find(&clusters).Error; err != nil {
+		logger.GetLogger().Errorf("Failed to list clusters: %s", err.Error())
+		return nil, err
+	}
+	if err := loadClusterNodes(database, clusters); err != nil {
+		return nil, err
+	}
+	return clusters, nil
+}
+
+// GetCluster returns the cluster with its decrypted configuration, it must be masked before it is rendered.
+func (cs clusterService) GetCluster(id uint) (*entity.Cluster, error) {
+	database, err := db.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	cluster, err := findCluster(database.Where("id = ?", id))
+	if err != nil {
+		return nil, err
+	}
+	if cluster == nil {
+		return nil, fmt.Errorf("%w: %d", ErrClusterNotFound, id)
+	}
+	if err := openClusterConfig(cluster); err != nil {
+		logger.GetLogger().Errorf("Failed to open cluster %d: %s", id, err.Error())
+		return nil, err
+	}
+	return cluster, nil
+}
+
+// DeleteCluster forgets the cluster, the cluster itself is left running.
+func (cs clusterService) DeleteCluster(id uint) error {
+	database, err := db.GetDB()
+	if err != nil {
+		return err
+	}
+	return inTransaction(database, func(tx *gorm.DB) error {
+		if err := tx.Where("cluster_id = ?", id).Delete(&entity.ClusterNode{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("id = ?", id).Delete(&entity.Cluster{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("%w: %d", ErrClusterNotFound, id)
+		}
+		return nil
+	})
+}
+
+// ClusterKubeconfig returns the admin kubeconfig recorded once the cluster was created.
+func (cs clusterService) ClusterKubeconfig(id uint) (string, error) {
+	database, err := db.GetDB()
+	if err != nil {
+		return "", err
+	}
+	cluster, err := findCluster(database.Where("id = ?", id))
+	if err != nil {
+		return "", err
+	}
+	if cluster == nil {
+		return "", fmt.Errorf("%w: %d", ErrClusterNotFound, id)
+	}
+	if cluster.KubeconfigData == "" {
+		return "", fmt.Errorf("cluster %s has no admin kubeconfig", cluster.Name)
+	}
+	kubeconfig, err := vault.DecryptString(cluster.KubeconfigData)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt the kubeconfig of cluster %s: %w", cluster.Name, err)
+	}
+	return kubeconfig, nil
+}
+
+// ResolveClusterConf merges a request referring to a recorded cluster into its stored configuration.
+// Hosts of the request replace stored hosts with the same name or are added, hosts marked IsDeleted only
+// mark the stored host, and the roles are added to the stored ones. The credentials of stored inventory
+// hosts and registries are resolved again from their references.
+func (cs clusterService) ResolveClusterConf(conf entity.KubekeyConf) (entity.KubekeyConf, error) {
+	if conf.ClusterID == 0 {
+		return conf, nil
+	}
+	cluster, err := cs.GetCluster(conf.ClusterID)
+	if err != nil {
+		return conf, err
+	}
+	merged := *cluster.Config
+	merged.ClusterID, merged.ClusterName = cluster.ID, cluster.Name
+	merged.Hosts = append([]entity.Host{}, merged.Hosts...)
+	if err := cs.resolveCredentials(&merged); err != nil {
+		logger.GetLogger().Errorf("Failed to resolve the credentials of cluster %s: %s", cluster.Name, err.Error())
+		return conf, err
+	}
+	for _, host := range conf.Hosts {
+		index := -1
+		for i := range merged.Hosts {
+			if merged.Hosts[i].Name == host.Name {
+				index = i
+			}
+		}
+		switch {
+		case index >= 0 && host.IsDeleted:
+			merged.Hosts[index].IsDeleted = true
+		case index >= 0:
+			merged.Hosts[index] = host
+		case host.IsDeleted:
+			return conf, fmt.Errorf("host %s is not a node of cluster %s", host.Name, cluster.Name)
+		default:
+			merged.Hosts = append(merged.Hosts, host)
+		}
+	}
+	merged.Etcds = mergeNames(merged.Etcds, conf.Etcds)
+	merged.ContronPlanes = mergeNames(merged.ContronPlanes, conf.ContronPlanes)
+	merged.Workers = mergeNames(merged.Workers, conf.Workers)
+	for _, field := range []struct{ stored, requested *string }{
+		{&merged.KKPath, &conf.KKPath},
+		{&merged.TaichuPackagePath, &conf.TaichuPackagePath},
+		{&merged.KubernetesVersion, &conf.KubernetesVersion},
+	} {
+		if *field.requested != "" {
+			*field.stored = *field.requested
+		}
+	}
+	return merged, nil
+}
+
+// resolveCredentials fills the credentials dropped by forgetCredentials from the inventory hosts and
+// credentials they reference.
+func (cs clusterService) resolveCredentials(conf *entity.KubekeyConf) error {
+	var ids []uint
+	for _, host := range conf.Hosts {
+		if host.InventoryID != 0 {
+			ids = append(ids, host.InventoryID)
+		}
+	}
+	if len(ids) > 0 {
+		hosts, err := cs.inventoryService.ResolveHosts(entity.HostSelection{HostIDs: ids})
+		if err != nil {
+			return fmt.Errorf("failed to resolve the hosts of cluster %s: %w", conf.ClusterName, err)
+		}
+		resolved := make(map[uint]entity.Host, len(hosts))
+		for _, host := range hosts {
+			resolved[host.InventoryID] = host
+		}
+		for i := range conf.Hosts {
+			if host, exists := resolved[conf.Hosts[i].InventoryID]; exists {
+				credentials, resolvedCredentials := hostCredentials(&conf.Hosts[i]), hostCredentials(&host)
+				for j := range credentials {
+					*credentials[j] = *resolvedCredentials[j]
+				}
+				conf.Hosts[i].User = host.User
+			}
+		}
+	}
+	for _, registry := range clusterRegistries(conf) {
+		if registry.CredentialID == 0 {
+			continue
+		}
+		credential, err := cs.credentialService.RevealCredential(registry.CredentialID, entity.CredentialRegistry)
+		if err != nil {
+			return fmt.Errorf("failed to resolve the registry credential of cluster %s: %w", conf.ClusterName, err)
+		}
+		registry.User, registry.Password = credential.Username, credential.Secret
+	}
+	return nil
+}
+
+// forgetCredentials drops the credentials that resolveCredentials can resolve again, so the stored
+// configuration follows the inventory and the credentials when they change.
+func forgetCredentials(conf *entity.KubekeyConf) {
+	for i := range conf.Hosts {
+		if conf.Hosts[i].Registry != nil {
+			registry := *conf.Hosts[i].Registry
+			conf.Hosts[i].Registry = &registry
+		}
+		if conf.Hosts[i].InventoryID != 0 {
+			for _, credential := range hostCredentials(&conf.Hosts[i]) {
+				*credential = ""
+			}
+		}
+	}
+	for _, registry := range clusterRegistries(conf) {
+		if registry.CredentialID != 0 {
+			registry.User, registry.Password = "", ""
+		}
+	}
+}
+
+// hostCredentials returns the settings of host that an inventory host resolves from its credentials.
+func hostCredentials(host *entity.Host) []*string {
+	return []*string{&host.Password, &host.PrivateKey, &host.Passphrase, &host.Certificate, &host.BecomePassword}
+}
+
+func clusterRegistries(conf *entity.KubekeyConf) []*entity.Registry {
+	registries := []*entity.Registry{&conf.Registry}
+	for i := range conf.Hosts {
+		if conf.Hosts[i].Registry != nil {
+			registries = append(registries, conf.Hosts[i].Registry)
+		}
+	}
+	return registries
+}
+
+func mergeNames(names, added []string) []string {
+	merged := append([]string{}, names...)
+	for _, name := range added {
+		exists := false
+		for _, existing := range merged {
+			exists = exists || existing == name
+		}
+		if !exists {
+			merged = append(merged, name)
+		}
+	}
+	return merged
+}
+
+// RecordCluster stores conf as the configuration of the cluster with its ID, or with its name when it has none.
+// Recording a running cluster as creating fails so a cluster is not created twice.
+func (cs clusterService) RecordCluster(conf entity.KubekeyConf, status string, cause error) (*entity.Cluster, error) {
+	if strings.TrimSpace(conf.ClusterName) == "" {
+		return nil, errors.New("cluster name is required")
+	}
+	database, err := db.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	scope := database.Where("name = ?", conf.ClusterName)
+	if conf.ClusterID != 0 {
+		scope = database.Where("id = ?", conf.ClusterID)
+	}
+	existing, err := findCluster(scope)
+	if err != nil {
+		return nil, err
+	}
+	cluster := entity.Cluster{}
+	if existing != nil {
+		if status == entity.ClusterCreating && existing.Status == entity.ClusterRunning {
+			return nil, fmt.Errorf("cluster %s already exists", existing.Name)
+		}
+		cluster = *existing
+	}
+	conf.ClusterID = 0
+	conf.HostSelection = entity.HostSelection{}
+	conf.Hosts = append([]entity.Host{}, conf.Hosts...)
+	for i := range conf.Hosts {
+		conf.Hosts[i].AuthMethods = nil
+	}
+	forgetCredentials(&conf)
+	cluster.Name, cluster.KubernetesVersion, cluster.VIPServer = conf.ClusterName, conf.KubernetesVersion, conf.VIPServer
+	cluster.Status, cluster.Message = status, ""
+	if cause != nil {
+		cluster.Message = cause.Error()
+	}
+	cluster.Config = &conf
+	if err := sealClusterConfig(&cluster); err != nil {
+		logger.GetLogger().Errorf("Failed to seal cluster %s: %s", conf.ClusterName, err.Error())
+		return nil, err
+	}
+	err = inTransaction(database, func(tx *gorm.DB) error {
+		if err := tx.Save(&cluster).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("cluster_id = ?", cluster.ID).Delete(&entity.ClusterNode{}).Error; err != nil {
+			return err
+		}
+		cluster.Nodes = clusterNodes(cluster.ID, conf)
+		for i := range cluster.Nodes {
+			if err := tx.Create(&cluster.Nodes[i]).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to record cluster %s: %s", conf.ClusterName, err.Error())
+		return nil, err
+	}
+	return &cluster, nil
+}
+
+// SetClusterKubeconfig stores the admin kubeconfig of the cluster.
+func (cs clusterService) SetClusterKubeconfig(id uint, kubeconfig string) error {
+	database, err := db.GetDB()
+	if err != nil {
+		return err
+	}
+	sealed, err := vault.EncryptString(kubeconfig)
+	if err != nil {
+		return err
+	}
+	return database.Model(&entity.Cluster{ID: id}).UpdateColumns(map[string]interface{}{"kubeconfig_data": sealed}).Error
+}
+
+// ForgetCluster deletes the record of a cluster that was torn down, unrecorded clusters are ignored.
+func (cs clusterService) ForgetCluster(conf entity.KubekeyConf) error {
+	database, err := db.GetDB()
+	if err != nil {
+		return err
+	}
+	scope := database.Where("name = ?", conf.ClusterName)
+	if conf.ClusterID != 0 {
+		scope = database.Where("id = ?", conf.ClusterID)
+	}
+	cluster, err := findCluster(scope)
+	if err != nil || cluster == nil {
+		return err
+	}
+	return cs.DeleteCluster(cluster.ID)
+}
+
+// findCluster returns the cluster matching scope with its nodes, nil when there is none.
+func findCluster(scope *gorm.DB) (*entity.Cluster, error) {
+	var clusters []entity.Cluster
+	if err := scope.Limit(1).Find(&clusters).Error; err != nil {
+		return nil, err
+	}
+	if len(clusters) == 0 {
+		return nil, nil
+	}
+	if err := loadClusterNodes(scope.New(), clusters); err != nil {
+		return nil, err
+	}
+	return &clusters[0], nil
+}
+
+func loadClusterNodes(database *gorm.DB, clusters []entity.Cluster) error {
+	if len(clusters) == 0 {
+		return nil
+	}
+	index := make(map[uint]int, len(clusters))
+	ids := make([]uint, 0, len(clusters))
+	for i := range clusters {
+		index[clusters[i].ID] = i
+		ids = append(ids, clusters[i].ID)
+		clusters[i].Nodes = []entity.ClusterNode{}
+		clusters[i].HasKubeconfig = clusters[i].KubeconfigData != ""
+	}
+	var nodes []entity.ClusterNode
+	if err := database.Where("cluster_id IN (?)", ids).Order("id").Find(&nodes).Error; err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		clusters[index[node.ClusterID]].Nodes = append(clusters[index[node.ClusterID]].Nodes, node)
+	}
+	return nil
+}
+
+// clusterNodes lists the hosts of conf that have a role, hosts marked IsDeleted are left out.
+func clusterNodes(clusterID uint, conf entity.KubekeyConf) []entity.ClusterNode {
+	contains := func(names []string, name string) bool {
+		for _, item := range names {
+			if item == name {
+				return true
+			}
+		}
+		return false
+	}
+	nodes := []entity.ClusterNode{}
+	for _, host := range conf.Hosts {
+		node := entity.ClusterNode{
+			ClusterID:       clusterID,
+			Name:            host.Name,
+			Address:         host.Address,
+			InternalAddress: host.InternalAddress,
+			ControlPlane:    contains(conf.ContronPlanes, host.Name),
+			Etcd:            contains(conf.Etcds, host.Name),
+			Worker:          contains(conf.Workers, host.Name),
+		}
+		if host.IsDeleted || !(node.ControlPlane || node.Etcd || node.Worker) {
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+func sealClusterConfig(cluster *entity.Cluster) error {
+	payload, err := json.Marshal(cluster.Config)
+	if err != nil {
+		return err
+	}
+	sealed, err := vault.Encrypt(payload)
+	if err != nil {
+		return err
+	}
+	version, _ := vault.Version(sealed)
+	cluster.ConfigData, cluster.KeyVersion = sealed, version
+	return nil
+}
+
+func openClusterConfig(cluster *entity.Cluster) error {
+	payload, err := vault.Decrypt(cluster.ConfigData)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt the configuration of cluster %s: %w", cluster.Name, err)
+	}
+	conf := &entity.KubekeyConf{}
+	if err := json.Unmarshal(payload, conf); err != nil {
+		return fmt.Errorf("corrupt configuration of cluster %s: %w", cluster.Name, err)
+	}
+	conf.ClusterID = cluster.ID
+	cluster.Config = conf
+	return nil
+}
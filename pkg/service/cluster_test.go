@@ -0,0 +1,157 @@
+package service
+
+import (
+	"errors"
+	"github.com/whoisfisher/mykubespray/pkg/db"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/utils/vault"
+	"strings"
+	"testing"
+)
+
+func TestClusterRegistry(t *testing.T) {
+	keys := newTestDB(t)
+	clusters := NewClusterService()
+	conf := entity.KubekeyConf{
+		ClusterName: "prod",
+		Hosts: []entity.Host{
+			{Name: "master-1", Address: "10.0.0.1", Password: "ssh-secret"},
+			{Name: "worker-1", Address: "10.0.0.2", Password: "ssh-secret"},
+		},
+		ContronPlanes:     []string{"master-1"},
+		Etcds:             []string{"master-1"},
+		Workers:           []string{"worker-1"},
+		KubernetesVersion: "v1.28.6",
+		Registry:          entity.Registry{Url: "registry.local", Password: "harbor"},
+	}
+	cluster, err := clusters.RecordCluster(conf, entity.ClusterCreating, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(cluster.ConfigData, "ssh-secret") || !vault.IsSealed(cluster.ConfigData) {
+		t.Fatalf("cluster configuration stored in plaintext: %q", cluster.ConfigData)
+	}
+	if _, err := clusters.RecordCluster(conf, entity.ClusterRunning, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clusters.RecordCluster(conf, entity.ClusterCreating, nil); err == nil {
+		t.Fatal("expected a running cluster not to be created again")
+	}
+	if err := clusters.SetClusterKubeconfig(cluster.ID, "apiVersion: v1\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	listed, err := clusters.ListClusters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(listed) != 1 || listed[0].Status != entity.ClusterRunning || listed[0].Config != nil || !listed[0].HasKubeconfig ||
+		len(listed[0].Nodes) != 2 || !listed[0].Nodes[0].ControlPlane || !listed[0].Nodes[1].Worker {
+		t.Fatalf("unexpected clusters %+v", listed)
+	}
+
+	merged, err := clusters.ResolveClusterConf(entity.KubekeyConf{
+		ClusterID: cluster.ID,
+		Hosts:     []entity.Host{{Name: "worker-2", Address: "10.0.0.3", Password: "new-secret"}},
+		Workers:   []string{"worker-2"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.ClusterName != "prod" || len(merged.Hosts) != 3 || merged.Hosts[0].Password != "ssh-secret" ||
+		merged.Registry.Password != "harbor" || strings.Join(merged.Workers, ",") != "worker-1,worker-2" {
+		t.Fatalf("unexpected merged configuration %+v", merged)
+	}
+	masked := merged.Masked()
+	if masked.Hosts[0].Password != entity.MaskedCredential || masked.Registry.Password != entity.MaskedCredential || merged.Hosts[0].Password != "ssh-secret" {
+		t.Fatalf("unexpected masked configuration %+v", masked)
+	}
+	if _, err := clusters.ResolveClusterConf(entity.KubekeyConf{ClusterID: cluster.ID, Hosts: []entity.Host{{Name: "missing", IsDeleted: true}}}); err == nil {
+		t.Fatal("expected deleting an unknown node to fail")
+	}
+	removed := withoutNode(merged, "worker-1")
+	if _, err := clusters.RecordCluster(removed, entity.ClusterRunning, nil); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := clusters.GetCluster(cluster.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Nodes) != 2 || stored.Nodes[1].Name != "worker-2" || strings.Join(stored.Config.Workers, ",") != "worker-2" {
+		t.Fatalf("unexpected cluster after deleting a node %+v", stored)
+	}
+
+	t.Setenv(vault.DefaultKeyEnv, keys+",2:"+newVaultKey(t))
+	rotation, err := NewCredentialService().RotateCredentials()
+	if err != nil || rotation.Clusters != 1 {
+		t.Fatalf("unexpected rotation %+v, %v", rotation, err)
+	}
+	if kubeconfig, err := clusters.ClusterKubeconfig(cluster.ID); err != nil || kubeconfig != "apiVersion: v1\n" {
+		t.Fatalf("unexpected kubeconfig after rotation %q, %v", kubeconfig, err)
+	}
+
+	if err := clusters.ForgetCluster(entity.KubekeyConf{ClusterName: "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clusters.GetCluster(cluster.ID); !errors.Is(err, ErrClusterNotFound) {
+		t.Fatalf("expected the cluster to be forgotten, got %v", err)
+	}
+	var nodes int
+	if err := db.DB.Model(&entity.ClusterNode{}).Count(&nodes).Error; err != nil || nodes != 0 {
+		t.Fatalf("expected the nodes to be deleted with the cluster, got %d, %v", nodes, err)
+	}
+}
+
+func TestClusterStoresCredentialReferences(t *testing.T) {
+	newTestDB(t)
+	credentials := NewCredentialService()
+	inventory := NewInventoryService()
+	clusters := NewClusterService()
+	login, err := credentials.CreateCredential(entity.Credential{Name: "ops", Kind: entity.CredentialPassword, Username: "ops", Secret: "ssh-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	harbor, err := credentials.CreateCredential(entity.Credential{Name: "harbor", Kind: entity.CredentialRegistry, Username: "admin", Secret: "harbor-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	master, err := inventory.CreateHost(entity.InventoryHost{Name: "master-1", Address: "10.0.0.1", CredentialID: login.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hosts, err := inventory.ResolveHosts(entity.HostSelection{HostIDs: []uint{master.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := entity.KubekeyConf{
+		ClusterName:   "prod",
+		Hosts:         append(hosts, entity.Host{Name: "worker-1", Address: "10.0.0.2", Password: "manual-secret"}),
+		ContronPlanes: []string{"master-1"},
+		Workers:       []string{"worker-1"},
+		Registry:      entity.Registry{Url: "registry.local", CredentialID: harbor.ID, User: "admin", Password: "harbor-secret"},
+	}
+	cluster, err := clusters.RecordCluster(conf, entity.ClusterRunning, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := vault.Decrypt(cluster.ConfigData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(payload), "ssh-secret") || strings.Contains(string(payload), "harbor-secret") ||
+		!strings.Contains(string(payload), "manual-secret") {
+		t.Fatalf("expected only the credentials without a reference to be stored: %s", payload)
+	}
+
+	if _, err := credentials.UpdateCredential(login.ID, entity.Credential{Name: "ops", Kind: entity.CredentialPassword, Username: "ops", Secret: "rotated-secret"}); err != nil {
+		t.Fatal(err)
+	}
+	resolved, err := clusters.ResolveClusterConf(entity.KubekeyConf{ClusterID: cluster.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.Hosts[0].Password != "rotated-secret" || resolved.Hosts[0].User != "ops" || resolved.Hosts[1].Password != "manual-secret" ||
+		resolved.Registry.User != "admin" || resolved.Registry.Password != "harbor-secret" {
+		t.Fatalf("unexpected resolved configuration %+v", resolved)
+	}
+}
@@ -0,0 +1,309 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"github.com/whoisfisher/mykubespray/pkg/db"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"github.com/whoisfisher/mykubespray/pkg/utils/vault"
+	"strings"
+)
+
+var ErrCredentialNotFound = errors.New("credential not found")
+
+var credentialKinds = map[string]bool{
+	entity.CredentialPassword:       true,
+	entity.CredentialPrivateKey:     true,
+	entity.CredentialRegistry:       true,
+	entity.CredentialKubeconfig:     true,
+	entity.CredentialKeycloakSecret: true,
+}
+
+type CredentialService interface {
+	CreateCredential(credential entity.Credential) (*entity.Credential, error)
+	GetCredential(id uint) (*entity.Credential, error)
+	UpdateCredential(id uint, credential entity.Credential) (*entity.Credential, error)
+	DeleteCredential(id uint) error
+	ListCredentials(kind string) ([]entity.Credential, error)
+	RevealCredential(id uint, kinds ...string) (*entity.Credential, error)
+	RotateCredentials() (*entity.CredentialRotation, error)
+}
+
+type credentialService struct {
+}
+
+func NewCredentialService() credentialService {
+	return credentialService{}
+}
+
+func validateCredential(credential entity.Credential) error {
+	if strings.TrimSpace(credential.Name) == "" {
+		return errors.New("credential name is required")
+	}
+	if !credentialKinds[credential.Kind] {
+		return fmt.Errorf("unknown credential kind %q", credential.Kind)
+	}
+	return nil
+}
+
+// sealCredential encrypts the secrets of credential into Ciphertext and clears them.
+func sealCredential(credential *entity.Credential) error {
+	payload, err := json.Marshal(entity.CredentialSecret{Secret: credential.Secret, Passphrase: credential.Passphrase})
+	if err != nil {
+		return err
+	}
+	sealed, err := vault.Encrypt(payload)
+	if err != nil {
+		return err
+	}
+	version, _ := vault.Version(sealed)
+	credential.Ciphertext, credential.KeyVersion = sealed, version
+	credential.Secret, credential.Passphrase = "", ""
+	return nil
+}
+
+func openCredential(credential *entity.Credential) error {
+	payload, err := vault.Decrypt(credential.Ciphertext)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt credential %s: %w", credential.Name, err)
+	}
+	var secret entity.CredentialSecret
+	if err := json.Unmarshal(payload, &secret); err != nil {
+		return fmt.Errorf("corrupt credential %s: %w", credential.Name, err)
+	}
+	credential.Secret, credential.Passphrase = secret.Secret, secret.Passphrase
+	return nil
+}
+
+func (cs credentialService) CreateCredential(credential entity.Credential) (*entity.Credential, error) {
+	if err := validateCredential(credential); err != nil {
+		return nil, err
+	}
+	if credential.Secret == "" {
+		return nil, errors.New("credential secret is required")
+	}
+	database, err := db.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	credential.ID = 0
+	if err := sealCredential(&credential); err != nil {
+		logger.GetLogger().Errorf("Failed to seal credential %s: %s", credential.Name, err.Error())
+		return nil, err
+	}
+	if err := database.Create(&credential).Error; err != nil {
+		logger.GetLogger().Errorf("Failed to create credential %s: %s", credential.Name, err.Error())
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// GetCredential returns the credential without its secrets.
+func (cs credentialService) GetCredential(id uint) (*entity.Credential, error) {
+	database, err := db.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	var credential entity.Credential
+	if err := database.First(&credential, id).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, fmt.Errorf("%w: %d", ErrCredentialNotFound, id)
+		}
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// UpdateCredential replaces the credential, the stored secrets are kept when Secret is empty or entity.MaskedCredential.
+func (cs credentialService) UpdateCredential(id uint, credential entity.Credential) (*entity.Credential, error) {
+	if err := validateCredential(credential); err != nil {
+		return nil, err
+	}
+	existing, err := cs.GetCredential(id)
+	if err != nil {
+		return nil, err
+	}
+	database, err := db.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	credential.ID = id
+	credential.CreatedAt = existing.CreatedAt
+	if credential.Secret == "" || credential.Secret == entity.MaskedCredential {
+		credential.Ciphertext, credential.KeyVersion = existing.Ciphertext, existing.KeyVersion
+		credential.Secret, credential.Passphrase = "", ""
+	} else if err := sealCredential(&credential); err != nil {
+		logger.GetLogger().Errorf("Failed to seal credential %s: %s", credential.Name, err.Error())
+		return nil, err
+	}
+	if err := database.Save(&credential).Error; err != nil {
+		logger.GetLogger().Errorf("Failed to update credential %d: %s", id, err.Error())
+		return nil, err
+	}
+	return &credential, nil
+}
+
+// DeleteCredential refuses to delete a credential referenced by inventory hosts.
+func (cs credentialService) DeleteCredential(id uint) error {
+	database, err := db.GetDB()
+	if err != nil {
+		return err
+	}
+	var references int
+	if err := database.Model(&entity.InventoryHost{}).Where("credential_id = ? OR become_credential_id = ?", id, id).Count(&references).Error; err != nil {
+		return err
+	}
+	if references > 0 {
+		return fmt.Errorf("credential %d is used by %d inventory hosts", id, references)
+	}
+	result := database.Where("id = ?", id).Delete(&entity.Credential{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return fmt.Errorf("%w: %d", ErrCredentialNotFound, id)
+	}
+	return nil
+}
+
+func (cs credentialService) ListCredentials(kind string) ([]entity.Credential, error) {
+	database, err := db.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	scope := database.Order("name")
+	if kind != "" {
+		scope = scope.Where("kind = ?", kind)
+	}
+	credentials := []entity.Credential{}
+	if err := scope.Find(&credentials).Error; err != nil {
+		logger.GetLogger().Errorf("Failed to list credentials: %s", err.Error())
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// RevealCredential returns the credential with its decrypted secrets, it fails unless the kind is one of kinds.
+// It is only used to fill requests on the server, its result must never be rendered.
+func (cs credentialService) RevealCredential(id uint, kinds ...string) (*entity.Credential, error) {
+	credential, err := cs.GetCredential(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(kinds) > 0 {
+		allowed := false
+		for _, kind := range kinds {
+			allowed = allowed || credential.Kind == kind
+		}
+		if !allowed {
+			return nil, fmt.Errorf("credential %s is a %s credential, expected %s", credential.Name, credential.Kind, strings.Join(kinds, " or "))
+		}
+	}
+	if err := openCredential(credential); err != nil {
+		logger.GetLogger().Errorf("Failed to reveal credential %d: %s", id, err.Error())
+		return nil, err
+	}
+	return credential, nil
+}
+
+// RotateCredentials reloads the master keys and re-encrypts every credential, inventory host secret and cluster
+// that is not sealed with the current key version in a single transaction. Retired keys can be removed once it succeeds.
+func (cs credentialService) RotateCredentials() (*entity.CredentialRotation, error) {
+	keyring, err := vault.Reload()
+	if err != nil {
+		return nil, err
+	}
+	database, err := db.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	rotation := &entity.CredentialRotation{KeyVersion: keyring.CurrentVersion()}
+	err = inTransaction(database, func(tx *gorm.DB) error {
+		var credentials []entity.Credential
+		if err := tx.Where("key_version <> ?", rotation.KeyVersion).Find(&credentials).Error; err != nil {
+			return err
+		}
+		for _, credential := range credentials {
+			if err := openCredential(&credential); err != nil {
+				logger.GetLogger().Errorf("Failed to rotate credential %d: %s", credential.ID, err.Error())
+				return err
+			}
+			if err := sealCredential(&credential); err != nil {
+				return err
+			}
+			columns := map[string]interface{}{"ciphertext": credential.Ciphertext, "key_version": credential.KeyVersion}
+			if err := tx.Model(&credential).UpdateColumns(columns).Error; err != nil {
+				return err
+			}
+			rotation.Credentials++
+		}
+		var hosts []entity.InventoryHost
+		if err := tx.Find(&hosts).Error; err != nil {
+			return err
+		}
+		for _, host := range hosts {
+			if !hostNeedsRotation(host, rotation.KeyVersion) {
+				continue
+			}
+			if err := openHostSecrets(&host); err != nil {
+				logger.GetLogger().Errorf("Failed to rotate inventory host %d: %s", host.ID, err.Error())
+				return err
+			}
+			if err := sealHostSecrets(&host); err != nil {
+				return err
+			}
+			columns := map[string]interface{}{
+				"password":        host.Password,
+				"private_key":     host.PrivateKey,
+				"passphrase":      host.Passphrase,
+				"become_password": host.BecomePassword,
+			}
+			if err := tx.Model(&host).UpdateColumns(columns).Error; err != nil {
+				return err
+			}
+			rotation.Hosts++
+		}
+		var clusters []entity.Cluster
+		if err := tx.Find(&clusters).Error; err != nil {
+			return err
+		}
+		for _, cluster := range clusters {
+			kubeconfigVersion, _ := vault.Version(cluster.KubeconfigData)
+			if cluster.KeyVersion == rotation.KeyVersion && (cluster.KubeconfigData == "" || kubeconfigVersion == rotation.KeyVersion) {
+				continue
+			}
+			if err := openClusterConfig(&cluster); err != nil {
+				logger.GetLogger().Errorf("Failed to rotate cluster %d: %s", cluster.ID, err.Error())
+				return err
+			}
+			if err := sealClusterConfig(&cluster); err != nil {
+				return err
+			}
+			columns := map[string]interface{}{"config_data": cluster.ConfigData, "key_version": cluster.KeyVersion}
+			if cluster.KubeconfigData != "" {
+				kubeconfig, err := vault.Decrypt(cluster.KubeconfigData)
+				if err != nil {
+					return err
+				}
+				if columns["kubeconfig_data"], err = vault.Encrypt(kubeconfig); err != nil {
+					return err
+				}
+			}
+			if err := tx.Model(&cluster).UpdateColumns(columns).Error; err != nil {
+				return err
+			}
+			rotation.Clusters++
+		}
+		return nil
+	})
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to rotate to key version %d, nothing was re-encrypted: %s", rotation.KeyVersion, err.Error())
+		return nil, err
+	}
+	logger.GetLogger().Infof("Rotated %d credentials, %d inventory hosts and %d clusters to key version %d",
+		rotation.Credentials, rotation.Hosts, rotation.Clusters, rotation.KeyVersion)
+	return rotation, nil
+}
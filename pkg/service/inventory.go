@@ -0,0 +1,559 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jinzhu/gorm"
+	"github.com/whoisfisher/mykubespray/pkg/db"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"github.com/whoisfisher/mykubespray/pkg/utils"
+	"github.com/whoisfisher/mykubespray/pkg/utils/vault"
+	"k8s.io/apimachinery/pkg/labels"
+	"sort"
+	"strings"
+)
+
+var ErrHostNotFound = errors.New("inventory host not found")
+
+type InventoryService interface {
+	CreateHost(host entity.InventoryHost) (*entity.InventoryHost, error)
+	GetHost(id uint) (*entity.InventoryHost, error)
+	UpdateHost(id uint, host entity.InventoryHost) (*entity.InventoryHost, error)
+	DeleteHost(id uint) error
+	ListHosts(query entity.InventoryQuery) ([]entity.InventoryHost, error)
+	ResolveHosts(selection entity.HostSelection) ([]entity.Host, error)
+	ImportAnsibleInventory(inventory entity.AnsibleInventory) ([]entity.InventoryHost, error)
+	HostFacts(id uint, refresh bool) (*entity.HostFacts, error)
+}
+
+type inventoryService struct {
+	credentialService CredentialService
+}
+
+func NewInventoryService() inventoryService {
+	return inventoryService{
+		credentialService: NewCredentialService(),
+	}
+}
+
+func validateInventoryHost(host entity.InventoryHost) error {
+	if strings.TrimSpace(host.Name) == "" {
+		return errors.New("host name is required")
+	}
+	if strings.TrimSpace(host.Address) == "" {
+		return errors.New("host address is required")
+	}
+	for name := range host.Labels {
+		if strings.TrimSpace(name) == "" {
+			return errors.New("label names must not be empty")
+		}
+	}
+	for _, group := range host.Groups {
+		if strings.TrimSpace(group) == "" {
+			return errors.New("group names must not be empty")
+		}
+	}
+	return nil
+}
+
+// checkHostCredentials makes sure the credentials referenced by host exist and have a usable kind.
+func (is inventoryService) checkHostCredentials(host entity.InventoryHost) error {
+	references := []struct {
+		id    uint
+		kinds []string
+	}{
+		{host.CredentialID, []string{entity.CredentialPassword, entity.CredentialPrivateKey}},
+		{host.BecomeCredentialID, []string{entity.CredentialPassword}},
+	}
+	for _, reference := range references {
+		if reference.id == 0 {
+			continue
+		}
+		credential, err := is.credentialService.GetCredential(reference.id)
+		if err != nil {
+			return err
+		}
+		allowed := false
+		for _, kind := range reference.kinds {
+			allowed = allowed || credential.Kind == kind
+		}
+		if !allowed {
+			return fmt.Errorf("credential %s is a %s credential, expected %s", credential.Name, credential.Kind, strings.Join(reference.kinds, " or "))
+		}
+	}
+	return nil
+}
+
+func hostSecrets(host *entity.InventoryHost) []*string {
+	return []*string{&host.Password, &host.PrivateKey, &host.Passphrase, &host.BecomePassword}
+}
+
+// sealHostSecrets encrypts the inline secrets of host before it is stored.
+func sealHostSecrets(host *entity.InventoryHost) error {
+	for _, secret := range hostSecrets(host) {
+		sealed, err := vault.EncryptString(*secret)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt secrets of host %s: %w", host.Name, err)
+		}
+		*secret = sealed
+	}
+	return nil
+}
+
+// openHostSecrets decrypts the inline secrets of a stored host, values stored before the vault are plaintext and kept as they are.
+func openHostSecrets(host *entity.InventoryHost) error {
+	for _, secret := range hostSecrets(host) {
+		if !vault.IsSealed(*secret) {
+			continue
+		}
+		plaintext, err := vault.DecryptString(*secret)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt secrets of host %s: %w", host.Name, err)
+		}
+		*secret = plaintext
+	}
+	return nil
+}
+
+// hostNeedsRotation reports whether a stored host has a secret that is plaintext or sealed with an older key.
+func hostNeedsRotation(host entity.InventoryHost, current int) bool {
+	for _, secret := range hostSecrets(&host) {
+		if *secret == "" {
+			continue
+		}
+		if version, ok := vault.Version(*secret); !ok || version != current {
+			return true
+		}
+	}
+	return false
+}
+
+func (is inventoryService) CreateHost(host entity.InventoryHost) (*entity.InventoryHost, error) {
+	if err := validateInventoryHost(host); err != nil {
+		return nil, err
+	}
+	database, err := db.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	if err := is.checkHostCredentials(host); err != nil {
+		return nil, err
+	}
+	host.ID = 0
+	if err := sealHostSecrets(&host); err != nil {
+		return nil, err
+	}
+	err = inTransaction(database, func(tx *gorm.DB) error {
+		return writeHost(tx, &host)
+	})
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to create inventory host %s: %s", host.Name, err.Error())
+		return nil, err
+	}
+	return &host, nil
+}
+
+func (is inventoryService) GetHost(id uint) (*entity.InventoryHost, error) {
+	database, err := db.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	var host entity.InventoryHost
+	if err := database.First(&host, id).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return nil, ErrHostNotFound
+		}
+		return nil, err
+	}
+	hosts := []entity.InventoryHost{host}
+	if err := loadHostRelations(database, hosts); err != nil {
+		return nil, err
+	}
+	if err := openHostSecrets(&hosts[0]); err != nil {
+		return nil, err
+	}
+	return &hosts[0], nil
+}
+
+// UpdateHost replaces the host, secrets sent back as entity.MaskedCredential keep their stored value.
+func (is inventoryService) UpdateHost(id uint, host entity.InventoryHost) (*entity.InventoryHost, error) {
+	if err := validateInventoryHost(host); err != nil {
+		return nil, err
+	}
+	existing, err := is.GetHost(id)
+	if err != nil {
+		return nil, err
+	}
+	database, err := db.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	keepMasked(&host.Password, existing.Password)
+	keepMasked(&host.PrivateKey, existing.PrivateKey)
+	keepMasked(&host.Passphrase, existing.Passphrase)
+	keepMasked(&host.BecomePassword, existing.BecomePassword)
+	if err := is.checkHostCredentials(host); err != nil {
+		return nil, err
+	}
+	host.ID = id
+	host.CreatedAt = existing.CreatedAt
+	if err := sealHostSecrets(&host); err != nil {
+		return nil, err
+	}
+	err = inTransaction(database, func(tx *gorm.DB) error {
+		return writeHost(tx, &host)
+	})
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to update inventory host %d: %s", id, err.Error())
+		return nil, err
+	}
+	is.invalidateFacts(*existing)
+	return &host, nil
+}
+
+// writeHost creates host when it has no ID, otherwise it replaces the stored host and its labels and groups.
+func writeHost(tx *gorm.DB, host *entity.InventoryHost) error {
+	if host.ID == 0 {
+		if err := tx.Create(host).Error; err != nil {
+			return err
+		}
+	} else {
+		if err := tx.Save(host).Error; err != nil {
+			return err
+		}
+		if err := deleteHostRelations(tx, host.ID); err != nil {
+			return err
+		}
+	}
+	return saveHostRelations(tx, *host)
+}
+
+func keepMasked(secret *string, stored string) {
+	if *secret == entity.MaskedCredential {
+		*secret = stored
+	}
+}
+
+func (is inventoryService) DeleteHost(id uint) error {
+	database, err := db.GetDB()
+	if err != nil {
+		return err
+	}
+	existing, err := is.GetHost(id)
+	if err != nil {
+		return err
+	}
+	defer is.invalidateFacts(*existing)
+	return inTransaction(database, func(tx *gorm.DB) error {
+		if err := deleteHostRelations(tx, id); err != nil {
+			return err
+		}
+		result := tx.Where("id = ?", id).Delete(&entity.InventoryHost{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrHostNotFound
+		}
+		return nil
+	})
+}
+
+// ListHosts returns the hosts matching query ordered by name, their secrets stay encrypted.
+func (is inventoryService) ListHosts(query entity.InventoryQuery) ([]entity.InventoryHost, error) {
+	selector, err := labels.Parse(query.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid label selector %q: %w", query.Selector, err)
+	}
+	database, err := db.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	scope := database.Order("name")
+	if query.Name != "" {
+		scope = scope.Where("name = ?", query.Name)
+	}
+	if query.Group != "" {
+		groupTable := database.NewScope(&entity.InventoryHostGroup{}).TableName()
+		scope = scope.Where(fmt.Sprintf("id IN (SELECT host_id FROM %s WHERE name = ?)", groupTable), query.Group)
+	}
+	var hosts []entity.InventoryHost
+	if err := scope.Find(&hosts).Error; err != nil {
+		logger.GetLogger().Errorf("Failed to list inventory hosts: %s", err.Error())
+		return nil, err
+	}
+	if err := loadHostRelations(database, hosts); err != nil {
+		return nil, err
+	}
+	matched := make([]entity.InventoryHost, 0, len(hosts))
+	for _, host := range hosts {
+		if selector.Matches(labels.Set(host.Labels)) {
+			matched = append(matched, host)
+		}
+	}
+	return matched, nil
+}
+
+// ResolveHosts returns the connection settings of the selected hosts, every ID must exist and a selector must match.
+func (is inventoryService) ResolveHosts(selection entity.HostSelection) ([]entity.Host, error) {
+	if selection.Empty() {
+		return nil, nil
+	}
+	database, err := db.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[uint]bool)
+	var hosts []entity.Host
+	if len(selection.HostIDs) > 0 {
+		var found []entity.InventoryHost
+		if err := database.Where("id IN (?)", selection.HostIDs).Order("name").Find(&found).Error; err != nil {
+			return nil, err
+		}
+		for _, host := range found {
+			seen[host.ID] = true
+			resolved, err := is.connectionHost(host)
+			if err != nil {
+				return nil, err
+			}
+			hosts = append(hosts, resolved)
+		}
+		var missing []string
+		for _, id := range selection.HostIDs {
+			if !seen[id] {
+				missing = append(missing, fmt.Sprint(id))
+			}
+		}
+		if len(missing) > 0 {
+			return nil, fmt.Errorf("inventory hosts not found: %s", strings.Join(missing, ", "))
+		}
+	}
+	if selection.Selector != "" || selection.Group != "" {
+		matched, err := is.ListHosts(entity.InventoryQuery{Selector: selection.Selector, Group: selection.Group})
+		if err != nil {
+			return nil, err
+		}
+		if len(matched) == 0 {
+			return nil, fmt.Errorf("no inventory host matches selector %q and group %q", selection.Selector, selection.Group)
+		}
+		for _, host := range matched {
+			if !seen[host.ID] {
+				seen[host.ID] = true
+				resolved, err := is.connectionHost(host)
+				if err != nil {
+					return nil, err
+				}
+				hosts = append(hosts, resolved)
+			}
+		}
+	}
+	return hosts, nil
+}
+
+// ImportAnsibleInventory creates the hosts of an Ansible inventory, or updates the hosts with the same name,
+// in a single transaction. The groups of a host are replaced by its inventory groups, its labels and credential
+// references are kept, as are stored secrets the inventory does not set.
+func (is inventoryService) ImportAnsibleInventory(inventory entity.AnsibleInventory) ([]entity.InventoryHost, error) {
+	database, err := db.GetDB()
+	if err != nil {
+		return nil, err
+	}
+	hostGroups := make(map[string][]string)
+	for group, members := range inventory.Groups {
+		for _, member := range members {
+			hostGroups[member] = append(hostGroups[member], group)
+		}
+	}
+	imported := make([]entity.InventoryHost, 0, len(inventory.Hosts))
+	var updated []entity.InventoryHost
+	for _, host := range inventory.Hosts {
+		groups := hostGroups[host.Name]
+		sort.Strings(groups)
+		inventoryHost := entity.InventoryHost{
+			Name:            host.Name,
+			Address:         host.Address,
+			InternalAddress: host.InternalAddress,
+			Port:            host.Port,
+			User:            host.User,
+			Password:        host.Password,
+			PrivateKey:      host.PrivateKey,
+			Passphrase:      host.Passphrase,
+			BecomeMethod:    host.BecomeMethod,
+			BecomeUser:      host.BecomeUser,
+			BecomePassword:  host.BecomePassword,
+			Groups:          groups,
+		}
+		if err := validateInventoryHost(inventoryHost); err != nil {
+			return nil, fmt.Errorf("import host %s: %w", host.Name, err)
+		}
+		existing, err := is.ListHosts(entity.InventoryQuery{Name: host.Name})
+		if err != nil {
+			return nil, err
+		}
+		if err := sealHostSecrets(&inventoryHost); err != nil {
+			return nil, err
+		}
+		if len(existing) > 0 {
+			inventoryHost.ID, inventoryHost.CreatedAt = existing[0].ID, existing[0].CreatedAt
+			inventoryHost.Labels = existing[0].Labels
+			inventoryHost.Certificate = existing[0].Certificate
+			inventoryHost.CredentialID, inventoryHost.BecomeCredentialID = existing[0].CredentialID, existing[0].BecomeCredentialID
+			// the stored secrets are still sealed
+			secrets, stored := hostSecrets(&inventoryHost), hostSecrets(&existing[0])
+			for i := range secrets {
+				if *secrets[i] == "" {
+					*secrets[i] = *stored[i]
+				}
+			}
+			updated = append(updated, existing[0])
+		}
+		imported = append(imported, inventoryHost)
+	}
+	err = inTransaction(database, func(tx *gorm.DB) error {
+		for i := range imported {
+			if err := writeHost(tx, &imported[i]); err != nil {
+				return fmt.Errorf("import host %s: %w", imported[i].Name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to import the Ansible inventory, no host was imported: %s", err.Error())
+		return nil, err
+	}
+	for _, host := range updated {
+		is.invalidateFacts(host)
+	}
+	return imported, nil
+}
+
+// connectionHost decrypts the secrets of a stored host and applies its referenced credentials.
+func (is inventoryService) connectionHost(host entity.InventoryHost) (entity.Host, error) {
+	if err := openHostSecrets(&host); err != nil {
+		return entity.Host{}, err
+	}
+	if host.CredentialID != 0 {
+		credential, err := is.credentialService.RevealCredential(host.CredentialID, entity.CredentialPassword, entity.CredentialPrivateKey)
+		if err != nil {
+			return entity.Host{}, err
+		}
+		if credential.Kind == entity.CredentialPrivateKey {
+			host.PrivateKey, host.Passphrase = credential.Secret, credential.Passphrase
+		} else {
+			host.Password = credential.Secret
+		}
+		if host.User == "" {
+			host.User = credential.Username
+		}
+	}
+	if host.BecomeCredentialID != 0 {
+		credential, err := is.credentialService.RevealCredential(host.BecomeCredentialID, entity.CredentialPassword)
+		if err != nil {
+			return entity.Host{}, err
+		}
+		host.BecomePassword = credential.Secret
+	}
+	return host.ToHost(), nil
+}
+
+// HostFacts returns the cached facts of the host, refresh gathers them again.
+func (is inventoryService) HostFacts(id uint, refresh bool) (*entity.HostFacts, error) {
+	host, err := is.GetHost(id)
+	if err != nil {
+		return nil, err
+	}
+	connection, err := is.connectionHost(*host)
+	if err != nil {
+		return nil, err
+	}
+	facts, err := utils.GetSSHExecutorPool().GatherFacts(connection, refresh)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to gather facts of inventory host %d: %s", id, err.Error())
+		return nil, err
+	}
+	return facts, nil
+}
+
+// invalidateFacts drops the cached facts of a host whose connection settings changed or that was deleted.
+func (is inventoryService) invalidateFacts(host entity.InventoryHost) {
+	if connection, err := is.connectionHost(host); err == nil {
+		utils.InvalidateFacts(connection)
+	}
+}
+
+func inTransaction(database *gorm.DB, fn func(tx *gorm.DB) error) error {
+	tx := database.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func saveHostRelations(tx *gorm.DB, host entity.InventoryHost) error {
+	names := make([]string, 0, len(host.Labels))
+	for name := range host.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		label := entity.InventoryHostLabel{HostID: host.ID, Name: name, Value: host.Labels[name]}
+		if err := tx.Create(&label).Error; err != nil {
+			return err
+		}
+	}
+	seen := make(map[string]bool)
+	for _, name := range host.Groups {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		group := entity.InventoryHostGroup{HostID: host.ID, Name: name}
+		if err := tx.Create(&group).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func deleteHostRelations(tx *gorm.DB, id uint) error {
+	if err := tx.Where("host_id = ?", id).Delete(&entity.InventoryHostLabel{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("host_id = ?", id).Delete(&entity.InventoryHostGroup{}).Error
+}
+
+// loadHostRelations fills the labels and groups of hosts in place.
+func loadHostRelations(database *gorm.DB, hosts []entity.InventoryHost) error {
+	if len(hosts) == 0 {
+		return nil
+	}
+	index := make(map[uint]int, len(hosts))
+	ids := make([]uint, 0, len(hosts))
+	for i := range hosts {
+		index[hosts[i].ID] = i
+		ids = append(ids, hosts[i].ID)
+		hosts[i].Labels = map[string]string{}
+		hosts[i].Groups = []string{}
+	}
+	var hostLabels []entity.InventoryHostLabel
+	if err := database.Where("host_id IN (?)", ids).Find(&hostLabels).Error; err != nil {
+		return err
+	}
+	for _, label := range hostLabels {
+		hosts[index[label.HostID]].Labels[label.Name] = label.Value
+	}
+	var hostGroups []entity.InventoryHostGroup
+	if err := database.Where("host_id IN (?)", ids).Order("name").Find(&hostGroups).Error; err != nil {
+		return err
+	}
+	for _, group := range hostGroups {
+		i := index[group.HostID]
+		hosts[i].Groups = append(hosts[i].Groups, group.Name)
+	}
+	return nil
+}
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"github.com/spf13/viper"
+	"github.com/whoisfisher/mykubespray/pkg/db"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/migrate"
+	"github.com/whoisfisher/mykubespray/pkg/utils/vault"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newVaultKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// newTestDB migrates and opens a SQLite database and a vault keyed from the environment.
+func newTestDB(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.db")
+	migratePhase := &migrate.InitMigrateDBPhase{Driver: db.DriverSQLite, Path: path, Dir: filepath.Join("..", "..", "migration")}
+	if err := migratePhase.Init(); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	dbPhase := &db.InitDBPhase{Driver: db.DriverSQLite, Path: path}
+	if err := dbPhase.Init(); err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	t.Cleanup(db.Close)
+
+	viper.Set("vault.key_file", "")
+	viper.Set("vault.key_env", vault.DefaultKeyEnv)
+	keys := "1:" + newVaultKey(t)
+	t.Setenv(vault.DefaultKeyEnv, keys)
+	if _, err := vault.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestInventoryWithCredentials(t *testing.T) {
+	keys := newTestDB(t)
+	credentials := NewCredentialService()
+	inventory := NewInventoryService()
+
+	credential, err := credentials.CreateCredential(entity.Credential{Name: "ops", Kind: entity.CredentialPassword, Username: "ops", Secret: "ssh-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.Secret != "" || credential.KeyVersion != 1 {
+		t.Fatalf("unexpected credential %+v", credential)
+	}
+	master, err := inventory.CreateHost(entity.InventoryHost{
+		Name:           "master-1",
+		Address:        "10.0.0.1",
+		CredentialID:   credential.ID,
+		BecomePassword: "sudo-secret",
+		Labels:         map[string]string{"role": "master", "env": "prod"},
+		Groups:         []string{"kube_control_plane", "etcd"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inventory.CreateHost(entity.InventoryHost{Name: "worker-1", Address: "10.0.0.2", Labels: map[string]string{"role": "worker", "env": "prod"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored entity.InventoryHost
+	if err := db.DB.First(&stored, master.ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !vault.IsSealed(stored.BecomePassword) || strings.Contains(stored.BecomePassword, "sudo-secret") {
+		t.Fatalf("become password stored in plaintext: %q", stored.BecomePassword)
+	}
+
+	hosts, err := inventory.ListHosts(entity.InventoryQuery{Selector: "env=prod,role!=worker"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1 || hosts[0].Name != "master-1" || len(hosts[0].Groups) != 2 {
+		t.Fatalf("unexpected hosts %+v", hosts)
+	}
+	resolved, err := inventory.ResolveHosts(entity.HostSelection{Group: "etcd"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resolved) != 1 || resolved[0].User != "ops" || resolved[0].Password != "ssh-secret" || resolved[0].BecomePassword != "sudo-secret" || resolved[0].Port != 22 {
+		t.Fatalf("unexpected resolved host %+v", resolved)
+	}
+	if err := credentials.DeleteCredential(credential.ID); err == nil {
+		t.Fatal("expected a referenced credential not to be deleted")
+	}
+
+	t.Setenv(vault.DefaultKeyEnv, keys+","+"2:"+newVaultKey(t))
+	rotation, err := credentials.RotateCredentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotation.KeyVersion != 2 || rotation.Credentials != 1 || rotation.Hosts != 1 {
+		t.Fatalf("unexpected rotation %+v", rotation)
+	}
+	revealed, err := credentials.RevealCredential(credential.ID, entity.CredentialPassword)
+	if err != nil || revealed.Secret != "ssh-secret" || revealed.KeyVersion != 2 {
+		t.Fatalf("unexpected credential after rotation %+v, %v", revealed, err)
+	}
+	if _, err := inventory.ResolveHosts(entity.HostSelection{HostIDs: []uint{master.ID, 99}}); err == nil {
+		t.Fatal("expected a missing host id to fail")
+	}
+}
+
+func TestRotateCredentialsRollsBack(t *testing.T) {
+	keys := newTestDB(t)
+	credentials := NewCredentialService()
+	credential, err := credentials.CreateCredential(entity.Credential{Name: "ops", Kind: entity.CredentialPassword, Username: "ops", Secret: "ssh-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DB.Create(&entity.Cluster{Name: "broken", ConfigData: "not sealed", KeyVersion: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(vault.DefaultKeyEnv, keys+",2:"+newVaultKey(t))
+	if _, err := credentials.RotateCredentials(); err == nil {
+		t.Fatal("expected a cluster that cannot be decrypted to fail the rotation")
+	}
+	var stored entity.Credential
+	if err := db.DB.First(&stored, credential.ID).Error; err != nil || stored.KeyVersion != 1 {
+		t.Fatalf("expected the rotated credential to be rolled back, got version %d, %v", stored.KeyVersion, err)
+	}
+}
+
+func TestImportAnsibleInventory(t *testing.T) {
+	newTestDB(t)
+	inventory := NewInventoryService()
+	master, err := inventory.CreateHost(entity.InventoryHost{Name: "master-1", Address: "10.0.0.1", Password: "ssh-secret", Labels: map[string]string{"env": "prod"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	duplicated := entity.AnsibleInventory{Hosts: []entity.Host{
+		{Name: "master-1", Address: "10.0.1.1"},
+		{Name: "worker-1", Address: "10.0.1.2"},
+		{Name: "worker-1", Address: "10.0.1.3"},
+	}}
+	if _, err := inventory.ImportAnsibleInventory(duplicated); err == nil {
+		t.Fatal("expected a host imported twice to fail the import")
+	}
+	if hosts, err := inventory.ListHosts(entity.InventoryQuery{}); err != nil || len(hosts) != 1 || hosts[0].Address != "10.0.0.1" {
+		t.Fatalf("expected the failed import to be rolled back, got %+v, %v", hosts, err)
+	}
+
+	imported, err := inventory.ImportAnsibleInventory(entity.AnsibleInventory{
+		Hosts:  []entity.Host{{Name: "master-1", Address: "10.0.1.1"}, {Name: "worker-1", Address: "10.0.1.2", Password: "worker-secret"}},
+		Groups: map[string][]string{"kube_node": {"worker-1"}, "etcd": {"master-1"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 2 || imported[0].ID != master.ID || imported[1].ID == 0 {
+		t.Fatalf("unexpected imported hosts %+v", imported)
+	}
+	stored, err := inventory.GetHost(master.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Address != "10.0.1.1" || stored.Password != "ssh-secret" || stored.Labels["env"] != "prod" || strings.Join(stored.Groups, ",") != "etcd" {
+		t.Fatalf("unexpected updated host %+v", stored)
+	}
+	worker, err := inventory.GetHost(imported[1].ID)
+	if err != nil || worker.Password != "worker-secret" || strings.Join(worker.Groups, ",") != "kube_node" {
+		t.Fatalf("unexpected created host %+v, %v", worker, err)
+	}
+}
+
+func TestValidateInventoryHost(t *testing.T) {
+	cases := map[string]entity.InventoryHost{
+		"name":    {Address: "10.0.0.1"},
+		"address": {Name: "node-1"},
+		"label":   {Name: "node-1", Address: "10.0.0.1", Labels: map[string]string{" ": "x"}},
+		"group":   {Name: "node-1", Address: "10.0.0.1", Groups: []string{""}},
+	}
+	for name, host := range cases {
+		if err := validateInventoryHost(host); err == nil {
+			t.Errorf("expected the host without a valid %s to be rejected", name)
+		}
+	}
+	if err := validateInventoryHost(entity.InventoryHost{Name: "node-1", Address: "10.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInventoryHostCRUD(t *testing.T) {
+	newTestDB(t)
+	inventory := NewInventoryService()
+
+	if _, err := inventory.CreateHost(entity.InventoryHost{Address: "10.0.0.1"}); err == nil {
+		t.Fatal("expected a host without a name to be rejected")
+	}
+	host, err := inventory.CreateHost(entity.InventoryHost{
+		Name:     "node-1",
+		Address:  "10.0.0.1",
+		User:     "root",
+		Password: "ssh-secret",
+		Labels:   map[string]string{"env": "dev"},
+		Groups:   []string{"kube_node"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inventory.CreateHost(entity.InventoryHost{Name: "node-1", Address: "10.0.0.9"}); err == nil {
+		t.Fatal("expected a duplicate host name to be rejected")
+	}
+
+	got, err := inventory.GetHost(host.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Address != "10.0.0.1" || got.Password != "ssh-secret" || got.Labels["env"] != "dev" || len(got.Groups) != 1 {
+		t.Fatalf("unexpected host %+v", got)
+	}
+
+	update := got.Masked()
+	update.Address = "10.0.0.11"
+	update.Labels = map[string]string{"env": "prod"}
+	update.Groups = []string{"kube_node", "etcd"}
+	if _, err := inventory.UpdateHost(host.ID, update); err != nil {
+		t.Fatal(err)
+	}
+	got, err = inventory.GetHost(host.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Address != "10.0.0.11" || got.Password != "ssh-secret" || got.Labels["env"] != "prod" || len(got.Groups) != 2 {
+		t.Fatalf("masked password not kept or update lost: %+v", got)
+	}
+	if hosts, err := inventory.ListHosts(entity.InventoryQuery{Selector: "env=dev"}); err != nil || len(hosts) != 0 {
+		t.Fatalf("expected stale labels to be replaced, got %+v, %v", hosts, err)
+	}
+	if hosts, err := inventory.ListHosts(entity.InventoryQuery{Group: "etcd"}); err != nil || len(hosts) != 1 {
+		t.Fatalf("expected the host in group etcd, got %+v, %v", hosts, err)
+	}
+	if _, err := inventory.ListHosts(entity.InventoryQuery{Selector: "env in ("}); err == nil {
+		t.Fatal("expected an invalid selector to fail")
+	}
+
+	if err := inventory.DeleteHost(host.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inventory.GetHost(host.ID); !errors.Is(err, ErrHostNotFound) {
+		t.Fatalf("expected ErrHostNotFound after delete, got %v", err)
+	}
+	if err := inventory.DeleteHost(host.ID); !errors.Is(err, ErrHostNotFound) {
+		t.Fatalf("expected ErrHostNotFound deleting twice, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"github.com/whoisfisher/mykubespray/pkg/utils"
+)
+
+type KnownHostsService interface {
+	List() []entity.KnownHost
+	Accept(conf entity.KnownHostConf) error
+	Revoke(conf entity.KnownHostConf) error
+}
+
+type knownHostsService struct {
+}
+
+func NewKnownHostsService() knownHostsService {
+	return knownHostsService{}
+}
+
+func (ks knownHostsService) List() []entity.KnownHost {
+	return utils.GetKnownHostsStore().List()
+}
+
+func (ks knownHostsService) Accept(conf entity.KnownHostConf) error {
+	if conf.Port == 0 {
+		conf.Port = 22
+	}
+	err := utils.GetKnownHostsStore().Accept(conf.Address, conf.Port, conf.Fingerprint)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to accept host key for %s:%d: %s", conf.Address, conf.Port, err)
+		return err
+	}
+	return nil
+}
+
+func (ks knownHostsService) Revoke(conf entity.KnownHostConf) error {
+	if conf.Port == 0 {
+		conf.Port = 22
+	}
+	err := utils.GetKnownHostsStore().Revoke(conf.Address, conf.Port)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to revoke host key for %s:%d: %s", conf.Address, conf.Port, err)
+		return err
+	}
+	return nil
+}
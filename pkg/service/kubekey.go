@@ -1,165 +1,242 @@
 package service
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/whoisfisher/mykubespray/pkg/db"
 	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
 	"github.com/whoisfisher/mykubespray/pkg/utils"
+	"time"
 )
 
 type KubekeyService interface {
 	//GenerateConfig() error
-	CreateCluster(conf entity.KubekeyConf, logChan chan utils.LogEntry) error
-	DeleteCluster(conf entity.KubekeyConf, logChan chan utils.LogEntry) error
-	AddNodeToCluster(conf entity.KubekeyConf, logChan chan utils.LogEntry) error
-	DeleteNodeFromCluster(conf entity.KubekeyConf, logChan chan utils.LogEntry) error
+	CreateCluster(ctx context.Context, conf entity.KubekeyConf, logChan chan utils.LogEntry) error
+	DeleteCluster(ctx context.Context, conf entity.KubekeyConf, logChan chan utils.LogEntry) error
+	AddNodeToCluster(ctx context.Context, conf entity.KubekeyConf, logChan chan utils.LogEntry) error
+	DeleteNodeFromCluster(ctx context.Context, conf entity.KubekeyConf, logChan chan utils.LogEntry) error
+	ExportKubesprayInventory(conf entity.KubesprayExportConf) (*entity.KubesprayInventory, error)
 }
 
 type kubekeyService struct {
+	clusterService ClusterService
+}
+
+// kubekeyCommandTimeout bounds a single kk run, zero means no limit.
+func kubekeyCommandTimeout() time.Duration {
+	return viper.GetDuration("kubekey.command_timeout")
 }
 
 func NewKubekeyService() kubekeyService {
-	return kubekeyService{}
+	return kubekeyService{
+		clusterService: NewClusterService(),
+	}
 }
 
-func (ks kubekeyService) CreateCluster(conf entity.KubekeyConf, logChan chan utils.LogEntry) error {
-	sshConfig := utils.SSHConfig{}
+// prepareKubekey connects to the registry host of conf and generates the kk config there,
+// close releases the connection once kk is done.
+func (ks kubekeyService) prepareKubekey(conf entity.KubekeyConf) (client *utils.KubekeyClient, close func(), err error) {
 	registryHost := entity.Host{}
 	for _, host := range conf.Hosts {
 		if host.Registry != nil {
-			sshConfig.Host = host.Address
-			sshConfig.Port = host.Port
-			sshConfig.User = host.User
-			sshConfig.Password = host.Password
-			sshConfig.PrivateKey = host.PrivateKey
-			sshConfig.AuthMethods = host.AuthMethods
 			conf.Registry = *host.Registry
 			registryHost = host
 		}
 	}
-	//connection, err := utils.NewSSHConnection(sshConfig)
-	//if err != nil {
-	//	logger.GetLogger().Errorf("Failed to create SSH connection: %s", err)
-	//	return err
-	//}
 	osCOnf := utils.OSConf{}
 	localExecutor := utils.NewLocalExecutor()
-	//sshExecutor := utils.NewSSHExecutor(*connection)
 	sshExecutor := utils.NewExecutor(registryHost)
+	if sshExecutor == nil {
+		return nil, nil, fmt.Errorf("failed to connect to registry host %s", registryHost.Address)
+	}
 	osclient := utils.NewOSClient(osCOnf, *sshExecutor, *localExecutor)
-	client := utils.NewKubekeyClient(conf, *osclient)
+	client = utils.NewKubekeyClient(conf, *osclient)
 	if len(conf.VIPServer) > 0 {
-		client.GenerateConfigWithVIP()
+		err = client.GenerateConfigWithVIP()
 	} else {
-		client.GenerateConfig()
+		err = client.GenerateConfig()
 	}
-	client.CreateCluster(logChan)
-	return nil
+	if err != nil {
+		sshExecutor.Connection.Close()
+		logger.GetLogger().Errorf("Failed to generate the kubekey config of cluster %s: %s", conf.ClusterName, err.Error())
+		return nil, nil, fmt.Errorf("failed to generate the kubekey config of cluster %s: %w", conf.ClusterName, err)
+	}
+	return client, func() { sshExecutor.Connection.Close() }, nil
 }
 
-func (ks kubekeyService) DeleteCluster(conf entity.KubekeyConf, logChan chan utils.LogEntry) error {
-	sshConfig := utils.SSHConfig{}
-	registryHost := entity.Host{}
-	for _, host := range conf.Hosts {
-		if host.Registry != nil {
-			sshConfig.Host = host.Address
-			sshConfig.Port = host.Port
-			sshConfig.User = host.User
-			sshConfig.Password = host.Password
-			sshConfig.PrivateKey = host.PrivateKey
-			sshConfig.AuthMethods = host.AuthMethods
-			conf.Registry = *host.Registry
-			registryHost = host
+// runKubekey runs kk, bounded by kubekey.command_timeout.
+func (ks kubekeyService) runKubekey(ctx context.Context, run func(ctx context.Context) error) error {
+	ctx, cancel := utils.WithCommandTimeout(ctx, kubekeyCommandTimeout())
+	defer cancel()
+	return run(ctx)
+}
+
+// recordCluster stores conf in the cluster registry, it is skipped when no database is configured.
+func (ks kubekeyService) recordCluster(conf entity.KubekeyConf, status string, cause error) (*entity.Cluster, error) {
+	cluster, err := ks.clusterService.RecordCluster(conf, status, cause)
+	if errors.Is(err, db.ErrNotConfigured) {
+		logger.GetLogger().Warnf("Cluster %s is not recorded: %s", conf.ClusterName, err.Error())
+		return nil, nil
+	}
+	return cluster, err
+}
+
+// CreateCluster records the cluster once its kk config is generated and its status and admin kubeconfig once it is done.
+func (ks kubekeyService) CreateCluster(ctx context.Context, conf entity.KubekeyConf, logChan chan utils.LogEntry) error {
+	conf, err := ks.clusterService.ResolveClusterConf(conf)
+	if err != nil {
+		return err
+	}
+	client, closeClient, err := ks.prepareKubekey(conf)
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+	cluster, err := ks.recordCluster(conf, entity.ClusterCreating, nil)
+	if err != nil {
+		return err
+	}
+	if cluster != nil {
+		conf.ClusterID = cluster.ID
+	}
+	err = ks.runKubekey(ctx, func(ctx context.Context) error {
+		return client.CreateCluster(ctx, logChan)
+	})
+	if err != nil {
+		if _, recordErr := ks.recordCluster(conf, entity.ClusterFailed, err); recordErr != nil {
+			logger.GetLogger().Errorf("Failed to record cluster %s: %s", conf.ClusterName, recordErr.Error())
 		}
+		return err
 	}
-	//connection, err := utils.NewSSHConnection(sshConfig)
-	//if err != nil {
-	//	logger.GetLogger().Errorf("Failed to create SSH connection: %s", err)
-	//	return err
-	//}
-	osCOnf := utils.OSConf{}
-	localExecutor := utils.NewLocalExecutor()
-	//sshExecutor := utils.NewSSHExecutor(*connection)
-	sshExecutor := utils.NewExecutor(registryHost)
-	osclient := utils.NewOSClient(osCOnf, *sshExecutor, *localExecutor)
-	client := utils.NewKubekeyClient(conf, *osclient)
-	if len(conf.VIPServer) > 0 {
-		client.GenerateConfigWithVIP()
-	} else {
-		client.GenerateConfig()
+	if _, err := ks.recordCluster(conf, entity.ClusterRunning, nil); err != nil || cluster == nil {
+		return err
 	}
-	client.DeleteCluster(logChan)
+	ks.recordKubeconfig(cluster.ID, conf)
 	return nil
 }
 
-func (ks kubekeyService) AddNodeToCluster(conf entity.KubekeyConf, logChan chan utils.LogEntry) error {
-	sshConfig := utils.SSHConfig{}
-	registryHost := entity.Host{}
+// recordKubeconfig stores the admin kubeconfig of the first control plane node, reachable at the VIP if there is one.
+func (ks kubekeyService) recordKubeconfig(id uint, conf entity.KubekeyConf) {
 	for _, host := range conf.Hosts {
-		if host.Registry != nil {
-			sshConfig.Host = host.Address
-			sshConfig.Port = host.Port
-			sshConfig.User = host.User
-			sshConfig.Password = host.Password
-			sshConfig.PrivateKey = host.PrivateKey
-			sshConfig.AuthMethods = host.AuthMethods
-			conf.Registry = *host.Registry
-			registryHost = host
+		if len(conf.ContronPlanes) == 0 || host.Name != conf.ContronPlanes[0] {
+			continue
+		}
+		server := host.Address
+		if conf.VIPServer != "" {
+			server = conf.VIPServer
+		}
+		kubeconfig, err := utils.FetchAdminKubeconfig(host, server)
+		if err == nil {
+			err = ks.clusterService.SetClusterKubeconfig(id, kubeconfig)
+		}
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to record the admin kubeconfig of cluster %s: %s", conf.ClusterName, err.Error())
 		}
+		return
 	}
-	//connection, err := utils.NewSSHConnection(sshConfig)
-	//if err != nil {
-	//	logger.GetLogger().Errorf("Failed to create SSH connection: %s", err)
-	//	return err
-	//}
-	osCOnf := utils.OSConf{}
-	localExecutor := utils.NewLocalExecutor()
-	//sshExecutor := utils.NewSSHExecutor(*connection)
-	sshExecutor := utils.NewExecutor(registryHost)
-	osclient := utils.NewOSClient(osCOnf, *sshExecutor, *localExecutor)
-	client := utils.NewKubekeyClient(conf, *osclient)
-	if len(conf.VIPServer) > 0 {
-		client.GenerateConfigWithVIP()
-	} else {
-		client.GenerateConfig()
+}
+
+// DeleteCluster tears the cluster down and forgets it.
+func (ks kubekeyService) DeleteCluster(ctx context.Context, conf entity.KubekeyConf, logChan chan utils.LogEntry) error {
+	conf, err := ks.clusterService.ResolveClusterConf(conf)
+	if err != nil {
+		return err
+	}
+	client, closeClient, err := ks.prepareKubekey(conf)
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+	err = ks.runKubekey(ctx, func(ctx context.Context) error {
+		return client.DeleteCluster(ctx, logChan)
+	})
+	if err != nil {
+		return err
+	}
+	if err := ks.clusterService.ForgetCluster(conf); err != nil && !errors.Is(err, db.ErrNotConfigured) {
+		return err
 	}
-	client.AddNode(logChan)
 	return nil
 }
 
-func (ks kubekeyService) DeleteNodeFromCluster(conf entity.KubekeyConf, logChan chan utils.LogEntry) error {
+// AddNodeToCluster adds the new hosts of conf, with a ClusterID only the new hosts and their roles are needed.
+func (ks kubekeyService) AddNodeToCluster(ctx context.Context, conf entity.KubekeyConf, logChan chan utils.LogEntry) error {
+	conf, err := ks.clusterService.ResolveClusterConf(conf)
+	if err != nil {
+		return err
+	}
+	client, closeClient, err := ks.prepareKubekey(conf)
+	if err != nil {
+		return err
+	}
+	defer closeClient()
+	err = ks.runKubekey(ctx, func(ctx context.Context) error {
+		return client.AddNode(ctx, logChan)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = ks.recordCluster(conf, entity.ClusterRunning, nil)
+	return err
+}
+
+// DeleteNodeFromCluster deletes the host marked IsDeleted, with a ClusterID only that host is needed.
+func (ks kubekeyService) DeleteNodeFromCluster(ctx context.Context, conf entity.KubekeyConf, logChan chan utils.LogEntry) error {
+	conf, err := ks.clusterService.ResolveClusterConf(conf)
+	if err != nil {
+		return err
+	}
 	deleteNode := ""
-	sshConfig := utils.SSHConfig{}
-	registryHost := entity.Host{}
 	for _, host := range conf.Hosts {
-		if host.Registry != nil {
-			sshConfig.Host = host.Address
-			sshConfig.Port = host.Port
-			sshConfig.User = host.User
-			sshConfig.Password = host.Password
-			sshConfig.PrivateKey = host.PrivateKey
-			sshConfig.AuthMethods = host.AuthMethods
-			conf.Registry = *host.Registry
-			registryHost = host
-		}
 		if host.IsDeleted {
 			deleteNode = host.Name
 		}
 	}
-	//connection, err := utils.NewSSHConnection(sshConfig)
-	//if err != nil {
-	//	logger.GetLogger().Errorf("Failed to create SSH connection: %s", err)
-	//	return err
-	//}
-	osCOnf := utils.OSConf{}
-	localExecutor := utils.NewLocalExecutor()
-	//sshExecutor := utils.NewSSHExecutor(*connection)
-	sshExecutor := utils.NewExecutor(registryHost)
-	osclient := utils.NewOSClient(osCOnf, *sshExecutor, *localExecutor)
-	client := utils.NewKubekeyClient(conf, *osclient)
-	if len(conf.VIPServer) > 0 {
-		client.GenerateConfigWithVIP()
-	} else {
-		client.GenerateConfig()
+	client, closeClient, err := ks.prepareKubekey(conf)
+	if err != nil {
+		return err
 	}
-	client.DeleteNode(deleteNode, logChan)
-	return nil
+	defer closeClient()
+	err = ks.runKubekey(ctx, func(ctx context.Context) error {
+		return client.DeleteNode(ctx, deleteNode, logChan)
+	})
+	if err != nil {
+		return err
+	}
+	_, err = ks.recordCluster(withoutNode(conf, deleteNode), entity.ClusterRunning, nil)
+	return err
+}
+
+// withoutNode removes the host name and its roles from conf.
+func withoutNode(conf entity.KubekeyConf, name string) entity.KubekeyConf {
+	hosts := []entity.Host{}
+	for _, host := range conf.Hosts {
+		if host.Name != name {
+			hosts = append(hosts, host)
+		}
+	}
+	conf.Hosts = hosts
+	for _, names := range []*[]string{&conf.Etcds, &conf.ContronPlanes, &conf.Workers} {
+		kept := []string{}
+		for _, item := range *names {
+			if item != name {
+				kept = append(kept, item)
+			}
+		}
+		*names = kept
+	}
+	return conf
+}
+
+// ExportKubesprayInventory renders the cluster as a kubespray hosts.yaml and offline.yml.
+func (ks kubekeyService) ExportKubesprayInventory(conf entity.KubesprayExportConf) (*entity.KubesprayInventory, error) {
+	inventory, err := utils.ExportKubesprayInventory(conf.KubekeyConf, conf.IncludeSecrets)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to export kubespray inventory: %s", err.Error())
+		return nil, err
+	}
+	return inventory, nil
 }
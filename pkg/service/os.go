@@ -105,5 +105,10 @@ func (os osService) CopyFile(conf entity.CertConf) error {
 	//sshExecutor := utils.NewSSHExecutor(*connection)
 	sshExecutor := utils.NewExecutor(conf.Host)
 	client := utils.NewOSClient(osCOnf, *sshExecutor, *localExecutor)
-	return client.CopyFile(conf.CertPath, conf.DestPath)
+	opts, err := utils.NewTransferOptions(conf.Mode, conf.Owner, conf.Group)
+	if err != nil {
+		logger.GetLogger().Errorf("Invalid copy options: %s", err)
+		return err
+	}
+	return client.CopyFileWithOptions(conf.CertPath, conf.DestPath, opts)
 }
@@ -4,13 +4,20 @@ import (
 	"errors"
 	"github.com/whoisfisher/mykubespray/pkg/entity"
 	"github.com/whoisfisher/mykubespray/pkg/utils"
+	"io"
 )
 
 type PoolService interface {
-	CopyFile(srcFile, destFile string, hosts []entity.Host) error
-	AddHosts(record entity.Record, hosts []entity.Host) error
-	ExecuteCommand(command string, hosts []entity.Host) error
-	AddDNS(dns string, hosts []entity.Host) error
+	CopyFile(srcFile, destFile string, opts utils.TransferOptions, hosts []entity.Host, strategy entity.ParallelStrategy) error
+	AddHosts(record entity.Record, hosts []entity.Host, strategy entity.ParallelStrategy) error
+	ExecuteCommand(command string, hosts []entity.Host, strategy entity.ParallelStrategy) (*utils.CopyResult, error)
+	AddDNS(dns string, hosts []entity.Host, strategy entity.ParallelStrategy) error
+	SyncDirectory(srcDir, destDir string, opts utils.SyncOptions, hosts []entity.Host, strategy entity.ParallelStrategy) (*utils.CopyResult, error)
+	FetchFiles(remoteFiles []string, localDir string, hosts []entity.Host, strategy entity.ParallelStrategy) (*utils.CopyResult, error)
+	EnsureFile(file utils.ManagedFile, hosts []entity.Host, strategy entity.ParallelStrategy) (*utils.CopyResult, error)
+	DownloadFiles(w io.Writer, remoteFiles []string, hosts []entity.Host) error
+	CopyRemoteToRemote(srcHost entity.Host, srcFile string, destHost entity.Host, destFile string, opts utils.TransferOptions) error
+	Stats() utils.PoolStats
 }
 
 type poolService struct {
@@ -20,38 +27,77 @@ func NewPoolService() poolService {
 	return poolService{}
 }
 
-func (pool poolService) CopyFile(srcFile, destFile string, hosts []entity.Host) error {
-	execPool := utils.NewSSHExecutorPool()
-	result := execPool.CopyFileParallel(srcFile, destFile, hosts)
+func (pool poolService) CopyFile(srcFile, destFile string, opts utils.TransferOptions, hosts []entity.Host, strategy entity.ParallelStrategy) error {
+	execPool := utils.GetSSHExecutorPool()
+	result := execPool.CopyFileParallel(srcFile, destFile, opts, hosts, strategy)
 	if result.OverallSuccess {
 		return nil
 	}
 	return errors.New("add keycloak cert failed")
 }
 
-func (pool poolService) AddHosts(record entity.Record, hosts []entity.Host) error {
-	execPool := utils.NewSSHExecutorPool()
-	result := execPool.AddHostsParallel(record, hosts)
+func (pool poolService) AddHosts(record entity.Record, hosts []entity.Host, strategy entity.ParallelStrategy) error {
+	execPool := utils.GetSSHExecutorPool()
+	result := execPool.AddHostsParallel(record, hosts, strategy)
 	if result.OverallSuccess {
 		return nil
 	}
 	return errors.New("add /etc/hosts failed")
 }
 
-func (pool poolService) AddDNS(dns string, hosts []entity.Host) error {
-	execPool := utils.NewSSHExecutorPool()
-	result := execPool.AddDNSParallel(dns, hosts)
+func (pool poolService) AddDNS(dns string, hosts []entity.Host, strategy entity.ParallelStrategy) error {
+	execPool := utils.GetSSHExecutorPool()
+	result := execPool.AddDNSParallel(dns, hosts, strategy)
 	if result.OverallSuccess {
 		return nil
 	}
 	return errors.New("add /etc/resolv.conf failed")
 }
 
-func (pool poolService) ExecuteCommand(command string, hosts []entity.Host) error {
-	execPool := utils.NewSSHExecutorPool()
-	result := execPool.ExecuteCommandParallel(command, hosts)
+func (pool poolService) ExecuteCommand(command string, hosts []entity.Host, strategy entity.ParallelStrategy) (*utils.CopyResult, error) {
+	execPool := utils.GetSSHExecutorPool()
+	result := execPool.ExecuteCommandParallel(command, hosts, strategy)
 	if result.OverallSuccess {
-		return nil
+		return result, nil
+	}
+	return result, errors.New("execute command failed")
+}
+
+func (pool poolService) SyncDirectory(srcDir, destDir string, opts utils.SyncOptions, hosts []entity.Host, strategy entity.ParallelStrategy) (*utils.CopyResult, error) {
+	execPool := utils.GetSSHExecutorPool()
+	result := execPool.SyncDirectoryParallel(srcDir, destDir, opts, hosts, strategy)
+	if result.OverallSuccess {
+		return result, nil
 	}
-	return errors.New("execute command failed")
+	return result, errors.New("sync directory failed")
+}
+
+func (pool poolService) FetchFiles(remoteFiles []string, localDir string, hosts []entity.Host, strategy entity.ParallelStrategy) (*utils.CopyResult, error) {
+	execPool := utils.GetSSHExecutorPool()
+	result := execPool.FetchFilesParallel(remoteFiles, localDir, hosts, strategy)
+	if result.OverallSuccess {
+		return result, nil
+	}
+	return result, errors.New("fetch files failed")
+}
+
+func (pool poolService) EnsureFile(file utils.ManagedFile, hosts []entity.Host, strategy entity.ParallelStrategy) (*utils.CopyResult, error) {
+	execPool := utils.GetSSHExecutorPool()
+	result := execPool.EnsureFileParallel(file, hosts, strategy)
+	if result.OverallSuccess {
+		return result, nil
+	}
+	return result, errors.New("ensure file failed")
+}
+
+func (pool poolService) DownloadFiles(w io.Writer, remoteFiles []string, hosts []entity.Host) error {
+	return utils.GetSSHExecutorPool().WriteFilesTarGz(w, remoteFiles, hosts)
+}
+
+func (pool poolService) CopyRemoteToRemote(srcHost entity.Host, srcFile string, destHost entity.Host, destFile string, opts utils.TransferOptions) error {
+	return utils.GetSSHExecutorPool().CopyRemoteToRemote(srcHost, srcFile, destHost, destFile, opts)
+}
+
+func (pool poolService) Stats() utils.PoolStats {
+	return utils.GetSSHExecutorPool().Stats()
 }
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"github.com/spf13/viper"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/utils"
+	"time"
+)
+
+const defaultTerminalIdleTimeout = 15 * time.Minute
+
+type TerminalService interface {
+	Open(conf entity.TerminalConf) (*utils.Terminal, error)
+	IdleTimeout() time.Duration
+}
+
+type terminalService struct {
+}
+
+func NewTerminalService() terminalService {
+	return terminalService{}
+}
+
+func (ts terminalService) Open(conf entity.TerminalConf) (*utils.Terminal, error) {
+	return utils.OpenTerminal(conf.Host, conf.Cols, conf.Rows)
+}
+
+// IdleTimeout is how long a terminal may go without input, 0 disables it.
+func (ts terminalService) IdleTimeout() time.Duration {
+	if !viper.IsSet("terminal.idle_timeout") {
+		return defaultTerminalIdleTimeout
+	}
+	return viper.GetDuration("terminal.idle_timeout")
+}
@@ -0,0 +1,559 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"gopkg.in/yaml.v2"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Ansible inventory formats accepted by ParseAnsibleInventory.
+const (
+	InventoryFormatYAML = "yaml"
+	InventoryFormatINI  = "ini"
+)
+
+// ansibleInventory collects hosts, groups and variables in the order they appear in the file.
+type ansibleInventory struct {
+	hosts         []string
+	hostVars      map[string]map[string]string
+	groupHosts    map[string][]string
+	groupChildren map[string][]string
+	groupVars     map[string]map[string]string
+}
+
+func newAnsibleInventory() *ansibleInventory {
+	return &ansibleInventory{
+		hostVars:      make(map[string]map[string]string),
+		groupHosts:    make(map[string][]string),
+		groupChildren: make(map[string][]string),
+		groupVars:     make(map[string]map[string]string),
+	}
+}
+
+func (inventory *ansibleInventory) addHost(group, name string, vars map[string]string) {
+	if _, exists := inventory.hostVars[name]; !exists {
+		inventory.hosts = append(inventory.hosts, name)
+		inventory.hostVars[name] = make(map[string]string)
+	}
+	for key, value := range vars {
+		inventory.hostVars[name][key] = value
+	}
+	inventory.addGroup(group)
+	for _, member := range inventory.groupHosts[group] {
+		if member == name {
+			return
+		}
+	}
+	inventory.groupHosts[group] = append(inventory.groupHosts[group], name)
+}
+
+func (inventory *ansibleInventory) addGroup(group string) {
+	if _, exists := inventory.groupVars[group]; !exists {
+		inventory.groupVars[group] = make(map[string]string)
+	}
+}
+
+func (inventory *ansibleInventory) addChild(parent, child string) {
+	inventory.addGroup(parent)
+	inventory.addGroup(child)
+	for _, existing := range inventory.groupChildren[parent] {
+		if existing == child {
+			return
+		}
+	}
+	inventory.groupChildren[parent] = append(inventory.groupChildren[parent], child)
+}
+
+// ParseAnsibleInventory reads an Ansible YAML or INI inventory, such as a kubespray hosts.yaml.
+// The format is detected when it is empty. Group variables apply to the hosts of the group and its children,
+// host variables win, Jinja expressions and host ranges are kept verbatim.
+func ParseAnsibleInventory(data []byte, format string) (*entity.AnsibleInventory, error) {
+	inventory := newAnsibleInventory()
+	if format == "" {
+		format = detectInventoryFormat(data)
+	}
+	var err error
+	switch format {
+	case InventoryFormatYAML:
+		err = inventory.parseYAML(data)
+	case InventoryFormatINI:
+		err = inventory.parseINI(data)
+	default:
+		err = fmt.Errorf("unsupported inventory format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return inventory.result()
+}
+
+func detectInventoryFormat(data []byte) string {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") || line == "---" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") || !strings.Contains(line, ":") {
+			return InventoryFormatINI
+		}
+		return InventoryFormatYAML
+	}
+	return InventoryFormatYAML
+}
+
+func (inventory *ansibleInventory) parseYAML(data []byte) error {
+	var root yaml.MapSlice
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return fmt.Errorf("invalid YAML inventory: %w", err)
+	}
+	for _, item := range root {
+		if err := inventory.yamlGroup(fmt.Sprint(item.Key), item.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (inventory *ansibleInventory) yamlGroup(group string, value interface{}) error {
+	inventory.addGroup(group)
+	if value == nil {
+		return nil
+	}
+	body, ok := value.(yaml.MapSlice)
+	if !ok {
+		return fmt.Errorf("group %s must be a mapping", group)
+	}
+	for _, item := range body {
+		section := fmt.Sprint(item.Key)
+		entries, ok := item.Value.(yaml.MapSlice)
+		if item.Value != nil && !ok {
+			return fmt.Errorf("%s of group %s must be a mapping", section, group)
+		}
+		switch section {
+		case "hosts":
+			for _, host := range entries {
+				vars, err := yamlVars(host.Value)
+				if err != nil {
+					return fmt.Errorf("host %v: %w", host.Key, err)
+				}
+				inventory.addHost(group, fmt.Sprint(host.Key), vars)
+			}
+		case "children":
+			for _, child := range entries {
+				inventory.addChild(group, fmt.Sprint(child.Key))
+				if err := inventory.yamlGroup(fmt.Sprint(child.Key), child.Value); err != nil {
+					return err
+				}
+			}
+		case "vars":
+			vars, err := yamlVars(item.Value)
+			if err != nil {
+				return fmt.Errorf("vars of group %s: %w", group, err)
+			}
+			for key, value := range vars {
+				inventory.groupVars[group][key] = value
+			}
+		default:
+			return fmt.Errorf("unknown key %q in group %s", section, group)
+		}
+	}
+	return nil
+}
+
+func yamlVars(value interface{}) (map[string]string, error) {
+	vars := make(map[string]string)
+	if value == nil {
+		return vars, nil
+	}
+	entries, ok := value.(yaml.MapSlice)
+	if !ok {
+		return nil, fmt.Errorf("variables must be a mapping")
+	}
+	for _, entry := range entries {
+		if entry.Value == nil {
+			vars[fmt.Sprint(entry.Key)] = ""
+			continue
+		}
+		vars[fmt.Sprint(entry.Key)] = fmt.Sprint(entry.Value)
+	}
+	return vars, nil
+}
+
+func (inventory *ansibleInventory) parseINI(data []byte) error {
+	group, kind := "ungrouped", ""
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			group, kind = line[1:len(line)-1], ""
+			if index := strings.Index(group, ":"); index >= 0 {
+				group, kind = group[:index], group[index+1:]
+			}
+			if kind != "" && kind != "children" && kind != "vars" {
+				return fmt.Errorf("line %d: unknown section type %q", number, kind)
+			}
+			inventory.addGroup(group)
+			continue
+		}
+		fields, err := splitINIFields(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", number, err)
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		switch kind {
+		case "children":
+			inventory.addChild(group, fields[0])
+		case "vars":
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return fmt.Errorf("line %d: expected key=value", number)
+			}
+			inventory.groupVars[group][strings.TrimSpace(key)] = unquote(strings.TrimSpace(value))
+		default:
+			vars := make(map[string]string)
+			for _, field := range fields[1:] {
+				key, value, ok := strings.Cut(field, "=")
+				if !ok {
+					return fmt.Errorf("line %d: expected key=value, got %q", number, field)
+				}
+				vars[key] = value
+			}
+			inventory.addHost(group, fields[0], vars)
+		}
+	}
+	return scanner.Err()
+}
+
+// splitINIFields splits a host line on whitespace, quoted values may contain spaces and a word starting with # or ; starts a comment.
+func splitINIFields(line string) ([]string, error) {
+	var fields []string
+	var field strings.Builder
+	var quote rune
+	for _, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			field.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+		case (r == '#' || r == ';') && field.Len() == 0:
+			return fields, nil
+		case r == ' ' || r == '\t':
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+		default:
+			field.WriteRune(r)
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+	return fields, nil
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// members returns the hosts of group and of its children.
+func (inventory *ansibleInventory) members(group string, visited map[string]bool) []string {
+	if visited[group] {
+		return nil
+	}
+	visited[group] = true
+	members := append([]string{}, inventory.groupHosts[group]...)
+	for _, child := range inventory.groupChildren[group] {
+		members = append(members, inventory.members(child, visited)...)
+	}
+	return members
+}
+
+// depth orders group variables, parents are applied before their children.
+func (inventory *ansibleInventory) depth(group string, parents map[string][]string, visiting map[string]bool) int {
+	if visiting[group] {
+		return 0
+	}
+	visiting[group] = true
+	defer delete(visiting, group)
+	depth := 0
+	for _, parent := range parents[group] {
+		if d := inventory.depth(parent, parents, visiting) + 1; d > depth {
+			depth = d
+		}
+	}
+	return depth
+}
+
+func (inventory *ansibleInventory) result() (*entity.AnsibleInventory, error) {
+	parents := make(map[string][]string)
+	for parent, children := range inventory.groupChildren {
+		for _, child := range children {
+			parents[child] = append(parents[child], parent)
+		}
+	}
+	groups := make([]string, 0, len(inventory.groupVars))
+	depths := make(map[string]int)
+	for group := range inventory.groupVars {
+		groups = append(groups, group)
+		depths[group] = inventory.depth(group, parents, map[string]bool{})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if depths[groups[i]] != depths[groups[j]] {
+			return depths[groups[i]] < depths[groups[j]]
+		}
+		return groups[i] < groups[j]
+	})
+
+	result := &entity.AnsibleInventory{Groups: make(map[string][]string)}
+	hostGroups := make(map[string]map[string]bool)
+	for _, group := range groups {
+		seen := make(map[string]bool)
+		for _, member := range inventory.members(group, map[string]bool{}) {
+			if seen[member] {
+				continue
+			}
+			seen[member] = true
+			if hostGroups[member] == nil {
+				hostGroups[member] = make(map[string]bool)
+			}
+			hostGroups[member][group] = true
+			if group != "all" && group != "ungrouped" {
+				result.Groups[group] = append(result.Groups[group], member)
+			}
+		}
+	}
+	for _, name := range inventory.hosts {
+		vars := make(map[string]string)
+		for key, value := range inventory.groupVars["all"] {
+			vars[key] = value
+		}
+		for _, group := range groups {
+			if group == "all" || !hostGroups[name][group] {
+				continue
+			}
+			for key, value := range inventory.groupVars[group] {
+				vars[key] = value
+			}
+		}
+		for key, value := range inventory.hostVars[name] {
+			vars[key] = value
+		}
+		host, err := ansibleHost(name, vars)
+		if err != nil {
+			return nil, err
+		}
+		result.Hosts = append(result.Hosts, host)
+	}
+	return result, nil
+}
+
+// ansibleHost maps the connection variables of an inventory host, other variables are ignored.
+func ansibleHost(name string, vars map[string]string) (entity.Host, error) {
+	host := entity.Host{Name: name, Address: name, Port: 22}
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if value := vars[key]; value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+	if address := first("ansible_host", "ansible_ssh_host"); address != "" {
+		host.Address = address
+	}
+	host.InternalAddress = first("ip", "access_ip")
+	if host.InternalAddress == "" {
+		host.InternalAddress = host.Address
+	}
+	if port := first("ansible_port", "ansible_ssh_port"); port != "" {
+		value, err := strconv.ParseInt(port, 10, 32)
+		if err != nil {
+			return host, fmt.Errorf("host %s: invalid ansible_port %q", name, port)
+		}
+		host.Port = int32(value)
+	}
+	host.User = first("ansible_user", "ansible_ssh_user")
+	host.Password = first("ansible_password", "ansible_ssh_pass", "ansible_ssh_password")
+	host.PrivateKey = first("ansible_ssh_private_key_file", "ansible_private_key_file")
+	host.BecomeMethod = first("ansible_become_method")
+	host.BecomeUser = first("ansible_become_user")
+	host.BecomePassword = first("ansible_become_password", "ansible_become_pass", "ansible_sudo_pass")
+	return host, nil
+}
+
+// ExportKubesprayInventory renders the hosts and roles of conf as a kubespray hosts.yaml, and its cluster settings
+// as offline.yml variables. Passwords are only written when includeSecrets is set, private keys only as file paths.
+func ExportKubesprayInventory(conf entity.KubekeyConf, includeSecrets bool) (*entity.KubesprayInventory, error) {
+	known := make(map[string]bool, len(conf.Hosts))
+	hosts := yaml.MapSlice{}
+	for _, host := range conf.Hosts {
+		if host.Name == "" {
+			return nil, fmt.Errorf("host %s has no name", host.Address)
+		}
+		known[host.Name] = true
+		vars := yaml.MapSlice{{Key: "ansible_host", Value: host.Address}}
+		if host.Port != 0 && host.Port != 22 {
+			vars = append(vars, yaml.MapItem{Key: "ansible_port", Value: host.Port})
+		}
+		if host.User != "" {
+			vars = append(vars, yaml.MapItem{Key: "ansible_user", Value: host.User})
+		}
+		if includeSecrets && host.Password != "" {
+			vars = append(vars, yaml.MapItem{Key: "ansible_ssh_pass", Value: host.Password})
+		}
+		if host.PrivateKey != "" && !strings.Contains(host.PrivateKey, "PRIVATE KEY") {
+			vars = append(vars, yaml.MapItem{Key: "ansible_ssh_private_key_file", Value: host.PrivateKey})
+		}
+		if host.BecomeMethod != "" && host.BecomeMethod != "none" {
+			vars = append(vars, yaml.MapItem{Key: "ansible_become_method", Value: host.BecomeMethod})
+		}
+		if host.BecomeUser != "" {
+			vars = append(vars, yaml.MapItem{Key: "ansible_become_user", Value: host.BecomeUser})
+		}
+		if includeSecrets && host.BecomePassword != "" {
+			vars = append(vars, yaml.MapItem{Key: "ansible_become_password", Value: host.BecomePassword})
+		}
+		internal := host.InternalAddress
+		if internal == "" {
+			internal = host.Address
+		}
+		vars = append(vars, yaml.MapItem{Key: "ip", Value: internal}, yaml.MapItem{Key: "access_ip", Value: internal})
+		hosts = append(hosts, yaml.MapItem{Key: host.Name, Value: vars})
+	}
+	roleGroup := func(role string, names []string) (yaml.MapItem, error) {
+		members := yaml.MapSlice{}
+		for _, name := range names {
+			if !known[name] {
+				return yaml.MapItem{}, fmt.Errorf("%s host %s is not in the host list", role, name)
+			}
+			members = append(members, yaml.MapItem{Key: name, Value: nil})
+		}
+		return yaml.MapItem{Key: role, Value: yaml.MapSlice{{Key: "hosts", Value: members}}}, nil
+	}
+	children := yaml.MapSlice{}
+	for _, role := range []struct {
+		name  string
+		hosts []string
+	}{
+		{"kube_control_plane", conf.ContronPlanes},
+		{"kube_node", conf.Workers},
+		{"etcd", conf.Etcds},
+	} {
+		group, err := roleGroup(role.name, role.hosts)
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, group)
+	}
+	children = append(children,
+		yaml.MapItem{Key: "k8s_cluster", Value: yaml.MapSlice{{Key: "children", Value: yaml.MapSlice{
+			{Key: "kube_control_plane", Value: nil},
+			{Key: "kube_node", Value: nil},
+		}}}},
+		yaml.MapItem{Key: "calico_rr", Value: yaml.MapSlice{{Key: "hosts", Value: yaml.MapSlice{}}}},
+	)
+	inventory := yaml.MapSlice{{Key: "all", Value: yaml.MapSlice{
+		{Key: "hosts", Value: hosts},
+		{Key: "children", Value: children},
+	}}}
+	hostsYAML, err := yaml.Marshal(inventory)
+	if err != nil {
+		return nil, err
+	}
+	offlineYAML, err := yaml.Marshal(kubesprayVars(conf, includeSecrets))
+	if err != nil {
+		return nil, err
+	}
+	return &entity.KubesprayInventory{HostsYAML: string(hostsYAML), OfflineYAML: string(offlineYAML)}, nil
+}
+
+// kubesprayVars maps the cluster settings of conf to kubespray variables, unset settings keep the kubespray defaults.
+func kubesprayVars(conf entity.KubekeyConf, includeSecrets bool) yaml.MapSlice {
+	vars := yaml.MapSlice{}
+	set := func(key string, value string) {
+		if value != "" {
+			vars = append(vars, yaml.MapItem{Key: key, Value: value})
+		}
+	}
+	set("kube_version", conf.KubernetesVersion)
+	set("container_manager", conf.ContainerManager)
+	set("kube_pods_subnet", conf.KubePodsCIDR)
+	set("kube_service_addresses", conf.KubeServiceCIDR)
+	set("kube_proxy_mode", conf.ProxyMode)
+	set("calico_ipip_mode", conf.IPIPMode)
+	set("calico_vxlan_mode", conf.VxlanMode)
+	if len(conf.NtpServers) > 0 {
+		vars = append(vars, yaml.MapItem{Key: "ntp_enabled", Value: true}, yaml.MapItem{Key: "ntp_servers", Value: conf.NtpServers})
+	}
+	if conf.VIPServer != "" {
+		address, port := conf.VIPServer, 6443
+		if host, p, err := net.SplitHostPort(conf.VIPServer); err == nil {
+			if value, err := strconv.Atoi(p); err == nil {
+				address, port = host, value
+			}
+		}
+		vars = append(vars, yaml.MapItem{Key: "loadbalancer_apiserver", Value: yaml.MapSlice{
+			{Key: "address", Value: address},
+			{Key: "port", Value: port},
+		}})
+	}
+	registry := conf.Registry
+	registryHost := registry.Url
+	if index := strings.Index(registryHost, "://"); index >= 0 {
+		registryHost = registryHost[index+3:]
+	}
+	registryHost = strings.TrimSuffix(registryHost, "/")
+	if registryHost == "" {
+		return vars
+	}
+	set("registry_host", registryHost)
+	for _, repo := range []string{"kube_image_repo", "gcr_image_repo", "github_image_repo", "docker_image_repo", "quay_image_repo"} {
+		set(repo, "{{ registry_host }}")
+	}
+	if registry.SkipTLS || registry.PlainHttp {
+		scheme := "https"
+		if registry.PlainHttp {
+			scheme = "http"
+		}
+		vars = append(vars, yaml.MapItem{Key: "containerd_registries_mirrors", Value: []yaml.MapSlice{{
+			{Key: "prefix", Value: registryHost},
+			{Key: "mirrors", Value: []yaml.MapSlice{{
+				{Key: "host", Value: scheme + "://" + registryHost},
+				{Key: "capabilities", Value: []string{"pull", "resolve"}},
+				{Key: "skip_verify", Value: registry.SkipTLS},
+			}}},
+		}}})
+	}
+	if len(registry.InsecureRegistries) > 0 {
+		vars = append(vars, yaml.MapItem{Key: "docker_insecure_registries", Value: registry.InsecureRegistries})
+	}
+	if includeSecrets && registry.User != "" {
+		vars = append(vars, yaml.MapItem{Key: "containerd_registry_auth", Value: []yaml.MapSlice{{
+			{Key: "registry", Value: registryHost},
+			{Key: "username", Value: registry.User},
+			{Key: "password", Value: registry.Password},
+		}}})
+	}
+	return vars
+}
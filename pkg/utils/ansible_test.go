@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const kubesprayYAMLInventory = `all:
+  vars:
+    ansible_user: root
+  hosts:
+    node1:
+      ansible_host: 192.168.227.143
+      ansible_port: 2222
+      ip: 10.0.0.1
+      ansible_ssh_pass: secret
+    node2:
+      ansible_host: 192.168.227.144
+      ansible_user: admin
+  children:
+    kube_control_plane:
+      hosts:
+        node1:
+    kube_node:
+      hosts:
+        node1:
+        node2:
+    etcd:
+      hosts:
+        node1:
+    k8s_cluster:
+      vars:
+        ansible_become_user: root
+      children:
+        kube_control_plane:
+        kube_node:
+    calico_rr:
+      hosts: {}
+`
+
+func TestParseAnsibleYAMLInventory(t *testing.T) {
+	inventory, err := ParseAnsibleInventory([]byte(kubesprayYAMLInventory), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inventory.Hosts) != 2 {
+		t.Fatalf("expected 2 hosts, got %+v", inventory.Hosts)
+	}
+	node1, node2 := inventory.Hosts[0], inventory.Hosts[1]
+	if node1.Name != "node1" || node1.Address != "192.168.227.143" || node1.InternalAddress != "10.0.0.1" || node1.Port != 2222 ||
+		node1.User != "root" || node1.Password != "secret" || node1.BecomeUser != "root" {
+		t.Fatalf("unexpected node1 %+v", node1)
+	}
+	if node2.User != "admin" || node2.Port != 22 || node2.InternalAddress != node2.Address {
+		t.Fatalf("host variables must win over group variables: %+v", node2)
+	}
+	expected := map[string][]string{
+		"kube_control_plane": {"node1"},
+		"kube_node":          {"node1", "node2"},
+		"etcd":               {"node1"},
+		"k8s_cluster":        {"node1", "node2"},
+	}
+	if !reflect.DeepEqual(inventory.Groups, expected) {
+		t.Fatalf("unexpected groups %v", inventory.Groups)
+	}
+}
+
+func TestParseAnsibleINIInventory(t *testing.T) {
+	ini := `# kubespray inventory
+node1 ansible_host=10.0.0.1 ip=10.1.0.1
+[kube_control_plane]
+node1
+[kube_node]
+node2 ansible_host=10.0.0.2 ansible_ssh_pass="p w" ; worker
+[k8s_cluster:children]
+kube_control_plane
+kube_node
+[k8s_cluster:vars]
+ansible_user = 'ops'
+`
+	inventory, err := ParseAnsibleInventory([]byte(ini), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inventory.Hosts) != 2 || inventory.Hosts[0].InternalAddress != "10.1.0.1" || inventory.Hosts[1].Password != "p w" {
+		t.Fatalf("unexpected hosts %+v", inventory.Hosts)
+	}
+	for _, host := range inventory.Hosts {
+		if host.User != "ops" {
+			t.Fatalf("expected group vars of the parent group, got %+v", host)
+		}
+	}
+	if !reflect.DeepEqual(inventory.Groups["k8s_cluster"], []string{"node1", "node2"}) {
+		t.Fatalf("unexpected groups %v", inventory.Groups)
+	}
+	if _, err := ParseAnsibleInventory([]byte("[all:hosts]\nnode1\n"), InventoryFormatINI); err == nil {
+		t.Fatal("expected an unknown section type to fail")
+	}
+}
+
+func TestExportKubesprayInventoryRoundTrip(t *testing.T) {
+	conf := entity.KubekeyConf{
+		Hosts: []entity.Host{
+			{Name: "master", Address: "10.0.0.1", InternalAddress: "172.16.0.1", Port: 22, User: "root", Password: "secret"},
+			{Name: "worker", Address: "10.0.0.2", Port: 2222, User: "root", Password: "secret"},
+		},
+		ContronPlanes:     []string{"master"},
+		Etcds:             []string{"master"},
+		Workers:           []string{"master", "worker"},
+		KubernetesVersion: "v1.28.6",
+		VIPServer:         "10.0.0.100",
+		Registry:          entity.Registry{Url: "https://registry.local:5000/", SkipTLS: true, User: "admin", Password: "harbor"},
+	}
+	exported, err := ExportKubesprayInventory(conf, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(exported.HostsYAML+exported.OfflineYAML, "secret") || strings.Contains(exported.OfflineYAML, "harbor") {
+		t.Fatalf("secrets exported without includeSecrets:\n%s\n%s", exported.HostsYAML, exported.OfflineYAML)
+	}
+	for _, expected := range []string{"kube_version: v1.28.6", "registry_host: registry.local:5000", "address: 10.0.0.100", "skip_verify: true"} {
+		if !strings.Contains(exported.OfflineYAML, expected) {
+			t.Fatalf("offline.yml misses %q:\n%s", expected, exported.OfflineYAML)
+		}
+	}
+	inventory, err := ParseAnsibleInventory([]byte(exported.HostsYAML), InventoryFormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inventory.Hosts) != 2 || inventory.Hosts[0].InternalAddress != "172.16.0.1" || inventory.Hosts[1].Port != 2222 {
+		t.Fatalf("unexpected hosts after round trip %+v", inventory.Hosts)
+	}
+	if !reflect.DeepEqual(inventory.Groups["kube_node"], conf.Workers) || !reflect.DeepEqual(inventory.Groups["etcd"], conf.Etcds) {
+		t.Fatalf("unexpected groups after round trip %v", inventory.Groups)
+	}
+
+	conf.Workers = append(conf.Workers, "missing")
+	if _, err := ExportKubesprayInventory(conf, true); err == nil {
+		t.Fatal("expected a role with an unknown host to fail")
+	}
+}
@@ -0,0 +1,433 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"golang.org/x/crypto/ssh"
+	"hash"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	AuditKindCommand  = "command"
+	AuditKindStream   = "stream"
+	AuditKindTerminal = "terminal"
+)
+
+const (
+	defaultAuditMaxRecords = 10000
+	defaultCastDir         = "data/casts"
+	redacted               = "******"
+)
+
+// secretArgument matches values of credential options and variables, such as --password x, --token=x or DB_PASSWORD=x.
+var secretArgument = regexp.MustCompile(`(?i)((?:^|\s)(?:--?[a-z0-9_-]*(?:password|passwd|passphrase|secret|token|api[_-]?key)[a-z0-9_-]*(?:=|\s+)|[a-z0-9_]*(?:password|passwd|passphrase|secret|token|api_?key)[a-z0-9_]*=))('[^']*'|"[^"]*"|\S+)`)
+
+// CommandRecord is the audit trail of one remote execution.
+type CommandRecord struct {
+	ID           string    `json:"id"`
+	Kind         string    `json:"kind"`
+	Host         string    `json:"host"`
+	User         string    `json:"user"`
+	Command      string    `json:"command"`
+	ExitCode     int       `json:"exit_code"`
+	Error        string    `json:"error,omitempty"`
+	StartTime    time.Time `json:"start_time"`
+	EndTime      time.Time `json:"end_time"`
+	DurationMs   int64     `json:"duration_ms"`
+	OutputSize   int64     `json:"output_size"`
+	OutputDigest string    `json:"output_digest"`
+	CastFile     string    `json:"cast_file,omitempty"`
+}
+
+// AuditQuery filters records, empty fields match everything.
+type AuditQuery struct {
+	Host  string
+	User  string
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+func (query AuditQuery) Match(record CommandRecord) bool {
+	if query.Host != "" && record.Host != query.Host {
+		return false
+	}
+	if query.User != "" && record.User != query.User {
+		return false
+	}
+	if !query.Since.IsZero() && record.StartTime.Before(query.Since) {
+		return false
+	}
+	if !query.Until.IsZero() && record.StartTime.After(query.Until) {
+		return false
+	}
+	return true
+}
+
+// AuditLog keeps the most recent audit.max_records command records in memory and hands every record to the sink
+// set by SetSink, which stores it in the database. Without a sink the records are lost on restart.
+type AuditLog struct {
+	mutex      sync.Mutex
+	records    []CommandRecord
+	maxRecords int
+	sink       func(record CommandRecord) error
+}
+
+var (
+	sharedAuditLog     *AuditLog
+	sharedAuditLogOnce sync.Once
+)
+
+// GetAuditLog returns the audit log every executor records to.
+func GetAuditLog() *AuditLog {
+	sharedAuditLogOnce.Do(func() {
+		maxRecords := viper.GetInt("audit.max_records")
+		if maxRecords <= 0 {
+			maxRecords = defaultAuditMaxRecords
+		}
+		sharedAuditLog = &AuditLog{maxRecords: maxRecords}
+	})
+	return sharedAuditLog
+}
+
+// SetSink makes Add persist every record with sink.
+func (auditLog *AuditLog) SetSink(sink func(record CommandRecord) error) {
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+	auditLog.sink = sink
+}
+
+func (auditLog *AuditLog) Add(record CommandRecord) {
+	auditLog.mutex.Lock()
+	auditLog.records = append(auditLog.records, record)
+	if len(auditLog.records) > auditLog.maxRecords {
+		auditLog.records = append([]CommandRecord(nil), auditLog.records[len(auditLog.records)-auditLog.maxRecords:]...)
+	}
+	sink := auditLog.sink
+	auditLog.mutex.Unlock()
+	if sink != nil {
+		if err := sink(record); err != nil {
+			logger.GetLogger().Errorf("Failed to store audit record %s: %s", record.ID, err.Error())
+		}
+	}
+}
+
+// Query returns the matching records, newest first.
+func (auditLog *AuditLog) Query(query AuditQuery) []CommandRecord {
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+	records := []CommandRecord{}
+	for i := len(auditLog.records) - 1; i >= 0; i-- {
+		if !query.Match(auditLog.records[i]) {
+			continue
+		}
+		records = append(records, auditLog.records[i])
+		if query.Limit > 0 && len(records) >= query.Limit {
+			break
+		}
+	}
+	return records
+}
+
+// Get returns the record with id.
+func (auditLog *AuditLog) Get(id string) (CommandRecord, bool) {
+	auditLog.mutex.Lock()
+	defer auditLog.mutex.Unlock()
+	for i := len(auditLog.records) - 1; i >= 0; i-- {
+		if auditLog.records[i].ID == id {
+			return auditLog.records[i], true
+		}
+	}
+	return CommandRecord{}, false
+}
+
+// RedactCommand hides the given secrets and credential looking arguments in command.
+func RedactCommand(command string, secrets ...string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			command = strings.ReplaceAll(command, secret, redacted)
+		}
+	}
+	return secretArgument.ReplaceAllString(command, "${1}"+redacted)
+}
+
+// secretKey matches the names of fields holding secrets, such as Password, private_key or ansible_ssh_pass.
+var secretKey = regexp.MustCompile(`(?i)(password|passwd|_pass$|passphrase|secret|token|private_?key|api_?key|kubeconfig$)`)
+
+// secretField matches "key: value" and "key=value" pairs of secret fields in YAML, INI and form bodies,
+// the value runs to the end of the line or the next form field.
+var secretField = regexp.MustCompile(`(?im)([a-z0-9_-]*(?:password|passwd|_pass|passphrase|secret|token|private_?key|api_?key)[a-z0-9_-]*["']?[ \t]*[:=][ \t]*)([^&\r\n]+)`)
+
+// secretBlock matches the line starting a YAML block scalar of a secret field, such as "private_key: |".
+var secretBlock = regexp.MustCompile(`(?i)^([ \t]*)["']?[a-z0-9_-]*(?:password|passwd|_pass|passphrase|secret|token|private_?key|api_?key|kubeconfig)[a-z0-9_-]*["']?[ \t]*:[ \t]*[|>][-+0-9]*[ \t]*$`)
+
+// privateKeyBlock matches PEM and OpenSSH private keys wherever they appear.
+var privateKeyBlock = regexp.MustCompile(`(?s)-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----.*?(-----END [A-Z0-9 ]*PRIVATE KEY-----|$)`)
+
+// BinaryContentType reports whether bodies of contentType are only described in the audit log.
+func BinaryContentType(contentType string) bool {
+	return strings.HasPrefix(contentType, "multipart/") || strings.HasPrefix(contentType, "application/octet-stream")
+}
+
+// RedactBody returns a request body for the audit log with its secret fields hidden. JSON fields are redacted by name,
+// other text by key/value pairs, binary bodies are only described, and the result is cut after limit bytes.
+func RedactBody(contentType string, body []byte, limit int) string {
+	if len(body) == 0 {
+		return ""
+	}
+	if BinaryContentType(contentType) {
+		return fmt.Sprintf("[%d bytes of %s]", len(body), contentType)
+	}
+	text := ""
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err == nil {
+		redactJSON(value)
+		data, _ := json.Marshal(value)
+		text = string(data)
+	} else {
+		text = redactText(string(body))
+	}
+	if limit > 0 && len(text) > limit {
+		text = text[:limit] + "...(truncated)"
+	}
+	return text
+}
+
+// redactText hides private keys, the indented lines of secret YAML block scalars and the values of secret fields.
+func redactText(text string) string {
+	text = privateKeyBlock.ReplaceAllString(text, redacted)
+	lines := strings.Split(text, "\n")
+	redactedLines := make([]string, 0, len(lines))
+	blockIndent := -1
+	for _, line := range lines {
+		if blockIndent >= 0 {
+			trimmed := strings.TrimLeft(line, " \t")
+			if strings.TrimSpace(line) == "" || len(line)-len(trimmed) > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+		if match := secretBlock.FindStringSubmatch(line); match != nil {
+			blockIndent = len(match[1])
+			redactedLines = append(redactedLines, strings.TrimRight(line, " \t\r")+" "+redacted)
+			continue
+		}
+		redactedLines = append(redactedLines, secretField.ReplaceAllString(line, "${1}"+redacted))
+	}
+	return strings.Join(redactedLines, "\n")
+}
+
+// RedactQuery returns a raw URL query with the values of secret parameters such as token hidden.
+func RedactQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+	params := strings.Split(rawQuery, "&")
+	for i, param := range params {
+		key, _, found := strings.Cut(param, "=")
+		if name, err := url.QueryUnescape(key); err == nil {
+			key = name
+		}
+		if found && secretKey.MatchString(key) {
+			params[i] = param[:strings.Index(param, "=")+1] + redacted
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func redactJSON(value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, item := range value {
+			if secretKey.MatchString(key) && item != nil && item != "" {
+				value[key] = redacted
+				continue
+			}
+			redactJSON(item)
+		}
+	case []interface{}:
+		for _, item := range value {
+			redactJSON(item)
+		}
+	}
+}
+
+// commandAudit collects the output digest and optional cast of one execution.
+type commandAudit struct {
+	mutex  sync.Mutex
+	record CommandRecord
+	hash   hash.Hash
+	cast   *castWriter
+}
+
+// startAudit begins recording command, streamed and interactive sessions also get a cast when audit.record_sessions is on.
+func (executor *SSHExecutor) startAudit(kind, command string, cols, rows int) *commandAudit {
+	host := executor.Host
+	audit := &commandAudit{
+		record: CommandRecord{
+			ID:        fmt.Sprintf("%s-%s", time.Now().Format("20060102150405"), randomSuffix()),
+			Kind:      kind,
+			Host:      host.Address,
+			User:      host.User,
+			Command:   RedactCommand(command, host.Password, host.BecomePassword, host.Passphrase),
+			ExitCode:  -1,
+			StartTime: time.Now(),
+		},
+		hash: sha256.New(),
+	}
+	if kind != AuditKindCommand && recordSessions() {
+		cast, err := newCastWriter(audit.record, cols, rows)
+		if err != nil {
+			logger.GetLogger().Warnf("Failed to start session recording: %s", err.Error())
+		} else {
+			audit.cast = cast
+			audit.record.CastFile = cast.name
+		}
+	}
+	return audit
+}
+
+func recordSessions() bool {
+	if !viper.IsSet("audit.record_sessions") {
+		return true
+	}
+	return viper.GetBool("audit.record_sessions")
+}
+
+func (audit *commandAudit) Write(p []byte) (int, error) {
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+	audit.hash.Write(p)
+	audit.record.OutputSize += int64(len(p))
+	if audit.cast != nil {
+		audit.cast.output(p)
+	}
+	return len(p), nil
+}
+
+// wrap tees writer into the audit, a nil writer is only audited.
+func (audit *commandAudit) wrap(writer io.Writer) io.Writer {
+	if writer == nil {
+		return audit
+	}
+	return io.MultiWriter(writer, audit)
+}
+
+// input records keystrokes sent to a terminal in the cast, the output digest only covers what the host sent.
+func (audit *commandAudit) input(p []byte) {
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+	if audit.cast != nil {
+		audit.cast.event("i", string(p))
+	}
+}
+
+// resize records a terminal size change in the cast.
+func (audit *commandAudit) resize(cols, rows int) {
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+	if audit.cast != nil {
+		audit.cast.event("r", fmt.Sprintf("%dx%d", cols, rows))
+	}
+}
+
+// finish stores the record, err decides the exit code.
+func (audit *commandAudit) finish(err error) {
+	audit.mutex.Lock()
+	defer audit.mutex.Unlock()
+	record := audit.record
+	record.EndTime = time.Now()
+	record.DurationMs = record.EndTime.Sub(record.StartTime).Milliseconds()
+	record.OutputDigest = hex.EncodeToString(audit.hash.Sum(nil))
+	var exitErr *ssh.ExitError
+	var exitMissing *ssh.ExitMissingError
+	switch {
+	case err == nil:
+		record.ExitCode = 0
+	case errors.As(err, &exitErr):
+		record.ExitCode = exitErr.ExitStatus()
+		record.Error = err.Error()
+	case errors.As(err, &exitMissing) && record.Kind == AuditKindTerminal:
+		record.ExitCode = 0
+	default:
+		record.Error = err.Error()
+	}
+	if audit.cast != nil {
+		audit.cast.close()
+		audit.cast = nil
+	}
+	GetAuditLog().Add(record)
+}
+
+// castWriter writes an asciinema v2 recording.
+type castWriter struct {
+	file  *os.File
+	name  string
+	start time.Time
+}
+
+func castDir() string {
+	if dir := viper.GetString("audit.cast_dir"); dir != "" {
+		return dir
+	}
+	return defaultCastDir
+}
+
+// CastPath returns where the cast of a record is stored.
+func CastPath(name string) string {
+	return filepath.Join(castDir(), filepath.Base(name))
+}
+
+func newCastWriter(record CommandRecord, cols, rows int) (*castWriter, error) {
+	if cols <= 0 || rows <= 0 {
+		cols, rows = defaultTerminalCols, defaultTerminalRows
+	}
+	if err := os.MkdirAll(castDir(), 0700); err != nil {
+		return nil, err
+	}
+	name := record.ID + ".cast"
+	file, err := os.OpenFile(CastPath(name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	header, _ := json.Marshal(map[string]interface{}{
+		"version":   2,
+		"width":     cols,
+		"height":    rows,
+		"timestamp": record.StartTime.Unix(),
+		"title":     fmt.Sprintf("%s@%s: %s", record.User, record.Host, record.Command),
+	})
+	if _, err := fmt.Fprintf(file, "%s\n", header); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return &castWriter{file: file, name: name, start: record.StartTime}, nil
+}
+
+func (cast *castWriter) output(p []byte) {
+	cast.event("o", string(p))
+}
+
+func (cast *castWriter) event(code, data string) {
+	event, _ := json.Marshal([]interface{}{time.Since(cast.start).Seconds(), code, data})
+	if _, err := fmt.Fprintf(cast.file, "%s\n", event); err != nil {
+		logger.GetLogger().Warnf("Failed to write session recording %s: %s", cast.name, err.Error())
+	}
+}
+
+func (cast *castWriter) close() {
+	cast.file.Close()
+}
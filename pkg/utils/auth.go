@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"net"
+	"os"
+	"strings"
+)
+
+// authMethods returns the auth methods for host in the order they are tried:
+// host.AuthMethods, public keys (certificate, private key, then agent keys) and finally the password.
+// The ssh client tries each method type only once, so every key source is offered by a single public key method.
+// The returned func closes the agent connection and must be called once the handshake is over.
+func authMethods(host entity.Host) ([]ssh.AuthMethod, func(), error) {
+	methods := append([]ssh.AuthMethod{}, host.AuthMethods...)
+	var signers []ssh.Signer
+	if host.PrivateKey != "" {
+		signer, err := loadPrivateKey(host.PrivateKey, host.Passphrase)
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to load private key for %s: %s", host.Address, err.Error())
+			return nil, nil, err
+		}
+		if host.Certificate != "" {
+			certSigner, err := loadCertificate(host.Certificate, signer)
+			if err != nil {
+				logger.GetLogger().Errorf("Failed to load certificate for %s: %s", host.Address, err.Error())
+				return nil, nil, err
+			}
+			signers = append(signers, certSigner)
+		}
+		signers = append(signers, signer)
+	} else if host.Certificate != "" {
+		return nil, nil, errors.New("a certificate needs the private key it was issued for")
+	}
+
+	cleanup := func() {}
+	var agentClient agent.ExtendedAgent
+	if host.UseAgent {
+		socket := host.AgentSocket
+		if socket == "" {
+			socket = os.Getenv("SSH_AUTH_SOCK")
+		}
+		if socket == "" {
+			return nil, nil, errors.New("ssh-agent requested but no agent socket is configured")
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to connect to ssh-agent at %s: %s", socket, err.Error())
+			return nil, nil, err
+		}
+		cleanup = func() { conn.Close() }
+		agentClient = agent.NewClient(conn)
+	}
+
+	if len(signers) > 0 || agentClient != nil {
+		methods = append(methods, ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			if agentClient == nil {
+				return signers, nil
+			}
+			agentSigners, err := agentClient.Signers()
+			if err != nil {
+				logger.GetLogger().Warnf("Failed to list ssh-agent keys: %s", err.Error())
+				return signers, nil
+			}
+			return append(append([]ssh.Signer{}, signers...), agentSigners...), nil
+		}))
+	}
+	if host.Password != "" {
+		methods = append(methods, ssh.Password(host.Password))
+	}
+	return methods, cleanup, nil
+}
+
+// loadPrivateKey parses key, which is either PEM content or a path to a key file on this server.
+func loadPrivateKey(key, passphrase string) (ssh.Signer, error) {
+	data := []byte(key)
+	if !strings.Contains(key, "-----BEGIN") {
+		var err error
+		if data, err = os.ReadFile(key); err != nil {
+			return nil, fmt.Errorf("failed to read private key file: %w", err)
+		}
+	}
+	if passphrase != "" {
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt private key: %w", err)
+		}
+		return signer, nil
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, errors.New("private key is encrypted, a passphrase is required")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+	return signer, nil
+}
+
+// loadCertificate parses an OpenSSH user certificate, given in authorized_keys format or as a path, and binds it to signer.
+func loadCertificate(certificate string, signer ssh.Signer) (ssh.Signer, error) {
+	data := []byte(certificate)
+	if !strings.Contains(certificate, "-cert-v01@openssh.com ") {
+		var err error
+		if data, err = os.ReadFile(certificate); err != nil {
+			return nil, fmt.Errorf("failed to read certificate file: %w", err)
+		}
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, errors.New("not an OpenSSH certificate")
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, errors.New("not a user certificate")
+	}
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate does not match the private key: %w", err)
+	}
+	return certSigner, nil
+}
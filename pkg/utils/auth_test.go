@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"golang.org/x/crypto/ssh"
+	"testing"
+)
+
+func TestLoadPrivateKeyWithPassphraseAndCertificate(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(key, "", []byte("pass"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted := string(pem.EncodeToMemory(block))
+	if _, err := loadPrivateKey(encrypted, ""); err == nil || err.Error() != "private key is encrypted, a passphrase is required" {
+		t.Fatalf("expected missing passphrase error, got %v", err)
+	}
+	signer, err := loadPrivateKey(encrypted, "pass")
+	if err != nil {
+		t.Fatalf("expected key to decrypt, got %v", err)
+	}
+
+	_, caKey, _ := ed25519.GenerateKey(rand.Reader)
+	caSigner, _ := ssh.NewSignerFromKey(caKey)
+	cert := &ssh.Certificate{Key: signer.PublicKey(), CertType: ssh.UserCert, ValidPrincipals: []string{"ops"}, ValidBefore: ssh.CertTimeInfinity}
+	if err := cert.SignCert(rand.Reader, caSigner); err != nil {
+		t.Fatal(err)
+	}
+	certSigner, err := loadCertificate(string(ssh.MarshalAuthorizedKey(cert)), signer)
+	if err != nil {
+		t.Fatalf("expected certificate to load, got %v", err)
+	}
+	if _, ok := certSigner.PublicKey().(*ssh.Certificate); !ok {
+		t.Fatalf("expected a certificate signer")
+	}
+}
@@ -0,0 +1,233 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"strings"
+	"sync"
+)
+
+const (
+	BecomeSudo = "sudo"
+	BecomeSu   = "su"
+	BecomeNone = "none"
+)
+
+const (
+	// becomeSudoPrefix starts commands that read the sudo password from stdin, -k makes sudo always ask for it.
+	becomeSudoPrefix = "sudo -k -S -p '' "
+	// becomeSuPrefix starts commands that need the su password typed on a terminal.
+	becomeSuPrefix   = "LC_ALL=C su "
+	suPasswordPrompt = "Password:"
+	maxPromptBuffer  = 4096
+)
+
+// ErrSuStreaming is returned for streamed commands that would need su, su only reads its password from a terminal.
+var ErrSuStreaming = errors.New("su cannot run streamed commands, configure sudo as the become method")
+
+// WhoAmI returns the remote login user, it is looked up once per connection.
+func (executor *SSHExecutor) WhoAmI() string {
+	state := executor.Connection.state
+	if state != nil {
+		state.becomeMutex.Lock()
+		defer state.becomeMutex.Unlock()
+		if state.user != "" {
+			return state.user
+		}
+	}
+	user, err := executor.ExecuteShortCommand("whoami")
+	if err != nil {
+		logger.GetLogger().Warnf("Read username failed: %v", err.Error())
+		return ""
+	}
+	user = strings.TrimSpace(user)
+	if state != nil {
+		state.user = user
+	}
+	return user
+}
+
+func (executor *SSHExecutor) becomeMethod() string {
+	switch executor.Host.BecomeMethod {
+	case BecomeSu, BecomeNone:
+		return executor.Host.BecomeMethod
+	default:
+		return BecomeSudo
+	}
+}
+
+func (executor *SSHExecutor) becomeUser() string {
+	if executor.Host.BecomeUser != "" {
+		return executor.Host.BecomeUser
+	}
+	return "root"
+}
+
+func (executor *SSHExecutor) becomePassword() string {
+	if executor.Host.BecomePassword != "" {
+		return executor.Host.BecomePassword
+	}
+	return executor.Host.Password
+}
+
+// NeedsBecome reports whether commands have to be wrapped to run as the become user.
+func (executor *SSHExecutor) NeedsBecome() bool {
+	return executor.becomeMethod() != BecomeNone && executor.WhoAmI() != executor.becomeUser()
+}
+
+// sudoNoPassword reports whether sudo works without a password, the answer is cached per connection.
+func (executor *SSHExecutor) sudoNoPassword(user string) bool {
+	state := executor.Connection.state
+	if state != nil {
+		state.becomeMutex.Lock()
+		nopasswd, ok := state.nopasswd[user]
+		state.becomeMutex.Unlock()
+		if ok {
+			return nopasswd
+		}
+	}
+	err := executor.runSession(context.Background(), fmt.Sprintf("sudo -n -u %s true", ShellQuote(user)), nil, nil)
+	nopasswd := err == nil
+	if state != nil {
+		state.becomeMutex.Lock()
+		state.nopasswd[user] = nopasswd
+		state.becomeMutex.Unlock()
+	}
+	return nopasswd
+}
+
+// Become wraps command so that it runs as the become user of the host.
+// The password is never part of the command, the executor writes it to the session when the command runs.
+func (executor *SSHExecutor) Become(command string) string {
+	if !executor.NeedsBecome() {
+		return command
+	}
+	user := executor.becomeUser()
+	script := ShellQuote(command)
+	switch executor.becomeMethod() {
+	case BecomeSu:
+		return fmt.Sprintf("%s%s -c %s", becomeSuPrefix, ShellQuote(user), script)
+	default:
+		if executor.sudoNoPassword(user) {
+			return fmt.Sprintf("sudo -n -u %s -- sh -c %s", ShellQuote(user), script)
+		}
+		return fmt.Sprintf("%s-u %s -- sh -c %s", becomeSudoPrefix, ShellQuote(user), script)
+	}
+}
+
+// promptResponder forwards output and types the password once the su prompt shows up.
+type promptResponder struct {
+	writer   io.Writer
+	stdin    io.Writer
+	password string
+	answered bool
+	buffer   []byte
+}
+
+func (responder *promptResponder) Write(p []byte) (int, error) {
+	if responder.answered {
+		return responder.writer.Write(p)
+	}
+	responder.buffer = append(responder.buffer, p...)
+	index := bytes.Index(responder.buffer, []byte(suPasswordPrompt))
+	if index < 0 {
+		if len(responder.buffer) > maxPromptBuffer {
+			responder.answered = true
+			if _, err := responder.writer.Write(responder.buffer); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	responder.answered = true
+	if _, err := fmt.Fprintln(responder.stdin, responder.password); err != nil {
+		return 0, err
+	}
+	rest := bytes.TrimLeft(responder.buffer[index+len(suPasswordPrompt):], " \r\n")
+	if len(rest) > 0 {
+		if _, err := responder.writer.Write(rest); err != nil {
+			return 0, err
+		}
+	}
+	responder.buffer = nil
+	return len(p), nil
+}
+
+// attachSession wires stdout and stderr to session and hands over the become password when command needs it.
+func (executor *SSHExecutor) attachSession(session *ssh.Session, command string, stdout, stderr io.Writer) error {
+	session.Stdout = stdout
+	session.Stderr = stderr
+	switch {
+	case strings.HasPrefix(command, becomeSudoPrefix):
+		session.Stdin = strings.NewReader(executor.becomePassword() + "\n")
+	case strings.HasPrefix(command, becomeSuPrefix):
+		// su only reads the password from a terminal.
+		if err := session.RequestPty("xterm", 40, 200, ssh.TerminalModes{ssh.ECHO: 0}); err != nil {
+			return err
+		}
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			return err
+		}
+		if stdout == nil {
+			stdout = io.Discard
+		}
+		session.Stdout = &promptResponder{writer: stdout, stdin: stdin, password: executor.becomePassword()}
+	}
+	return nil
+}
+
+// writeBecomePassword answers sudo on a streaming session, streamCommand refuses su before it gets here.
+func (executor *SSHExecutor) writeBecomePassword(stdin io.Writer, command string) {
+	if strings.HasPrefix(command, becomeSudoPrefix) {
+		fmt.Fprintln(stdin, executor.becomePassword())
+	}
+}
+
+// runSession runs command on a new session and stops it when ctx is done.
+// Every run is added to the audit log.
+func (executor *SSHExecutor) runSession(ctx context.Context, command string, stdout, stderr io.Writer) (err error) {
+	audit := executor.startAudit(AuditKindCommand, command, 0, 0)
+	defer func() { audit.finish(err) }()
+	session, release, err := executor.newSession(ctx)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to create SSH session: %s", err.Error())
+		return err
+	}
+	defer release()
+	defer session.Close()
+	if err := executor.attachSession(session, command, audit.wrap(stdout), audit.wrap(stderr)); err != nil {
+		logger.GetLogger().Errorf("Failed to prepare SSH session: %s", err.Error())
+		return err
+	}
+	stop := watchSession(ctx, session)
+	err = session.Run(command)
+	stop()
+	if ctx.Err() != nil {
+		return fmt.Errorf("command cancelled: %w", ctx.Err())
+	}
+	return err
+}
+
+// lockedBuffer collects stdout and stderr of a session into one buffer.
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Bytes()
+}
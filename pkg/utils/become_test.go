@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPromptResponderAnswersSuPrompt(t *testing.T) {
+	var output, stdin bytes.Buffer
+	responder := &promptResponder{writer: &output, stdin: &stdin, password: "s3cr'et"}
+	responder.Write([]byte("Pass"))
+	if stdin.Len() != 0 {
+		t.Fatalf("expected no answer before the prompt is complete")
+	}
+	responder.Write([]byte("word: \r\nroot\r\n"))
+	if stdin.String() != "s3cr'et\n" {
+		t.Fatalf("expected password on stdin, got %q", stdin.String())
+	}
+	responder.Write([]byte("done\r\n"))
+	if output.String() != "root\r\ndone\r\n" {
+		t.Fatalf("expected prompt to be stripped from output, got %q", output.String())
+	}
+}
+
+func TestBecomeWrapsCommands(t *testing.T) {
+	command := `echo "it's" 'quoted' $HOME && cat /etc/hosts`
+	cases := []struct {
+		name       string
+		method     string
+		loginUser  string
+		sudoNoPass bool
+		prefix     string
+	}{
+		{name: "sudo without password", method: BecomeSudo, loginUser: "ops", sudoNoPass: true, prefix: "sudo -n -u 'root' -- sh -c "},
+		{name: "sudo with password", method: BecomeSudo, loginUser: "ops", prefix: becomeSudoPrefix + "-u 'root' -- sh -c "},
+		{name: "default method", loginUser: "ops", prefix: becomeSudoPrefix + "-u 'root' -- sh -c "},
+		{name: "su", method: BecomeSu, loginUser: "ops", prefix: becomeSuPrefix + "'root' -c "},
+		{name: "no become", method: BecomeNone, loginUser: "ops"},
+		{name: "already the become user", method: BecomeSudo, loginUser: "root"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server, host := newTestServer(t)
+			server.Handle(`^whoami$`, c.loginUser+"\n", 0)
+			sudoStatus := 1
+			if c.sudoNoPass {
+				sudoStatus = 0
+			}
+			server.Handle(`^sudo -n -u 'root' true$`, "", sudoStatus)
+			host.BecomeMethod = c.method
+			executor := NewExecutor(host)
+			if executor == nil {
+				t.Fatalf("failed to connect to %s:%d", host.Address, host.Port)
+			}
+			defer executor.Connection.Close()
+
+			wrapped := executor.Become(command)
+			if c.prefix == "" {
+				if wrapped != command {
+					t.Fatalf("expected the command to be left alone, got %q", wrapped)
+				}
+				return
+			}
+			if !strings.HasPrefix(wrapped, c.prefix) {
+				t.Fatalf("expected prefix %q, got %q", c.prefix, wrapped)
+			}
+			if words := splitShellWords(strings.TrimPrefix(wrapped, c.prefix)); len(words) != 1 || words[0] != command {
+				t.Fatalf("expected the quoted script to read back as the command, got %q", words)
+			}
+		})
+	}
+}
+
+func TestStreamingWithSuFails(t *testing.T) {
+	server, host := newTestServer(t)
+	server.Handle(`^whoami$`, "ops\n", 0)
+	host.BecomeMethod = BecomeSu
+	executor := NewExecutor(host)
+	if executor == nil {
+		t.Fatalf("failed to connect to %s:%d", host.Address, host.Port)
+	}
+	defer executor.Connection.Close()
+
+	logChan := make(chan LogEntry, 4)
+	err := executor.ExecuteCommandContext(context.Background(), executor.Become("apt-get install -y haproxy"), logChan)
+	if !errors.Is(err, ErrSuStreaming) {
+		t.Fatalf("expected ErrSuStreaming, got %v", err)
+	}
+	for _, command := range server.Commands() {
+		if strings.Contains(command, "apt-get") {
+			t.Fatalf("expected the command not to run, it ran as %q", command)
+		}
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"os"
 	"regexp"
 	"strings"
+	"time"
 )
 
 //var kubekeyLogo = `
@@ -42,6 +43,25 @@ type LogEntry struct {
 	IsError bool   // 是否为错误日志
 }
 
+// CommandResult 单条命令在某台主机上的执行结果
+type CommandResult struct {
+	Host      string    `json:"host"`
+	Command   string    `json:"command"`
+	ExitCode  int       `json:"exit_code"`
+	Stdout    string    `json:"stdout"`
+	Stderr    string    `json:"stderr"`
+	StartTime time.Time `json:"start_time"`
+	EndTime   time.Time `json:"end_time"`
+}
+
+func (result *CommandResult) Success() bool {
+	return result.ExitCode == 0
+}
+
+func (result *CommandResult) Duration() time.Duration {
+	return result.EndTime.Sub(result.StartTime)
+}
+
 func DecodeBytes(data []byte, decoder *encoding.Decoder) (string, error) {
 	reader := transform.NewReader(bytes.NewReader(data), decoder)
 	decodedData, err := io.ReadAll(reader)
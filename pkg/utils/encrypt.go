@@ -1,22 +1,14 @@
 package utils
 
 import (
-	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
-	"crypto/rand"
 	"encoding/base64"
 	"fmt"
 	"github.com/spf13/viper"
-	"io"
+	"github.com/whoisfisher/mykubespray/pkg/utils/vault"
 )
 
-func padding(plaintext []byte, blockSize int) []byte {
-	padding := blockSize - len(plaintext)%blockSize
-	padtext := bytes.Repeat([]byte{byte(padding)}, padding)
-	return append(plaintext, padtext...)
-}
-
 func unPadding(origData []byte) []byte {
 	length := len(origData)
 	unpadding := int(origData[length-1])
@@ -36,24 +28,6 @@ func aesDecrypt(key, crypted []byte) ([]byte, error) {
 	return origData, nil
 }
 
-func aesEncryptWithSalt(key, plaintext []byte) ([]byte, error) {
-	plaintext = padding(plaintext, aes.BlockSize)
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
-	ciphertext := make([]byte, aes.BlockSize+len(plaintext))
-	iv := ciphertext[0:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		return nil, err
-	}
-	for i := 2; i < 8; i++ {
-		iv[i] = 1
-	}
-	cbc := cipher.NewCBCEncrypter(block, iv)
-	cbc.CryptBlocks(ciphertext[aes.BlockSize:], plaintext)
-	return ciphertext, nil
-}
 func aesDecryptWithSalt(key, ciphertext []byte) ([]byte, error) {
 	var block cipher.Block
 	block, err := aes.NewCipher(key)
@@ -71,18 +45,20 @@ func aesDecryptWithSalt(key, ciphertext []byte) ([]byte, error) {
 	return ciphertext, nil
 }
 
+// StringEncrypt seals text with the vault master key.
 func StringEncrypt(text string) (string, error) {
-	key := viper.GetString("encrypt.key")
-	pass := []byte(text)
-	xpass, err := aesEncryptWithSalt([]byte(key), pass)
-	if err == nil {
-		pass64 := base64.StdEncoding.EncodeToString(xpass)
-		return pass64, err
-	}
-	return "", err
+	return vault.Encrypt([]byte(text))
 }
 
+// StringDecrypt opens text sealed by StringEncrypt, values written before the vault are decrypted with encrypt.key.
 func StringDecrypt(text string) (string, error) {
+	if vault.IsSealed(text) {
+		plaintext, err := vault.Decrypt(text)
+		if err != nil {
+			return "", err
+		}
+		return string(plaintext), nil
+	}
 	key := viper.GetString("encrypt.key")
 	bytesPass, err := base64.StdEncoding.DecodeString(text)
 	if err != nil {
@@ -0,0 +1,381 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const factsMarker = "@@facts:"
+
+// factsSources are read by a single command, each output is preceded by a marker line naming its section.
+var factsSources = []struct {
+	section string
+	command string
+}{
+	{"hostname", "hostname"},
+	{"os-release", "cat /etc/os-release"},
+	{"osrelease", "cat /proc/sys/kernel/osrelease"},
+	{"kernel-version", "cat /proc/sys/kernel/version"},
+	{"arch", "uname -m"},
+	{"cpuinfo", "cat /proc/cpuinfo"},
+	{"meminfo", "cat /proc/meminfo"},
+	{"lsblk", "lsblk -J -b -o NAME,TYPE,SIZE,MODEL,ROTA,MOUNTPOINT"},
+	{"ip", "ip -j addr show"},
+	{"speed", `for nic in /sys/class/net/*; do echo "${nic##*/} $(cat $nic/speed 2>/dev/null)"; done`},
+	{"timedatectl", "timedatectl show"},
+}
+
+// FactsCommand prints every facts source, a missing tool leaves its section empty instead of failing the command.
+var FactsCommand = func() string {
+	commands := make([]string, 0, len(factsSources)+1)
+	for _, source := range factsSources {
+		commands = append(commands, fmt.Sprintf("echo '%s%s'; %s 2>/dev/null", factsMarker, source.section, source.command))
+	}
+	return strings.Join(append(commands, "true"), "; ")
+}()
+
+// GatherFacts reads the facts of the host in one round trip.
+func (executor *SSHExecutor) GatherFacts() (*entity.HostFacts, error) {
+	output, err := executor.ExecuteShortCommand(FactsCommand)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to gather facts of %s: %s", executor.Host.Address, err.Error())
+		return nil, err
+	}
+	facts, err := ParseFacts(output)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to parse facts of %s: %s", executor.Host.Address, err.Error())
+		return nil, err
+	}
+	return facts, nil
+}
+
+// ParseFacts parses the output of FactsCommand.
+func ParseFacts(output string) (*entity.HostFacts, error) {
+	sections := splitFactsSections(output)
+	if len(sections) == 0 {
+		return nil, fmt.Errorf("no facts in output %q", output)
+	}
+	facts := &entity.HostFacts{
+		Hostname: strings.TrimSpace(sections["hostname"]),
+		OS:       parseOSReleaseFacts(sections["os-release"]),
+		Kernel: entity.KernelFacts{
+			Release: strings.TrimSpace(sections["osrelease"]),
+			Version: strings.TrimSpace(sections["kernel-version"]),
+		},
+		Arch:       strings.TrimSpace(sections["arch"]),
+		CPU:        parseCPUInfo(sections["cpuinfo"]),
+		Memory:     parseMemInfo(sections["meminfo"]),
+		TimeSync:   parseTimedatectl(sections["timedatectl"]),
+		GatheredAt: time.Now(),
+	}
+	var err error
+	if facts.Disks, err = parseLsblk(sections["lsblk"]); err != nil {
+		return nil, fmt.Errorf("lsblk: %w", err)
+	}
+	if facts.NICs, err = parseIPAddr(sections["ip"], sections["speed"]); err != nil {
+		return nil, fmt.Errorf("ip addr: %w", err)
+	}
+	return facts, nil
+}
+
+func splitFactsSections(output string) map[string]string {
+	sections := map[string]string{}
+	section := ""
+	var content strings.Builder
+	flush := func() {
+		if section != "" {
+			sections[section] = content.String()
+		}
+		content.Reset()
+	}
+	for _, line := range strings.Split(output, "\n") {
+		if strings.HasPrefix(line, factsMarker) {
+			flush()
+			section = strings.TrimSpace(strings.TrimPrefix(line, factsMarker))
+			continue
+		}
+		content.WriteString(line)
+		content.WriteString("\n")
+	}
+	flush()
+	return sections
+}
+
+// keyValues parses "key=value" or "key: value" lines, values are unquoted.
+func keyValues(content, separator string) map[string]string {
+	values := map[string]string{}
+	for _, line := range strings.Split(content, "\n") {
+		key, value, found := strings.Cut(line, separator)
+		if !found {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `"'`)
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	return values
+}
+
+func parseOSReleaseFacts(content string) entity.OSFacts {
+	values := keyValues(content, "=")
+	return entity.OSFacts{ID: values["ID"], Name: values["NAME"], Version: values["VERSION_ID"], PrettyName: values["PRETTY_NAME"]}
+}
+
+func parseCPUInfo(content string) entity.CPUFacts {
+	cpu := entity.CPUFacts{}
+	sockets := map[string]int{}
+	for _, block := range strings.Split(content, "\n\n") {
+		values := keyValues(block, ":")
+		if _, ok := values["processor"]; !ok {
+			continue
+		}
+		cpu.Threads++
+		if cpu.Model == "" {
+			cpu.Model = values["model name"]
+		}
+		cores, _ := strconv.Atoi(values["cpu cores"])
+		sockets[values["physical id"]] = cores
+	}
+	cpu.Sockets = len(sockets)
+	for _, cores := range sockets {
+		cpu.Cores += cores
+	}
+	// virtual machines and some architectures report neither sockets nor cores.
+	if cpu.Cores == 0 {
+		cpu.Cores = cpu.Threads
+	}
+	return cpu
+}
+
+func parseMemInfo(content string) entity.MemoryFacts {
+	values := keyValues(content, ":")
+	kib := func(key string) uint64 {
+		value, _ := strconv.ParseUint(strings.TrimSuffix(values[key], " kB"), 10, 64)
+		return value * 1024
+	}
+	return entity.MemoryFacts{TotalBytes: kib("MemTotal"), AvailableBytes: kib("MemAvailable"), SwapTotalBytes: kib("SwapTotal")}
+}
+
+func parseTimedatectl(content string) entity.TimeSyncFacts {
+	values := keyValues(content, "=")
+	if len(values) == 0 {
+		return entity.TimeSyncFacts{}
+	}
+	return entity.TimeSyncFacts{
+		Available:    true,
+		Synchronized: values["NTPSynchronized"] == "yes",
+		NTP:          values["NTP"] == "yes",
+		Timezone:     values["Timezone"],
+	}
+}
+
+// jsonScalar accepts the strings, numbers and booleans older util-linux and iproute2 versions use interchangeably.
+type jsonScalar string
+
+func (scalar *jsonScalar) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		return nil
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*scalar = jsonScalar(strings.TrimSpace(fmt.Sprint(value)))
+	return nil
+}
+
+type lsblkDevice struct {
+	Name       jsonScalar    `json:"name"`
+	Type       jsonScalar    `json:"type"`
+	Size       jsonScalar    `json:"size"`
+	Model      jsonScalar    `json:"model"`
+	Rota       jsonScalar    `json:"rota"`
+	MountPoint jsonScalar    `json:"mountpoint"`
+	Children   []lsblkDevice `json:"children"`
+}
+
+func (device lsblkDevice) facts() entity.DiskFacts {
+	size, _ := strconv.ParseFloat(string(device.Size), 64)
+	disk := entity.DiskFacts{
+		Name:       string(device.Name),
+		Type:       string(device.Type),
+		SizeBytes:  uint64(size),
+		Model:      string(device.Model),
+		Rotational: device.Rota == "1" || device.Rota == "true",
+		MountPoint: string(device.MountPoint),
+	}
+	for _, child := range device.Children {
+		disk.Children = append(disk.Children, child.facts())
+	}
+	return disk
+}
+
+func parseLsblk(content string) ([]entity.DiskFacts, error) {
+	disks := []entity.DiskFacts{}
+	if strings.TrimSpace(content) == "" {
+		return disks, nil
+	}
+	var output struct {
+		BlockDevices []lsblkDevice `json:"blockdevices"`
+	}
+	if err := json.Unmarshal([]byte(content), &output); err != nil {
+		return nil, err
+	}
+	for _, device := range output.BlockDevices {
+		disks = append(disks, device.facts())
+	}
+	return disks, nil
+}
+
+type ipLink struct {
+	Name      string `json:"ifname"`
+	MTU       int    `json:"mtu"`
+	State     string `json:"operstate"`
+	Address   string `json:"address"`
+	Addresses []struct {
+		Family    string `json:"family"`
+		Local     string `json:"local"`
+		PrefixLen int    `json:"prefixlen"`
+	} `json:"addr_info"`
+}
+
+func parseIPAddr(content, speeds string) ([]entity.NICFacts, error) {
+	nics := []entity.NICFacts{}
+	if strings.TrimSpace(content) == "" {
+		return nics, nil
+	}
+	var links []ipLink
+	if err := json.Unmarshal([]byte(content), &links); err != nil {
+		return nil, err
+	}
+	speed := map[string]int{}
+	for _, line := range strings.Split(speeds, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			// virtual interfaces report -1.
+			if mbps, err := strconv.Atoi(fields[1]); err == nil && mbps > 0 {
+				speed[fields[0]] = mbps
+			}
+		}
+	}
+	for _, link := range links {
+		nic := entity.NICFacts{Name: link.Name, MAC: link.Address, MTU: link.MTU, State: link.State, SpeedMbps: speed[link.Name], Addresses: []string{}}
+		for _, address := range link.Addresses {
+			if address.Local != "" {
+				nic.Addresses = append(nic.Addresses, fmt.Sprintf("%s/%d", address.Local, address.PrefixLen))
+			}
+		}
+		nics = append(nics, nic)
+	}
+	return nics, nil
+}
+
+// RootDisk returns the device mounted at /, nil when lsblk reported none.
+func RootDisk(facts *entity.HostFacts) *entity.DiskFacts {
+	var find func(disks []entity.DiskFacts) *entity.DiskFacts
+	find = func(disks []entity.DiskFacts) *entity.DiskFacts {
+		for i := range disks {
+			if disks[i].MountPoint == "/" {
+				return &disks[i]
+			}
+			if disk := find(disks[i].Children); disk != nil {
+				return disk
+			}
+		}
+		return nil
+	}
+	return find(facts.Disks)
+}
+
+// HumanSize formats bytes with binary units the way df -h does, e.g. 100G or 9.8G.
+func HumanSize(size uint64) string {
+	units := []string{"B", "K", "M", "G", "T", "P"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if value < 10 && unit > 0 && value != float64(int(value)) {
+		return fmt.Sprintf("%.1f%s", value, units[unit])
+	}
+	return fmt.Sprintf("%.0f%s", value, units[unit])
+}
+
+// factsCache keeps the facts of each host until facts.cache_ttl has passed.
+type factsCache struct {
+	mutex   sync.Mutex
+	entries map[string]*entity.HostFacts
+}
+
+var hostFacts = &factsCache{entries: map[string]*entity.HostFacts{}}
+
+func factsCacheTTL() time.Duration {
+	return viper.GetDuration("facts.cache_ttl")
+}
+
+// CachedFacts returns the cached facts of the host, gathering them when they are missing or older than
+// facts.cache_ttl. A zero TTL disables the cache.
+func (executor *SSHExecutor) CachedFacts() (*entity.HostFacts, error) {
+	key := hostKey(executor.Host)
+	ttl := factsCacheTTL()
+	hostFacts.mutex.Lock()
+	facts, exists := hostFacts.entries[key]
+	hostFacts.mutex.Unlock()
+	if exists && ttl > 0 && time.Since(facts.GatheredAt) < ttl {
+		return facts, nil
+	}
+	facts, err := executor.GatherFacts()
+	if err != nil {
+		return nil, err
+	}
+	if ttl > 0 {
+		hostFacts.mutex.Lock()
+		hostFacts.entries[key] = facts
+		hostFacts.mutex.Unlock()
+	}
+	return facts, nil
+}
+
+// InvalidateFacts drops the cached facts of the host, the next CachedFacts gathers them again.
+func InvalidateFacts(host entity.Host) {
+	hostFacts.mutex.Lock()
+	defer hostFacts.mutex.Unlock()
+	delete(hostFacts.entries, hostKey(host))
+}
+
+// PruneFacts drops the cached facts older than facts.cache_ttl.
+func PruneFacts() {
+	ttl := factsCacheTTL()
+	hostFacts.mutex.Lock()
+	defer hostFacts.mutex.Unlock()
+	for key, facts := range hostFacts.entries {
+		if time.Since(facts.GatheredAt) >= ttl {
+			delete(hostFacts.entries, key)
+		}
+	}
+}
+
+// GatherFacts returns the facts of host over its pooled connection, refresh bypasses the cache.
+func (pool *SSHExecutorPool) GatherFacts(host entity.Host, refresh bool) (*entity.HostFacts, error) {
+	executor, err := pool.GetSSHExecutor(host)
+	if err != nil {
+		return nil, err
+	}
+	if refresh {
+		InvalidateFacts(host)
+	}
+	return executor.CachedFacts()
+}
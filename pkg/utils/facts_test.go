@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testFactsOutput is the output of FactsCommand on an 8 thread Ubuntu virtual machine.
+var testFactsOutput = func() string {
+	var cpuinfo strings.Builder
+	for i := 0; i < 8; i++ {
+		fmt.Fprintf(&cpuinfo, "processor\t: %d\nmodel name\t: Intel(R) Xeon(R)\nphysical id\t: %d\ncpu cores\t: 2\n\n", i, i/4)
+	}
+	return `@@facts:hostname
+node1
+@@facts:os-release
+NAME="Ubuntu"
+ID=ubuntu
+VERSION_ID="22.04"
+PRETTY_NAME="Ubuntu 22.04.4 LTS"
+@@facts:osrelease
+5.15.0-105-generic
+@@facts:kernel-version
+#115-Ubuntu SMP Mon Apr 15 09:52:04 UTC 2024
+@@facts:arch
+x86_64
+@@facts:cpuinfo
+` + cpuinfo.String() + `@@facts:meminfo
+MemTotal:       16265216 kB
+MemFree:         1024000 kB
+MemAvailable:    8132608 kB
+SwapTotal:             0 kB
+@@facts:lsblk
+{"blockdevices": [
+  {"name":"sda", "type":"disk", "size":107374182400, "model":"QEMU HARDDISK", "rota":true, "mountpoint":null,
+   "children": [{"name":"sda1", "type":"part", "size":"107373133824", "model":null, "rota":"1", "mountpoint":"/"}]},
+  {"name":"sr0", "type":"rom", "size":1073741312, "model":"QEMU DVD-ROM", "rota":true, "mountpoint":null}
+]}
+@@facts:ip
+[{"ifindex":1,"ifname":"lo","mtu":65536,"operstate":"UNKNOWN","address":"00:00:00:00:00:00","addr_info":[{"family":"inet","local":"127.0.0.1","prefixlen":8}]},
+ {"ifindex":2,"ifname":"eth0","mtu":1450,"operstate":"UP","address":"52:54:00:12:34:56","addr_info":[{"family":"inet","local":"10.0.0.1","prefixlen":24},{"family":"inet6","local":"fe80::1","prefixlen":64}]}]
+@@facts:speed
+eth0 10000
+lo 
+@@facts:timedatectl
+Timezone=Asia/Shanghai
+NTP=yes
+NTPSynchronized=yes
+`
+}()
+
+func TestParseFacts(t *testing.T) {
+	facts, err := ParseFacts(testFactsOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if facts.Hostname != "node1" || facts.OS.ID != "ubuntu" || facts.OS.Version != "22.04" || facts.OS.PrettyName != "Ubuntu 22.04.4 LTS" ||
+		facts.Kernel.Release != "5.15.0-105-generic" || facts.Arch != "x86_64" {
+		t.Fatalf("unexpected os facts %+v", facts)
+	}
+	if facts.CPU.Sockets != 2 || facts.CPU.Cores != 4 || facts.CPU.Threads != 8 || facts.CPU.Model != "Intel(R) Xeon(R)" {
+		t.Fatalf("unexpected cpu facts %+v", facts.CPU)
+	}
+	if facts.Memory.TotalBytes != 16265216*1024 || facts.Memory.AvailableBytes != 8132608*1024 {
+		t.Fatalf("unexpected memory facts %+v", facts.Memory)
+	}
+	root := RootDisk(facts)
+	if len(facts.Disks) != 2 || root == nil || root.Name != "sda1" || !root.Rotational || HumanSize(root.SizeBytes) != "100G" {
+		t.Fatalf("unexpected disks %+v", facts.Disks)
+	}
+	eth0 := facts.NICs[1]
+	if eth0.MTU != 1450 || eth0.SpeedMbps != 10000 || eth0.State != "UP" || len(eth0.Addresses) != 2 || eth0.Addresses[0] != "10.0.0.1/24" {
+		t.Fatalf("unexpected nic %+v", eth0)
+	}
+	if !facts.TimeSync.Available || !facts.TimeSync.Synchronized || facts.TimeSync.Timezone != "Asia/Shanghai" {
+		t.Fatalf("unexpected time sync %+v", facts.TimeSync)
+	}
+
+	minimal, err := ParseFacts("@@facts:hostname\nnode2\n@@facts:lsblk\n@@facts:ip\n@@facts:timedatectl\n")
+	if err != nil || len(minimal.Disks) != 0 || minimal.TimeSync.Available {
+		t.Fatalf("missing tools must leave their facts empty: %+v, %v", minimal, err)
+	}
+	if _, err := ParseFacts("sh: 1: syntax error\n"); err == nil {
+		t.Fatal("expected output without facts to fail")
+	}
+}
+
+func TestCachedFacts(t *testing.T) {
+	server, executor := newTestExecutor(t)
+	server.Handle(`@@facts:hostname`, testFactsOutput, 0)
+	t.Cleanup(func() { InvalidateFacts(executor.Host) })
+
+	countGathered := func() int {
+		count := 0
+		for _, command := range server.Commands() {
+			if command == FactsCommand {
+				count++
+			}
+		}
+		return count
+	}
+	viper.Set("facts.cache_ttl", time.Minute)
+	for i := 0; i < 3; i++ {
+		if _, err := executor.CachedFacts(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if countGathered() != 1 {
+		t.Fatalf("expected facts to be gathered once, got %d", countGathered())
+	}
+	InvalidateFacts(executor.Host)
+	if _, err := executor.CachedFacts(); err != nil || countGathered() != 2 {
+		t.Fatalf("expected invalidated facts to be gathered again, got %d, %v", countGathered(), err)
+	}
+}
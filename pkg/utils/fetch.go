@@ -0,0 +1,300 @@
+package utils
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/pkg/sftp"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// remoteFileReader is called with an open remote file and its stat.
+type remoteFileReader func(reader io.Reader, info os.FileInfo) error
+
+// readRemoteFile opens remoteFile over SFTP and passes it to read.
+// Files the login user cannot read are copied to /tmp as the become user first and removed afterwards.
+func (executor *SSHExecutor) readRemoteFile(client *sftp.Client, remoteFile string, read remoteFileReader) error {
+	file, err := client.Open(remoteFile)
+	if err != nil && errors.Is(err, os.ErrPermission) && executor.NeedsBecome() {
+		staged, stageErr := executor.stageRemoteFile(remoteFile)
+		if stageErr != nil {
+			return stageErr
+		}
+		defer client.Remove(staged)
+		file, err = client.Open(staged)
+	}
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to open remote file %s: %s", remoteFile, err.Error())
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to stat remote file %s: %s", remoteFile, err.Error())
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", remoteFile)
+	}
+	return read(file, info)
+}
+
+// stageRemoteFile copies remoteFile to a temp file owned by the login user and returns its path.
+func (executor *SSHExecutor) stageRemoteFile(remoteFile string) (string, error) {
+	staged := path.Join("/tmp", fmt.Sprintf(".%s.%s.fetch", path.Base(remoteFile), randomSuffix()))
+	script := fmt.Sprintf("cp -- %[1]s %[2]s && chown %[3]s %[2]s && chmod 600 %[2]s || { rm -f %[2]s; exit 1; }",
+		ShellQuote(remoteFile), ShellQuote(staged), ShellQuote(executor.WhoAmI()))
+	if err := executor.ExecuteCommandWithoutReturn(executor.Become(script)); err != nil {
+		logger.GetLogger().Errorf("Failed to stage %s on %s: %s", remoteFile, executor.Host.Address, err.Error())
+		return "", err
+	}
+	return staged, nil
+}
+
+// FetchFile downloads remoteFile to localFile, the file is verified by SHA-256 and renamed into place.
+func (executor *SSHExecutor) FetchFile(remoteFile, localFile string) error {
+	client, err := executor.NewSFTPClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return executor.fetchFile(client, remoteFile, localFile)
+}
+
+func (executor *SSHExecutor) fetchFile(client *sftp.Client, remoteFile, localFile string) error {
+	if err := os.MkdirAll(filepath.Dir(localFile), 0755); err != nil {
+		logger.GetLogger().Errorf("Failed to create directory for %s: %s", localFile, err.Error())
+		return err
+	}
+	return executor.readRemoteFile(client, remoteFile, func(reader io.Reader, info os.FileInfo) error {
+		tempFile := fmt.Sprintf("%s.%s.tmp", localFile, randomSuffix())
+		file, err := os.OpenFile(tempFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to create local file %s: %s", tempFile, err.Error())
+			return err
+		}
+		defer os.Remove(tempFile)
+		hash := sha256.New()
+		_, err = io.Copy(io.MultiWriter(file, hash), reader)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to download %s: %s", remoteFile, err.Error())
+			return err
+		}
+		checksum := hex.EncodeToString(hash.Sum(nil))
+		remoteChecksum, err := executor.RemoteSHA256(remoteFile)
+		if err != nil {
+			// the login user may not be able to read the original.
+			remoteChecksum, err = executor.remoteSHA256AsBecomeUser(remoteFile)
+		}
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to checksum %s: %s", remoteFile, err.Error())
+			return err
+		}
+		if remoteChecksum != checksum {
+			return fmt.Errorf("checksum mismatch after fetching %s: remote %s, local %s", remoteFile, remoteChecksum, checksum)
+		}
+		return os.Rename(tempFile, localFile)
+	})
+}
+
+func (executor *SSHExecutor) remoteSHA256AsBecomeUser(file string) (string, error) {
+	output, err := executor.ExecuteShortCommand(executor.Become(fmt.Sprintf("sha256sum %s", ShellQuote(file))))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("unexpected sha256sum output for %s: %q", file, output)
+	}
+	return fields[0], nil
+}
+
+// FetchFiles downloads remote files and directories below localDir keeping their absolute paths,
+// /etc/kubernetes/admin.conf ends up as localDir/etc/kubernetes/admin.conf. It returns the local files written.
+func (executor *SSHExecutor) FetchFiles(remotePaths []string, localDir string) ([]string, error) {
+	remoteFiles, err := executor.expandRemotePaths(remotePaths)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to list %s on %s: %s", strings.Join(remotePaths, ", "), executor.Host.Address, err.Error())
+		return nil, err
+	}
+	client, err := executor.NewSFTPClient()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	var fetched, failed []string
+	for _, remoteFile := range remoteFiles {
+		localFile := filepath.Join(localDir, filepath.FromSlash(strings.TrimPrefix(remoteFile, "/")))
+		if err := executor.fetchFile(client, remoteFile, localFile); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %s", remoteFile, err.Error()))
+			continue
+		}
+		fetched = append(fetched, localFile)
+	}
+	if len(failed) > 0 {
+		return fetched, fmt.Errorf("failed to fetch %d files from %s: %s", len(failed), executor.Host.Address, strings.Join(failed, "; "))
+	}
+	return fetched, nil
+}
+
+// expandRemotePaths turns remote paths into the absolute paths of the regular files they contain.
+func (executor *SSHExecutor) expandRemotePaths(remotePaths []string) ([]string, error) {
+	if len(remotePaths) == 0 {
+		return nil, errors.New("no remote files given")
+	}
+	cleaned := make([]string, len(remotePaths))
+	for i, remotePath := range remotePaths {
+		if !path.IsAbs(remotePath) {
+			return nil, fmt.Errorf("remote path %s is not absolute", remotePath)
+		}
+		cleaned[i] = path.Clean(remotePath)
+	}
+	output, err := executor.ExecuteShortCommand(executor.Become(fmt.Sprintf("find %s -type f", quoteAll(cleaned))))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); path.IsAbs(line) {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
+
+// WriteTarEntries appends the remote files and directories to tw below prefix.
+func (executor *SSHExecutor) WriteTarEntries(tw *tar.Writer, prefix string, remotePaths []string) error {
+	remoteFiles, err := executor.expandRemotePaths(remotePaths)
+	if err != nil {
+		return err
+	}
+	client, err := executor.NewSFTPClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	for _, remoteFile := range remoteFiles {
+		err := executor.readRemoteFile(client, remoteFile, func(reader io.Reader, info os.FileInfo) error {
+			header := &tar.Header{
+				Name:    path.Join(prefix, strings.TrimPrefix(remoteFile, "/")),
+				Mode:    int64(info.Mode().Perm()),
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+			}
+			return writeTarFile(tw, header, reader)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to archive %s from %s: %w", remoteFile, executor.Host.Address, err)
+		}
+	}
+	return nil
+}
+
+// writeTarFile stages the file in a temporary file before writing its header, so a read failing halfway
+// leaves no short entry behind and the archive stays valid for the entries that follow.
+func writeTarFile(tw *tar.Writer, header *tar.Header, reader io.Reader) error {
+	staged, err := os.CreateTemp("", "mykubespray-fetch-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+	// a file that changes while it is read would not match its header, so stage exactly Size bytes.
+	if _, err := io.CopyN(staged, reader, header.Size); err != nil {
+		return err
+	}
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, staged, header.Size)
+	return err
+}
+
+// CopyRemoteToRemote streams srcFile on the executor's host to destFile on destHost without touching local disk.
+func (executor *SSHExecutor) CopyRemoteToRemote(srcFile string, destHost entity.Host, destFile string, outputHandler func(string)) error {
+	dest := NewExecutor(destHost)
+	if dest == nil {
+		return fmt.Errorf("failed to connect to %s", destHost.Address)
+	}
+	defer dest.Connection.Close()
+	if err := copyRemoteToRemote(executor, srcFile, dest, destFile, TransferOptions{}); err != nil {
+		return err
+	}
+	outputHandler(fmt.Sprintf("Copied %s:%s to %s:%s", executor.Host.Address, srcFile, destHost.Address, destFile))
+	return nil
+}
+
+func copyRemoteToRemote(src *SSHExecutor, srcFile string, dest *SSHExecutor, destFile string, opts TransferOptions) error {
+	client, err := src.NewSFTPClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return src.readRemoteFile(client, path.Clean(srcFile), func(reader io.Reader, info os.FileInfo) error {
+		if opts.Mode == 0 {
+			opts.Mode = info.Mode().Perm()
+		}
+		return dest.Upload(reader, info.Size(), destFile, opts)
+	})
+}
+
+// hostDirName names the per host directory of fetched files.
+func hostDirName(host entity.Host) string {
+	name := host.Name
+	if name == "" {
+		name = host.Address
+	}
+	return strings.NewReplacer("/", "_", "\\", "_", "..", "_").Replace(name)
+}
+
+// WriteFilesTarGz streams a tar.gz of the remote paths of every host, each host gets its own top level directory.
+// Hosts that fail are listed in errors.txt at the end of the archive since the response has already started.
+func (pool *SSHExecutorPool) WriteFilesTarGz(w io.Writer, remotePaths []string, hosts []entity.Host) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	var failed []string
+	for _, host := range hosts {
+		executor, err := pool.GetSSHExecutor(host)
+		if err == nil {
+			err = executor.WriteTarEntries(tw, hostDirName(host), remotePaths)
+		}
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to archive files from %s: %s", host.Address, err.Error())
+			failed = append(failed, fmt.Sprintf("%s: %s", host.Address, err.Error()))
+		}
+	}
+	if len(failed) > 0 {
+		report := strings.Join(failed, "\n") + "\n"
+		if err := tw.WriteHeader(&tar.Header{Name: "errors.txt", Mode: 0644, Size: int64(len(report))}); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(tw, report); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to archive files from %d hosts", len(failed))
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestWriteTarFileLeavesNoShortEntry(t *testing.T) {
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	failing := io.MultiReader(strings.NewReader("half"), iotest.ErrReader(errors.New("connection lost")))
+	if err := writeTarFile(tw, &tar.Header{Name: "node-1/etc/big.conf", Mode: 0644, Size: 8}, failing); err == nil {
+		t.Fatal("expected the failed read to be reported")
+	}
+	if err := writeTarFile(tw, &tar.Header{Name: "errors.txt", Mode: 0644, Size: 5}, strings.NewReader("node1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := tar.NewReader(&archive)
+	var names []string
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("corrupt archive: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		names = append(names, header.Name+"="+string(data))
+	}
+	if len(names) != 1 || names[0] != "errors.txt=node1" {
+		t.Fatalf("unexpected entries %v", names)
+	}
+}
@@ -3,6 +3,7 @@ package utils
 import (
 	"os"
 	"regexp"
+	"strings"
 )
 
 func Exists(path string) bool {
@@ -20,3 +21,8 @@ func CompressStr(str, seq string) string {
 	reg := regexp.MustCompile("\\s+")
 	return reg.ReplaceAllString(str, seq)
 }
+
+// ShellQuote quotes str for safe use as a single POSIX shell word.
+func ShellQuote(str string) string {
+	return "'" + strings.ReplaceAll(str, "'", `'"'"'`) + "'"
+}
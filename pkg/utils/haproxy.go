@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/whoisfisher/mykubespray/pkg/entity"
 	"github.com/whoisfisher/mykubespray/pkg/logger"
@@ -9,6 +10,9 @@ import (
 	"text/template"
 )
 
+// haproxyValidate checks a new config before it is activated, hosts without haproxy installed yet skip the check.
+const haproxyValidate = "if command -v haproxy >/dev/null 2>&1; then haproxy -c -f %s; fi"
+
 type HaproxyClient struct {
 	HaproxyConf entity.HaproxyConf
 	OSClient    OSClient
@@ -29,11 +33,12 @@ func (client *HaproxyClient) InstallHaproxy(logChan chan LogEntry) error {
 		return err
 	}
 	if os == "ubuntu" {
-		command = "sudo apt install haproxy -y"
+		command = "apt install haproxy -y"
 	} else if os == "centos" {
-		command = "sudo yum install haproxy -y"
+		command = "yum install haproxy -y"
 	}
-	err = client.OSClient.SSExecutor.ExecuteCommand(command, logChan)
+	command = client.OSClient.SSExecutor.Become(command)
+	err = client.OSClient.SSExecutor.ExecuteCommandContext(withDeclaredPrompts(context.Background()), command, logChan, PackageInstallPrompts...)
 	if err != nil {
 		logger.GetLogger().Printf("Failed to install haproxy: %s", err.Error())
 		return err
@@ -73,10 +78,11 @@ backend kube-apiserver
   default-server inter 10s downinter 5s rise 2 fall 2 slowstart 60s maxconn 250 maxqueue 256 weight 100
   {{ .StrServers }}
 	`
+	servers := ""
 	for index, server := range client.HaproxyConf.Servers {
-		client.HaproxyConf.StrServers += fmt.Sprintf("server kube-apiserver-%d %s check\n  ", index, server)
+		servers += fmt.Sprintf("server kube-apiserver-%d %s check\n  ", index, server)
 	}
-	client.HaproxyConf.StrServers = strings.TrimSpace(client.HaproxyConf.StrServers)
+	client.HaproxyConf.StrServers = strings.TrimSpace(servers)
 	tmpl, err := template.New("haproxy.conf").Parse(templateText)
 	if err != nil {
 		logger.GetLogger().Printf("Failed to generate template object: %s", err.Error())
@@ -88,14 +94,17 @@ backend kube-apiserver
 		logger.GetLogger().Printf("Failed to generate template: %s", err.Error())
 		return err
 	}
-	command := fmt.Sprintf("bash -c \"echo '%s' > %s\"", rendered.String(), configFile)
-	if client.OSClient.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.OSClient.SSExecutor.Host.Password)
-	}
-	err = client.OSClient.SSExecutor.ExecuteCommandWithoutReturn(command)
+	result, err := client.OSClient.SSExecutor.EnsureFile(ManagedFile{
+		Path:     configFile,
+		Content:  rendered.Bytes(),
+		Mode:     0644,
+		Backup:   true,
+		Validate: haproxyValidate,
+	})
 	if err != nil {
 		logger.GetLogger().Printf("Failed to generate haproxy config: %s", err.Error())
 		return err
 	}
+	logger.GetLogger().Infof("Haproxy config %s changed: %t", configFile, result.Changed)
 	return nil
 }
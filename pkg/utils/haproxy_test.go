@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/utils/sshtest"
+	"strings"
+	"testing"
+)
+
+const haproxyConfig = "/etc/haproxy/haproxy.cfg"
+
+// handleHaproxyCheck answers the haproxy -c validation, configs containing "invalid" servers fail it.
+func handleHaproxyCheck(server *sshtest.Server) *int {
+	checks := 0
+	server.HandleFunc(`^if command -v haproxy >/dev/null 2>&1; then haproxy -c -f '(\S+)'; fi$`, func(exec *sshtest.Exec) int {
+		checks++
+		data, _ := server.ReadFile(exec.Match[1])
+		if strings.Contains(string(data), "invalid") {
+			exec.Stderr.Write([]byte("[ALERT] parsing [haproxy.cfg:30] : unknown server address\n"))
+			return 1
+		}
+		return 0
+	})
+	return &checks
+}
+
+func TestConfigureHaproxyRendersConfig(t *testing.T) {
+	server, osClient := newTestOSClient(t)
+	checks := handleHaproxyCheck(server)
+	if err := server.WriteFile(haproxyConfig, []byte("# packaged config\n")); err != nil {
+		t.Fatal(err)
+	}
+	client := NewHaproxyClient(entity.HaproxyConf{Servers: []string{"10.0.0.11:6443", "10.0.0.12:6443"}}, *osClient)
+	if err := client.ConfigureHaproxy(); err != nil {
+		t.Fatalf("configure failed: %v", err)
+	}
+	data, err := server.ReadFile(haproxyConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := string(data)
+	for _, want := range []string{"bind *:6443", "server kube-apiserver-0 10.0.0.11:6443 check\n  server kube-apiserver-1 10.0.0.12:6443 check\n"} {
+		if !strings.Contains(config, want) {
+			t.Fatalf("expected %q in config:\n%s", want, config)
+		}
+	}
+	if *checks != 1 {
+		t.Fatalf("expected the config to be validated once, got %d", *checks)
+	}
+	infos, err := server.ReadDir("/etc/haproxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	backups := 0
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), "haproxy.cfg.") && strings.HasSuffix(info.Name(), ".bak") {
+			backups++
+		}
+	}
+	if backups != 1 {
+		t.Fatalf("expected the packaged config to be backed up once, got %d backups", backups)
+	}
+
+	if err := client.ConfigureHaproxy(); err != nil {
+		t.Fatalf("second configure failed: %v", err)
+	}
+	if again, _ := server.ReadFile(haproxyConfig); string(again) != config || *checks != 1 {
+		t.Fatalf("expected a second run to leave the config alone, got %d checks:\n%s", *checks, again)
+	}
+}
+
+func TestConfigureHaproxyKeepsConfigWhenValidationFails(t *testing.T) {
+	server, osClient := newTestOSClient(t)
+	handleHaproxyCheck(server)
+	if err := server.WriteFile(haproxyConfig, []byte("# packaged config\n")); err != nil {
+		t.Fatal(err)
+	}
+	client := NewHaproxyClient(entity.HaproxyConf{Servers: []string{"invalid:6443"}}, *osClient)
+	if err := client.ConfigureHaproxy(); err == nil || !strings.Contains(err.Error(), "unknown server address") {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	if data, _ := server.ReadFile(haproxyConfig); string(data) != "# packaged config\n" {
+		t.Fatalf("the packaged config was replaced: %q", data)
+	}
+}
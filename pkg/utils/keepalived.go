@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/whoisfisher/mykubespray/pkg/entity"
 	"github.com/whoisfisher/mykubespray/pkg/logger"
@@ -29,11 +30,12 @@ func (client *KeepalivedClient) InstallKeepalived(logChan chan LogEntry) error {
 		return err
 	}
 	if os == "ubuntu" {
-		command = "sudo apt install keepalived -y"
+		command = "apt install keepalived -y"
 	} else if os == "centos" {
-		command = "sudo yum install keepalived -y"
+		command = "yum install keepalived -y"
 	}
-	err = client.OSClient.SSExecutor.ExecuteCommand(command, logChan)
+	command = client.OSClient.SSExecutor.Become(command)
+	err = client.OSClient.SSExecutor.ExecuteCommandContext(withDeclaredPrompts(context.Background()), command, logChan, PackageInstallPrompts...)
 	if err != nil {
 		logger.GetLogger().Printf("Failed to install keepalived: %s", err.Error())
 		return err
@@ -94,20 +96,22 @@ vrrp_instance keepalived-vip {
 		logger.GetLogger().Printf("Failed to generate template: %s", err.Error())
 		return err
 	}
-	command := fmt.Sprintf("bash -c \"echo '%s' > %s\"", rendered.String(), configFile)
-	if client.OSClient.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.OSClient.SSExecutor.Host.Password)
-	}
-	err = client.OSClient.SSExecutor.ExecuteCommandWithoutReturn(command)
+	result, err := client.OSClient.SSExecutor.EnsureFile(ManagedFile{
+		Path:    configFile,
+		Content: rendered.Bytes(),
+		Mode:    0644,
+		Backup:  true,
+	})
 	if err != nil {
 		logger.GetLogger().Printf("Failed to generate Keepalived config: %s", err.Error())
 		return err
 	}
+	logger.GetLogger().Infof("Keepalived config %s changed: %t", configFile, result.Changed)
 	return nil
 }
 
 func (client *KeepalivedClient) IsVirtualIPActive() bool {
-	command := "ip addr show dev" + client.KeepalivedConf.IntFace
+	command := "ip addr show dev " + client.KeepalivedConf.IntFace
 	output, err := client.OSClient.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Printf("Failed to query Keepalived vip: %s", err.Error())
@@ -0,0 +1,35 @@
+package utils
+
+import (
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"strings"
+	"testing"
+)
+
+func TestConfigureKeepalivedRendersConfig(t *testing.T) {
+	server, osClient := newTestOSClient(t)
+	conf := entity.KeepalivedConf{State: "MASTER", IntFace: "eth0", Priority: 100, AuthType: "PASS", AuthPass: "k8s", SrcIP: "10.0.0.11", Peers: []string{"10.0.0.12", "10.0.0.13"}, VIP: "10.0.0.100"}
+	client := NewKeepalivedClient(conf, *osClient)
+	if err := client.ConfigureKeepalived(); err != nil {
+		t.Fatalf("configure failed: %v", err)
+	}
+	data, err := server.ReadFile("/etc/keepalived/keepalived.conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := string(data)
+	for _, want := range []string{"state MASTER", "interface eth0", "unicast_src_ip 10.0.0.11", "10.0.0.12\n    10.0.0.13", "virtual_ipaddress {\n    10.0.0.100"} {
+		if !strings.Contains(config, want) {
+			t.Fatalf("expected %q in config:\n%s", want, config)
+		}
+	}
+}
+
+func TestKeepalivedVirtualIPActive(t *testing.T) {
+	server, osClient := newTestOSClient(t)
+	server.Handle(`^ip addr show dev eth0$`, "inet 10.0.0.11/24\n    inet 10.0.0.100/32 scope global eth0\n", 0)
+	client := NewKeepalivedClient(entity.KeepalivedConf{IntFace: "eth0", VIP: "10.0.0.100"}, *osClient)
+	if !client.IsVirtualIPActive() {
+		t.Fatalf("expected the virtual ip to be active")
+	}
+}
@@ -0,0 +1,265 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"golang.org/x/crypto/ssh"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	HostKeyModeStrict   = "strict"
+	HostKeyModeTOFU     = "tofu"
+	HostKeyModeInsecure = "insecure"
+)
+
+const (
+	KnownHostTrusted = "trusted"
+	KnownHostPending = "pending"
+)
+
+const defaultKnownHostsFile = "data/known_hosts.json"
+
+var ErrKnownHostNotFound = errors.New("known host not found")
+
+// HostKeyMismatchError is returned when a host presents a key that differs from the trusted one.
+type HostKeyMismatchError struct {
+	Address  string
+	Expected string
+	Actual   string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("host key mismatch for %s: expected %s, got %s; the host may have been replaced or spoofed, revoke the old key only if the change is expected", e.Address, e.Expected, e.Actual)
+}
+
+// HostKeyUntrustedError is returned in strict mode when a host key has not been accepted yet.
+type HostKeyUntrustedError struct {
+	Address     string
+	Fingerprint string
+}
+
+func (e *HostKeyUntrustedError) Error() string {
+	return fmt.Sprintf("host key %s for %s is not trusted, accept it before connecting", e.Fingerprint, e.Address)
+}
+
+// KnownHostsStore keeps host key fingerprints in a JSON file keyed by address and port.
+type KnownHostsStore struct {
+	path  string
+	mutex sync.RWMutex
+	hosts map[string]*entity.KnownHost
+}
+
+var (
+	knownHostsStore     *KnownHostsStore
+	knownHostsStoreOnce sync.Once
+)
+
+// GetKnownHostsStore returns the store configured by ssh.known_hosts_file.
+func GetKnownHostsStore() *KnownHostsStore {
+	knownHostsStoreOnce.Do(func() {
+		path := viper.GetString("ssh.known_hosts_file")
+		if path == "" {
+			path = defaultKnownHostsFile
+		}
+		store, err := NewKnownHostsStore(path)
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to load known hosts from %s: %s", path, err.Error())
+			store = &KnownHostsStore{path: path, hosts: make(map[string]*entity.KnownHost)}
+		}
+		knownHostsStore = store
+	})
+	return knownHostsStore
+}
+
+// GetHostKeyMode returns the configured host key verification mode.
+func GetHostKeyMode() string {
+	switch mode := viper.GetString("ssh.host_key_mode"); mode {
+	case HostKeyModeStrict, HostKeyModeInsecure:
+		return mode
+	default:
+		return HostKeyModeTOFU
+	}
+}
+
+func NewKnownHostsStore(path string) (*KnownHostsStore, error) {
+	store := &KnownHostsStore{
+		path:  path,
+		hosts: make(map[string]*entity.KnownHost),
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return store, nil
+		}
+		return nil, err
+	}
+	var hosts []*entity.KnownHost
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &hosts); err != nil {
+			return nil, err
+		}
+	}
+	for _, host := range hosts {
+		store.hosts[knownHostKey(host.Address, host.Port)] = host
+	}
+	return store, nil
+}
+
+func knownHostKey(address string, port int32) string {
+	return net.JoinHostPort(address, strconv.Itoa(int(port)))
+}
+
+// save writes the store to disk, the caller must hold the write lock.
+func (store *KnownHostsStore) save() error {
+	hosts := make([]*entity.KnownHost, 0, len(store.hosts))
+	for _, host := range store.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return knownHostKey(hosts[i].Address, hosts[i].Port) < knownHostKey(hosts[j].Address, hosts[j].Port)
+	})
+	data, err := json.MarshalIndent(hosts, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(store.path); dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	tmpFile := store.path + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, store.path)
+}
+
+func (store *KnownHostsStore) List() []entity.KnownHost {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	hosts := make([]entity.KnownHost, 0, len(store.hosts))
+	for _, host := range store.hosts {
+		hosts = append(hosts, *host)
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		return knownHostKey(hosts[i].Address, hosts[i].Port) < knownHostKey(hosts[j].Address, hosts[j].Port)
+	})
+	return hosts
+}
+
+func (store *KnownHostsStore) Get(address string, port int32) (entity.KnownHost, bool) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	host, ok := store.hosts[knownHostKey(address, port)]
+	if !ok {
+		return entity.KnownHost{}, false
+	}
+	return *host, true
+}
+
+// Accept marks the recorded key of a host as trusted. When fingerprint is set it must match the recorded key.
+func (store *KnownHostsStore) Accept(address string, port int32, fingerprint string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	host, ok := store.hosts[knownHostKey(address, port)]
+	if !ok {
+		return ErrKnownHostNotFound
+	}
+	if fingerprint != "" && fingerprint != host.Fingerprint {
+		return &HostKeyMismatchError{Address: knownHostKey(address, port), Expected: fingerprint, Actual: host.Fingerprint}
+	}
+	host.Status = KnownHostTrusted
+	return store.save()
+}
+
+// Revoke forgets the key of a host, the next connection records it again.
+func (store *KnownHostsStore) Revoke(address string, port int32) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	key := knownHostKey(address, port)
+	if _, ok := store.hosts[key]; !ok {
+		return ErrKnownHostNotFound
+	}
+	delete(store.hosts, key)
+	return store.save()
+}
+
+// Verify checks a presented key against the store according to mode. The store is only written when a key
+// is recorded or trusted, LastSeen is kept in memory until then, and a failed write does not fail the handshake.
+func (store *KnownHostsStore) Verify(name, address string, port int32, key ssh.PublicKey, mode string) error {
+	if mode == HostKeyModeInsecure {
+		return nil
+	}
+	fingerprint := ssh.FingerprintSHA256(key)
+	now := time.Now()
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	hostKey := knownHostKey(address, port)
+	known, ok := store.hosts[hostKey]
+	if ok && known.Fingerprint != fingerprint {
+		logger.GetLogger().Errorf("Host key mismatch for %s: expected %s, got %s", hostKey, known.Fingerprint, fingerprint)
+		return &HostKeyMismatchError{Address: hostKey, Expected: known.Fingerprint, Actual: fingerprint}
+	}
+	changed := !ok
+	if !ok {
+		known = &entity.KnownHost{
+			Name:        name,
+			Address:     address,
+			Port:        port,
+			KeyType:     key.Type(),
+			Fingerprint: fingerprint,
+			PublicKey:   base64.StdEncoding.EncodeToString(key.Marshal()),
+			Status:      KnownHostPending,
+			FirstSeen:   now,
+		}
+		store.hosts[hostKey] = known
+	}
+	known.LastSeen = now
+	if known.Status != KnownHostTrusted && mode == HostKeyModeTOFU {
+		logger.GetLogger().Infof("Trusting host key %s for %s on first use", fingerprint, hostKey)
+		known.Status = KnownHostTrusted
+		changed = true
+	}
+	if changed {
+		if err := store.save(); err != nil {
+			logger.GetLogger().Errorf("Failed to save known hosts to %s: %s", store.path, err.Error())
+		}
+	}
+	if known.Status != KnownHostTrusted {
+		return &HostKeyUntrustedError{Address: hostKey, Fingerprint: fingerprint}
+	}
+	return nil
+}
+
+// HostKeyCallback returns a callback verifying the key of the given host.
+func (store *KnownHostsStore) HostKeyCallback(name, address string, port int32, mode string) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		return store.Verify(name, address, port, key, mode)
+	}
+}
+
+// NewHostKeyCallback builds a callback for host using the configured store and mode.
+func NewHostKeyCallback(host entity.Host) ssh.HostKeyCallback {
+	mode := GetHostKeyMode()
+	if mode == HostKeyModeInsecure {
+		return ssh.InsecureIgnoreHostKey()
+	}
+	port := host.Port
+	if port == 0 {
+		port = 22
+	}
+	return GetKnownHostsStore().HostKeyCallback(host.Name, host.Address, port, mode)
+}
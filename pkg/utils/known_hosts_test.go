@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("failed to convert key: %v", err)
+	}
+	return key
+}
+
+func TestKnownHostsTOFU(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts.json")
+	store, err := NewKnownHostsStore(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	key := newTestHostKey(t)
+	if err := store.Verify("node1", "10.0.0.1", 22, key, HostKeyModeTOFU); err != nil {
+		t.Fatalf("expected first use to be trusted, got %v", err)
+	}
+	if err := store.Verify("node1", "10.0.0.1", 22, key, HostKeyModeStrict); err != nil {
+		t.Fatalf("expected trusted key to pass strict mode, got %v", err)
+	}
+
+	var mismatch *HostKeyMismatchError
+	err = store.Verify("node1", "10.0.0.1", 22, newTestHostKey(t), HostKeyModeTOFU)
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected host key mismatch, got %v", err)
+	}
+
+	reloaded, err := NewKnownHostsStore(path)
+	if err != nil {
+		t.Fatalf("expected no error reloading store, got %v", err)
+	}
+	known, ok := reloaded.Get("10.0.0.1", 22)
+	if !ok || known.Status != KnownHostTrusted || known.Fingerprint != ssh.FingerprintSHA256(key) {
+		t.Fatalf("expected persisted trusted key, got %+v", known)
+	}
+}
+
+func TestKnownHostsStrictAcceptRevoke(t *testing.T) {
+	store, err := NewKnownHostsStore(filepath.Join(t.TempDir(), "known_hosts.json"))
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	key := newTestHostKey(t)
+	var untrusted *HostKeyUntrustedError
+	if err := store.Verify("node1", "10.0.0.1", 22, key, HostKeyModeStrict); !errors.As(err, &untrusted) {
+		t.Fatalf("expected untrusted key in strict mode, got %v", err)
+	}
+	if err := store.Accept("10.0.0.1", 22, "SHA256:wrong"); err == nil {
+		t.Fatalf("expected accept with wrong fingerprint to fail")
+	}
+	if err := store.Accept("10.0.0.1", 22, ssh.FingerprintSHA256(key)); err != nil {
+		t.Fatalf("expected accept to succeed, got %v", err)
+	}
+	if err := store.Verify("node1", "10.0.0.1", 22, key, HostKeyModeStrict); err != nil {
+		t.Fatalf("expected accepted key to pass, got %v", err)
+	}
+	if err := store.Revoke("10.0.0.1", 22); err != nil {
+		t.Fatalf("expected revoke to succeed, got %v", err)
+	}
+	if _, ok := store.Get("10.0.0.1", 22); ok {
+		t.Fatalf("expected revoked key to be removed")
+	}
+}
+
+func TestKnownHostsVerifyWritesOnlyChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "known_hosts.json")
+	store, err := NewKnownHostsStore(path)
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	key := newTestHostKey(t)
+	if err := store.Verify("node1", "10.0.0.1", 22, key, HostKeyModeTOFU); err != nil {
+		t.Fatalf("expected first use to be trusted, got %v", err)
+	}
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("expected the new key to be saved, got %v", err)
+	}
+	if err := store.Verify("node1", "10.0.0.1", 22, key, HostKeyModeTOFU); err != nil {
+		t.Fatalf("expected the known key to pass, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected a known key not to rewrite the store, got %v", err)
+	}
+
+	// a regular file in place of the directory makes every save fail
+	blocked := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(blocked, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	store.path = filepath.Join(blocked, "known_hosts.json")
+	if err := store.Verify("node2", "10.0.0.2", 22, newTestHostKey(t), HostKeyModeTOFU); err != nil {
+		t.Fatalf("expected a failed save not to fail the handshake, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"github.com/whoisfisher/mykubespray/pkg/entity"
 	"github.com/whoisfisher/mykubespray/pkg/logger"
@@ -11,6 +12,11 @@ import (
 	"text/template"
 )
 
+// kubekeyPrompts confirms the [yes/no] question kk asks before changing a cluster.
+var kubekeyPrompts = []PromptRule{
+	MustPromptRule(`\[yes/no\]`, "yes", 1, false),
+}
+
 type KubekeyClient struct {
 	KubekeyConf entity.KubekeyConf
 	OSClient    OSClient
@@ -167,11 +173,7 @@ spec:
 		logger.GetLogger().Errorf("Failed to generate dir %s: %s", configPath, err.Error())
 		return err
 	}
-	command := fmt.Sprintf("bash -c \"echo '%s' > %s\"", rendered.String(), path)
-	if client.OSClient.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.OSClient.SSExecutor.Host.Password)
-	}
-	err = client.OSClient.SSExecutor.ExecuteCommandWithoutReturn(command)
+	_, err = client.OSClient.SSExecutor.EnsureFile(ManagedFile{Path: path, Content: rendered.Bytes(), Mode: 0644, Backup: true})
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to generate kubekey config: %s", err.Error())
 		return err
@@ -264,12 +266,7 @@ spec:
 		logger.GetLogger().Errorf("Failed to generate dir %s: %s", configPath, err.Error())
 		return err
 	}
-	//command := fmt.Sprintf("echo '%s' > %s", rendered.String(), path)
-	command := fmt.Sprintf("bash -c \"echo '%s' > %s\"", rendered.String(), path)
-	if client.OSClient.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.OSClient.SSExecutor.Host.Password)
-	}
-	err = client.OSClient.SSExecutor.ExecuteCommandWithoutReturn(command)
+	_, err = client.OSClient.SSExecutor.EnsureFile(ManagedFile{Path: path, Content: rendered.Bytes(), Mode: 0644, Backup: true})
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to generate kubekey config: %s", err.Error())
 		return err
@@ -277,14 +274,14 @@ spec:
 	return nil
 }
 
-func (client *KubekeyClient) CreateCluster(logChan chan LogEntry) error {
+func (client *KubekeyClient) CreateCluster(ctx context.Context, logChan chan LogEntry) error {
 	dirPath := filepath.Dir(client.KubekeyConf.KKPath)
 	configPath := filepath.Join(dirPath, client.KubekeyConf.ClusterName)
 	configPath = filepath.ToSlash(configPath)
 	path := filepath.Join(configPath, "config-sample.yaml")
 	path = filepath.ToSlash(path)
 	command := fmt.Sprintf("kk create cluster -f %s -a %s --with-packages --yes", path, client.KubekeyConf.TaichuPackagePath)
-	err := client.OSClient.SSExecutor.ExecuteCommand(command, logChan)
+	err := client.OSClient.SSExecutor.ExecuteCommandContext(withDeclaredPrompts(ctx), command, logChan, kubekeyPrompts...)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to create cluster %s: %s", client.KubekeyConf.ClusterName, err.Error())
 		return err
@@ -292,14 +289,14 @@ func (client *KubekeyClient) CreateCluster(logChan chan LogEntry) error {
 	return nil
 }
 
-func (client *KubekeyClient) DeleteCluster(logChan chan LogEntry) error {
+func (client *KubekeyClient) DeleteCluster(ctx context.Context, logChan chan LogEntry) error {
 	dirPath := filepath.Dir(client.KubekeyConf.KKPath)
 	configPath := filepath.Join(dirPath, client.KubekeyConf.ClusterName)
 	configPath = filepath.ToSlash(configPath)
 	path := filepath.Join(configPath, "config-sample.yaml")
 	path = filepath.ToSlash(path)
 	command := fmt.Sprintf("kk delete cluster -f %s --yes", path)
-	err := client.OSClient.SSExecutor.ExecuteCommand(command, logChan)
+	err := client.OSClient.SSExecutor.ExecuteCommandContext(withDeclaredPrompts(ctx), command, logChan, kubekeyPrompts...)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to delete cluster %s: %s", client.KubekeyConf.ClusterName, err.Error())
 		return err
@@ -307,14 +304,14 @@ func (client *KubekeyClient) DeleteCluster(logChan chan LogEntry) error {
 	return nil
 }
 
-func (client *KubekeyClient) AddNode(logChan chan LogEntry) error {
+func (client *KubekeyClient) AddNode(ctx context.Context, logChan chan LogEntry) error {
 	dirPath := filepath.Dir(client.KubekeyConf.KKPath)
 	configPath := filepath.Join(dirPath, client.KubekeyConf.ClusterName)
 	configPath = filepath.ToSlash(configPath)
 	path := filepath.Join(configPath, "config-sample.yaml")
 	path = filepath.ToSlash(path)
 	command := fmt.Sprintf("kk add nodes -f %s --yes", path)
-	err := client.OSClient.SSExecutor.ExecuteCommand(command, logChan)
+	err := client.OSClient.SSExecutor.ExecuteCommandContext(withDeclaredPrompts(ctx), command, logChan, kubekeyPrompts...)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to add node to cluster %s: %s", client.KubekeyConf.ClusterName, err.Error())
 		return err
@@ -322,14 +319,14 @@ func (client *KubekeyClient) AddNode(logChan chan LogEntry) error {
 	return nil
 }
 
-func (client *KubekeyClient) DeleteNode(nodeName string, logChan chan LogEntry) error {
+func (client *KubekeyClient) DeleteNode(ctx context.Context, nodeName string, logChan chan LogEntry) error {
 	dirPath := filepath.Dir(client.KubekeyConf.KKPath)
 	configPath := filepath.Join(dirPath, client.KubekeyConf.ClusterName)
 	configPath = filepath.ToSlash(configPath)
 	path := filepath.Join(configPath, "config-sample.yaml")
 	path = filepath.ToSlash(path)
 	command := fmt.Sprintf("kk delete node %s -f %s", nodeName, path)
-	err := client.OSClient.SSExecutor.ExecuteCommand(command, logChan)
+	err := client.OSClient.SSExecutor.ExecuteCommandContext(withDeclaredPrompts(ctx), command, logChan, kubekeyPrompts...)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to delete node %s from cluster %s: %s", nodeName, client.KubekeyConf.ClusterName, err.Error())
 		return err
@@ -337,14 +334,14 @@ func (client *KubekeyClient) DeleteNode(nodeName string, logChan chan LogEntry)
 	return nil
 }
 
-func (client *KubekeyClient) CheckCertExpirtation(logChan chan LogEntry) error {
+func (client *KubekeyClient) CheckCertExpirtation(ctx context.Context, logChan chan LogEntry) error {
 	dirPath := filepath.Dir(client.KubekeyConf.KKPath)
 	configPath := filepath.Join(dirPath, client.KubekeyConf.ClusterName)
 	configPath = filepath.ToSlash(configPath)
 	path := filepath.Join(configPath, "config-sample.yaml")
 	path = filepath.ToSlash(path)
 	command := fmt.Sprintf("kk certs check-expirtation -f %s", path)
-	err := client.OSClient.SSExecutor.ExecuteCommand(command, logChan)
+	err := client.OSClient.SSExecutor.ExecuteCommandContext(withDeclaredPrompts(ctx), command, logChan, kubekeyPrompts...)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to check cert expiration %s: %s", client.KubekeyConf.ClusterName, err.Error())
 		return err
@@ -352,14 +349,14 @@ func (client *KubekeyClient) CheckCertExpirtation(logChan chan LogEntry) error {
 	return nil
 }
 
-func (client *KubekeyClient) RenewCert(logChan chan LogEntry) error {
+func (client *KubekeyClient) RenewCert(ctx context.Context, logChan chan LogEntry) error {
 	dirPath := filepath.Dir(client.KubekeyConf.KKPath)
 	configPath := filepath.Join(dirPath, client.KubekeyConf.ClusterName)
 	configPath = filepath.ToSlash(configPath)
 	path := filepath.Join(configPath, "config-sample.yaml")
 	path = filepath.ToSlash(path)
 	command := fmt.Sprintf("kk certs renew -f %s", path)
-	err := client.OSClient.SSExecutor.ExecuteCommand(command, logChan)
+	err := client.OSClient.SSExecutor.ExecuteCommandContext(withDeclaredPrompts(ctx), command, logChan, kubekeyPrompts...)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to renew cert %s: %s", client.KubekeyConf.ClusterName, err.Error())
 		return err
@@ -367,17 +364,40 @@ func (client *KubekeyClient) RenewCert(logChan chan LogEntry) error {
 	return nil
 }
 
-func (client *KubekeyClient) UpgradeCluster(logChan chan LogEntry) error {
+func (client *KubekeyClient) UpgradeCluster(ctx context.Context, logChan chan LogEntry) error {
 	dirPath := filepath.Dir(client.KubekeyConf.KKPath)
 	configPath := filepath.Join(dirPath, client.KubekeyConf.ClusterName)
 	configPath = filepath.ToSlash(configPath)
 	path := filepath.Join(configPath, "config-sample.yaml")
 	path = filepath.ToSlash(path)
 	command := fmt.Sprintf("kk upgrade -f %s", path)
-	err := client.OSClient.SSExecutor.ExecuteCommand(command, logChan)
+	err := client.OSClient.SSExecutor.ExecuteCommandContext(withDeclaredPrompts(ctx), command, logChan, kubekeyPrompts...)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to upgrade %s: %s", client.KubekeyConf.ClusterName, err.Error())
 		return err
 	}
 	return nil
 }
+
+// kubekeyDomain is the control plane endpoint of the generated configs, it only resolves on the nodes.
+const kubekeyDomain = "lb.cars.local"
+
+// FetchAdminKubeconfig reads the admin kubeconfig of a control plane node and points it at server.
+func FetchAdminKubeconfig(host entity.Host, server string) (string, error) {
+	executor, err := GetSSHExecutorPool().GetSSHExecutor(host)
+	if err != nil {
+		return "", err
+	}
+	kubeconfig, err := executor.ExecuteShortCommand(executor.Become("cat /etc/kubernetes/admin.conf"))
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to read admin kubeconfig of %s: %s", host.Address, err.Error())
+		return "", err
+	}
+	if !strings.Contains(kubeconfig, "apiVersion") {
+		return "", fmt.Errorf("unexpected admin kubeconfig of %s: %q", host.Address, kubeconfig)
+	}
+	if strings.Contains(server, ":") {
+		server = "[" + server + "]"
+	}
+	return strings.ReplaceAll(kubeconfig, "://"+kubekeyDomain+":", "://"+server+":"), nil
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/utils/sshtest"
+	"strings"
+	"testing"
+)
+
+func newTestKubekeyClient(t *testing.T) (*sshtest.Server, *KubekeyClient) {
+	server, osClient := newTestOSClient(t)
+	conf := entity.KubekeyConf{
+		ClusterName:       "demo",
+		KKPath:            "/opt/kubekey/kk",
+		Hosts:             []entity.Host{{Name: "node1", Address: "10.0.0.11", InternalAddress: "10.0.0.11", Port: 22, User: "root"}},
+		Etcds:             []string{"node1"},
+		ContronPlanes:     []string{"node1"},
+		Workers:           []string{"node1"},
+		Registry:          entity.Registry{NodeName: "node1", Url: "registry.local"},
+		KubernetesVersion: "v1.28.2",
+	}
+	return server, NewKubekeyClient(conf, *osClient)
+}
+
+func TestKubekeyGenerateConfig(t *testing.T) {
+	server, client := newTestKubekeyClient(t)
+	if err := client.GenerateConfig(); err != nil {
+		t.Fatalf("generate config failed: %v", err)
+	}
+	data, err := server.ReadFile("/opt/kubekey/demo/config-sample.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"name: demo", "- {name: node1, address: 10.0.0.11", "version: v1.28.2", `privateRegistry: "registry.local"`} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("expected %q in config:\n%s", want, data)
+		}
+	}
+}
+
+func TestKubekeyDeleteNodeConfirmsPrompt(t *testing.T) {
+	server, client := newTestKubekeyClient(t)
+	server.HandleFunc(`^kk delete node node2 -f /opt/kubekey/demo/config-sample.yaml$`, func(exec *sshtest.Exec) int {
+		fmt.Fprint(exec.Stdout, "Are you sure to delete this node? [yes/no]: ")
+		answer, _ := bufio.NewReader(exec.Stdin).ReadString('\n')
+		if answer != "yes\n" {
+			return 1
+		}
+		fmt.Fprintln(exec.Stdout, "\nnode2 deleted")
+		return 0
+	})
+	logChan := make(chan LogEntry, 16)
+	if err := client.DeleteNode(context.Background(), "node2", logChan); err != nil {
+		t.Fatalf("delete node failed: %v", err)
+	}
+}
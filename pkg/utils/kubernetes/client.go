@@ -101,10 +101,22 @@ func GetKubernetesRestConfig(c *entity.K8sConfig) (*rest.Config, error) {
 			return nil, err
 		}
 	}
+	if c.SSHTunnel != nil {
+		if err := tunnelRestConfig(kubeConf, *c.SSHTunnel); err != nil {
+			return nil, err
+		}
+	}
 	return kubeConf, nil
 }
 
 func NewHelmClient(c *entity.K8sConfig) (helm.Client, error) {
+	if c.SSHTunnel != nil {
+		kubeConf, err := GetKubernetesRestConfig(c)
+		if err != nil {
+			return nil, err
+		}
+		return NewHelmClientFromRestConfig(kubeConf)
+	}
 	options := &helm.KubeConfClientOptions{
 		Options: &helm.Options{
 			Namespace:        "",
@@ -0,0 +1,43 @@
+package kubernetes
+
+import (
+	"fmt"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"github.com/whoisfisher/mykubespray/pkg/utils"
+	"k8s.io/client-go/rest"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// tunnelRestConfig points kubeConf at a pooled SSH local-forward to its API server.
+// The certificate is still verified against the original server name.
+func tunnelRestConfig(kubeConf *rest.Config, host entity.Host) error {
+	server := kubeConf.Host
+	if !strings.Contains(server, "://") {
+		server = "https://" + server
+	}
+	apiServer, err := url.Parse(server)
+	if err != nil {
+		return fmt.Errorf("invalid api server %q: %w", kubeConf.Host, err)
+	}
+	port := apiServer.Port()
+	if port == "" {
+		port = "443"
+		if apiServer.Scheme == "http" {
+			port = "80"
+		}
+	}
+	tunnel, err := utils.GetSSHExecutorPool().LocalForward(host, net.JoinHostPort(apiServer.Hostname(), port))
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to open tunnel to %s through %s: %s", kubeConf.Host, host.Address, err.Error())
+		return err
+	}
+	if kubeConf.TLSClientConfig.ServerName == "" {
+		kubeConf.TLSClientConfig.ServerName = apiServer.Hostname()
+	}
+	apiServer.Host = tunnel.LocalAddr()
+	kubeConf.Host = apiServer.String()
+	return nil
+}
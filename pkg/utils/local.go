@@ -2,11 +2,15 @@ package utils
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"os/exec"
+	"time"
 )
 
 // LocalExecutor implements Executor for local system commands.
@@ -28,6 +32,37 @@ func (executor *LocalExecutor) ExecuteShortCommand(command string) (string, erro
 	return string(res), nil
 }
 
+// RunCommand executes command locally and collects its exit code, stdout and stderr separately.
+// A non-zero exit code is returned as an error together with the result.
+func (executor *LocalExecutor) RunCommand(ctx context.Context, command string) (*CommandResult, error) {
+	result := &CommandResult{Host: "localhost", Command: command, ExitCode: -1}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	result.StartTime = time.Now()
+	err := cmd.Run()
+	result.EndTime = time.Now()
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	if ctx.Err() != nil {
+		log.Printf("Local command cancelled: %s", ctx.Err().Error())
+		return result, fmt.Errorf("command %q cancelled: %w", command, ctx.Err())
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		result.ExitCode = 0
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	}
+	if err != nil {
+		log.Printf("Local command execution failed: %s", err.Error())
+		return result, err
+	}
+	return result, nil
+}
+
 func (executor *LocalExecutor) ExecuteCommand(command string, logChan chan LogEntry) error {
 	cmd := exec.Command("sh", "-c", command)
 	return executor.executeCommand(cmd, logChan)
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLocalExecutorRunCommand(t *testing.T) {
+	executor := NewLocalExecutor()
+	result, err := executor.RunCommand(context.Background(), "echo out; echo err >&2; exit 3")
+	if err == nil {
+		t.Fatalf("expected error for non-zero exit code")
+	}
+	if result.ExitCode != 3 {
+		t.Fatalf("expected exit code 3, got %d", result.ExitCode)
+	}
+	if result.Stdout != "out\n" || result.Stderr != "err\n" {
+		t.Fatalf("expected separate stdout and stderr, got %q and %q", result.Stdout, result.Stderr)
+	}
+	if result.EndTime.Before(result.StartTime) {
+		t.Fatalf("expected end time after start time")
+	}
+
+	result, err = executor.RunCommand(context.Background(), "true")
+	if err != nil || !result.Success() {
+		t.Fatalf("expected success, got %v, %+v", err, result)
+	}
+}
@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ManagedFile is the desired state of a remote file.
+type ManagedFile struct {
+	Path    string
+	Content []byte
+	// Mode, Owner and Group keep the values of an existing file when empty.
+	Mode  os.FileMode
+	Owner string
+	Group string
+	// Backup copies the previous version to Path.<timestamp>.bak, or into BackupDir when set, before it is replaced.
+	Backup    bool
+	BackupDir string
+	// Validate checks the new file before it replaces Path, %s is replaced by the path of the new file, such as "haproxy -c -f %s".
+	Validate string
+}
+
+// ManagedFileResult reports what EnsureFile did.
+type ManagedFileResult struct {
+	Path     string `json:"path"`
+	Changed  bool   `json:"changed"`
+	Checksum string `json:"checksum"`
+	Backup   string `json:"backup,omitempty"`
+}
+
+type remoteFileState struct {
+	exists   bool
+	checksum string
+	mode     os.FileMode
+	owner    string
+	group    string
+}
+
+// EnsureFile makes the remote file match file. Unchanged content only has its mode and owner corrected,
+// new content is uploaded next to the file, validated and renamed over it so readers never see a partial file.
+func (executor *SSHExecutor) EnsureFile(file ManagedFile) (*ManagedFileResult, error) {
+	if file.Path == "" || !path.IsAbs(file.Path) {
+		return nil, fmt.Errorf("managed file path must be absolute: %q", file.Path)
+	}
+	target := path.Clean(file.Path)
+	sum := sha256.Sum256(file.Content)
+	result := &ManagedFileResult{Path: target, Checksum: hex.EncodeToString(sum[:])}
+
+	current, err := executor.statManagedFile(target)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to inspect %s: %s", target, err.Error())
+		return nil, err
+	}
+	opts := TransferOptions{Mode: file.Mode, Owner: file.Owner, Group: file.Group}
+	if current.exists {
+		if opts.Mode == 0 {
+			opts.Mode = current.mode
+		}
+		if opts.Owner == "" && opts.Group == "" {
+			opts.Owner, opts.Group = current.owner, current.group
+		}
+		if current.checksum == result.Checksum {
+			changed, err := executor.fixAttributes(target, current, opts)
+			result.Changed = changed
+			return result, err
+		}
+	}
+
+	staged := path.Join(path.Dir(target), fmt.Sprintf(".%s.%s.new", path.Base(target), randomSuffix()))
+	if err := executor.Upload(bytes.NewReader(file.Content), int64(len(file.Content)), staged, opts); err != nil {
+		logger.GetLogger().Errorf("Failed to stage %s: %s", target, err.Error())
+		return nil, err
+	}
+	if file.Validate != "" {
+		if err := executor.validateFile(staged, file.Validate); err != nil {
+			executor.removeRemoteFile(staged)
+			return nil, err
+		}
+	}
+	if current.exists && file.Backup {
+		backup, err := executor.backupFile(target, file.BackupDir)
+		if err != nil {
+			executor.removeRemoteFile(staged)
+			return nil, err
+		}
+		result.Backup = backup
+	}
+	if err := executor.renameRemoteFile(staged, target); err != nil {
+		executor.removeRemoteFile(staged)
+		return nil, err
+	}
+	result.Changed = true
+	return result, nil
+}
+
+// statManagedFile reads mode, owner and checksum of file as the become user, a missing file is not an error.
+// test -e tells a missing file apart from a failing stat regardless of the locale of the host.
+func (executor *SSHExecutor) statManagedFile(file string) (remoteFileState, error) {
+	var state remoteFileState
+	command := fmt.Sprintf("test ! -e %[1]s || stat -c '%%a %%U %%G' %[1]s", ShellQuote(file))
+	result, err := executor.RunCommand(context.Background(), executor.Become(command))
+	if err != nil {
+		return state, err
+	}
+	if strings.TrimSpace(result.Stdout) == "" {
+		return state, nil
+	}
+	fields := strings.Fields(result.Stdout)
+	if len(fields) != 3 {
+		return state, fmt.Errorf("unexpected stat output for %s: %q", file, result.Stdout)
+	}
+	mode, err := strconv.ParseUint(fields[0], 8, 32)
+	if err != nil {
+		return state, fmt.Errorf("unexpected mode of %s: %q", file, fields[0])
+	}
+	state.exists, state.mode, state.owner, state.group = true, os.FileMode(mode), fields[1], fields[2]
+	if state.checksum, err = executor.remoteSHA256AsBecomeUser(file); err != nil {
+		return state, err
+	}
+	return state, nil
+}
+
+// fixAttributes corrects mode and owner of an up to date file.
+func (executor *SSHExecutor) fixAttributes(file string, current remoteFileState, opts TransferOptions) (bool, error) {
+	var steps []string
+	if opts.Mode != 0 && opts.Mode.Perm() != current.mode.Perm() {
+		steps = append(steps, fmt.Sprintf("chmod %o %s", opts.Mode.Perm(), ShellQuote(file)))
+	}
+	if (opts.Owner != "" && opts.Owner != current.owner) || (opts.Group != "" && opts.Group != current.group) {
+		steps = append(steps, fmt.Sprintf("chown %s %s", ShellQuote(ownerSpec(opts)), ShellQuote(file)))
+	}
+	if len(steps) == 0 {
+		return false, nil
+	}
+	for _, step := range steps {
+		if err := executor.ExecuteCommandWithoutReturn(executor.Become(step)); err != nil {
+			logger.GetLogger().Errorf("Failed to update attributes of %s: %s", file, err.Error())
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (executor *SSHExecutor) validateFile(file, validate string) error {
+	command := strings.ReplaceAll(validate, "%s", ShellQuote(file))
+	result, err := executor.RunCommand(context.Background(), executor.Become(command))
+	if err != nil {
+		output := ""
+		if result != nil {
+			output = strings.TrimSpace(result.Stderr + result.Stdout)
+		}
+		logger.GetLogger().Errorf("Validation of %s failed: %s, %s", file, err.Error(), output)
+		return fmt.Errorf("validation %q failed: %s", validate, output)
+	}
+	return nil
+}
+
+// backupFile copies file keeping its attributes and returns the backup path.
+func (executor *SSHExecutor) backupFile(file, backupDir string) (string, error) {
+	name := fmt.Sprintf("%s.%s.bak", path.Base(file), time.Now().Format("20060102150405"))
+	backup := path.Join(path.Dir(file), name)
+	command := fmt.Sprintf("cp -p %s %s", ShellQuote(file), ShellQuote(backup))
+	if backupDir != "" {
+		backup = path.Join(backupDir, name)
+		command = fmt.Sprintf("mkdir -p %s && cp -p %s %s", ShellQuote(backupDir), ShellQuote(file), ShellQuote(backup))
+	}
+	if err := executor.ExecuteCommandWithoutReturn(executor.Become(command)); err != nil {
+		logger.GetLogger().Errorf("Failed to back up %s: %s", file, err.Error())
+		return "", err
+	}
+	return backup, nil
+}
+
+func (executor *SSHExecutor) renameRemoteFile(from, to string) error {
+	if !executor.NeedsBecome() {
+		client, err := executor.NewSFTPClient()
+		if err == nil {
+			defer client.Close()
+			if err = client.PosixRename(from, to); err == nil {
+				return nil
+			}
+		}
+	}
+	command := fmt.Sprintf("mv -f %s %s", ShellQuote(from), ShellQuote(to))
+	if err := executor.ExecuteCommandWithoutReturn(executor.Become(command)); err != nil {
+		logger.GetLogger().Errorf("Failed to move %s to %s: %s", from, to, err.Error())
+		return err
+	}
+	return nil
+}
+
+func (executor *SSHExecutor) removeRemoteFile(file string) {
+	if !executor.NeedsBecome() {
+		if client, err := executor.NewSFTPClient(); err == nil {
+			defer client.Close()
+			if err := client.Remove(file); err == nil || os.IsNotExist(err) {
+				return
+			}
+		}
+	}
+	if err := executor.ExecuteCommandWithoutReturn(executor.Become(fmt.Sprintf("rm -f %s", ShellQuote(file)))); err != nil {
+		logger.GetLogger().Warnf("Failed to remove %s: %s", file, err.Error())
+	}
+}
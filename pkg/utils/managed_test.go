@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"github.com/whoisfisher/mykubespray/pkg/utils/sshtest"
+	"strings"
+	"testing"
+)
+
+func TestEnsureFileReportsChanges(t *testing.T) {
+	server, executor := newTestExecutor(t)
+	file := ManagedFile{Path: "/etc/app/app.conf", Content: []byte("port = 80\n")}
+
+	result, err := executor.EnsureFile(file)
+	if err != nil || !result.Changed || result.Backup != "" {
+		t.Fatalf("expected a new file, got %+v, %v", result, err)
+	}
+	result, err = executor.EnsureFile(file)
+	if err != nil || result.Changed {
+		t.Fatalf("expected no change, got %+v, %v", result, err)
+	}
+
+	file.Content = []byte("port = 8080\n")
+	file.Backup = true
+	result, err = executor.EnsureFile(file)
+	if err != nil || !result.Changed {
+		t.Fatalf("expected a change, got %+v, %v", result, err)
+	}
+	if data, _ := server.ReadFile("/etc/app/app.conf"); string(data) != "port = 8080\n" {
+		t.Fatalf("unexpected content %q", data)
+	}
+	if data, err := server.ReadFile(result.Backup); err != nil || string(data) != "port = 80\n" {
+		t.Fatalf("unexpected backup %s: %q, %v", result.Backup, data, err)
+	}
+}
+
+func TestEnsureFileKeepsOldVersionWhenValidationFails(t *testing.T) {
+	server, executor := newTestExecutor(t)
+	if err := server.WriteFile("/etc/app/app.conf", []byte("valid\n")); err != nil {
+		t.Fatal(err)
+	}
+	server.HandleFunc(`^check-config '(\S+)'$`, func(exec *sshtest.Exec) int {
+		data, _ := server.ReadFile(exec.Match[1])
+		if strings.Contains(string(data), "invalid") {
+			exec.Stderr.Write([]byte("syntax error on line 1\n"))
+			return 1
+		}
+		return 0
+	})
+
+	_, err := executor.EnsureFile(ManagedFile{Path: "/etc/app/app.conf", Content: []byte("invalid\n"), Validate: "check-config %s"})
+	if err == nil || !strings.Contains(err.Error(), "syntax error") {
+		t.Fatalf("expected a validation error, got %v", err)
+	}
+	if data, _ := server.ReadFile("/etc/app/app.conf"); string(data) != "valid\n" {
+		t.Fatalf("the old file was replaced: %q", data)
+	}
+}
@@ -0,0 +1,252 @@
+package minggorm
+
+import (
+	"container/list"
+	"context"
+	"gorm.io/gorm"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultCacheCapacity = 1024
+	DefaultCacheTTL      = 5 * time.Minute
+)
+
+// Cache stores query results by key, entries are tagged with the tables they were read from
+// so that writes to a table can drop them.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	// Generation counts the invalidations of table, it is read before a query runs.
+	Generation(table string) uint64
+	// Set stores value for the tables of generations, unless one of them was invalidated since,
+	// so a query racing with a write cannot bring stale rows back.
+	Set(key string, value []byte, generations map[string]uint64) bool
+	InvalidateTable(table string)
+	Purge()
+	Stats() CacheStats
+}
+
+// CacheStats counts the lookups of a cache since it was created.
+type CacheStats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	// Stale counts the results dropped by Set because their table was written while they were read.
+	Stale   uint64 `json:"stale"`
+	Entries int    `json:"entries"`
+}
+
+type lruEntry struct {
+	key       string
+	value     []byte
+	tables    []string
+	expiresAt time.Time
+}
+
+// LRUCache is an in-memory Cache that evicts the least recently used entry once it holds capacity entries.
+type LRUCache struct {
+	mutex    sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  map[string]*list.Element
+	order    *list.List
+	tables   map[string]map[string]struct{}
+	// generations survive Purge, a query started before it must not be stored after it
+	generations map[string]uint64
+	stats       CacheStats
+}
+
+// NewLRUCache returns an LRUCache, a capacity of 0 uses DefaultCacheCapacity and a ttl of 0 keeps entries until evicted.
+func NewLRUCache(capacity int, ttl time.Duration) *LRUCache {
+	if capacity <= 0 {
+		capacity = DefaultCacheCapacity
+	}
+	return &LRUCache{
+		capacity:    capacity,
+		ttl:         ttl,
+		entries:     map[string]*list.Element{},
+		order:       list.New(),
+		tables:      map[string]map[string]struct{}{},
+		generations: map[string]uint64{},
+	}
+}
+
+func (cache *LRUCache) Get(key string) ([]byte, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	element, exists := cache.entries[key]
+	if !exists {
+		cache.stats.Misses++
+		return nil, false
+	}
+	entry := element.Value.(*lruEntry)
+	if !entry.expiresAt.IsZero() && time.Now().After(entry.expiresAt) {
+		cache.remove(element)
+		cache.stats.Misses++
+		return nil, false
+	}
+	cache.order.MoveToFront(element)
+	cache.stats.Hits++
+	return entry.value, true
+}
+
+func (cache *LRUCache) Generation(table string) uint64 {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.generations[table]
+}
+
+func (cache *LRUCache) Set(key string, value []byte, generations map[string]uint64) bool {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	tables := make([]string, 0, len(generations))
+	for table, generation := range generations {
+		if cache.generations[table] != generation {
+			cache.stats.Stale++
+			return false
+		}
+		tables = append(tables, table)
+	}
+	if element, exists := cache.entries[key]; exists {
+		cache.remove(element)
+	}
+	entry := &lruEntry{key: key, value: value, tables: tables}
+	if cache.ttl > 0 {
+		entry.expiresAt = time.Now().Add(cache.ttl)
+	}
+	cache.entries[key] = cache.order.PushFront(entry)
+	for _, table := range tables {
+		if cache.tables[table] == nil {
+			cache.tables[table] = map[string]struct{}{}
+		}
+		cache.tables[table][key] = struct{}{}
+	}
+	for cache.order.Len() > cache.capacity {
+		cache.remove(cache.order.Back())
+		cache.stats.Evictions++
+	}
+	return true
+}
+
+// InvalidateTable drops the entries read from table.
+func (cache *LRUCache) InvalidateTable(table string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.generations[table]++
+	for key := range cache.tables[table] {
+		if element, exists := cache.entries[key]; exists {
+			cache.remove(element)
+			cache.stats.Invalidations++
+		}
+	}
+	delete(cache.tables, table)
+}
+
+func (cache *LRUCache) Purge() {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.entries = map[string]*list.Element{}
+	cache.order.Init()
+	cache.tables = map[string]map[string]struct{}{}
+}
+
+func (cache *LRUCache) Stats() CacheStats {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	stats := cache.stats
+	stats.Entries = cache.order.Len()
+	return stats
+}
+
+// remove unlinks element, the caller holds the mutex.
+func (cache *LRUCache) remove(element *list.Element) {
+	entry := cache.order.Remove(element).(*lruEntry)
+	delete(cache.entries, entry.key)
+	for _, table := range entry.tables {
+		if keys, exists := cache.tables[table]; exists {
+			delete(keys, entry.key)
+			if len(keys) == 0 {
+				delete(cache.tables, table)
+			}
+		}
+	}
+}
+
+var (
+	queryCacheMutex sync.RWMutex
+	queryCache      Cache = NewLRUCache(DefaultCacheCapacity, DefaultCacheTTL)
+)
+
+// SetCache replaces the cache used by CacheQuery, such as a shared backend in place of the default LRUCache.
+func SetCache(cache Cache) {
+	queryCacheMutex.Lock()
+	defer queryCacheMutex.Unlock()
+	queryCache = cache
+}
+
+// GetCache returns the cache used by CacheQuery.
+func GetCache() Cache {
+	queryCacheMutex.RLock()
+	defer queryCacheMutex.RUnlock()
+	return queryCache
+}
+
+type pendingTablesKey struct{}
+
+// pendingTables collects the tables written inside Transaction until it commits.
+type pendingTables struct {
+	mutex  sync.Mutex
+	tables map[string]struct{}
+}
+
+// invalidateTables drops the cached queries of the written tables. Inside Transaction this waits for the
+// commit, otherwise a query could cache the rows from before the commit, and a rollback invalidates nothing.
+func invalidateTables(db *gorm.DB, tables ...string) {
+	if db != nil && db.Statement != nil && db.Statement.Context != nil {
+		if pending, ok := db.Statement.Context.Value(pendingTablesKey{}).(*pendingTables); ok {
+			pending.mutex.Lock()
+			for _, table := range tables {
+				pending.tables[table] = struct{}{}
+			}
+			pending.mutex.Unlock()
+			return
+		}
+		if _, inTransaction := db.Statement.ConnPool.(gorm.TxCommitter); inTransaction {
+			log.Printf("Tables %v were written in a transaction not started by Transaction, their cached queries are invalidated before the commit", tables)
+		}
+	}
+	cache := GetCache()
+	for _, table := range tables {
+		if table == "" {
+			log.Printf("Cannot invalidate the cached queries of a write whose table cannot be resolved")
+			continue
+		}
+		cache.InvalidateTable(table)
+	}
+}
+
+// inTransaction runs txFunc in a transaction and invalidates the tables it wrote once it commits,
+// nested calls hand their tables to the outermost one.
+func inTransaction(db *gorm.DB, txFunc func(tx *gorm.DB) error) error {
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if _, nested := ctx.Value(pendingTablesKey{}).(*pendingTables); nested {
+		return db.Transaction(txFunc)
+	}
+	pending := &pendingTables{tables: map[string]struct{}{}}
+	err := db.WithContext(context.WithValue(ctx, pendingTablesKey{}, pending)).Transaction(txFunc)
+	if err != nil {
+		return err
+	}
+	tables := make([]string, 0, len(pending.tables))
+	for table := range pending.tables {
+		tables = append(tables, table)
+	}
+	invalidateTables(db, tables...)
+	return nil
+}
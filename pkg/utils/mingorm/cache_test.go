@@ -0,0 +1,183 @@
+package minggorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLRUCache(t *testing.T) {
+	cache := NewLRUCache(2, 0)
+	cache.Set("a", []byte("1"), map[string]uint64{"rdev_host": 0})
+	cache.Set("b", []byte("2"), map[string]uint64{"rdev_cluster": 0})
+	if value, ok := cache.Get("a"); !ok || string(value) != "1" {
+		t.Fatalf("expected a hit for a, got %q %v", value, ok)
+	}
+	cache.Set("c", []byte("3"), map[string]uint64{"rdev_host": 0})
+	if _, ok := cache.Get("b"); ok {
+		t.Fatal("expected the least recently used entry to be evicted")
+	}
+	cache.InvalidateTable("rdev_host")
+	if _, ok := cache.Get("a"); ok {
+		t.Fatal("expected the entries of rdev_host to be invalidated")
+	}
+	stats := cache.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Evictions != 1 || stats.Invalidations != 2 || stats.Entries != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if cache.Set("d", []byte("4"), map[string]uint64{"rdev_host": 0}) {
+		t.Fatal("expected a result read before the invalidation to be dropped")
+	}
+	if !cache.Set("d", []byte("4"), map[string]uint64{"rdev_host": cache.Generation("rdev_host")}) {
+		t.Fatal("expected a result read after the invalidation to be stored")
+	}
+	if stats := cache.Stats(); stats.Stale != 1 || stats.Entries != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	expiring := NewLRUCache(0, 10*time.Millisecond)
+	expiring.Set("a", []byte("1"), nil)
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := expiring.Get("a"); ok {
+		t.Fatal("expected the entry to expire")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				key := fmt.Sprintf("%d-%d", i, j%5)
+				cache.Set(key, []byte(key), map[string]uint64{"rdev_host": cache.Generation("rdev_host")})
+				cache.Get(key)
+				if j%10 == 0 {
+					cache.InvalidateTable("rdev_host")
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+type cachedHost struct {
+	ID   uint
+	Name string
+}
+
+func (host *cachedHost) GetID() interface{}             { return host.ID }
+func (host *cachedHost) SetID(id interface{})           { host.ID = id.(uint) }
+func (host *cachedHost) TableName() string              { return "rdev_host" }
+func (host *cachedHost) BeforeSave(tx *gorm.DB) error   { return nil }
+func (host *cachedHost) AfterSave(tx *gorm.DB) error    { return nil }
+func (host *cachedHost) BeforeDelete(tx *gorm.DB) error { return nil }
+func (host *cachedHost) AfterDelete(tx *gorm.DB) error  { return nil }
+
+func TestCacheQueryInvalidation(t *testing.T) {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewLRUCache(0, time.Minute)
+	SetCache(cache)
+	t.Cleanup(func() { SetCache(NewLRUCache(DefaultCacheCapacity, DefaultCacheTTL)) })
+
+	var hosts []cachedHost
+	for i := 0; i < 2; i++ {
+		if err := CacheQuery(db, "hosts", NewQueryBuilder(db), &hosts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := cache.Stats(); stats.Hits != 1 || stats.Misses != 1 || stats.Entries != 1 {
+		t.Fatalf("expected the second query to be cached, got %+v", stats)
+	}
+	if err := Create(db, &cachedHost{Name: "master-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if stats := cache.Stats(); stats.Invalidations != 1 || stats.Entries != 0 {
+		t.Fatalf("expected Create to invalidate rdev_host, got %+v", stats)
+	}
+}
+
+// txConnPool lets the dry run dialector begin transactions, committing and rolling back is left to fakeTx.
+type txConnPool struct{}
+
+type fakeTx struct{ txConnPool }
+
+func (pool txConnPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (pool txConnPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (pool txConnPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, errors.New("not supported")
+}
+
+func (pool txConnPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return nil
+}
+
+func (pool *txConnPool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	return &fakeTx{}, nil
+}
+
+func (tx *fakeTx) Commit() error   { return nil }
+func (tx *fakeTx) Rollback() error { return nil }
+
+func TestCacheInvalidationWaitsForCommit(t *testing.T) {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true, ConnPool: &txConnPool{}, DisableNestedTransaction: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewLRUCache(0, time.Minute)
+	SetCache(cache)
+	t.Cleanup(func() { SetCache(NewLRUCache(DefaultCacheCapacity, DefaultCacheTTL)) })
+
+	var hosts []cachedHost
+	if err := CacheQuery(db, "hosts", NewQueryBuilder(db), &hosts); err != nil {
+		t.Fatal(err)
+	}
+	err = Transaction(db, func(tx *gorm.DB) error {
+		if err := Create(tx, &cachedHost{Name: "master-1"}); err != nil {
+			return err
+		}
+		if _, ok := cache.Get("hosts"); !ok {
+			t.Error("expected the cached query to stay until the commit")
+		}
+		return errors.New("rolled back")
+	})
+	if err == nil {
+		t.Fatal("expected the transaction to fail")
+	}
+	if _, ok := cache.Get("hosts"); !ok {
+		t.Fatal("expected a rolled back write to leave the cache alone")
+	}
+	err = Transaction(db, func(tx *gorm.DB) error {
+		return Transaction(tx, func(tx *gorm.DB) error {
+			return Create(tx, &cachedHost{Name: "master-1"})
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("hosts"); ok {
+		t.Fatal("expected the commit to invalidate rdev_host")
+	}
+
+	var rows []map[string]interface{}
+	if err := CacheQuery(db, "rows", NewQueryBuilder(db.Table("rdev_host")), &rows); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("rows"); ok {
+		t.Fatal("expected a query without a table not to be cached")
+	}
+}
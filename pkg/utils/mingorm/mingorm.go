@@ -1,14 +1,11 @@
 package minggorm
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
 	"encoding/json"
 	"errors"
+	"github.com/whoisfisher/mykubespray/pkg/utils/vault"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
-	"io"
 	"log"
 	"time"
 )
@@ -173,6 +170,7 @@ func Create(db *gorm.DB, model HookableModel) error {
 	if err := db.Table(model.TableName()).Create(model).Error; err != nil {
 		return err
 	}
+	invalidateTables(db, model.TableName())
 	return model.AfterSave(db)
 }
 
@@ -189,6 +187,7 @@ func Update(db *gorm.DB, model HookableModel) error {
 	if err := db.Table(model.TableName()).Save(model).Error; err != nil {
 		return err
 	}
+	invalidateTables(db, model.TableName())
 	return model.AfterSave(db)
 }
 
@@ -200,6 +199,7 @@ func UpdateFields(db *gorm.DB, model HookableModel, fields map[string]interface{
 	if err := db.Table(model.TableName()).Model(model).Updates(fields).Error; err != nil {
 		return err
 	}
+	invalidateTables(db, model.TableName())
 	return model.AfterSave(db)
 }
 
@@ -211,6 +211,7 @@ func Delete(db *gorm.DB, model HookableModel) error {
 	if err := db.Table(model.TableName()).Delete(model, model.GetID()).Error; err != nil {
 		return err
 	}
+	invalidateTables(db, model.TableName())
 	return model.AfterDelete(db)
 }
 
@@ -225,6 +226,7 @@ func SoftDelete(db *gorm.DB, model HookableModel) error {
 	if err := db.Table(model.TableName()).Updates(map[string]interface{}{"deleted_at": time.Now()}).Error; err != nil {
 		return err
 	}
+	invalidateTables(db, model.TableName())
 	return model.AfterDelete(db)
 }
 
@@ -251,16 +253,15 @@ func GetCount(db *gorm.DB, model Model, conditions []interface{}) (int64, error)
 	return count, err
 }
 
-// Transaction performs a series of operations within a database transaction
+// Transaction performs a series of operations within a database transaction, the cached queries of the
+// tables written through this package are invalidated once it commits.
 func Transaction(db *gorm.DB, txFunc func(tx *gorm.DB) error) error {
-	return db.Transaction(func(tx *gorm.DB) error {
-		return txFunc(tx)
-	})
+	return inTransaction(db, txFunc)
 }
 
 // RawTransaction executes raw SQL within a transaction
 func RawTransaction(db *gorm.DB, txFunc func(tx *gorm.DB) error, query string, args ...interface{}) error {
-	return db.Transaction(func(tx *gorm.DB) error {
+	return inTransaction(db, func(tx *gorm.DB) error {
 		if err := tx.Exec(query, args...).Error; err != nil {
 			return err
 		}
@@ -270,17 +271,29 @@ func RawTransaction(db *gorm.DB, txFunc func(tx *gorm.DB) error, query string, a
 
 // BatchCreate creates multiple records in one transaction
 func BatchCreate(db *gorm.DB, models interface{}) error {
-	return db.Create(models).Error
+	if err := db.Create(models).Error; err != nil {
+		return err
+	}
+	invalidateTables(db, tableOf(db, models))
+	return nil
 }
 
 // BatchUpdate updates multiple records in one transaction
 func BatchUpdate(db *gorm.DB, models interface{}) error {
-	return db.Save(models).Error
+	if err := db.Save(models).Error; err != nil {
+		return err
+	}
+	invalidateTables(db, tableOf(db, models))
+	return nil
 }
 
 // BatchDelete deletes multiple records by their IDs
 func BatchDelete(db *gorm.DB, model Model, ids []interface{}) error {
-	return db.Table(model.TableName()).Delete(model, ids).Error
+	if err := db.Table(model.TableName()).Delete(model, ids).Error; err != nil {
+		return err
+	}
+	invalidateTables(db, model.TableName())
+	return nil
 }
 
 // Migrate performs automatic database migration
@@ -288,25 +301,55 @@ func Migrate(db *gorm.DB, models ...interface{}) error {
 	return db.AutoMigrate(models...)
 }
 
-// CacheQuery caches query results for improved performance
-func CacheQuery(db *gorm.DB, cacheKey string, builder *QueryBuilder, result interface{}) error {
-	// Replace with actual cache implementation
-	cachedResult, err := getCache(cacheKey)
-	if err == nil {
+// CacheQuery caches query results in GetCache. The entry is dropped when one of tables is written through
+// this package, tables defaults to the table of result, joined tables have to be listed.
+//
+// The generations of the tables are read before the query runs, Set drops the result when a write
+// invalidated one of them meanwhile. A query whose table cannot be resolved is not cached.
+func CacheQuery(db *gorm.DB, cacheKey string, builder *QueryBuilder, result interface{}, tables ...string) error {
+	cache := GetCache()
+	if cachedResult, exists := cache.Get(cacheKey); exists {
 		return json.Unmarshal(cachedResult, result)
 	}
-	err = builder.Execute(result)
-	if err != nil {
+	if len(tables) == 0 {
+		if table := tableOf(db, result); table != "" {
+			tables = []string{table}
+		}
+	}
+	if len(tables) == 0 {
+		log.Printf("Not caching query %s: the table of %T cannot be resolved, pass it to CacheQuery", cacheKey, result)
+		return builder.Execute(result)
+	}
+	generations := make(map[string]uint64, len(tables))
+	for _, table := range tables {
+		generations[table] = cache.Generation(table)
+	}
+	if err := builder.Execute(result); err != nil {
 		return err
 	}
-	cachedResult, err = json.Marshal(result)
+	cachedResult, err := json.Marshal(result)
 	if err != nil {
 		return err
 	}
-	setCache(cacheKey, cachedResult)
+	cache.Set(cacheKey, cachedResult, generations)
 	return nil
 }
 
+// tableOf returns the table of a model or a slice of models, or "" when it cannot be parsed.
+func tableOf(db *gorm.DB, value interface{}) string {
+	if tabler, ok := value.(interface{ TableName() string }); ok {
+		return tabler.TableName()
+	}
+	if db == nil {
+		return ""
+	}
+	statement := &gorm.Statement{DB: db}
+	if err := statement.Parse(value); err != nil || statement.Schema == nil {
+		return ""
+	}
+	return statement.Schema.Table
+}
+
 // SetupLogger configures logging for GORM
 func SetupLogger(db *gorm.DB, level logger.LogLevel) {
 	db.Logger = logger.Default.LogMode(level)
@@ -365,53 +408,34 @@ func RollbackSnapshot(db *gorm.DB, model HookableModel, snapshot map[string]inte
 	if err = db.Table(model.TableName()).Model(model).Updates(updatedModel).Error; err != nil {
 		return err
 	}
+	invalidateTables(db, model.TableName())
 
 	return model.AfterSave(db)
 }
 
-// EncryptData encrypts the data before saving
+// EncryptData encrypts the data before saving with the vault master key
 func EncryptData(data interface{}) ([]byte, error) {
-	key := []byte("examplekey123456") // Replace with secure key
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
+	plain, ok := data.([]byte)
+	if !ok {
+		return nil, errors.New("data must be a byte slice")
 	}
-	gcm, err := cipher.NewGCM(block)
+	encrypted, err := vault.Encrypt(plain)
 	if err != nil {
 		return nil, err
 	}
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		return nil, err
-	}
-	encrypted := gcm.Seal(nonce, nonce, data.([]byte), nil)
-	return encrypted, nil
+	return []byte(encrypted), nil
 }
 
 // DecryptData decrypts the data after retrieving
 func DecryptData(data []byte, result interface{}) error {
-	key := []byte("examplekey123456") // Replace with secure key
-	block, err := aes.NewCipher(key)
-	if err != nil {
-		return err
-	}
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return err
-	}
-	nonceSize := gcm.NonceSize()
-	if len(data) < nonceSize {
-		return errors.New("ciphertext too short")
-	}
-	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
-	plain, err := gcm.Open(nil, nonce, ciphertext, nil)
-	if err != nil {
-		return err
-	}
 	resultData, ok := result.(*[]byte)
 	if !ok {
 		return errors.New("result must be a pointer to a byte slice")
 	}
+	plain, err := vault.Decrypt(string(data))
+	if err != nil {
+		return err
+	}
 	*resultData = plain
 	return nil
 }
@@ -422,7 +446,11 @@ func DataVersionControl(db *gorm.DB, model HookableModel, version int) error {
 	if !db.Migrator().HasColumn(model.TableName(), "version") {
 		return errors.New("model does not have a 'version' column")
 	}
-	return db.Table(model.TableName()).Where("id = ?", model.GetID()).Updates(map[string]interface{}{"version": version}).Error
+	if err := db.Table(model.TableName()).Where("id = ?", model.GetID()).Updates(map[string]interface{}{"version": version}).Error; err != nil {
+		return err
+	}
+	invalidateTables(db, model.TableName())
+	return nil
 }
 
 // SoftDeleteByCondition marks records as deleted based on a condition
@@ -433,6 +461,7 @@ func SoftDeleteByCondition(db *gorm.DB, model HookableModel, condition string) e
 	if err := db.Table(model.TableName()).Where(condition).Updates(map[string]interface{}{"deleted_at": time.Now()}).Error; err != nil {
 		return err
 	}
+	invalidateTables(db, model.TableName())
 	return nil
 }
 
@@ -440,19 +469,3 @@ func SoftDeleteByCondition(db *gorm.DB, model HookableModel, condition string) e
 func RawQuery(db *gorm.DB, query string, result interface{}, args ...interface{}) error {
 	return db.Raw(query, args...).Scan(result).Error
 }
-
-// Example cache functions (Replace with actual cache implementation)
-var cache = make(map[string][]byte)
-
-// getCache retrieves data from the cache
-func getCache(key string) ([]byte, error) {
-	if data, exists := cache[key]; exists {
-		return data, nil
-	}
-	return nil, errors.New("cache miss")
-}
-
-// setCache stores data in the cache
-func setCache(key string, value []byte) {
-	cache[key] = value
-}
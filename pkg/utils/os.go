@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/whoisfisher/mykubespray/pkg/entity"
 	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"strconv"
 	"strings"
 )
 
@@ -34,12 +35,7 @@ func NewClient(host entity.Host) *OSClient {
 		SSExecutor:    *sshExecutor,
 		LocalExecutor: *localExecutor,
 	}
-	osclient.GetOSConf()
-	osclient.GetCPU()
-	osclient.GetCPUCores()
-	osclient.GetMemorySize()
-	osclient.GetDiskSize()
-	osclient.GetNetCardList()
+	osclient.loadFacts()
 	return osclient
 }
 
@@ -49,15 +45,43 @@ func NewOSClient(osConf OSConf, sshExecutor SSHExecutor, localExecutor LocalExec
 		SSExecutor:    sshExecutor,
 		LocalExecutor: localExecutor,
 	}
-	osclient.GetOSConf()
-	osclient.GetCPU()
-	osclient.GetCPUCores()
-	osclient.GetMemorySize()
-	osclient.GetDiskSize()
-	osclient.GetNetCardList()
+	osclient.loadFacts()
 	return osclient
 }
 
+// loadFacts fills OSConf from the cached facts of the host instead of a command per field.
+func (client *OSClient) loadFacts() bool {
+	facts, err := client.SSExecutor.CachedFacts()
+	if err != nil {
+		client.OSConf.Arch, client.OSConf.Name, client.OSConf.Version = "Unknown", "Unknown", "Unknown"
+		client.OSConf.CPU, client.OSConf.CPUCores, client.OSConf.MemorySize, client.OSConf.DiskSize = "Unknown", "Unknown", "Unknown", "Unknown"
+		client.OSConf.NetCardList = []string{"Unknown"}
+		return false
+	}
+	client.OSConf.Name = orUnknown(facts.OS.ID)
+	client.OSConf.Version = orUnknown(facts.OS.Version)
+	client.OSConf.Arch = orUnknown(facts.Arch)
+	client.OSConf.CPU = orUnknown(facts.CPU.Model)
+	client.OSConf.CPUCores = strconv.Itoa(facts.CPU.Threads)
+	client.OSConf.MemorySize = fmt.Sprintf("%dMB", facts.Memory.TotalBytes/1024/1024)
+	client.OSConf.DiskSize = "Unknown"
+	if disk := RootDisk(facts); disk != nil {
+		client.OSConf.DiskSize = HumanSize(disk.SizeBytes)
+	}
+	client.OSConf.NetCardList = make([]string, 0, len(facts.NICs))
+	for _, nic := range facts.NICs {
+		client.OSConf.NetCardList = append(client.OSConf.NetCardList, nic.Name)
+	}
+	return true
+}
+
+func orUnknown(value string) string {
+	if value == "" {
+		return "Unknown"
+	}
+	return value
+}
+
 func (client *OSClient) GetOSConf() bool {
 	command := fmt.Sprintf("cat /etc/os-release")
 	output, err := client.SSExecutor.ExecuteShortCommand(command)
@@ -98,9 +122,7 @@ func (client *OSClient) GetDistribution() (string, error) {
 
 func (client *OSClient) DaemonReload() error {
 	command := fmt.Sprintf("systemctl daemon-reload")
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	_, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to reload daemon: %s", err.Error())
@@ -111,9 +133,7 @@ func (client *OSClient) DaemonReload() error {
 
 func (client *OSClient) RestartService(service string) error {
 	command := fmt.Sprintf("systemctl restart %s", service)
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	_, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to restart %s: %s", service, err.Error())
@@ -124,9 +144,7 @@ func (client *OSClient) RestartService(service string) error {
 
 func (client *OSClient) StartService(service string) error {
 	command := fmt.Sprintf("systemctl start %s", service)
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	_, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to start %s: %s", service, err.Error())
@@ -137,9 +155,7 @@ func (client *OSClient) StartService(service string) error {
 
 func (client *OSClient) StopService(service string) error {
 	command := fmt.Sprintf("systemctl stop %s", service)
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	_, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to stop %s: %s", service, err.Error())
@@ -150,9 +166,7 @@ func (client *OSClient) StopService(service string) error {
 
 func (client *OSClient) DisableService(service string) error {
 	command := fmt.Sprintf("systemctl disable %s", service)
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	_, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to disable %s: %s", service, err.Error())
@@ -163,9 +177,7 @@ func (client *OSClient) DisableService(service string) error {
 
 func (client *OSClient) EnableService(service string) error {
 	command := fmt.Sprintf("systemctl enable %s", service)
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	_, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to enable %s: %s", service, err.Error())
@@ -176,9 +188,7 @@ func (client *OSClient) EnableService(service string) error {
 
 func (client *OSClient) MaskService(service string) error {
 	command := fmt.Sprintf("systemctl mask %s", service)
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	_, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to mask %s: %s", service, err.Error())
@@ -189,9 +199,7 @@ func (client *OSClient) MaskService(service string) error {
 
 func (client *OSClient) UNMaskService(service string) error {
 	command := fmt.Sprintf("systemctl unmask %s", service)
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	_, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to unmask %s: %s", service, err.Error())
@@ -202,9 +210,7 @@ func (client *OSClient) UNMaskService(service string) error {
 
 func (client *OSClient) StatusService(service string) bool {
 	command := fmt.Sprintf("systemctl status %s | grep -iE active", service)
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	res, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to view %s status: %s", service, err.Error())
@@ -218,9 +224,7 @@ func (client *OSClient) StatusService(service string) bool {
 
 func (client *OSClient) GetCPUCores() bool {
 	command := "grep -c ^processor /proc/cpuinfo"
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	res, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to get cpu cores: %s", err.Error())
@@ -234,9 +238,7 @@ func (client *OSClient) GetCPUCores() bool {
 
 func (client *OSClient) GetCPU() bool {
 	command := "grep -iE \"^model\\s+name\\s+:\" /proc/cpuinfo | awk -F':' '{print $NF}' | sort -u"
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	res, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to get cpu info: %s", err.Error())
@@ -250,9 +252,7 @@ func (client *OSClient) GetCPU() bool {
 
 func (client *OSClient) GetAvailableCPU() string {
 	command := "top -bn1 | grep 'Cpu(s)' | awk '{print $8\"%\"}'"
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	res, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to get cpu info: %s", err.Error())
@@ -264,9 +264,7 @@ func (client *OSClient) GetAvailableCPU() string {
 
 func (client *OSClient) GetMemorySize() bool {
 	command := "free -m | grep Mem | awk '{print $2}'"
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	res, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to get memory info: %s", err.Error())
@@ -280,9 +278,7 @@ func (client *OSClient) GetMemorySize() bool {
 
 func (client *OSClient) GetAvailableMemory() string {
 	command := "free -m | grep Mem | awk '{print $4}'"
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	res, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to get memory info: %s", err.Error())
@@ -294,9 +290,7 @@ func (client *OSClient) GetAvailableMemory() string {
 
 func (client *OSClient) GetDiskSize() bool {
 	command := "df -h / | tail -n 1 | awk '{print $2}'"
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	res, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to get disk size: %s", err.Error())
@@ -310,9 +304,7 @@ func (client *OSClient) GetDiskSize() bool {
 
 func (client *OSClient) GetNetCardList() bool {
 	command := "ip addr show | grep -o '^[0-9]\\+: [a-zA-Z0-9]*' | awk '{print $2}'"
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	res, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to get netcard list: %s", err.Error())
@@ -325,9 +317,7 @@ func (client *OSClient) GetNetCardList() bool {
 
 func (client *OSClient) GetSpecifyNetCard(ipaddr string) string {
 	command := fmt.Sprintf("ip addr | grep -B 2 '%s' | head -n 1 | awk -F':' '{print $2}'", ipaddr)
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	res, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to get netcard info for %s: %s", ipaddr, err.Error())
@@ -339,9 +329,7 @@ func (client *OSClient) GetSpecifyNetCard(ipaddr string) string {
 
 func (client *OSClient) IsProcessExist(processName string) bool {
 	command := fmt.Sprintf("pgrep %s", processName)
-	if client.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, client.SSExecutor.Host.Password)
-	}
+	command = client.SSExecutor.Become(command)
 	_, err := client.SSExecutor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Warnf("The process %s is non-exist: %s", processName, err.Error())
@@ -351,20 +339,12 @@ func (client *OSClient) IsProcessExist(processName string) bool {
 }
 
 func (client *OSClient) WhoAmI() string {
-	command := fmt.Sprintf("whoami")
-	user, err := client.SSExecutor.ExecuteShortCommand(command)
-	if err != nil {
-		logger.GetLogger().Warnf("Read username failed: %v", err.Error())
-		return ""
-	}
-	return strings.TrimSpace(user)
+	return client.SSExecutor.WhoAmI()
 }
 
 func (client *OSClient) Chmod(file string, mode string) error {
 	cmd := fmt.Sprintf("chmod %s %s", mode, file)
-	if client.WhoAmI() != "root" {
-		cmd = SudoPrefixWithPassword(cmd, client.SSExecutor.Host.Password)
-	}
+	cmd = client.SSExecutor.Become(cmd)
 	_, err := client.SSExecutor.ExecuteShortCommand(cmd)
 	if err != nil {
 		logger.GetLogger().Errorf("Chmod %s failed: %v", file, err)
@@ -393,12 +373,10 @@ func (client *OSClient) ReadBytes(file string) ([]byte, error) {
 	return data, nil
 }
 
+// WriteFile replaces file with content atomically, keeping the mode and owner of an existing file.
+// A newline is appended like the echo this used to run did.
 func (client *OSClient) WriteFile(content, file string) error {
-	cmd := fmt.Sprintf("bash -c \"echo '%s' > %s\"", content, file)
-	if client.WhoAmI() != "root" {
-		cmd = SudoPrefixWithPassword(cmd, client.SSExecutor.Host.Password)
-	}
-	err := client.SSExecutor.ExecuteCommandWithoutReturn(cmd)
+	_, err := client.SSExecutor.EnsureFile(ManagedFile{Path: file, Content: []byte(content + "\n")})
 	if err != nil {
 		logger.GetLogger().Errorf("Write %s failed: %v", file, err)
 		return err
@@ -409,9 +387,7 @@ func (client *OSClient) WriteFile(content, file string) error {
 func (client *OSClient) QueryVGName() (*entity.LVS, error) {
 	lvs := &entity.LVS{}
 	cmd := "lvs"
-	if client.WhoAmI() != "root" {
-		cmd = SudoPrefixWithPassword(cmd, client.SSExecutor.Host.Password)
-	}
+	cmd = client.SSExecutor.Become(cmd)
 	data, err := client.SSExecutor.ExecuteShortCommand(cmd)
 	if err != nil {
 		logger.GetLogger().Errorf("Query VGName failed: %v", err)
@@ -431,9 +407,7 @@ func (client *OSClient) QueryVGName() (*entity.LVS, error) {
 
 func (client *OSClient) CreatePV(diskConf entity.DiskConf) error {
 	cmd := fmt.Sprintf("pvcreate %s", diskConf.Device)
-	if client.WhoAmI() != "root" {
-		cmd = SudoPrefixWithPassword(cmd, client.SSExecutor.Host.Password)
-	}
+	cmd = client.SSExecutor.Become(cmd)
 	data, err := client.SSExecutor.ExecuteShortCommand(cmd)
 	if err != nil {
 		logger.GetLogger().Errorf("pvcreate failed: %v,%s", err, data)
@@ -445,9 +419,7 @@ func (client *OSClient) CreatePV(diskConf entity.DiskConf) error {
 
 func (client *OSClient) ExtendVG(diskConf entity.DiskConf) error {
 	cmd := fmt.Sprintf("vgextend %s %s", diskConf.VGName, diskConf.Device)
-	if client.WhoAmI() != "root" {
-		cmd = SudoPrefixWithPassword(cmd, client.SSExecutor.Host.Password)
-	}
+	cmd = client.SSExecutor.Become(cmd)
 	data, err := client.SSExecutor.ExecuteShortCommand(cmd)
 	if err != nil {
 		logger.GetLogger().Errorf("vgextend failed: %v,%s", err, data)
@@ -458,9 +430,7 @@ func (client *OSClient) ExtendVG(diskConf entity.DiskConf) error {
 
 func (client *OSClient) ExtendLVPercent100(diskConf entity.DiskConf) error {
 	cmd := fmt.Sprintf("lvextend -l +100%%FREE /dev/mapper/%s-%s", diskConf.VGName, diskConf.LVName)
-	if client.WhoAmI() != "root" {
-		cmd = SudoPrefixWithPassword(cmd, client.SSExecutor.Host.Password)
-	}
+	cmd = client.SSExecutor.Become(cmd)
 	data, err := client.SSExecutor.ExecuteShortCommand(cmd)
 	if err != nil {
 		logger.GetLogger().Errorf("lvextend failed: %v,%s", err, data)
@@ -471,9 +441,7 @@ func (client *OSClient) ExtendLVPercent100(diskConf entity.DiskConf) error {
 
 func (client *OSClient) ExtendLV(diskConf entity.DiskConf) error {
 	cmd := fmt.Sprintf("lvextend -L +%s /dev/mapper/%s-%s", diskConf.Size, diskConf.VGName, diskConf.LVName)
-	if client.WhoAmI() != "root" {
-		cmd = SudoPrefixWithPassword(cmd, client.SSExecutor.Host.Password)
-	}
+	cmd = client.SSExecutor.Become(cmd)
 	data, err := client.SSExecutor.ExecuteShortCommand(cmd)
 	if err != nil {
 		logger.GetLogger().Errorf("lvextend failed: %v,%s", err, data)
@@ -484,9 +452,7 @@ func (client *OSClient) ExtendLV(diskConf entity.DiskConf) error {
 
 func (client *OSClient) XGrowFS(diskConf entity.DiskConf) error {
 	cmd := fmt.Sprintf("xfs_growfs /dev/mapper/%s-%s", diskConf.VGName, diskConf.LVName)
-	if client.WhoAmI() != "root" {
-		cmd = SudoPrefixWithPassword(cmd, client.SSExecutor.Host.Password)
-	}
+	cmd = client.SSExecutor.Become(cmd)
 	data, err := client.SSExecutor.ExecuteShortCommand(cmd)
 	if err != nil {
 		logger.GetLogger().Errorf("xfs_growfs failed: %v,%s", err, data)
@@ -497,9 +463,7 @@ func (client *OSClient) XGrowFS(diskConf entity.DiskConf) error {
 
 func (client *OSClient) Resize2FS(diskConf entity.DiskConf) error {
 	cmd := fmt.Sprintf("resize2fs /dev/mapper/%s-%s", diskConf.VGName, diskConf.LVName)
-	if client.WhoAmI() != "root" {
-		cmd = SudoPrefixWithPassword(cmd, client.SSExecutor.Host.Password)
-	}
+	cmd = client.SSExecutor.Become(cmd)
 	data, err := client.SSExecutor.ExecuteShortCommand(cmd)
 	if err != nil {
 		logger.GetLogger().Errorf("xfs_growfs failed: %v,%s", err, data)
@@ -513,6 +477,11 @@ func (client *OSClient) CopyFile(srcFile, destFile string) error {
 	return client.SSExecutor.CopyFile(srcFile, destFile, outputHandler)
 }
 
+func (client *OSClient) CopyFileWithOptions(srcFile, destFile string, opts TransferOptions) error {
+	outputHandler := func(string) { logger.GetLogger().Infof("Copy file") }
+	return client.SSExecutor.CopyFileWithOptions(srcFile, destFile, opts, outputHandler)
+}
+
 func (client *OSClient) CopyMultiFile(files []entity.FileSrcDest) *CopyResult {
 	outputHandler := func(string) { logger.GetLogger().Infof("Copy file") }
 	return client.SSExecutor.CopyMultiFile(files, outputHandler)
@@ -535,7 +504,3 @@ func SudoPrefixWithEOF(cmd string) string {
 func SudoPrefix(cmd string) string {
 	return fmt.Sprintf("sudo %s", cmd)
 }
-
-func SudoPrefixWithPassword(cmd, sudoPassword string) string {
-	return fmt.Sprintf("echo %s | sudo -S %s", sudoPassword, SudoPrefix(cmd))
-}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"testing"
+)
+
+func TestNewOSClientCollectsFacts(t *testing.T) {
+	_, client := newTestOSClient(t)
+	conf := client.OSConf
+	if conf.Name != "ubuntu" || conf.Version != "22.04" || conf.Arch != "x86_64" {
+		t.Fatalf("unexpected os facts %+v", conf)
+	}
+	if conf.CPUCores != "8" || conf.CPU != "Intel(R) Xeon(R)" || conf.MemorySize != "15884MB" || conf.DiskSize != "100G" {
+		t.Fatalf("unexpected hardware facts %+v", conf)
+	}
+	if len(conf.NetCardList) != 2 || conf.NetCardList[1] != "eth0" {
+		t.Fatalf("unexpected net cards %q", conf.NetCardList)
+	}
+}
+
+func TestOSClientServiceStatus(t *testing.T) {
+	server, client := newTestOSClient(t)
+	server.Handle(`^systemctl status haproxy `, "   Active: active (running)\n", 0)
+	server.Handle(`^systemctl status keepalived `, "   Active: inactive (dead)\n", 0)
+	if !client.StatusService("haproxy") {
+		t.Fatalf("expected haproxy to be active")
+	}
+	if client.StatusService("keepalived") {
+		t.Fatalf("expected keepalived to be inactive")
+	}
+}
+
+func TestOSClientWriteAndReadFile(t *testing.T) {
+	server, client := newTestOSClient(t)
+	content := "# it's \"quoted\"\nnameserver 10.0.0.2"
+	if err := client.WriteFile(content, "/etc/resolv.conf"); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+	if data, err := server.ReadFile("/etc/resolv.conf"); err != nil || string(data) != content+"\n" {
+		t.Fatalf("unexpected content %q, %v", data, err)
+	}
+	read, err := client.ReadFile("/etc/resolv.conf")
+	if err != nil || read != "# it's \"quoted\"\nnameserver 10.0.0.2" {
+		t.Fatalf("unexpected content %q, %v", read, err)
+	}
+}
@@ -0,0 +1,127 @@
+package utils
+
+import (
+	"fmt"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// ParseBatchSize turns a batch size such as "5" or "10%" into a host count, an empty size means all hosts.
+func ParseBatchSize(size string, total int) (int, error) {
+	size = strings.TrimSpace(size)
+	if size == "" || total == 0 {
+		return total, nil
+	}
+	if strings.HasSuffix(size, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(size, "%"), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return 0, fmt.Errorf("invalid batch size %q", size)
+		}
+		count := int(float64(total) * percent / 100)
+		if float64(count) < float64(total)*percent/100 {
+			count++
+		}
+		return count, nil
+	}
+	count, err := strconv.Atoi(size)
+	if err != nil || count <= 0 {
+		return 0, fmt.Errorf("invalid batch size %q", size)
+	}
+	if count > total {
+		count = total
+	}
+	return count, nil
+}
+
+// ValidateParallelStrategy checks a strategy from a request body.
+func ValidateParallelStrategy(strategy entity.ParallelStrategy) error {
+	if strategy.Concurrency < 0 {
+		return fmt.Errorf("invalid concurrency %d", strategy.Concurrency)
+	}
+	if strategy.MaxFailPercentage < 0 || strategy.MaxFailPercentage > 100 {
+		return fmt.Errorf("invalid max fail percentage %d", strategy.MaxFailPercentage)
+	}
+	_, err := ParseBatchSize(strategy.BatchSize, 1)
+	return err
+}
+
+// RunParallel runs task on hosts batch by batch following strategy, the results keep the order of hosts.
+// Hosts left out after an abort are reported as skipped.
+func RunParallel(operation string, hosts []entity.Host, strategy entity.ParallelStrategy, task func(host entity.Host) MachineResult) *CopyResult {
+	results := make([]MachineResult, len(hosts))
+	batchSize, err := ParseBatchSize(strategy.BatchSize, len(hosts))
+	if err != nil {
+		logger.GetLogger().Errorf("Ignoring batch size for %s: %s", operation, err.Error())
+		batchSize = len(hosts)
+	}
+	var failed int32
+	var stopped atomic.Bool
+	for start := 0; start < len(hosts); start += batchSize {
+		end := start + batchSize
+		if end > len(hosts) {
+			end = len(hosts)
+		}
+		if !stopped.Load() {
+			runBatch(hosts, results, start, end, strategy, task, &failed, &stopped)
+		}
+		if stopped.Load() {
+			continue
+		}
+		if strategy.MaxFailPercentage > 0 && int(atomic.LoadInt32(&failed))*100 > strategy.MaxFailPercentage*len(hosts) {
+			logger.GetLogger().Errorf("Aborting %s, %d of %d hosts failed", operation, failed, len(hosts))
+			stopped.Store(true)
+		}
+	}
+
+	copyResult := &CopyResult{OverallSuccess: true, Aborted: stopped.Load()}
+	for i, result := range results {
+		if result.Machine == "" {
+			result = MachineResult{Machine: hosts[i].Address, Skipped: true, Error: fmt.Sprintf("Skipped %s on %s after earlier failures", operation, hosts[i].Address)}
+		}
+		if result.Success {
+			logger.GetLogger().Infof("Successfully %s on %s\n", operation, result.Machine)
+		} else {
+			logger.GetLogger().Errorf("Failed to %s on %s: %s\n", operation, result.Machine, result.Error)
+			copyResult.OverallSuccess = false
+		}
+		copyResult.Results = append(copyResult.Results, result)
+	}
+	return copyResult
+}
+
+func runBatch(hosts []entity.Host, results []MachineResult, start, end int, strategy entity.ParallelStrategy, task func(host entity.Host) MachineResult, failed *int32, stopped *atomic.Bool) {
+	concurrency := strategy.Concurrency
+	if concurrency <= 0 || concurrency > end-start {
+		concurrency = end - start
+	}
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := start; i < end; i++ {
+		slots <- struct{}{}
+		if stopped.Load() {
+			<-slots
+			break
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-slots }()
+			result := task(hosts[i])
+			if result.Machine == "" {
+				result.Machine = hosts[i].Address
+			}
+			results[i] = result
+			if !result.Success {
+				atomic.AddInt32(failed, 1)
+				if strategy.StopOnFirstFailure {
+					stopped.Store(true)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+)
+
+func testHosts(count int) []entity.Host {
+	hosts := make([]entity.Host, count)
+	for i := range hosts {
+		hosts[i] = entity.Host{Address: fmt.Sprintf("10.0.0.%d", i+1)}
+	}
+	return hosts
+}
+
+func TestParseBatchSize(t *testing.T) {
+	cases := []struct {
+		size  string
+		total int
+		want  int
+	}{
+		{"", 7, 7},
+		{"3", 7, 3},
+		{"30", 7, 7},
+		{"10%", 25, 3},
+		{"100%", 4, 4},
+	}
+	for _, c := range cases {
+		got, err := ParseBatchSize(c.size, c.total)
+		if err != nil || got != c.want {
+			t.Fatalf("ParseBatchSize(%q, %d) = %d, %v, want %d", c.size, c.total, got, err, c.want)
+		}
+	}
+	if _, err := ParseBatchSize("abc", 3); err == nil {
+		t.Fatalf("expected invalid batch size to fail")
+	}
+}
+
+func TestRunParallelMaxFailPercentage(t *testing.T) {
+	hosts := testHosts(10)
+	var calls int32
+	strategy := entity.ParallelStrategy{BatchSize: "2", MaxFailPercentage: 10}
+	result := RunParallel("test", hosts, strategy, func(host entity.Host) MachineResult {
+		atomic.AddInt32(&calls, 1)
+		return MachineResult{Success: host.Address != "10.0.0.1" && host.Address != "10.0.0.2"}
+	})
+	if calls != 2 {
+		t.Fatalf("expected only the first batch to run, got %d calls", calls)
+	}
+	if result.OverallSuccess || !result.Aborted {
+		t.Fatalf("expected aborted failed result, got %+v", result)
+	}
+	if len(result.Results) != 10 || !result.Results[9].Skipped || result.Results[9].Machine != "10.0.0.10" {
+		t.Fatalf("expected remaining hosts to be skipped, got %+v", result.Results)
+	}
+}
+
+func TestRunParallelStopOnFirstFailure(t *testing.T) {
+	hosts := testHosts(5)
+	strategy := entity.ParallelStrategy{Concurrency: 1, StopOnFirstFailure: true}
+	result := RunParallel("test", hosts, strategy, func(host entity.Host) MachineResult {
+		return MachineResult{Success: host.Address != "10.0.0.2"}
+	})
+	if !result.Results[0].Success || result.Results[1].Success || result.Results[1].Skipped {
+		t.Fatalf("expected first host to succeed and second to fail, got %+v", result.Results)
+	}
+	for _, machine := range result.Results[2:] {
+		if !machine.Skipped {
+			t.Fatalf("expected hosts after the failure to be skipped, got %+v", machine)
+		}
+	}
+}
+
+func TestRunParallelConcurrency(t *testing.T) {
+	var running, peak int32
+	result := RunParallel("test", testHosts(8), entity.ParallelStrategy{Concurrency: 2}, func(host entity.Host) MachineResult {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if current <= old || atomic.CompareAndSwapInt32(&peak, old, current) {
+				break
+			}
+		}
+		atomic.AddInt32(&running, -1)
+		return MachineResult{Success: true}
+	})
+	if !result.OverallSuccess || peak > 2 {
+		t.Fatalf("expected success with at most 2 concurrent hosts, got %v with peak %d", result.OverallSuccess, peak)
+	}
+}
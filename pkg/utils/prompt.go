@@ -0,0 +1,189 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// looksLikePrompt matches output that stopped mid line waiting for an answer, such as "Continue? [yes/no]: ".
+var looksLikePrompt = regexp.MustCompile(`(?i)([:?>\]#$]|\(y/n\)|\[y/n\])\s*$`)
+
+// ErrPromptTimeout is returned when a command waits for input no rule answers.
+var ErrPromptTimeout = errors.New("command is waiting for input")
+
+// PromptRule answers a prompt of a streamed command.
+type PromptRule struct {
+	Pattern  *regexp.Regexp
+	Response string
+	// MaxCount is how often the rule may fire, 0 means once.
+	MaxCount int
+	// Secret keeps the response out of the logs.
+	Secret bool
+}
+
+// NewPromptRule compiles pattern into a rule.
+func NewPromptRule(pattern, response string, maxCount int, secret bool) (PromptRule, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return PromptRule{}, fmt.Errorf("invalid prompt pattern %q: %w", pattern, err)
+	}
+	return PromptRule{Pattern: re, Response: response, MaxCount: maxCount, Secret: secret}, nil
+}
+
+// MustPromptRule is like NewPromptRule but panics on an invalid pattern, it is meant for package level rules.
+func MustPromptRule(pattern, response string, maxCount int, secret bool) PromptRule {
+	rule, err := NewPromptRule(pattern, response, maxCount, secret)
+	if err != nil {
+		panic(err)
+	}
+	return rule
+}
+
+// PackageInstallPrompts confirm apt and yum transactions and GPG key imports.
+var PackageInstallPrompts = []PromptRule{
+	MustPromptRule(`Do you want to continue\? \[Y/n\]`, "Y", 1, false),
+	MustPromptRule(`Is this ok \[y/N\]`, "y", 3, false),
+}
+
+// defaultPromptTimeout applies to commands that declare their prompts when ssh.prompt_timeout is not set.
+const defaultPromptTimeout = time.Minute
+
+type promptTimeoutKey struct{}
+
+// WithPromptTimeout makes a streamed command run with ctx fail once it waits timeout at a prompt no rule answers.
+// A quiet command whose last line ends like a prompt is failed too, so only set it for commands that are expected
+// to finish their lines, 0 never fails a command.
+func WithPromptTimeout(ctx context.Context, timeout time.Duration) context.Context {
+	return context.WithValue(ctx, promptTimeoutKey{}, timeout)
+}
+
+// withDeclaredPrompts sets ssh.prompt_timeout on ctx for a command that declares the prompts it expects,
+// unless the caller already set a timeout with WithPromptTimeout.
+func withDeclaredPrompts(ctx context.Context) context.Context {
+	if _, ok := ctx.Value(promptTimeoutKey{}).(time.Duration); ok {
+		return ctx
+	}
+	timeout := defaultPromptTimeout
+	if viper.IsSet("ssh.prompt_timeout") {
+		timeout = viper.GetDuration("ssh.prompt_timeout")
+	}
+	return WithPromptTimeout(ctx, timeout)
+}
+
+// promptTimeout returns the timeout set by WithPromptTimeout, without one a command is never failed at a prompt.
+func promptTimeout(ctx context.Context) time.Duration {
+	timeout, _ := ctx.Value(promptTimeoutKey{}).(time.Duration)
+	return timeout
+}
+
+// promptWatcher answers prompts on stdin and reports output that waits for input no rule matches.
+type promptWatcher struct {
+	mutex     sync.Mutex
+	rules     []PromptRule
+	counts    []int
+	stdin     io.Writer
+	timeout   time.Duration
+	onTimeout func(prompt string)
+	address   string
+}
+
+func newPromptWatcher(rules []PromptRule, stdin io.Writer, timeout time.Duration, onTimeout func(prompt string)) *promptWatcher {
+	return &promptWatcher{rules: rules, counts: make([]int, len(rules)), stdin: stdin, timeout: timeout, onTimeout: onTimeout}
+}
+
+// answer writes the response of the first rule matching text that has not used up its count.
+func (watcher *promptWatcher) answer(text string) bool {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	for i, rule := range watcher.rules {
+		limit := rule.MaxCount
+		if limit <= 0 {
+			limit = 1
+		}
+		if watcher.counts[i] >= limit || !rule.Pattern.MatchString(text) {
+			continue
+		}
+		watcher.counts[i]++
+		response := rule.Response
+		if rule.Secret {
+			response = "******"
+		}
+		logger.GetLogger().Infof("Answering prompt %q on %s with %q", strings.TrimSpace(text), watcher.address, response)
+		if _, err := fmt.Fprintln(watcher.stdin, rule.Response); err != nil {
+			logger.GetLogger().Errorf("Failed to answer prompt on %s: %s", watcher.address, err.Error())
+		}
+		return true
+	}
+	return false
+}
+
+// Write writes to stdin without interleaving with answers.
+func (watcher *promptWatcher) Write(p []byte) (int, error) {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	return watcher.stdin.Write(p)
+}
+
+// stream reads output line by line into emit, answering prompts as they appear even without a trailing newline.
+func (watcher *promptWatcher) stream(reader io.Reader, emit func(line string)) error {
+	var pending string
+	answered := false
+	var timer *time.Timer
+	stopTimer := func() {
+		if timer != nil {
+			timer.Stop()
+			timer = nil
+		}
+	}
+	defer stopTimer()
+	buffer := make([]byte, 4096)
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			stopTimer()
+			pending += string(buffer[:n])
+			for {
+				index := strings.IndexByte(pending, '\n')
+				if index < 0 {
+					break
+				}
+				line := strings.TrimRight(pending[:index], "\r")
+				pending = pending[index+1:]
+				if !answered {
+					watcher.answer(line)
+				}
+				answered = false
+				emit(line)
+			}
+			if len(pending) > maxPromptBuffer {
+				// long output without newlines, such as progress bars, is not a prompt.
+				emit(strings.TrimRight(pending, "\r"))
+				pending, answered = "", false
+			}
+			if pending != "" && !answered {
+				answered = watcher.answer(pending)
+				if !answered && watcher.timeout > 0 && looksLikePrompt.MatchString(pending) {
+					prompt := strings.TrimSpace(pending)
+					timer = time.AfterFunc(watcher.timeout, func() { watcher.onTimeout(prompt) })
+				}
+			}
+		}
+		if err != nil {
+			if pending != "" {
+				emit(strings.TrimRight(pending, "\r"))
+			}
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
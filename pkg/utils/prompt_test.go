@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/utils/sshtest"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPromptWatcherAnswersRulesUpToMaxCount(t *testing.T) {
+	var stdin bytes.Buffer
+	rules := []PromptRule{MustPromptRule(`\[yes/no\]`, "yes", 1, false)}
+	watcher := newPromptWatcher(rules, &stdin, 0, nil)
+	reader, writer := io.Pipe()
+	done := make(chan []string)
+	go func() {
+		var lines []string
+		watcher.stream(reader, func(line string) { lines = append(lines, line) })
+		done <- lines
+	}()
+	writer.Write([]byte("Continue this installation? [yes/no]: "))
+	writer.Write([]byte("\nagain? [yes/no]: \n"))
+	writer.Close()
+	lines := <-done
+	if stdin.String() != "yes\n" {
+		t.Fatalf("expected a single answer, got %q", stdin.String())
+	}
+	if len(lines) != 2 || lines[0] != "Continue this installation? [yes/no]: " {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestPromptWatcherTimesOutOnUnmatchedPrompt(t *testing.T) {
+	prompts := make(chan string, 1)
+	watcher := newPromptWatcher(nil, io.Discard, 10*time.Millisecond, func(prompt string) { prompts <- prompt })
+	reader, writer := io.Pipe()
+	go watcher.stream(reader, func(string) {})
+	defer writer.Close()
+	writer.Write([]byte("Enter passphrase: "))
+	select {
+	case prompt := <-prompts:
+		if prompt != "Enter passphrase:" {
+			t.Fatalf("unexpected prompt %q", prompt)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected the unmatched prompt to time out")
+	}
+}
+
+func TestDeclaredPromptsTimeOut(t *testing.T) {
+	server, osClient := newTestOSClient(t)
+	viper.Set("ssh.prompt_timeout", 100*time.Millisecond)
+	t.Cleanup(func() { viper.Set("ssh.prompt_timeout", nil) })
+	// both commands stop writing after output that ends like a prompt.
+	for _, pattern := range []string{`^kk delete cluster -f \S+ --yes$`, `^progress$`} {
+		server.HandleFunc(pattern, func(exec *sshtest.Exec) int {
+			fmt.Fprint(exec.Stdout, "Enter passphrase for key '/root/.ssh/id_rsa': ")
+			if exec.Command == "progress" {
+				time.Sleep(300 * time.Millisecond)
+				return 0
+			}
+			// the prompt waits for stdin until the command is stopped.
+			<-exec.Signaled
+			return 143
+		})
+	}
+	logChan := make(chan LogEntry)
+	go func() {
+		for range logChan {
+		}
+	}()
+	defer close(logChan)
+
+	client := NewKubekeyClient(entity.KubekeyConf{ClusterName: "prod", KKPath: "/opt/kk/kk"}, *osClient)
+	// the deadline only keeps a regression from hanging the test.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	err := client.DeleteCluster(ctx, logChan)
+	if !errors.Is(err, ErrPromptTimeout) || !strings.Contains(err.Error(), "Enter passphrase") {
+		t.Fatalf("expected kk to stop at the unanswered prompt, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= sessionKillGrace {
+		t.Fatalf("expected kk to stop after the prompt timeout, took %s", elapsed)
+	}
+
+	if err := osClient.SSExecutor.ExecuteCommandContext(context.Background(), "progress", logChan); err != nil {
+		t.Fatalf("expected a command without declared prompts not to be failed, got %v", err)
+	}
+}
@@ -1,15 +1,20 @@
 package utils
 
 import (
-	"bufio"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/whoisfisher/mykubespray/pkg/entity"
 	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"golang.org/x/crypto/ssh"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
 // SSHExecutor implements Executor for SSH connections.
@@ -31,46 +36,94 @@ func NewSSHExecutor(connection SSHConnection) *SSHExecutor {
 	return &SSHExecutor{Connection: connection}
 }
 
-// ExecuteCommand executes a command over SSH.
+// sessionKillGrace is how long a cancelled command gets to exit after SIGTERM before its session is closed,
+// a variable so tests can shorten it.
+var sessionKillGrace = 5 * time.Second
 
-func (executor *SSHExecutor) ExecuteShortCommand(command string) (string, error) {
-	session, err := executor.Connection.Client.NewSession()
-	if err != nil {
-		logger.GetLogger().Errorf("Failed to create SSH session: %s", err.Error())
-		return "", err
+// WithCommandTimeout derives a context for a single command, a timeout of zero means no deadline.
+func WithCommandTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
-	defer session.Close()
-	res, err := session.CombinedOutput(command)
+	return context.WithTimeout(ctx, timeout)
+}
+
+// watchSession signals and closes session once ctx is done, the returned func stops watching.
+func watchSession(ctx context.Context, session *ssh.Session) func() {
+	grace := sessionKillGrace
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			if err := session.Signal(ssh.SIGTERM); err != nil {
+				logger.GetLogger().Warnf("Failed to signal remote command: %s", err.Error())
+			}
+			select {
+			case <-done:
+			case <-time.After(grace):
+			}
+			session.Close()
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// ExecuteShortCommand executes a command over SSH and returns its combined output.
+func (executor *SSHExecutor) ExecuteShortCommand(command string) (string, error) {
+	return executor.ExecuteShortCommandContext(context.Background(), command)
+}
+
+// ExecuteShortCommandContext is like ExecuteShortCommand but stops the remote command when ctx is done.
+func (executor *SSHExecutor) ExecuteShortCommandContext(ctx context.Context, command string) (string, error) {
+	var output lockedBuffer
+	err := executor.runSession(ctx, command, &output, &output)
 	if err != nil {
-		logger.GetLogger().Errorf("Failed to execute command: %s, %s", err.Error(), res)
+		logger.GetLogger().Errorf("Failed to execute command: %s, %s", err.Error(), output.Bytes())
 		return "", err
 	}
-	return string(res), nil
+	return string(output.Bytes()), nil
+}
+
+// RunCommand executes command and collects its exit code, stdout and stderr separately.
+// A non-zero exit code is returned as an error together with the result.
+func (executor *SSHExecutor) RunCommand(ctx context.Context, command string) (*CommandResult, error) {
+	result := &CommandResult{Host: executor.Host.Address, Command: command, ExitCode: -1}
+	var stdout, stderr bytes.Buffer
+	result.StartTime = time.Now()
+	err := executor.runSession(ctx, command, &stdout, &stderr)
+	result.EndTime = time.Now()
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		result.ExitCode = 0
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+	}
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to execute command: %s, %s", err.Error(), result.Stderr)
+		return result, err
+	}
+	return result, nil
 }
 
 func (executor *SSHExecutor) ExecuteShortCMD(command string) ([]byte, error) {
-	session, err := executor.Connection.Client.NewSession()
-	if err != nil {
-		logger.GetLogger().Errorf("Failed to create SSH session: %s", err.Error())
-		return nil, err
-	}
-	defer session.Close()
-	res, err := session.CombinedOutput(command)
+	var output lockedBuffer
+	err := executor.runSession(context.Background(), command, &output, &output)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to execute command: %s", err.Error())
 		return nil, err
 	}
-	return res, nil
+	return output.Bytes(), nil
 }
 
 func (executor *SSHExecutor) ExecuteCommandWithoutReturn(command string) error {
-	session, err := executor.Connection.Client.NewSession()
-	if err != nil {
-		logger.GetLogger().Errorf("Failed to create SSH session: %s", err.Error())
-		return err
-	}
-	defer session.Close()
-	err = session.Run(command)
+	err := executor.runSession(context.Background(), command, nil, nil)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to execute command: %s", err.Error())
 		return err
@@ -79,13 +132,7 @@ func (executor *SSHExecutor) ExecuteCommandWithoutReturn(command string) error {
 }
 
 func (executor *SSHExecutor) ExecuteCMDWithoutReturn(command string, outputHandler func(string)) error {
-	session, err := executor.Connection.Client.NewSession()
-	if err != nil {
-		logger.GetLogger().Errorf("Failed to create SSH session: %s", err.Error())
-		return err
-	}
-	defer session.Close()
-	err = session.Run(command)
+	err := executor.runSession(context.Background(), command, nil, nil)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to execute command: %s", err.Error())
 		return err
@@ -94,202 +141,132 @@ func (executor *SSHExecutor) ExecuteCMDWithoutReturn(command string, outputHandl
 	return nil
 }
 
-func (executor *SSHExecutor) WhoAmI() string {
-	command := fmt.Sprintf("whoami")
-	user, err := executor.ExecuteShortCommand(command)
-	if err != nil {
-		logger.GetLogger().Warnf("Read username failed: %v", err.Error())
-		return ""
-	}
-	return strings.TrimSpace(user)
-}
-
 func (executor *SSHExecutor) ExecuteCommand(command string, logChan chan LogEntry) error {
-	session, err := executor.Connection.Client.NewSession()
-	if err != nil {
-		logger.GetLogger().Errorf("Failed to create SSH session: %s", err.Error())
-		return err
-	}
-	defer session.Close()
-	//session.RequestPty("xterm", 80, 40, ssh.TerminalModes{})
-
-	stdin, err := session.StdinPipe()
-	if err != nil {
-		logger.GetLogger().Errorf("Unable to setup stdin for session: %v", err)
-		return err
-	}
+	return executor.ExecuteCommandContext(context.Background(), command, logChan)
+}
 
-	stdoutPipe, err := session.StdoutPipe()
-	if err != nil {
-		logger.GetLogger().Errorf("Unable to create stdout pipe: %v", err.Error())
-		return err
-	}
-	stderrPipe, err := session.StderrPipe()
-	if err != nil {
-		logger.GetLogger().Errorf("Failed to create stderr pipe: %s", err.Error())
-		return err
-	}
+// ExecuteCommandContext streams the output of command to logChan and stops the remote command when ctx is done.
+// Prompts the command shows are answered by prompts, a prompt none of them matches only fails the command when
+// ctx carries a timeout set with WithPromptTimeout, callers declaring prompts set ssh.prompt_timeout.
+func (executor *SSHExecutor) ExecuteCommandContext(ctx context.Context, command string, logChan chan LogEntry, prompts ...PromptRule) error {
+	err := executor.streamCommand(ctx, command, logChan, prompts)
+	return finishPipeline(ctx, command, logChan, err, "Pipeline Done", "Pipeline Done")
+}
 
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				logger.GetLogger().Errorf("Recovered from panic in stderr pipe: %v", r)
-			}
-		}()
-		scanner := bufio.NewScanner(stdoutPipe)
-		for scanner.Scan() {
-			go fmt.Fprintln(stdin, "yes\n")
-			text := scanner.Text()
-			if strings.Contains(text, "[yes/no]") {
-				continue
-			} else {
-				logChan <- LogEntry{Message: text, IsError: false}
-			}
-		}
-	}()
+func (executor *SSHExecutor) ExecuteCommandNew(command string, logChan chan LogEntry) error {
+	return executor.ExecuteCommandNewContext(context.Background(), command, logChan)
+}
 
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				logger.GetLogger().Errorf("Recovered from panic in stderr pipe: %v", r)
-			}
-		}()
-		scanner := bufio.NewScanner(stderrPipe)
-		for scanner.Scan() {
-			go fmt.Fprintln(stdin, "yes\n")
-			text := scanner.Text()
-			if strings.Contains(text, "[yes/no]") {
-				continue
-			} else {
-				logChan <- LogEntry{Message: text, IsError: false}
-			}
-		}
-	}()
+// ExecuteCommandNewContext is like ExecuteCommandNew but stops the remote command when ctx is done.
+func (executor *SSHExecutor) ExecuteCommandNewContext(ctx context.Context, command string, logChan chan LogEntry, prompts ...PromptRule) error {
+	err := executor.streamCommand(ctx, command, logChan, prompts)
+	return finishPipeline(ctx, command, logChan, err, "Pipeline Failed", "Pipeline Success")
+}
 
-	err = session.Start(command)
-	if err != nil {
-		logChan <- LogEntry{Message: "Pipeline Done", IsError: true}
-		logger.GetLogger().Errorf("Failed to run SSH command: %s", err.Error())
+// finishPipeline sends the final status of a streamed command to logChan.
+func finishPipeline(ctx context.Context, command string, logChan chan LogEntry, err error, failed, success string) error {
+	switch {
+	case errors.Is(err, ErrPromptTimeout):
+		logger.GetLogger().Errorf("SSH command stuck at a prompt: %s", err.Error())
+		logChan <- LogEntry{Message: err.Error(), IsError: true}
+		logChan <- LogEntry{Message: failed, IsError: true}
 		return err
-	}
-
-	err = session.Wait()
-	if err != nil {
+	case ctx.Err() != nil:
+		logger.GetLogger().Errorf("SSH command cancelled: %s", ctx.Err().Error())
+		logChan <- LogEntry{Message: "Pipeline Cancelled", IsError: true}
+		return fmt.Errorf("command %q cancelled: %w", command, ctx.Err())
+	case err != nil:
 		logger.GetLogger().Errorf("SSH command execution failed: %s", err.Error())
-		logChan <- LogEntry{Message: "Pipeline Done", IsError: true}
+		logChan <- LogEntry{Message: failed, IsError: true}
 		return err
 	}
-	logChan <- LogEntry{Message: "Pipeline Done", IsError: false}
+	logChan <- LogEntry{Message: success, IsError: false}
 	return nil
 }
 
-func (executor *SSHExecutor) ExecuteCommandNew(command string, logChan chan LogEntry) error {
-	session, err := executor.Connection.Client.NewSession()
+// streamCommand runs command sending stdout and stderr lines to logChan, prompts are answered on stdin.
+func (executor *SSHExecutor) streamCommand(ctx context.Context, command string, logChan chan LogEntry, prompts []PromptRule) (err error) {
+	audit := executor.startAudit(AuditKindStream, command, 0, 0)
+	defer func() { audit.finish(err) }()
+	if strings.HasPrefix(command, becomeSuPrefix) {
+		logger.GetLogger().Errorf("Cannot stream a command on %s: %s", executor.Host.Address, ErrSuStreaming.Error())
+		return fmt.Errorf("%w: %s", ErrSuStreaming, executor.Host.Address)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	session, release, err := executor.newSession(ctx)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to create SSH session: %s", err.Error())
-		logChan <- LogEntry{Message: "Pipeline Failed", IsError: true}
 		return err
 	}
+	defer release()
 	defer session.Close()
-	//session.RequestPty("xterm", 80, 40, ssh.TerminalModes{})
 
 	stdin, err := session.StdinPipe()
 	if err != nil {
 		logger.GetLogger().Errorf("Unable to setup stdin for session: %v", err)
-		logChan <- LogEntry{Message: "Pipeline Failed", IsError: true}
 		return err
 	}
-
 	stdoutPipe, err := session.StdoutPipe()
 	if err != nil {
 		logger.GetLogger().Errorf("Unable to create stdout pipe: %v", err.Error())
-		logChan <- LogEntry{Message: "Pipeline Failed", IsError: true}
 		return err
 	}
 	stderrPipe, err := session.StderrPipe()
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to create stderr pipe: %s", err.Error())
-		logChan <- LogEntry{Message: "Pipeline Failed", IsError: true}
 		return err
 	}
 
-	var wg sync.WaitGroup
-
-	doneStdout := make(chan struct{})
-	doneStderr := make(chan struct{})
-
-	wg.Add(1)
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				logger.GetLogger().Errorf("Recovered from panic in stdout pipe: %v", r)
-			}
-			wg.Done()
-		}()
-		defer close(doneStdout)
-		scanner := bufio.NewScanner(stdoutPipe)
-		for scanner.Scan() {
-			go fmt.Fprintln(stdin, "yes\n")
-			text := scanner.Text()
-			if strings.Contains(text, "[yes/no]") {
-				continue
-			} else {
-				select {
-				case logChan <- LogEntry{Message: text, IsError: false}:
-				case <-doneStdout:
-					return
-				}
-			}
-		}
-		if err := scanner.Err(); err != nil {
-			logger.GetLogger().Errorf("Error reading stdout pipe: %v", err)
+	var promptMutex sync.Mutex
+	var promptErr error
+	watcher := newPromptWatcher(prompts, stdin, promptTimeout(ctx), func(prompt string) {
+		promptMutex.Lock()
+		if promptErr == nil {
+			promptErr = fmt.Errorf("%w: no answer for %q on %s", ErrPromptTimeout, prompt, executor.Host.Address)
 		}
-	}()
+		promptMutex.Unlock()
+		cancel()
+	})
+	watcher.address = executor.Host.Address
 
-	wg.Add(1)
-	go func() {
-		defer func() {
-			if r := recover(); r != nil {
-				logger.GetLogger().Errorf("Recovered from panic in stderr pipe: %v", r)
-			}
-			wg.Done()
-		}()
-		defer close(doneStderr)
-		scanner := bufio.NewScanner(stderrPipe)
-		for scanner.Scan() {
-			go fmt.Fprintln(stdin, "yes\n")
-			text := scanner.Text()
-			if strings.Contains(text, "[yes/no]") {
-				continue
-			} else {
-				select {
-				case logChan <- LogEntry{Message: text, IsError: true}:
-				case <-doneStderr:
-					return
-				}
+	var wg sync.WaitGroup
+	for _, output := range []struct {
+		reader  io.Reader
+		isError bool
+	}{{stdoutPipe, false}, {stderrPipe, true}} {
+		wg.Add(1)
+		go func(reader io.Reader, isError bool) {
+			defer wg.Done()
+			// the digest covers the output as the host sent it, not the lines split from it.
+			err := watcher.stream(io.TeeReader(reader, audit), func(line string) {
+				logChan <- LogEntry{Message: line, IsError: isError}
+			})
+			if err != nil {
+				logger.GetLogger().Errorf("Error reading command output: %v", err)
 			}
-		}
-		if err := scanner.Err(); err != nil {
-			logger.GetLogger().Errorf("Error reading stderr pipe: %v", err)
-		}
-	}()
+		}(output.reader, output.isError)
+	}
 
 	err = session.Start(command)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to run SSH command: %s", err.Error())
-		logChan <- LogEntry{Message: "Pipeline Failed", IsError: true}
+		session.Close()
+		wg.Wait()
 		return err
 	}
+	executor.writeBecomePassword(watcher, command)
 
+	stop := watchSession(ctx, session)
 	err = session.Wait()
-	if err != nil {
-		logger.GetLogger().Errorf("SSH command execution failed: %s", err.Error())
-		logChan <- LogEntry{Message: "Pipeline Failed", IsError: true}
-		return err
-	}
-	logChan <- LogEntry{Message: "Pipeline Success", IsError: false}
-	close(logChan)
-	return nil
+	stop()
+	wg.Wait()
+	promptMutex.Lock()
+	defer promptMutex.Unlock()
+	if promptErr != nil {
+		return promptErr
+	}
+	return err
 }
 
 func (executor *SSHExecutor) CopyMultiFile(files []entity.FileSrcDest, outputHandler func(string)) *CopyResult {
@@ -299,41 +276,18 @@ func (executor *SSHExecutor) CopyMultiFile(files []entity.FileSrcDest, outputHan
 		wg.Add(1)
 		go func(file entity.FileSrcDest) {
 			defer wg.Done()
-			byteData, err := os.ReadFile(file.SrcFile)
+			opts, err := NewTransferOptions(file.Mode, file.Owner, file.Group)
 			if err != nil {
-				logger.GetLogger().Errorf("Failed to open source file: %s", err.Error())
-				results <- MachineResult{Machine: "", Success: false, Error: fmt.Sprintf("Failed to open source file: %s", err.Error())}
+				results <- MachineResult{Machine: "", Success: false, Error: err.Error()}
 				return
 			}
-
-			fileName := filepath.Base(file.DestFile)
-			tempFile := fmt.Sprintf("/tmp/%s", fileName)
-
-			touchCommand := fmt.Sprintf("echo '%s' > %s", string(byteData), tempFile)
-			err = executor.ExecuteCommandWithoutReturn(touchCommand)
-			if err != nil {
-				logger.GetLogger().Errorf("Failed to copy file to destination: %s", err.Error())
-				return
-			}
-
-			command := fmt.Sprintf("cp -f %s %s", tempFile, file.DestFile)
-			if executor.WhoAmI() != "root" {
-				command = SudoPrefixWithPassword(command, executor.Host.Password)
-			}
-			err = executor.ExecuteCommandWithoutReturn(command)
+			err = executor.UploadFile(file.SrcFile, file.DestFile, opts)
 			if err != nil {
 				logger.GetLogger().Errorf("Failed to copy file to destination: %s", err.Error())
 				results <- MachineResult{Machine: "", Success: false, Error: fmt.Sprintf("Failed to copy file to destination: %s", err.Error())}
 				return
 			}
 
-			rmCommand := fmt.Sprintf("rm -f %s", tempFile)
-			err = executor.ExecuteCommandWithoutReturn(rmCommand)
-			if err != nil {
-				logger.GetLogger().Errorf("Failed to delete temp file: %s", err.Error())
-				return
-			}
-
 			results <- MachineResult{Machine: "", Success: true, Error: ""}
 			outputHandler(fmt.Sprintf("Copied file %s to %s", file.SrcFile, file.DestFile))
 			return
@@ -365,41 +319,18 @@ func (executor *SSHExecutor) CopyMultiFile(files []entity.FileSrcDest, outputHan
 	return &copyResult
 }
 
-// CopyFile copies a file over SSH using SCP.
+// CopyFile copies a file over SFTP keeping the mode of the source file.
 func (executor *SSHExecutor) CopyFile(srcFile, destFile string, outputHandler func(string)) error {
-	byteData, err := os.ReadFile(srcFile)
-	if err != nil {
-		logger.GetLogger().Errorf("Failed to open source file: %s", err.Error())
-		return err
-	}
-
-	fileName := filepath.Base(destFile)
-	tempFile := fmt.Sprintf("/tmp/%s", fileName)
-
-	touchCommand := fmt.Sprintf("echo '%s' > %s", string(byteData), tempFile)
-	err = executor.ExecuteCommandWithoutReturn(touchCommand)
-	if err != nil {
-		logger.GetLogger().Errorf("Failed to copy file to destination: %s", err.Error())
-		return err
-	}
+	return executor.CopyFileWithOptions(srcFile, destFile, TransferOptions{}, outputHandler)
+}
 
-	command := fmt.Sprintf("cp -f %s %s", tempFile, destFile)
-	if executor.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, executor.Host.Password)
-	}
-	err = executor.ExecuteCommandWithoutReturn(command)
+// CopyFileWithOptions copies a file over SFTP with the given mode, owner and progress callback.
+func (executor *SSHExecutor) CopyFileWithOptions(srcFile, destFile string, opts TransferOptions, outputHandler func(string)) error {
+	err := executor.UploadFile(srcFile, destFile, opts)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to copy file to destination: %s", err.Error())
 		return err
 	}
-
-	rmCommand := fmt.Sprintf("rm -f %s", tempFile)
-	err = executor.ExecuteCommandWithoutReturn(rmCommand)
-	if err != nil {
-		logger.GetLogger().Errorf("Failed to delete temp file: %s", err.Error())
-		return err
-	}
-
 	outputHandler(fmt.Sprintf("Copied file %s to %s", srcFile, destFile))
 	return nil
 }
@@ -407,13 +338,11 @@ func (executor *SSHExecutor) CopyFile(srcFile, destFile string, outputHandler fu
 func (executor *SSHExecutor) MkDirALL(path string, outputHandler func(string)) error {
 	path = filepath.ToSlash(path)
 	command := fmt.Sprintf("mkdir -p %s", path)
-	if executor.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, executor.Host.Password)
-	}
+	command = executor.Become(command)
 	err := executor.ExecuteCommandWithoutReturn(command)
 	if err != nil {
 		errMsg := fmt.Sprintf("Failed to create directory '%s' on remote host: %s", path, err)
-		log.Println("%s: %s", errMsg, err.Error())
+		log.Printf("%s: %s", errMsg, err.Error())
 		return err
 	}
 	_ = fmt.Sprintf("Directory '%s' created successfully on remote host\n", path)
@@ -426,21 +355,19 @@ func (executor *SSHExecutor) AddHosts(record entity.Record, outputHandler func(s
 	hostContent, err := executor.ExecuteShortCommand(getHostContentCMD)
 	if err != nil {
 		errMsg := fmt.Errorf("failed to read /etc/hosts: %w", err)
-		log.Println("%s: %s", errMsg, err.Error())
+		log.Printf("%s: %s", errMsg, err.Error())
 		return err
 	}
 	if strings.TrimSpace(hostContent) != "" {
 		cmdUpdate := fmt.Sprintf(`
 		#!/bin/bash
         # Remove all lines containing the hostname
-        sudo sed -i "/^.* %s$/d" /etc/hosts
+        sed -i "/^.* %s$/d" /etc/hosts
         # Add new entry
-        echo "%s %s" | sudo tee -a /etc/hosts > /dev/null
+        echo "%s %s" | tee -a /etc/hosts > /dev/null
     `, record.Domain, record.IP, record.Domain)
 		cmdUpdate = fmt.Sprintf("bash -c '%s'", cmdUpdate)
-		if executor.WhoAmI() != "root" {
-			cmdUpdate = SudoPrefixWithPassword(cmdUpdate, executor.Host.Password)
-		}
+		cmdUpdate = executor.Become(cmdUpdate)
 		_, err = executor.ExecuteShortCommand(cmdUpdate)
 		if err != nil {
 			logger.GetLogger().Errorf("failed to update /etc/hosts: %v", err)
@@ -450,9 +377,7 @@ func (executor *SSHExecutor) AddHosts(record entity.Record, outputHandler func(s
 		fmt.Printf("Updated %s to IP %s\n", record.Domain, record.IP)
 	} else {
 		cmdAdd := fmt.Sprintf(`bash -c 'echo "%s %s" >> /etc/hosts'`, record.IP, record.Domain)
-		if executor.WhoAmI() != "root" {
-			cmdAdd = SudoPrefixWithPassword(cmdAdd, executor.Host.Password)
-		}
+		cmdAdd = executor.Become(cmdAdd)
 		_, err = executor.ExecuteShortCommand(cmdAdd)
 		if err != nil {
 			logger.GetLogger().Errorf("failed to add to /etc/hosts: %v", err)
@@ -470,7 +395,7 @@ func (executor *SSHExecutor) AddMultiHosts(records []entity.Record, outputHandle
 	hostContent, err := executor.ExecuteShortCommand(getHostContentCMD)
 	if err != nil {
 		errMsg := fmt.Errorf("failed to read /etc/hosts: %w", err)
-		log.Println("%s: %s", errMsg, err.Error())
+		log.Printf("%s: %s", errMsg, err.Error())
 		return err
 	}
 	lines := strings.Split(hostContent, "\n")
@@ -496,15 +421,11 @@ func (executor *SSHExecutor) AddMultiHosts(records []entity.Record, outputHandle
 	tmpFile := "/tmp/hosts"
 	err = os.WriteFile(tmpFile, []byte(updateContent.String()), 0644)
 	if err != nil {
-		logger.GetLogger().Error("Failed to write "+
-			""+
-			" temporary file: %s", err)
+		logger.GetLogger().Errorf("Failed to write temporary file: %s", err)
 		return fmt.Errorf("Failed to write to temporary file: %s", err)
 	}
 	cmd := fmt.Sprintf("cp %s /etc/hosts", tmpFile)
-	if executor.WhoAmI() != "root" {
-		cmd = SudoPrefixWithPassword(cmd, executor.Host.Password)
-	}
+	cmd = executor.Become(cmd)
 	_, err = executor.ExecuteShortCommand(cmd)
 	if err != nil {
 		logger.GetLogger().Errorf("failed to add to /etc/hosts: %v", err)
@@ -518,7 +439,7 @@ func (executor *SSHExecutor) UpdateHostsFile(ip string, domain string) error {
 	getHostContentCMD := "cat /etc/hosts"
 	hostContent, err := executor.ExecuteShortCommand(getHostContentCMD)
 	if err != nil {
-		logger.GetLogger().Errorf("读取 /etc/hosts 出错: %v\", err")
+		logger.GetLogger().Errorf("读取 /etc/hosts 出错: %v", err)
 		return fmt.Errorf("读取 /etc/hosts 出错: %v", err)
 	}
 	lines := strings.Split(hostContent, "\n")
@@ -542,10 +463,8 @@ func (executor *SSHExecutor) UpdateHostsFile(ip string, domain string) error {
 	}
 
 	// 写入更新后的内容
-	command := fmt.Sprintf("bash -c \"echo -n '%s' | sudo tee /etc/hosts\"", strings.Join(updatedLines, "\n"))
-	if executor.WhoAmI() != "root" {
-		command = SudoPrefixWithPassword(command, executor.Host.Password)
-	}
+	command := fmt.Sprintf("bash -c \"echo -n '%s' | tee /etc/hosts\"", strings.Join(updatedLines, "\n"))
+	command = executor.Become(command)
 	_, err = executor.ExecuteShortCommand(command)
 	if err != nil {
 		logger.GetLogger().Errorf("写入 /etc/hosts 出错: %v", err)
@@ -573,10 +492,8 @@ func (executor *SSHExecutor) UpdateResolvFile(ip string) error {
 	}
 
 	if !ipExists {
-		command := fmt.Sprintf("bash -c \" echo -n 'nameserver %s\n' | sudo tee -a /etc/resolv.conf\"", ip)
-		if executor.WhoAmI() != "root" {
-			command = SudoPrefixWithPassword(command, executor.Host.Password)
-		}
+		command := fmt.Sprintf("bash -c \" echo -n 'nameserver %s\n' | tee -a /etc/resolv.conf\"", ip)
+		command = executor.Become(command)
 		_, err = executor.ExecuteShortCommand(command)
 		if err != nil {
 			logger.GetLogger().Errorf("追加到 /etc/resolv.conf 出错: %v", err)
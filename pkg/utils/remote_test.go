@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/whoisfisher/mykubespray/pkg/utils/sshtest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunCommandSplitsOutputAndExitCode(t *testing.T) {
+	server, executor := newTestExecutor(t)
+	server.HandleFunc(`^check$`, func(exec *sshtest.Exec) int {
+		fmt.Fprint(exec.Stdout, "out")
+		fmt.Fprint(exec.Stderr, "err")
+		return 3
+	})
+	result, err := executor.RunCommand(context.Background(), "check")
+	if err == nil {
+		t.Fatalf("expected an error for a non-zero exit code")
+	}
+	if result.ExitCode != 3 || result.Stdout != "out" || result.Stderr != "err" {
+		t.Fatalf("unexpected result %+v", result)
+	}
+}
+
+func TestExecuteCommandContextAnswersPrompts(t *testing.T) {
+	server, executor := newTestExecutor(t)
+	server.HandleFunc(`^install$`, func(exec *sshtest.Exec) int {
+		fmt.Fprint(exec.Stdout, "Continue? [yes/no]: ")
+		answer, _ := bufio.NewReader(exec.Stdin).ReadString('\n')
+		fmt.Fprintf(exec.Stdout, "\ngot %s", answer)
+		return 0
+	})
+	logChan := make(chan LogEntry, 16)
+	prompts := []PromptRule{MustPromptRule(`\[yes/no\]`, "yes", 1, false)}
+	if err := executor.ExecuteCommandContext(context.Background(), "install", logChan, prompts...); err != nil {
+		t.Fatalf("expected command to succeed, got %v", err)
+	}
+	close(logChan)
+	var lines []string
+	for entry := range logChan {
+		lines = append(lines, entry.Message)
+	}
+	if !strings.Contains(strings.Join(lines, "\n"), "got yes") {
+		t.Fatalf("expected the prompt to be answered, got %q", lines)
+	}
+}
+
+func TestCommandStopsAtDeadline(t *testing.T) {
+	server, host := newTestServer(t)
+	// hang ignores signals like a process stuck in the kernel, its session is closed after the grace.
+	stuck := make(chan struct{})
+	t.Cleanup(func() { close(stuck) })
+	server.HandleFunc(`^hang$`, func(exec *sshtest.Exec) int {
+		<-stuck
+		return 0
+	})
+	grace := sessionKillGrace
+	sessionKillGrace = 200 * time.Millisecond
+	t.Cleanup(func() { sessionKillGrace = grace })
+	pool := NewSSHExecutorPool()
+	defer pool.Close()
+	executor, err := pool.GetSSHExecutor(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := 100 * time.Millisecond
+	for _, command := range []string{"sleep 30", "hang"} {
+		ctx, cancel := WithCommandTimeout(context.Background(), deadline)
+		start := time.Now()
+		_, err := executor.ExecuteShortCommandContext(ctx, command)
+		elapsed := time.Since(start)
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected %q to stop at the deadline, got %v", command, err)
+		}
+		if elapsed > deadline+sessionKillGrace+time.Second {
+			t.Fatalf("expected %q to stop within the deadline and the kill grace, took %s", command, elapsed)
+		}
+		if command == "sleep 30" && elapsed >= deadline+sessionKillGrace {
+			t.Fatalf("expected %q to exit on SIGTERM before the kill grace, took %s", command, elapsed)
+		}
+		if stats := pool.Stats(); stats.ActiveSessions != 0 {
+			t.Fatalf("expected the session of %q to be closed, got %+v", command, stats)
+		}
+	}
+	if signals := server.Signals(); strings.Join(signals, ",") != "TERM,TERM" {
+		t.Fatalf("expected each command to get SIGTERM, got %q", signals)
+	}
+	if output, err := executor.ExecuteShortCommand("whoami"); err != nil || strings.TrimSpace(output) != "root" {
+		t.Fatalf("expected the connection to stay usable, got %q, %v", output, err)
+	}
+}
+
+func TestUploadAndFetchFile(t *testing.T) {
+	server, executor := newTestExecutor(t)
+	local := filepath.Join(t.TempDir(), "admin.conf")
+	if err := os.WriteFile(local, []byte("apiVersion: v1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := executor.UploadFile(local, "/etc/kubernetes/admin.conf", TransferOptions{}); err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	data, err := server.ReadFile("/etc/kubernetes/admin.conf")
+	if err != nil || string(data) != "apiVersion: v1\n" {
+		t.Fatalf("unexpected remote content %q, %v", data, err)
+	}
+
+	fetched := filepath.Join(t.TempDir(), "fetched.conf")
+	if err := executor.FetchFile("/etc/kubernetes/admin.conf", fetched); err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	if data, _ := os.ReadFile(fetched); string(data) != "apiVersion: v1\n" {
+		t.Fatalf("unexpected fetched content %q", data)
+	}
+}
@@ -0,0 +1,257 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/sftp"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const defaultTransferChunkSize = 256 * 1024
+
+// TransferOptions controls how a file is placed on the remote host.
+type TransferOptions struct {
+	Mode      os.FileMode
+	Owner     string
+	Group     string
+	ChunkSize int
+	Progress  func(written, total int64)
+}
+
+// ParseFileMode parses an octal mode such as "0644", an empty string yields 0.
+func ParseFileMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid file mode %q: %w", mode, err)
+	}
+	return os.FileMode(value), nil
+}
+
+// NewTransferOptions builds TransferOptions from the string mode, owner and group of a request.
+func NewTransferOptions(mode, owner, group string) (TransferOptions, error) {
+	fileMode, err := ParseFileMode(mode)
+	if err != nil {
+		return TransferOptions{}, err
+	}
+	return TransferOptions{Mode: fileMode, Owner: owner, Group: group}, nil
+}
+
+type progressWriter struct {
+	writer   io.Writer
+	written  int64
+	total    int64
+	progress func(written, total int64)
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.writer.Write(p)
+	pw.written += int64(n)
+	if pw.progress != nil {
+		pw.progress(pw.written, pw.total)
+	}
+	return n, err
+}
+
+func randomSuffix() string {
+	buf := make([]byte, 6)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.Itoa(os.Getpid())
+	}
+	return hex.EncodeToString(buf)
+}
+
+// NewSFTPClient opens an SFTP subsystem on the executor's connection, redialing once when the transport is broken.
+func (executor *SSHExecutor) NewSFTPClient() (*sftp.Client, error) {
+	conn := executor.Connection.currentClient()
+	client, err := sftp.NewClient(conn, sftp.UseConcurrentWrites(true))
+	if err != nil && executor.Connection.canRedial() {
+		logger.GetLogger().Warnf("SFTP to %s failed, redialing: %s", executor.Host.Address, err.Error())
+		if conn, err = executor.Connection.reconnect(conn); err == nil {
+			client, err = sftp.NewClient(conn, sftp.UseConcurrentWrites(true))
+		}
+	}
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to create SFTP client: %s", err.Error())
+		return nil, err
+	}
+	return client, nil
+}
+
+// RemoteSHA256 returns the SHA-256 checksum of a remote file.
+func (executor *SSHExecutor) RemoteSHA256(file string) (string, error) {
+	output, err := executor.ExecuteShortCommand(fmt.Sprintf("sha256sum %s", ShellQuote(file)))
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(output)
+	if len(fields) == 0 {
+		return "", fmt.Errorf("unexpected sha256sum output for %s: %q", file, output)
+	}
+	return fields[0], nil
+}
+
+// UploadFile streams a local file to destFile on the remote host.
+func (executor *SSHExecutor) UploadFile(srcFile, destFile string, opts TransferOptions) error {
+	src, err := os.Open(srcFile)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to open source file: %s", err.Error())
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to stat source file: %s", err.Error())
+		return err
+	}
+	if opts.Mode == 0 {
+		opts.Mode = info.Mode().Perm()
+	}
+	return executor.Upload(src, info.Size(), destFile, opts)
+}
+
+// Upload streams reader to a temp file over SFTP, verifies its SHA-256 and atomically renames it to destFile.
+// Users that need privilege escalation upload to /tmp and move the file into place as the become user.
+func (executor *SSHExecutor) Upload(reader io.Reader, size int64, destFile string, opts TransferOptions) error {
+	client, err := executor.NewSFTPClient()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	destFile = path.Clean(destFile)
+	destDir := path.Dir(destFile)
+	tempName := fmt.Sprintf(".%s.%s.tmp", path.Base(destFile), randomSuffix())
+	isRoot := !executor.NeedsBecome()
+	tempFile := path.Join(destDir, tempName)
+	if isRoot {
+		if err := client.MkdirAll(destDir); err != nil {
+			logger.GetLogger().Errorf("Failed to create directory %s: %s", destDir, err.Error())
+			return err
+		}
+	} else {
+		tempFile = path.Join("/tmp", tempName)
+	}
+
+	checksum, err := executor.writeRemoteFile(client, reader, size, tempFile, opts)
+	if err != nil {
+		client.Remove(tempFile)
+		return err
+	}
+	remoteChecksum, err := executor.RemoteSHA256(tempFile)
+	if err != nil {
+		client.Remove(tempFile)
+		logger.GetLogger().Errorf("Failed to checksum %s: %s", tempFile, err.Error())
+		return err
+	}
+	if remoteChecksum != checksum {
+		client.Remove(tempFile)
+		return fmt.Errorf("checksum mismatch after uploading %s: local %s, remote %s", destFile, checksum, remoteChecksum)
+	}
+
+	if isRoot {
+		err = executor.installAsRoot(client, tempFile, destFile, opts)
+	} else {
+		err = executor.installWithSudo(tempFile, destFile, opts)
+	}
+	if err != nil {
+		client.Remove(tempFile)
+		return err
+	}
+	return nil
+}
+
+func (executor *SSHExecutor) writeRemoteFile(client *sftp.Client, reader io.Reader, size int64, tempFile string, opts TransferOptions) (string, error) {
+	file, err := client.Create(tempFile)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to create remote file %s: %s", tempFile, err.Error())
+		return "", err
+	}
+	defer file.Close()
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = defaultTransferChunkSize
+	}
+	hash := sha256.New()
+	writer := &progressWriter{writer: file, total: size, progress: opts.Progress}
+	_, err = io.CopyBuffer(writer, io.TeeReader(reader, hash), make([]byte, chunkSize))
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to upload %s: %s", tempFile, err.Error())
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		logger.GetLogger().Errorf("Failed to close remote file %s: %s", tempFile, err.Error())
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func ownerSpec(opts TransferOptions) string {
+	if opts.Owner == "" && opts.Group == "" {
+		return ""
+	}
+	if opts.Group == "" {
+		return opts.Owner
+	}
+	return fmt.Sprintf("%s:%s", opts.Owner, opts.Group)
+}
+
+func (executor *SSHExecutor) installAsRoot(client *sftp.Client, tempFile, destFile string, opts TransferOptions) error {
+	if opts.Mode != 0 {
+		if err := client.Chmod(tempFile, opts.Mode); err != nil {
+			logger.GetLogger().Errorf("Failed to chmod %s: %s", tempFile, err.Error())
+			return err
+		}
+	}
+	if owner := ownerSpec(opts); owner != "" {
+		command := fmt.Sprintf("chown %s %s", ShellQuote(owner), ShellQuote(tempFile))
+		if err := executor.ExecuteCommandWithoutReturn(command); err != nil {
+			logger.GetLogger().Errorf("Failed to chown %s: %s", tempFile, err.Error())
+			return err
+		}
+	}
+	if err := client.PosixRename(tempFile, destFile); err != nil {
+		command := fmt.Sprintf("mv -f %s %s", ShellQuote(tempFile), ShellQuote(destFile))
+		if err := executor.ExecuteCommandWithoutReturn(command); err != nil {
+			logger.GetLogger().Errorf("Failed to move %s to %s: %s", tempFile, destFile, err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
+func (executor *SSHExecutor) installWithSudo(tempFile, destFile string, opts TransferOptions) error {
+	destDir := path.Dir(destFile)
+	stagedFile := path.Join(destDir, path.Base(tempFile))
+	steps := []string{
+		fmt.Sprintf("mkdir -p %s", ShellQuote(destDir)),
+		fmt.Sprintf("mv -f %s %s", ShellQuote(tempFile), ShellQuote(stagedFile)),
+	}
+	if opts.Mode != 0 {
+		steps = append(steps, fmt.Sprintf("chmod %o %s", opts.Mode, ShellQuote(stagedFile)))
+	}
+	owner := ownerSpec(opts)
+	if owner == "" {
+		owner = executor.becomeUser()
+	}
+	steps = append(steps,
+		fmt.Sprintf("chown %s %s", ShellQuote(owner), ShellQuote(stagedFile)),
+		fmt.Sprintf("mv -f %s %s", ShellQuote(stagedFile), ShellQuote(destFile)),
+	)
+	script := fmt.Sprintf("%s || { rm -f %s; exit 1; }", strings.Join(steps, " && "), ShellQuote(stagedFile))
+	command := executor.Become(script)
+	if err := executor.ExecuteCommandWithoutReturn(command); err != nil {
+		logger.GetLogger().Errorf("Failed to move %s to %s: %s", tempFile, destFile, err.Error())
+		return err
+	}
+	return nil
+}
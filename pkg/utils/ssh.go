@@ -1,11 +1,18 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/spf13/viper"
 	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
 	"golang.org/x/crypto/ssh"
 	"log"
-	"os"
+	"net"
+	"strconv"
+	"sync"
+	"time"
 )
 
 type SSHConfig struct {
@@ -13,97 +20,356 @@ type SSHConfig struct {
 	Port        int32
 	User        string
 	PrivateKey  string
+	Passphrase  string
+	Certificate string
+	UseAgent    bool
+	AgentSocket string
 	Password    string
 	AuthMethods []ssh.AuthMethod
+	ProxyJump   []entity.Host
 }
 
 // SSHConnection manages an SSH connection.
 type SSHConnection struct {
 	Client *ssh.Client
+	// Jumps holds the bastion connections dialed for this connection only, pooled bastions are not listed.
+	Jumps []*ssh.Client
+	state *connectionState
 }
 
-func NewConnection(host entity.Host) (*SSHConnection, error) {
-	sshConfig := &ssh.ClientConfig{
-		User:            host.User,
-		Auth:            host.AuthMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+const defaultMaxSessions = 8
+
+// connectionState is shared by every copy of an SSHConnection, it tracks the live transport and cached remote facts.
+type connectionState struct {
+	mutex    sync.Mutex
+	client   *ssh.Client
+	redial   func() (*ssh.Client, error)
+	sessions chan struct{}
+	active   int
+	forwards int
+	lastUsed time.Time
+	redials  int
+	healthy  bool
+	closed   bool
+	// evicted is set once the pool closed the transport as idle, the next use redials it
+	evicted bool
+	// redialing is closed once the redial in flight ends
+	redialing chan struct{}
+
+	becomeMutex sync.Mutex
+	user        string
+	nopasswd    map[string]bool
+}
+
+func newConnectionState(client *ssh.Client) *connectionState {
+	maxSessions := viper.GetInt("ssh.max_sessions")
+	if maxSessions <= 0 {
+		maxSessions = defaultMaxSessions
 	}
-	if host.Password != "" {
-		sshConfig.Auth = append(sshConfig.Auth, ssh.Password(host.Password))
+	return &connectionState{
+		client:   client,
+		sessions: make(chan struct{}, maxSessions),
+		lastUsed: time.Now(),
+		healthy:  true,
+		nopasswd: make(map[string]bool),
 	}
+}
 
-	if host.PrivateKey != "" {
-		key, err := parsePrivateKey(host.PrivateKey)
+// NewConnection dials host, going through every hop of host.ProxyJump in order.
+func NewConnection(host entity.Host) (*SSHConnection, error) {
+	var jumps []*ssh.Client
+	var via *ssh.Client
+	for _, hop := range host.ProxyJump {
+		client, err := dialSSH(via, hop)
 		if err != nil {
-			log.Printf("Failed to parse private key: %s", err.Error())
+			log.Printf("Failed to dial jump host %s: %s", hop.Address, err.Error())
+			closeClients(jumps)
 			return nil, err
 		}
-		sshConfig.Auth = append(sshConfig.Auth, key)
+		jumps = append(jumps, client)
+		via = client
+	}
+	connection, err := NewConnectionVia(via, host)
+	if err != nil {
+		closeClients(jumps)
+		return nil, err
 	}
+	connection.Jumps = jumps
+	return connection, nil
+}
 
-	address := fmt.Sprintf("%s:%d", host.Address, host.Port)
-	client, err := ssh.Dial("tcp", address, sshConfig)
+// NewConnectionVia dials host through an already established bastion client, or directly when via is nil.
+func NewConnectionVia(via *ssh.Client, host entity.Host) (*SSHConnection, error) {
+	client, err := dialSSH(via, host)
 	if err != nil {
 		log.Printf("Failed to dial: %s", err.Error())
 		return nil, err
 	}
-
 	connection := &SSHConnection{
 		Client: client,
+		state:  newConnectionState(client),
 	}
-
 	return connection, nil
 }
 
 // NewSSHConnection establishes a new SSH connection.
 func NewSSHConnection(config SSHConfig) (*SSHConnection, error) {
-	sshConfig := &ssh.ClientConfig{
-		User:            config.User,
-		Auth:            config.AuthMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	host := entity.Host{
+		Address:     config.Host,
+		Port:        config.Port,
+		User:        config.User,
+		Password:    config.Password,
+		PrivateKey:  config.PrivateKey,
+		Passphrase:  config.Passphrase,
+		Certificate: config.Certificate,
+		UseAgent:    config.UseAgent,
+		AgentSocket: config.AgentSocket,
+		AuthMethods: config.AuthMethods,
+		ProxyJump:   config.ProxyJump,
 	}
+	return NewConnection(host)
+}
 
-	if config.Password != "" {
-		sshConfig.Auth = append(sshConfig.Auth, ssh.Password(config.Password))
+func newClientConfig(host entity.Host) (*ssh.ClientConfig, func(), error) {
+	auth, cleanup, err := authMethods(host)
+	if err != nil {
+		return nil, nil, err
+	}
+	sshConfig := &ssh.ClientConfig{
+		User:            host.User,
+		Auth:            auth,
+		HostKeyCallback: NewHostKeyCallback(host),
 	}
+	return sshConfig, cleanup, nil
+}
 
-	if config.PrivateKey != "" {
-		key, err := parsePrivateKey(config.PrivateKey)
-		if err != nil {
-			log.Printf("Failed to parse private key: %s", err.Error())
-			return nil, err
-		}
-		sshConfig.Auth = append(sshConfig.Auth, key)
+func hostAddress(host entity.Host) string {
+	port := host.Port
+	if port == 0 {
+		port = 22
 	}
+	return net.JoinHostPort(host.Address, strconv.Itoa(int(port)))
+}
 
-	address := fmt.Sprintf("%s:%d", config.Host, config.Port)
-	client, err := ssh.Dial("tcp", address, sshConfig)
+func dialSSH(via *ssh.Client, host entity.Host) (*ssh.Client, error) {
+	sshConfig, cleanup, err := newClientConfig(host)
 	if err != nil {
-		log.Printf("Failed to dial: %s", err.Error())
 		return nil, err
 	}
+	defer cleanup()
+	address := hostAddress(host)
+	if via == nil {
+		return ssh.Dial("tcp", address, sshConfig)
+	}
+	conn, err := via.Dial("tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s through jump host: %w", address, err)
+	}
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, address, sshConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(clientConn, chans, reqs), nil
+}
 
-	connection := &SSHConnection{
-		Client: client,
+func closeClients(clients []*ssh.Client) {
+	for i := len(clients) - 1; i >= 0; i-- {
+		clients[i].Close()
 	}
+}
 
-	return connection, nil
+// errConnectionClosed is returned when a closed connection is redialed.
+var errConnectionClosed = errors.New("ssh connection is closed")
+
+// Close closes the connection and the jump host connections dialed for it, a redial in flight is dropped.
+func (conn *SSHConnection) Close() error {
+	client := conn.Client
+	if conn.state != nil {
+		conn.state.mutex.Lock()
+		conn.state.closed = true
+		if conn.state.client != nil {
+			client = conn.state.client
+		}
+		conn.state.mutex.Unlock()
+	}
+	var err error
+	if client != nil {
+		err = client.Close()
+	}
+	if conn.Client != nil && conn.Client != client {
+		conn.Client.Close()
+	}
+	closeClients(conn.Jumps)
+	return err
 }
 
-func parsePrivateKey(keyPath string) (ssh.AuthMethod, error) {
-	key, err := os.ReadFile(keyPath)
-	if err != nil {
-		log.Printf("Failed to read private key file: %s", err.Error())
-		return nil, err
+// evict closes the transport of an idle connection, unlike Close the connection redials on its next use
+// so executors handed out before keep working.
+func (conn *SSHConnection) evict() {
+	state := conn.state
+	state.mutex.Lock()
+	if state.closed || state.evicted {
+		state.mutex.Unlock()
+		return
+	}
+	state.evicted = true
+	client := state.client
+	state.mutex.Unlock()
+	if client != nil {
+		client.Close()
+	}
+}
+
+// currentClient returns the live transport, which differs from Client once the connection was redialed.
+func (conn *SSHConnection) currentClient() *ssh.Client {
+	if conn.state == nil {
+		return conn.Client
 	}
+	conn.state.mutex.Lock()
+	defer conn.state.mutex.Unlock()
+	if conn.state.client == nil {
+		return conn.Client
+	}
+	return conn.state.client
+}
+
+func (conn *SSHConnection) canRedial() bool {
+	return conn.state != nil && conn.state.redial != nil
+}
+
+// reconnect replaces the broken transport old, unless another caller already did. The dial runs without
+// holding the state lock, callers arriving meanwhile wait for it instead of dialing again.
+func (conn *SSHConnection) reconnect(old *ssh.Client) (*ssh.Client, error) {
+	state := conn.state
+	state.mutex.Lock()
+	for {
+		if state.closed {
+			state.mutex.Unlock()
+			return nil, errConnectionClosed
+		}
+		if state.client != old && state.client != nil {
+			client := state.client
+			state.mutex.Unlock()
+			return client, nil
+		}
+		if state.redialing == nil {
+			break
+		}
+		redialing := state.redialing
+		state.mutex.Unlock()
+		<-redialing
+		state.mutex.Lock()
+	}
+	redialing := make(chan struct{})
+	state.redialing = redialing
+	state.mutex.Unlock()
+
+	client, err := state.redial()
 
-	signer, err := ssh.ParsePrivateKey(key)
+	state.mutex.Lock()
+	state.redialing = nil
+	close(redialing)
 	if err != nil {
-		log.Printf("Failed to parse private key: %s", err.Error())
+		state.healthy = false
+		state.mutex.Unlock()
 		return nil, err
 	}
+	if state.closed {
+		// the connection was evicted or closed during the dial, nothing would close the new client
+		state.mutex.Unlock()
+		client.Close()
+		return nil, errConnectionClosed
+	}
+	state.client = client
+	state.redials++
+	state.healthy = true
+	state.evicted = false
+	state.mutex.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	return client, nil
+}
+
+// touch marks the connection as used.
+func (conn *SSHConnection) touch() {
+	if conn.state == nil {
+		return
+	}
+	conn.state.mutex.Lock()
+	conn.state.lastUsed = time.Now()
+	conn.state.mutex.Unlock()
+}
+
+// acquire waits for a free session slot, the returned func gives it back.
+func (conn *SSHConnection) acquire(ctx context.Context) (func(), error) {
+	state := conn.state
+	if state == nil {
+		return func() {}, nil
+	}
+	select {
+	case state.sessions <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	state.mutex.Lock()
+	state.active++
+	state.lastUsed = time.Now()
+	state.mutex.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			state.mutex.Lock()
+			state.active--
+			state.lastUsed = time.Now()
+			state.mutex.Unlock()
+			<-state.sessions
+		})
+	}, nil
+}
 
-	return ssh.PublicKeys(signer), nil
+// hold marks a forwarded channel as open so the connection is not evicted as idle, the returned func ends the hold.
+func (conn *SSHConnection) hold() func() {
+	state := conn.state
+	if state == nil {
+		return func() {}
+	}
+	state.mutex.Lock()
+	state.forwards++
+	state.lastUsed = time.Now()
+	state.mutex.Unlock()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			state.mutex.Lock()
+			state.forwards--
+			state.lastUsed = time.Now()
+			state.mutex.Unlock()
+		})
+	}
+}
+
+// newSession opens a session once a slot is free, redialing once when the transport is broken.
+func (executor *SSHExecutor) newSession(ctx context.Context) (*ssh.Session, func(), error) {
+	conn := &executor.Connection
+	release, err := conn.acquire(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	client := conn.currentClient()
+	session, err := client.NewSession()
+	if err != nil && conn.canRedial() {
+		logger.GetLogger().Warnf("SSH session to %s failed, redialing: %s", executor.Host.Address, err.Error())
+		if client, err = conn.reconnect(client); err == nil {
+			session, err = client.NewSession()
+		}
+	}
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	return session, release, nil
 }
 
 // Connect establishes an SSH connection.
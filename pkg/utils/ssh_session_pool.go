@@ -1,11 +1,27 @@
 package utils
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"github.com/spf13/viper"
 	"github.com/whoisfisher/mykubespray/pkg/entity"
 	"github.com/whoisfisher/mykubespray/pkg/logger"
 	"golang.org/x/crypto/ssh"
+	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+)
+
+const (
+	defaultKeepaliveInterval = 30 * time.Second
+	defaultIdleTimeout       = 10 * time.Minute
 )
 
 type SSHConnectionPool struct {
@@ -18,9 +34,59 @@ type SSHSessionPool struct {
 	mutex    sync.Mutex
 }
 
+// SSHExecutorPool shares one connection per address, port and user between executors.
+// Connections are kept alive, redialed when broken and closed after being idle.
 type SSHExecutorPool struct {
-	Executors sync.Map
-	mutex     sync.Mutex
+	Bastions          sync.Map
+	mutex             sync.Mutex
+	connections       map[string]*pooledConnection
+	tunnels           map[string]*Tunnel
+	keepaliveInterval time.Duration
+	idleTimeout       time.Duration
+	done              chan struct{}
+	closeOnce         sync.Once
+}
+
+type pooledConnection struct {
+	key   string
+	host  entity.Host
+	ready chan struct{}
+	conn  *SSHConnection
+	err   error
+}
+
+// PoolStats describes the connections held by an SSHExecutorPool.
+type PoolStats struct {
+	Connections    int                  `json:"connections"`
+	ActiveSessions int                  `json:"active_sessions"`
+	Bastions       int                  `json:"bastions"`
+	Hosts          []PoolConnectionStat `json:"hosts"`
+	Tunnels        []TunnelStat         `json:"tunnels"`
+}
+
+type PoolConnectionStat struct {
+	Key            string    `json:"key"`
+	Address        string    `json:"address"`
+	User           string    `json:"user"`
+	ActiveSessions int       `json:"active_sessions"`
+	Forwards       int       `json:"forwards"`
+	MaxSessions    int       `json:"max_sessions"`
+	Redials        int       `json:"redials"`
+	Healthy        bool      `json:"healthy"`
+	LastUsed       time.Time `json:"last_used"`
+}
+
+var (
+	sharedExecutorPool     *SSHExecutorPool
+	sharedExecutorPoolOnce sync.Once
+)
+
+// GetSSHExecutorPool returns the pool shared by the services.
+func GetSSHExecutorPool() *SSHExecutorPool {
+	sharedExecutorPoolOnce.Do(func() {
+		sharedExecutorPool = NewSSHExecutorPool()
+	})
+	return sharedExecutorPool
 }
 
 func NewSSHConnectionPool() *SSHConnectionPool {
@@ -32,27 +98,79 @@ func NewSSHSessionPool() *SSHSessionPool {
 }
 
 func NewSSHExecutorPool() *SSHExecutorPool {
-	return &SSHExecutorPool{}
+	keepaliveInterval := viper.GetDuration("ssh.keepalive_interval")
+	if keepaliveInterval <= 0 {
+		keepaliveInterval = defaultKeepaliveInterval
+	}
+	idleTimeout := viper.GetDuration("ssh.idle_timeout")
+	if idleTimeout <= 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	pool := &SSHExecutorPool{
+		connections:       make(map[string]*pooledConnection),
+		keepaliveInterval: keepaliveInterval,
+		idleTimeout:       idleTimeout,
+		done:              make(chan struct{}),
+	}
+	go pool.maintain()
+	go pool.maintainTunnels()
+	return pool
+}
+
+// poolKey identifies a connection by user, address, port and credentials, including the jump hosts in front of it,
+// so a caller only shares a connection that was authenticated with the credentials it sent.
+func poolKey(host entity.Host) string {
+	chain := append(append([]entity.Host{}, host.ProxyJump...), host)
+	return jumpKey(chain)
+}
+
+// hostKey identifies a host by user, address, port and jump hosts regardless of the credentials used to reach it.
+func hostKey(host entity.Host) string {
+	keys := make([]string, 0, len(host.ProxyJump)+1)
+	for _, hop := range append(append([]entity.Host{}, host.ProxyJump...), host) {
+		keys = append(keys, fmt.Sprintf("%s@%s", hop.User, hostAddress(hop)))
+	}
+	return strings.Join(keys, ",")
+}
+
+// fingerprintKey keys the credential fingerprints, it is random so they cannot be compared across processes.
+var fingerprintKey = func() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}()
+
+// credentialFingerprint is an HMAC of the credentials of host. Connections authenticated with AuthMethods
+// are only shared by callers passing the same slice, the methods themselves cannot be compared.
+func credentialFingerprint(host entity.Host) string {
+	mac := hmac.New(sha256.New, fingerprintKey)
+	for _, field := range []string{host.Password, host.PrivateKey, host.Passphrase, host.Certificate, fmt.Sprint(host.UseAgent), host.AgentSocket} {
+		fmt.Fprintf(mac, "%d:%s", len(field), field)
+	}
+	if len(host.AuthMethods) > 0 {
+		fmt.Fprintf(mac, "methods:%p", &host.AuthMethods[0])
+	}
+	return hex.EncodeToString(mac.Sum(nil))[:16]
 }
 
 func (pool *SSHConnectionPool) GetSSHConnection(host entity.Host) (*SSHConnection, error) {
-	//pool.mutex.Lock()
-	//defer pool.mutex.Unlock()
-	if conn, exists := pool.Connections.Load(host.Name); exists {
+	key := poolKey(host)
+	if conn, exists := pool.Connections.Load(key); exists {
 		return conn.(*SSHConnection), nil
 	}
 	conn, err := NewConnection(host)
 	if err != nil {
 		return nil, err
 	}
-	pool.Connections.Store(host.Name, conn)
+	pool.Connections.Store(key, conn)
 	return conn, nil
 }
 
 func (pool *SSHSessionPool) GetSSHSession(host entity.Host) (*ssh.Session, error) {
-	//pool.mutex.Lock()
-	//defer pool.mutex.Unlock()
-	if session, exists := pool.Sessions.Load(host.Name); exists {
+	key := poolKey(host)
+	if session, exists := pool.Sessions.Load(key); exists {
 		return session.(*ssh.Session), nil
 	}
 	conn, err := NewConnection(host)
@@ -63,48 +181,299 @@ func (pool *SSHSessionPool) GetSSHSession(host entity.Host) (*ssh.Session, error
 	if err != nil {
 		return nil, err
 	}
-	pool.Sessions.Store(host.Name, session)
+	pool.Sessions.Store(key, session)
 	return session, nil
 }
 
 func (pool *SSHSessionPool) Close() {
 	pool.Sessions.Range(func(key, value interface{}) bool {
-		client := value.(*ssh.Client)
-		client.Close()
+		session := value.(*ssh.Session)
+		session.Close()
+		pool.Sessions.Delete(key)
 		return true
 	})
 }
 
+// GetSSHExecutor returns an executor on the pooled connection for host, dialing it on first use.
 func (pool *SSHExecutorPool) GetSSHExecutor(host entity.Host) (*SSHExecutor, error) {
-	//pool.mutex.Lock()
-	//defer pool.mutex.Unlock()
-	if executor, exists := pool.Executors.Load(host.Name); exists {
-		return executor.(*SSHExecutor), nil
+	key := poolKey(host)
+	pool.mutex.Lock()
+	if pool.connections == nil {
+		pool.mutex.Unlock()
+		return nil, errors.New("ssh executor pool is closed")
+	}
+	entry, exists := pool.connections[key]
+	if !exists {
+		entry = &pooledConnection{key: key, host: host, ready: make(chan struct{})}
+		pool.connections[key] = entry
+	} else {
+		// mark the connection used while holding the pool lock, so checkConnections cannot evict it before it is used
+		select {
+		case <-entry.ready:
+			if entry.err == nil {
+				entry.conn.touch()
+			}
+		default:
+		}
 	}
-	conn, err := NewConnection(host)
+	pool.mutex.Unlock()
+
+	if exists {
+		<-entry.ready
+	} else {
+		entry.conn, entry.err = pool.dial(host)
+		close(entry.ready)
+		if entry.err != nil {
+			pool.mutex.Lock()
+			if pool.connections[key] == entry {
+				delete(pool.connections, key)
+			}
+			pool.mutex.Unlock()
+		}
+	}
+	if entry.err != nil {
+		return nil, entry.err
+	}
+	return &SSHExecutor{Connection: *entry.conn, Host: host}, nil
+}
+
+func (pool *SSHExecutorPool) dial(host entity.Host) (*SSHConnection, error) {
+	via, err := pool.getJumpClient(host.ProxyJump)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := NewConnectionVia(via, host)
 	if err != nil {
 		return nil, err
 	}
-	executor := &SSHExecutor{
-		Connection: *conn,
-		Host:       host,
+	conn.state.redial = func() (*ssh.Client, error) {
+		return pool.redial(host)
+	}
+	return conn, nil
+}
+
+// redial dials host again, dropping the cached jump hosts when they turn out to be broken as well.
+func (pool *SSHExecutorPool) redial(host entity.Host) (*ssh.Client, error) {
+	via, err := pool.getJumpClient(host.ProxyJump)
+	if err == nil {
+		var client *ssh.Client
+		if client, err = dialSSH(via, host); err == nil || len(host.ProxyJump) == 0 {
+			return client, err
+		}
+	}
+	pool.dropJumpClients(host.ProxyJump)
+	if via, err = pool.getJumpClient(host.ProxyJump); err != nil {
+		return nil, err
+	}
+	return dialSSH(via, host)
+}
+
+func jumpKey(chain []entity.Host) string {
+	keys := make([]string, 0, len(chain))
+	for _, hop := range chain {
+		keys = append(keys, fmt.Sprintf("%s@%s#%s", hop.User, hostAddress(hop), credentialFingerprint(hop)))
 	}
-	pool.Executors.Store(host.Name, executor)
-	return executor, nil
+	return strings.Join(keys, ",")
+}
+
+// getJumpClient returns a pooled connection to the last hop of chain, so executors behind the same bastion share it.
+func (pool *SSHExecutorPool) getJumpClient(chain []entity.Host) (*ssh.Client, error) {
+	if len(chain) == 0 {
+		return nil, nil
+	}
+	key := jumpKey(chain)
+	if client, exists := pool.Bastions.Load(key); exists {
+		return client.(*ssh.Client), nil
+	}
+	via, err := pool.getJumpClient(chain[:len(chain)-1])
+	if err != nil {
+		return nil, err
+	}
+	hop := chain[len(chain)-1]
+	client, err := dialSSH(via, hop)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to dial jump host %s: %s", hop.Address, err.Error())
+		return nil, err
+	}
+	if existing, loaded := pool.Bastions.LoadOrStore(key, client); loaded {
+		client.Close()
+		return existing.(*ssh.Client), nil
+	}
+	return client, nil
+}
+
+func (pool *SSHExecutorPool) dropJumpClients(chain []entity.Host) {
+	for i := len(chain); i > 0; i-- {
+		if client, ok := pool.Bastions.LoadAndDelete(jumpKey(chain[:i])); ok {
+			client.(*ssh.Client).Close()
+		}
+	}
+}
+
+// sendKeepalive sends an OpenSSH keepalive request, any reply means the transport is alive.
+func sendKeepalive(client *ssh.Client, timeout time.Duration) error {
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+		result <- err
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(timeout):
+		return errors.New("keepalive timed out")
+	}
+}
+
+func (pool *SSHExecutorPool) maintain() {
+	ticker := time.NewTicker(pool.keepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pool.done:
+			return
+		case <-ticker.C:
+			pool.checkConnections()
+		}
+	}
+}
+
+// checkConnections closes the transport of idle connections and probes the others, redialing those that stopped answering.
+func (pool *SSHExecutorPool) checkConnections() {
+	pool.mutex.Lock()
+	entries := make([]*pooledConnection, 0, len(pool.connections))
+	for key, entry := range pool.connections {
+		select {
+		case <-entry.ready:
+		default:
+			continue
+		}
+		if entry.err != nil {
+			continue
+		}
+		state := entry.conn.state
+		state.mutex.Lock()
+		evicted := state.evicted
+		idle := !evicted && state.active == 0 && state.forwards == 0 && time.Since(state.lastUsed) > pool.idleTimeout
+		state.mutex.Unlock()
+		if idle {
+			// the entry stays, an executor still held by a caller redials it on its next command
+			logger.GetLogger().Infof("Closing idle SSH connection %s", key)
+			entry.conn.evict()
+			continue
+		}
+		if !evicted {
+			entries = append(entries, entry)
+		}
+	}
+	pool.mutex.Unlock()
+
+	for _, entry := range entries {
+		client := entry.conn.currentClient()
+		if err := sendKeepalive(client, pool.keepaliveInterval); err != nil {
+			logger.GetLogger().Warnf("SSH connection %s is broken, redialing: %s", entry.key, err.Error())
+			if _, err := entry.conn.reconnect(client); err != nil {
+				logger.GetLogger().Errorf("Failed to redial %s: %s", entry.key, err.Error())
+			}
+		}
+	}
+	pool.Bastions.Range(func(key, value interface{}) bool {
+		if err := sendKeepalive(value.(*ssh.Client), pool.keepaliveInterval); err != nil {
+			logger.GetLogger().Warnf("Jump host connection %s is broken: %s", key, err.Error())
+			if pool.Bastions.CompareAndDelete(key, value) {
+				value.(*ssh.Client).Close()
+			}
+		}
+		return true
+	})
+}
+
+// Stats reports the connections currently held by the pool.
+func (pool *SSHExecutorPool) Stats() PoolStats {
+	var stats PoolStats
+	pool.mutex.Lock()
+	for key, entry := range pool.connections {
+		select {
+		case <-entry.ready:
+		default:
+			continue
+		}
+		if entry.err != nil {
+			continue
+		}
+		state := entry.conn.state
+		state.mutex.Lock()
+		if state.evicted {
+			state.mutex.Unlock()
+			continue
+		}
+		stat := PoolConnectionStat{
+			Key:            key,
+			Address:        entry.host.Address,
+			User:           entry.host.User,
+			ActiveSessions: state.active,
+			Forwards:       state.forwards,
+			MaxSessions:    cap(state.sessions),
+			Redials:        state.redials,
+			Healthy:        state.healthy,
+			LastUsed:       state.lastUsed,
+		}
+		state.mutex.Unlock()
+		stats.Connections++
+		stats.ActiveSessions += stat.ActiveSessions
+		stats.Hosts = append(stats.Hosts, stat)
+	}
+	pool.mutex.Unlock()
+	pool.Bastions.Range(func(key, value interface{}) bool {
+		stats.Bastions++
+		return true
+	})
+	sort.Slice(stats.Hosts, func(i, j int) bool {
+		return stats.Hosts[i].Key < stats.Hosts[j].Key
+	})
+	for _, tunnel := range pool.tunnelList() {
+		stats.Tunnels = append(stats.Tunnels, tunnel.Stat())
+	}
+	return stats
 }
 
 func (pool *SSHExecutorPool) Close() {
-	pool.Executors.Range(func(key, value interface{}) bool {
-		client := value.(*SSHExecutor)
-		client.Connection.Client.Close()
+	pool.closeOnce.Do(func() {
+		close(pool.done)
+	})
+	pool.mutex.Lock()
+	connections := pool.connections
+	tunnels := pool.tunnels
+	pool.connections = nil
+	pool.tunnels = nil
+	pool.mutex.Unlock()
+	for _, tunnel := range tunnels {
+		tunnel.Close()
+	}
+	for _, entry := range connections {
+		<-entry.ready
+		if entry.conn != nil {
+			entry.conn.Close()
+		}
+	}
+	var bastions []string
+	pool.Bastions.Range(func(key, value interface{}) bool {
+		bastions = append(bastions, key.(string))
 		return true
 	})
+	// Close the deepest hops first, they are tunnelled through the shorter chains.
+	sort.Slice(bastions, func(i, j int) bool {
+		return strings.Count(bastions[i], ",") > strings.Count(bastions[j], ",")
+	})
+	for _, key := range bastions {
+		if client, ok := pool.Bastions.LoadAndDelete(key); ok {
+			client.(*ssh.Client).Close()
+		}
+	}
 }
 
 func (pool *SSHExecutorPool) ExecuteShortCommand(command string, host entity.Host) (string, error) {
-	pool.mutex.Lock()
 	executor, err := pool.GetSSHExecutor(host)
-	pool.mutex.Unlock()
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
 		return "", err
@@ -113,9 +482,7 @@ func (pool *SSHExecutorPool) ExecuteShortCommand(command string, host entity.Hos
 }
 
 func (pool *SSHExecutorPool) ExecuteShortCMD(command string, host entity.Host) ([]byte, error) {
-	pool.mutex.Lock()
 	executor, err := pool.GetSSHExecutor(host)
-	pool.mutex.Unlock()
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
 		return nil, err
@@ -124,9 +491,7 @@ func (pool *SSHExecutorPool) ExecuteShortCMD(command string, host entity.Host) (
 }
 
 func (pool *SSHExecutorPool) ExecuteCommand(command string, host entity.Host, logChan chan LogEntry) error {
-	pool.mutex.Lock()
 	executor, err := pool.GetSSHExecutor(host)
-	pool.mutex.Unlock()
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
 		return err
@@ -135,9 +500,7 @@ func (pool *SSHExecutorPool) ExecuteCommand(command string, host entity.Host, lo
 }
 
 func (pool *SSHExecutorPool) ExecuteCommandWithoutReturn(command string, host entity.Host) error {
-	pool.mutex.Lock()
 	executor, err := pool.GetSSHExecutor(host)
-	pool.mutex.Unlock()
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
 		return err
@@ -148,62 +511,33 @@ func (pool *SSHExecutorPool) ExecuteCommandWithoutReturn(command string, host en
 type MachineResult struct {
 	Machine string
 	Success bool
+	Skipped bool
 	Error   string
+	Result  *CommandResult     `json:",omitempty"`
+	Sync    *SyncResult        `json:",omitempty"`
+	Files   []string           `json:",omitempty"`
+	File    *ManagedFileResult `json:",omitempty"`
 }
 
 type CopyResult struct {
 	OverallSuccess bool
+	Aborted        bool
 	Results        []MachineResult
 }
 
-func (pool *SSHExecutorPool) ExecuteCommandParallel(command string, hosts []entity.Host) *CopyResult {
-	var wg sync.WaitGroup
-	results := make(chan MachineResult, len(hosts))
-	for _, host := range hosts {
-		wg.Add(1)
-		go func(host entity.Host) {
-			defer wg.Done()
-			pool.mutex.Lock()
-			executor, err := pool.GetSSHExecutor(host)
-			pool.mutex.Unlock()
-			if err != nil {
-				logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
-				results <- MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to connect to %s: %s", host.Address, err.Error())}
-				return
-			}
-			err = executor.ExecuteCMDWithoutReturn(command, func(msg string) {
-				results <- MachineResult{Machine: host.Address, Success: true, Error: ""}
-			})
-			if err != nil {
-				results <- MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to execute command on %s: %s", host.Address, err.Error())}
-			}
-		}(host)
-	}
-
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-	var successCount, failureCount int
-	var copyResult CopyResult
-	var machineResults []MachineResult
-	for result := range results {
-		if result.Success {
-			logger.GetLogger().Infof("Successfully to execute command on %s\n", result.Machine)
-			successCount++
-		} else {
-			logger.GetLogger().Errorf("Failedto execute command on %s: %s\n", result.Machine, result.Error)
-			failureCount++
+func (pool *SSHExecutorPool) ExecuteCommandParallel(command string, hosts []entity.Host, strategy entity.ParallelStrategy) *CopyResult {
+	return RunParallel("execute command", hosts, strategy, func(host entity.Host) MachineResult {
+		executor, err := pool.GetSSHExecutor(host)
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to connect to %s: %s", host.Address, err.Error())}
 		}
-		machineResults = append(machineResults, result)
-	}
-	copyResult.Results = machineResults
-	if failureCount > 0 {
-		copyResult.OverallSuccess = false
-	} else {
-		copyResult.OverallSuccess = true
-	}
-	return &copyResult
+		result, err := executor.RunCommand(context.Background(), command)
+		if err != nil {
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to execute command on %s: %s", host.Address, err.Error()), Result: result}
+		}
+		return MachineResult{Machine: host.Address, Success: true, Error: "", Result: result}
+	})
 }
 
 func (pool *SSHExecutorPool) ExecuteCommandParallelWithoutPool(command string, hosts []entity.Host) *CopyResult {
@@ -260,54 +594,19 @@ func (pool *SSHExecutorPool) ExecuteCommandParallelWithoutPool(command string, h
 	return &copyResult
 }
 
-func (pool *SSHExecutorPool) CopyFileParallel(srcFile, destFile string, hosts []entity.Host) *CopyResult {
-	var wg sync.WaitGroup
-	results := make(chan MachineResult, len(hosts))
-	for _, host := range hosts {
-		wg.Add(1)
-		go func(host entity.Host) {
-			defer wg.Done()
-			pool.mutex.Lock()
-			executor, err := pool.GetSSHExecutor(host)
-			pool.mutex.Unlock()
-			if err != nil {
-				logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
-				results <- MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to connect to %s: %s", host.Address, err.Error())}
-				return
-			}
-			err = executor.CopyFile(srcFile, destFile, func(msg string) {
-				results <- MachineResult{Machine: host.Address, Success: true, Error: ""}
-			})
-			if err != nil {
-				results <- MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to copy file to %s: %s", host.Address, err.Error())}
-			}
-		}(host)
-	}
-
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-	var successCount, failureCount int
-	var copyResult CopyResult
-	var machineResults []MachineResult
-	for result := range results {
-		if result.Success {
-			logger.GetLogger().Infof("Successfully copied file to %s\n", result.Machine)
-			successCount++
-		} else {
-			logger.GetLogger().Errorf("Failed to copy file to %s: %s\n", result.Machine, result.Error)
-			failureCount++
+func (pool *SSHExecutorPool) CopyFileParallel(srcFile, destFile string, opts TransferOptions, hosts []entity.Host, strategy entity.ParallelStrategy) *CopyResult {
+	return RunParallel("copy file", hosts, strategy, func(host entity.Host) MachineResult {
+		executor, err := pool.GetSSHExecutor(host)
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to connect to %s: %s", host.Address, err.Error())}
 		}
-		machineResults = append(machineResults, result)
-	}
-	copyResult.Results = machineResults
-	if failureCount > 0 {
-		copyResult.OverallSuccess = false
-	} else {
-		copyResult.OverallSuccess = true
-	}
-	return &copyResult
+		err = executor.CopyFileWithOptions(srcFile, destFile, opts, func(msg string) {})
+		if err != nil {
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to copy file to %s: %s", host.Address, err.Error())}
+		}
+		return MachineResult{Machine: host.Address, Success: true, Error: ""}
+	})
 }
 
 func (pool *SSHExecutorPool) CopyFileParallelWithoutPool(srcFile, destFile string, hosts []entity.Host) *CopyResult {
@@ -364,59 +663,43 @@ func (pool *SSHExecutorPool) CopyFileParallelWithoutPool(srcFile, destFile strin
 	return &copyResult
 }
 
-func (pool *SSHExecutorPool) CopyMultiFileParallel(files []entity.FileSrcDest, hosts []entity.Host) *CopyResult {
-	var wg sync.WaitGroup
-	results := make(chan MachineResult, len(hosts))
-	for _, host := range hosts {
-		wg.Add(1)
-		go func(host entity.Host) {
-			defer wg.Done()
-			pool.mutex.Lock()
-			executor, err := pool.GetSSHExecutor(host)
-			pool.mutex.Unlock()
-			if err != nil {
-				logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
-				results <- MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to connect to %s: %s", host.Address, err.Error())}
-				return
-			}
-			_ = executor.CopyMultiFile(files, func(msg string) {
-				results <- MachineResult{Machine: host.Address, Success: true, Error: ""}
-			})
-			if err != nil {
-				results <- MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to copy file to %s: %s", host.Address, err.Error())}
-			}
-		}(host)
-	}
+func (pool *SSHExecutorPool) SyncDirectoryParallel(srcDir, destDir string, opts SyncOptions, hosts []entity.Host, strategy entity.ParallelStrategy) *CopyResult {
+	return RunParallel("sync directory", hosts, strategy, func(host entity.Host) MachineResult {
+		executor, err := pool.GetSSHExecutor(host)
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to connect to %s: %s", host.Address, err.Error())}
+		}
+		result, err := executor.SyncDirectory(srcDir, destDir, opts)
+		if err != nil {
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to sync directory to %s: %s", host.Address, err.Error()), Sync: result}
+		}
+		return MachineResult{Machine: host.Address, Success: true, Error: "", Sync: result}
+	})
+}
 
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-	var successCount, failureCount int
-	var copyResult CopyResult
-	var machineResults []MachineResult
-	for result := range results {
-		if result.Success {
-			logger.GetLogger().Infof("Successfully copied file to %s\n", result.Machine)
-			successCount++
-		} else {
-			logger.GetLogger().Errorf("Failed to copy file to %s: %s\n", result.Machine, result.Error)
-			failureCount++
+func (pool *SSHExecutorPool) CopyMultiFileParallel(files []entity.FileSrcDest, hosts []entity.Host, strategy entity.ParallelStrategy) *CopyResult {
+	return RunParallel("copy files", hosts, strategy, func(host entity.Host) MachineResult {
+		executor, err := pool.GetSSHExecutor(host)
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to connect to %s: %s", host.Address, err.Error())}
 		}
-		machineResults = append(machineResults, result)
-	}
-	copyResult.Results = machineResults
-	if failureCount > 0 {
-		copyResult.OverallSuccess = false
-	} else {
-		copyResult.OverallSuccess = true
-	}
-	return &copyResult
+		result := executor.CopyMultiFile(files, func(msg string) {})
+		if !result.OverallSuccess {
+			var errs []string
+			for _, fileResult := range result.Results {
+				if !fileResult.Success {
+					errs = append(errs, fileResult.Error)
+				}
+			}
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to copy files to %s: %s", host.Address, strings.Join(errs, "; "))}
+		}
+		return MachineResult{Machine: host.Address, Success: true, Error: ""}
+	})
 }
 
 func (pool *SSHExecutorPool) CopyFile(srcFile, destFile string, host entity.Host) error {
-	pool.mutex.Lock()
-	defer pool.mutex.Unlock()
 	executor, err := pool.GetSSHExecutor(host)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
@@ -426,66 +709,94 @@ func (pool *SSHExecutorPool) CopyFile(srcFile, destFile string, host entity.Host
 	return executor.CopyFile(srcFile, destFile, outputHandler)
 }
 
-func (pool *SSHExecutorPool) CopyMultiFile(files []entity.FileSrcDest, host entity.Host) (*CopyResult, error) {
-	pool.mutex.Lock()
-	defer pool.mutex.Unlock()
+func (pool *SSHExecutorPool) FetchFile(remoteFile, localFile string, host entity.Host) error {
 	executor, err := pool.GetSSHExecutor(host)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
-		return nil, err
+		return err
 	}
-	outputHandler := func(string) { logger.GetLogger().Infof("Copy file") }
-	return executor.CopyMultiFile(files, outputHandler), nil
+	return executor.FetchFile(remoteFile, localFile)
 }
 
-func (pool *SSHExecutorPool) AddHostsParallel(record entity.Record, hosts []entity.Host) *CopyResult {
-	var wg sync.WaitGroup
-	results := make(chan MachineResult, len(hosts))
-	for _, host := range hosts {
-		wg.Add(1)
-		go func(host entity.Host) {
-			defer wg.Done()
-			pool.mutex.Lock()
-			executor, err := pool.GetSSHExecutor(host)
-			pool.mutex.Unlock()
-			if err != nil {
-				logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
-				results <- MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to connect to %s: %s", host.Address, err.Error())}
-				return
-			}
-			err = executor.AddHosts(record, func(msg string) {
-				results <- MachineResult{Machine: host.Address, Success: true, Error: ""}
-			})
-			if err != nil {
-				results <- MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to add hosts to %s: %s", host.Address, err.Error())}
-			}
-		}(host)
+func (pool *SSHExecutorPool) FetchFiles(remotePaths []string, localDir string, host entity.Host) ([]string, error) {
+	executor, err := pool.GetSSHExecutor(host)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
+		return nil, err
 	}
+	return executor.FetchFiles(remotePaths, localDir)
+}
 
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-	var successCount, failureCount int
-	var copyResult CopyResult
-	var machineResults []MachineResult
-	for result := range results {
-		if result.Success {
-			logger.GetLogger().Infof("Successfully add hosts to %s\n", result.Machine)
-			successCount++
-		} else {
-			logger.GetLogger().Errorf("Failed to add hosts %s: %s\n", result.Machine, result.Error)
-			failureCount++
+// FetchFilesParallel fetches the remote paths of every host into localDir/<host name>.
+func (pool *SSHExecutorPool) FetchFilesParallel(remotePaths []string, localDir string, hosts []entity.Host, strategy entity.ParallelStrategy) *CopyResult {
+	return RunParallel("fetch files", hosts, strategy, func(host entity.Host) MachineResult {
+		executor, err := pool.GetSSHExecutor(host)
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to connect to %s: %s", host.Address, err.Error())}
 		}
-		machineResults = append(machineResults, result)
+		files, err := executor.FetchFiles(remotePaths, filepath.Join(localDir, hostDirName(host)))
+		if err != nil {
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to fetch files from %s: %s", host.Address, err.Error()), Files: files}
+		}
+		return MachineResult{Machine: host.Address, Success: true, Error: "", Files: files}
+	})
+}
+
+// EnsureFileParallel makes file match on every host, the result of each host tells whether it changed.
+func (pool *SSHExecutorPool) EnsureFileParallel(file ManagedFile, hosts []entity.Host, strategy entity.ParallelStrategy) *CopyResult {
+	return RunParallel("ensure file", hosts, strategy, func(host entity.Host) MachineResult {
+		executor, err := pool.GetSSHExecutor(host)
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to connect to %s: %s", host.Address, err.Error())}
+		}
+		result, err := executor.EnsureFile(file)
+		if err != nil {
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to write %s on %s: %s", file.Path, host.Address, err.Error())}
+		}
+		return MachineResult{Machine: host.Address, Success: true, Error: "", File: result}
+	})
+}
+
+// CopyRemoteToRemote streams srcFile from srcHost to destFile on destHost through this node.
+func (pool *SSHExecutorPool) CopyRemoteToRemote(srcHost entity.Host, srcFile string, destHost entity.Host, destFile string, opts TransferOptions) error {
+	src, err := pool.GetSSHExecutor(srcHost)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
+		return err
 	}
-	copyResult.Results = machineResults
-	if failureCount > 0 {
-		copyResult.OverallSuccess = false
-	} else {
-		copyResult.OverallSuccess = true
+	dest, err := pool.GetSSHExecutor(destHost)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
+		return err
 	}
-	return &copyResult
+	return copyRemoteToRemote(src, srcFile, dest, destFile, opts)
+}
+
+func (pool *SSHExecutorPool) CopyMultiFile(files []entity.FileSrcDest, host entity.Host) (*CopyResult, error) {
+	executor, err := pool.GetSSHExecutor(host)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
+		return nil, err
+	}
+	outputHandler := func(string) { logger.GetLogger().Infof("Copy file") }
+	return executor.CopyMultiFile(files, outputHandler), nil
+}
+
+func (pool *SSHExecutorPool) AddHostsParallel(record entity.Record, hosts []entity.Host, strategy entity.ParallelStrategy) *CopyResult {
+	return RunParallel("add hosts", hosts, strategy, func(host entity.Host) MachineResult {
+		executor, err := pool.GetSSHExecutor(host)
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to connect to %s: %s", host.Address, err.Error())}
+		}
+		err = executor.AddHosts(record, func(msg string) {})
+		if err != nil {
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to add hosts to %s: %s", host.Address, err.Error())}
+		}
+		return MachineResult{Machine: host.Address, Success: true, Error: ""}
+	})
 }
 
 func (pool *SSHExecutorPool) AddHostsParallelWithoutPool(record entity.Record, hosts []entity.Host) *CopyResult {
@@ -542,59 +853,22 @@ func (pool *SSHExecutorPool) AddHostsParallelWithoutPool(record entity.Record, h
 	return &copyResult
 }
 
-func (pool *SSHExecutorPool) AddMultiHostsParallel(records []entity.Record, hosts []entity.Host) *CopyResult {
-	var wg sync.WaitGroup
-	results := make(chan MachineResult, len(hosts))
-	for _, host := range hosts {
-		wg.Add(1)
-		go func(host entity.Host) {
-			defer wg.Done()
-			pool.mutex.Lock()
-			executor, err := pool.GetSSHExecutor(host)
-			pool.mutex.Unlock()
-			if err != nil {
-				logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
-				results <- MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to connect to %s: %s", host.Address, err.Error())}
-				return
-			}
-			err = executor.AddMultiHosts(records, func(msg string) {
-				results <- MachineResult{Machine: host.Address, Success: true, Error: ""}
-			})
-			if err != nil {
-				results <- MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to add hosts to %s: %s", host.Address, err.Error())}
-			}
-		}(host)
-	}
-
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-	var successCount, failureCount int
-	var copyResult CopyResult
-	var machineResults []MachineResult
-	for result := range results {
-		if result.Success {
-			logger.GetLogger().Infof("Successfully add hosts to %s\n", result.Machine)
-			successCount++
-		} else {
-			logger.GetLogger().Errorf("Failed to add hosts %s: %s\n", result.Machine, result.Error)
-			failureCount++
+func (pool *SSHExecutorPool) AddMultiHostsParallel(records []entity.Record, hosts []entity.Host, strategy entity.ParallelStrategy) *CopyResult {
+	return RunParallel("add hosts", hosts, strategy, func(host entity.Host) MachineResult {
+		executor, err := pool.GetSSHExecutor(host)
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to connect to %s: %s", host.Address, err.Error())}
 		}
-		machineResults = append(machineResults, result)
-	}
-	copyResult.Results = machineResults
-	if failureCount > 0 {
-		copyResult.OverallSuccess = false
-	} else {
-		copyResult.OverallSuccess = true
-	}
-	return &copyResult
+		err = executor.AddMultiHosts(records, func(msg string) {})
+		if err != nil {
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to add hosts to %s: %s", host.Address, err.Error())}
+		}
+		return MachineResult{Machine: host.Address, Success: true, Error: ""}
+	})
 }
 
 func (pool *SSHExecutorPool) AddHosts(record entity.Record, host entity.Host) error {
-	pool.mutex.Lock()
-	defer pool.mutex.Unlock()
 	executor, err := pool.GetSSHExecutor(host)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
@@ -605,8 +879,6 @@ func (pool *SSHExecutorPool) AddHosts(record entity.Record, host entity.Host) er
 }
 
 func (pool *SSHExecutorPool) AddMultiHosts(records []entity.Record, host entity.Host) error {
-	pool.mutex.Lock()
-	defer pool.mutex.Unlock()
 	executor, err := pool.GetSSHExecutor(host)
 	if err != nil {
 		logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
@@ -616,50 +888,17 @@ func (pool *SSHExecutorPool) AddMultiHosts(records []entity.Record, host entity.
 	return executor.AddMultiHosts(records, outputHandler)
 }
 
-func (pool *SSHExecutorPool) AddDNSParallel(dns string, hosts []entity.Host) *CopyResult {
-	var wg sync.WaitGroup
-	results := make(chan MachineResult, len(hosts))
-	for _, host := range hosts {
-		wg.Add(1)
-		go func(host entity.Host) {
-			defer wg.Done()
-			pool.mutex.Lock()
-			executor, err := pool.GetSSHExecutor(host)
-			pool.mutex.Unlock()
-			if err != nil {
-				logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
-				results <- MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to connect to %s: %s", host.Address, err.Error())}
-				return
-			}
-			err = executor.UpdateResolvFile(dns)
-			if err != nil {
-				results <- MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to add dns to %s: %s", host.Address, err.Error())}
-			}
-		}(host)
-	}
-
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-	var successCount, failureCount int
-	var copyResult CopyResult
-	var machineResults []MachineResult
-	for result := range results {
-		if result.Success {
-			logger.GetLogger().Infof("Successfully add dns to %s\n", result.Machine)
-			successCount++
-		} else {
-			logger.GetLogger().Errorf("Failed to add dns %s: %s\n", result.Machine, result.Error)
-			failureCount++
+func (pool *SSHExecutorPool) AddDNSParallel(dns string, hosts []entity.Host, strategy entity.ParallelStrategy) *CopyResult {
+	return RunParallel("add dns", hosts, strategy, func(host entity.Host) MachineResult {
+		executor, err := pool.GetSSHExecutor(host)
+		if err != nil {
+			logger.GetLogger().Errorf("Failed to get SSH executor: %s", err.Error())
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to connect to %s: %s", host.Address, err.Error())}
 		}
-		machineResults = append(machineResults, result)
-	}
-	copyResult.Results = machineResults
-	if failureCount > 0 {
-		copyResult.OverallSuccess = false
-	} else {
-		copyResult.OverallSuccess = true
-	}
-	return &copyResult
+		err = executor.UpdateResolvFile(dns)
+		if err != nil {
+			return MachineResult{Machine: host.Address, Success: false, Error: fmt.Sprintf("Failed to add dns to %s: %s", host.Address, err.Error())}
+		}
+		return MachineResult{Machine: host.Address, Success: true, Error: ""}
+	})
 }
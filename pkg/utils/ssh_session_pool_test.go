@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"errors"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"golang.org/x/crypto/ssh"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecuteCommandParallelReportsEachHost(t *testing.T) {
+	healthy, healthyHost := newTestServer(t)
+	broken, brokenHost := newTestServer(t)
+	healthy.Handle(`^uptime$`, "up 3 days\n", 0)
+	broken.Handle(`^uptime$`, "", 1)
+	pool := NewSSHExecutorPool()
+	defer pool.Close()
+
+	result := pool.ExecuteCommandParallel("uptime", []entity.Host{healthyHost, brokenHost}, entity.ParallelStrategy{})
+	if result.OverallSuccess || len(result.Results) != 2 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	succeeded := 0
+	for _, machine := range result.Results {
+		if machine.Success {
+			succeeded++
+			if machine.Result == nil || !strings.Contains(machine.Result.Stdout, "up 3 days") {
+				t.Fatalf("unexpected output %+v", machine.Result)
+			}
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("expected exactly one host to succeed, got %d", succeeded)
+	}
+}
+
+func TestPoolRedialsDroppedConnection(t *testing.T) {
+	server, host := newTestServer(t)
+	pool := NewSSHExecutorPool()
+	defer pool.Close()
+
+	if output, err := pool.ExecuteShortCommand("whoami", host); err != nil || strings.TrimSpace(output) != "root" {
+		t.Fatalf("unexpected output %q, %v", output, err)
+	}
+	server.DropConnections()
+	if output, err := pool.ExecuteShortCommand("whoami", host); err != nil || strings.TrimSpace(output) != "root" {
+		t.Fatalf("expected the pool to redial, got %q, %v", output, err)
+	}
+}
+
+func TestPoolRedialsIdleConnectionOfHeldExecutor(t *testing.T) {
+	server, host := newTestServer(t)
+	pool := NewSSHExecutorPool()
+	defer pool.Close()
+	pool.idleTimeout = 20 * time.Millisecond
+
+	executor, err := pool.GetSSHExecutor(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := executor.ExecuteShortCommand("whoami"); err != nil {
+		t.Fatal(err)
+	}
+	// the caller keeps the executor through a long local step
+	time.Sleep(50 * time.Millisecond)
+	pool.checkConnections()
+	if stats := pool.Stats(); stats.Connections != 0 {
+		t.Fatalf("expected the idle connection to be closed, got %+v", stats)
+	}
+	if output, err := executor.ExecuteShortCommand("whoami"); err != nil || strings.TrimSpace(output) != "root" {
+		t.Fatalf("expected the held executor to redial, got %q, %v", output, err)
+	}
+	if server.Accepted() != 2 {
+		t.Fatalf("expected one redial, got %d connections", server.Accepted())
+	}
+	if stats := pool.Stats(); stats.Connections != 1 || stats.Hosts[0].Redials != 1 {
+		t.Fatalf("expected the redialed connection to be pooled again, got %+v", stats)
+	}
+	if _, err := pool.GetSSHExecutor(host); err != nil || server.Accepted() != 2 {
+		t.Fatalf("expected the pool to hand out the redialed connection, got %d connections, %v", server.Accepted(), err)
+	}
+}
+
+func TestPoolKeysConnectionsByCredentials(t *testing.T) {
+	_, host := newTestServer(t)
+	pool := NewSSHExecutorPool()
+	defer pool.Close()
+
+	if _, err := pool.ExecuteShortCommand("whoami", host); err != nil {
+		t.Fatal(err)
+	}
+	wrong := host
+	wrong.Password = "wrong"
+	if _, err := pool.GetSSHExecutor(wrong); err == nil {
+		t.Fatal("expected wrong credentials to be rejected instead of sharing the pooled connection")
+	}
+	if _, err := pool.GetSSHExecutor(host); err != nil {
+		t.Fatal(err)
+	}
+	if stats := pool.Stats(); stats.Connections != 1 {
+		t.Fatalf("expected one pooled connection, got %+v", stats)
+	}
+}
+
+func TestPoolReusesJumpClient(t *testing.T) {
+	bastion, bastionHost := newTestServer(t)
+	target, targetHost := newTestServer(t)
+	other, otherHost := newTestServer(t)
+	targetHost.ProxyJump = []entity.Host{bastionHost}
+	otherHost.ProxyJump = []entity.Host{bastionHost}
+	pool := NewSSHExecutorPool()
+	defer pool.Close()
+
+	for _, host := range []entity.Host{targetHost, otherHost} {
+		if output, err := pool.ExecuteShortCommand("whoami", host); err != nil || strings.TrimSpace(output) != "root" {
+			t.Fatalf("unexpected output through the jump host %q, %v", output, err)
+		}
+	}
+	if bastion.Accepted() != 1 || target.Accepted() != 1 || other.Accepted() != 1 {
+		t.Fatalf("expected one connection to each server, got bastion %d, target %d, other %d", bastion.Accepted(), target.Accepted(), other.Accepted())
+	}
+	if len(bastion.Commands()) != 0 {
+		t.Fatalf("expected the bastion only to forward, got commands %q", bastion.Commands())
+	}
+	if stats := pool.Stats(); stats.Connections != 2 || stats.Bastions != 1 {
+		t.Fatalf("expected two pooled connections behind one jump client, got %+v", stats)
+	}
+
+	target.DropConnections()
+	if output, err := pool.ExecuteShortCommand("whoami", targetHost); err != nil || strings.TrimSpace(output) != "root" {
+		t.Fatalf("expected the pool to redial through the jump host, got %q, %v", output, err)
+	}
+	if bastion.Accepted() != 1 || target.Accepted() != 2 {
+		t.Fatalf("expected the redial to reuse the jump client, got bastion %d, target %d", bastion.Accepted(), target.Accepted())
+	}
+}
+
+func TestReconnectDialsWithoutLockAndDropsClientOfClosedConnection(t *testing.T) {
+	_, host := newTestServer(t)
+	pool := NewSSHExecutorPool()
+	defer pool.Close()
+	executor, err := pool.GetSSHExecutor(host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := &executor.Connection
+	dialing, release := make(chan struct{}), make(chan struct{})
+	var redialed *ssh.Client
+	conn.state.redial = func() (*ssh.Client, error) {
+		close(dialing)
+		<-release
+		redialed, err = dialSSH(nil, host)
+		return redialed, err
+	}
+	result := make(chan error, 1)
+	go func() {
+		_, err := conn.reconnect(conn.currentClient())
+		result <- err
+	}()
+	<-dialing
+	// the state lock is free while the redial is in flight
+	conn.currentClient()
+	conn.Close()
+	close(release)
+	if err := <-result; !errors.Is(err, errConnectionClosed) {
+		t.Fatalf("expected errConnectionClosed, got %v", err)
+	}
+	if _, err := redialed.NewSession(); err == nil {
+		t.Fatal("expected the client dialed for a closed connection to be closed")
+	}
+}
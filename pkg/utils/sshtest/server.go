@@ -0,0 +1,608 @@
+// Package sshtest runs an in-process SSH server with scripted commands and an in-memory SFTP filesystem,
+// so code that talks to hosts over SSH can be unit tested without one.
+package sshtest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/sftp"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// SFTP open flags, the sftp package does not export them.
+const (
+	sftpRead  = 0x01
+	sftpWrite = 0x02
+	sftpCreat = 0x08
+	sftpTrunc = 0x10
+)
+
+// Exec is one command run on the server.
+type Exec struct {
+	Command string
+	// Match holds the submatches of the handler pattern.
+	Match  []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Pty is set when the client requested a terminal.
+	Pty bool
+	// Signaled is closed once the client signals the command, such as with SIGTERM.
+	Signaled <-chan struct{}
+	Server   *Server
+}
+
+// WindowSize is a terminal size a client requested.
+type WindowSize struct {
+	Cols int
+	Rows int
+}
+
+// HandlerFunc runs a command and returns its exit status.
+type HandlerFunc func(exec *Exec) int
+
+type handler struct {
+	pattern *regexp.Regexp
+	fn      HandlerFunc
+}
+
+// Server is a fake SSH server listening on 127.0.0.1.
+type Server struct {
+	User     string
+	Password string
+	Address  string
+	Port     int32
+
+	listener net.Listener
+	config   *ssh.ServerConfig
+	fs       sftp.Handlers
+
+	mutex    sync.Mutex
+	handlers []handler
+	commands []string
+	windows  []WindowSize
+	signals  []string
+	conns    []*ssh.ServerConn
+	accepted int
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server accepting user root with password "secret", it is closed when the test ends.
+// whoami, sha256sum, stat, mkdir -p, cat, cp -p, mv -f, rm -f, chmod and chown are answered from the in-memory filesystem,
+// as is "test ! -e file || command", "sleep N" waits until it is signaled, anything else needs a handler. Files are always owned by root, chmod and chown only check that the file exists.
+func NewServer(t testing.TB) *Server {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("sshtest: generate host key: %s", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("sshtest: host key signer: %s", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("sshtest: listen: %s", err)
+	}
+	server := &Server{
+		User:     "root",
+		Password: "secret",
+		listener: listener,
+		fs:       sftp.InMemHandler(),
+	}
+	server.config = &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == server.User && string(password) == server.Password {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("sshtest: access denied for %s", conn.User())
+		},
+	}
+	server.config.AddHostKey(signer)
+	tcpAddr := listener.Addr().(*net.TCPAddr)
+	server.Address = tcpAddr.IP.String()
+	server.Port = int32(tcpAddr.Port)
+	server.registerBuiltins()
+	server.wg.Add(1)
+	go server.serve()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// Host returns the entity.Host that logs into the server.
+func (server *Server) Host() entity.Host {
+	return entity.Host{
+		Name:     "sshtest-" + strconv.Itoa(int(server.Port)),
+		Address:  server.Address,
+		Port:     server.Port,
+		User:     server.User,
+		Password: server.Password,
+	}
+}
+
+// Handle answers commands matching pattern with fixed output and exit status.
+func (server *Server) Handle(pattern, stdout string, exitStatus int) {
+	server.HandleFunc(pattern, func(exec *Exec) int {
+		io.WriteString(exec.Stdout, stdout)
+		return exitStatus
+	})
+}
+
+// HandleFunc registers fn for commands matching pattern, later handlers take precedence.
+func (server *Server) HandleFunc(pattern string, fn HandlerFunc) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.handlers = append([]handler{{pattern: regexp.MustCompile(pattern), fn: fn}}, server.handlers...)
+}
+
+// WindowSizes returns the terminal sizes of every pty-req and window-change request, in order.
+func (server *Server) WindowSizes() []WindowSize {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]WindowSize(nil), server.windows...)
+}
+
+// Commands returns every command the server was asked to run, in order.
+func (server *Server) Commands() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]string(nil), server.commands...)
+}
+
+// WriteFile stores a file in the in-memory filesystem, creating its parent directories.
+func (server *Server) WriteFile(name string, data []byte) error {
+	if err := server.MkdirAll(path.Dir(name)); err != nil {
+		return err
+	}
+	request := sftp.NewRequest("Put", name)
+	request.Flags = sftpWrite | sftpCreat | sftpTrunc
+	writer, err := server.fs.FilePut.Filewrite(request)
+	if err != nil {
+		return err
+	}
+	_, err = writer.WriteAt(data, 0)
+	return err
+}
+
+// ReadFile returns a file of the in-memory filesystem.
+func (server *Server) ReadFile(name string) ([]byte, error) {
+	info, err := server.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	request := sftp.NewRequest("Get", name)
+	request.Flags = sftpRead
+	reader, err := server.fs.FileGet.Fileread(request)
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, info.Size())
+	if _, err := reader.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Stat returns the file info of name in the in-memory filesystem.
+func (server *Server) Stat(name string) (os.FileInfo, error) {
+	lister, err := server.fs.FileList.Filelist(sftp.NewRequest("Stat", name))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 1)
+	if n, err := lister.ListAt(infos, 0); n == 0 {
+		if err == nil || err == io.EOF {
+			err = os.ErrNotExist
+		}
+		return nil, err
+	}
+	return infos[0], nil
+}
+
+// ReadDir returns the entries of dir in the in-memory filesystem.
+func (server *Server) ReadDir(dir string) ([]os.FileInfo, error) {
+	lister, err := server.fs.FileList.Filelist(sftp.NewRequest("List", dir))
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	for {
+		batch := make([]os.FileInfo, 64)
+		n, err := lister.ListAt(batch, int64(len(infos)))
+		infos = append(infos, batch[:n]...)
+		if err == io.EOF || (err == nil && n < len(batch)) {
+			return infos, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Remove deletes a file or an empty directory of the in-memory filesystem.
+func (server *Server) Remove(name string) error {
+	info, err := server.Stat(name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return server.fs.FileCmd.Filecmd(sftp.NewRequest("Rmdir", name))
+	}
+	return server.fs.FileCmd.Filecmd(sftp.NewRequest("Remove", name))
+}
+
+// MkdirAll creates dir and its parents in the in-memory filesystem.
+func (server *Server) MkdirAll(dir string) error {
+	dir = path.Clean(dir)
+	if dir == "/" {
+		return nil
+	}
+	if info, err := server.Stat(dir); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("sshtest: %s is not a directory", dir)
+		}
+		return nil
+	}
+	if err := server.MkdirAll(path.Dir(dir)); err != nil {
+		return err
+	}
+	return server.fs.FileCmd.Filecmd(sftp.NewRequest("Mkdir", dir))
+}
+
+// Close stops the server and drops every connection.
+func (server *Server) Close() {
+	server.listener.Close()
+	server.mutex.Lock()
+	for _, conn := range server.conns {
+		conn.Close()
+	}
+	server.mutex.Unlock()
+	server.wg.Wait()
+}
+
+// Signals returns the names of the signals clients sent, such as "TERM", in order.
+func (server *Server) Signals() []string {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return append([]string(nil), server.signals...)
+}
+
+// Accepted counts the connections that logged in since the server started.
+func (server *Server) Accepted() int {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	return server.accepted
+}
+
+// DropConnections closes the live connections but keeps accepting new ones, to test redialing.
+func (server *Server) DropConnections() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, conn := range server.conns {
+		conn.Close()
+	}
+	server.conns = nil
+}
+
+// fileArgument matches a file name that may be single quoted.
+const fileArgument = `(?:'([^']*)'|(\S+))`
+
+func unquote(match []string) string {
+	for _, value := range match[1:] {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (server *Server) registerBuiltins() {
+	server.HandleFunc(`^whoami$`, func(exec *Exec) int {
+		fmt.Fprintln(exec.Stdout, server.User)
+		return 0
+	})
+	server.HandleFunc(`^sleep (\d+)$`, func(exec *Exec) int {
+		seconds, _ := strconv.Atoi(exec.Match[1])
+		select {
+		case <-time.After(time.Duration(seconds) * time.Second):
+			return 0
+		case <-exec.Signaled:
+			return 143
+		}
+	})
+	server.HandleFunc(`^sha256sum `+fileArgument+`$`, func(exec *Exec) int {
+		file := unquote(exec.Match)
+		data, err := server.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(exec.Stderr, "sha256sum: %s: No such file or directory\n", file)
+			return 1
+		}
+		sum := sha256.Sum256(data)
+		fmt.Fprintf(exec.Stdout, "%s  %s\n", hex.EncodeToString(sum[:]), file)
+		return 0
+	})
+	server.HandleFunc(`^mkdir -p `+fileArgument+`$`, func(exec *Exec) int {
+		if err := server.MkdirAll(unquote(exec.Match)); err != nil {
+			fmt.Fprintln(exec.Stderr, err)
+			return 1
+		}
+		return 0
+	})
+	server.HandleFunc(`^stat -c '%a %U %G' `+fileArgument+`$`, func(exec *Exec) int {
+		file := unquote(exec.Match)
+		info, err := server.Stat(file)
+		if err != nil {
+			fmt.Fprintf(exec.Stderr, "stat: cannot statx '%s': No such file or directory\n", file)
+			return 1
+		}
+		fmt.Fprintf(exec.Stdout, "%o root root\n", info.Mode().Perm())
+		return 0
+	})
+	server.HandleFunc(`^(?:cp -p|mv -f) `+fileArgument+` `+fileArgument+`$`, func(exec *Exec) int {
+		src, dest := unquote(exec.Match[:3]), unquote(exec.Match[2:])
+		data, err := server.ReadFile(src)
+		if err == nil {
+			err = server.WriteFile(dest, data)
+		}
+		if err == nil && strings.HasPrefix(exec.Command, "mv") {
+			err = server.fs.FileCmd.Filecmd(sftp.NewRequest("Remove", src))
+		}
+		if err != nil {
+			fmt.Fprintln(exec.Stderr, err)
+			return 1
+		}
+		return 0
+	})
+	server.HandleFunc(`^rm -f `+fileArgument+`$`, func(exec *Exec) int {
+		server.fs.FileCmd.Filecmd(sftp.NewRequest("Remove", unquote(exec.Match)))
+		return 0
+	})
+	server.HandleFunc(`^(?:chmod [0-7]+|chown \S+) `+fileArgument+`$`, func(exec *Exec) int {
+		file := unquote(exec.Match)
+		if _, err := server.Stat(file); err != nil {
+			fmt.Fprintf(exec.Stderr, "cannot access '%s': No such file or directory\n", file)
+			return 1
+		}
+		return 0
+	})
+	server.HandleFunc(`^test ! -e `+fileArgument+` \|\| (.+)$`, func(exec *Exec) int {
+		if _, err := server.Stat(unquote(exec.Match[:3])); err != nil {
+			return 0
+		}
+		return server.dispatch(exec, exec.Match[3])
+	})
+	server.HandleFunc(`^cat `+fileArgument+`$`, func(exec *Exec) int {
+		file := unquote(exec.Match)
+		data, err := server.ReadFile(file)
+		if err != nil {
+			fmt.Fprintf(exec.Stderr, "cat: %s: No such file or directory\n", file)
+			return 1
+		}
+		exec.Stdout.Write(data)
+		return 0
+	})
+}
+
+func (server *Server) serve() {
+	defer server.wg.Done()
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+		server.wg.Add(1)
+		go func() {
+			defer server.wg.Done()
+			server.handleConn(conn)
+		}()
+	}
+}
+
+func (server *Server) handleConn(conn net.Conn) {
+	serverConn, chans, reqs, err := ssh.NewServerConn(conn, server.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	server.mutex.Lock()
+	server.conns = append(server.conns, serverConn)
+	server.accepted++
+	server.mutex.Unlock()
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() == "direct-tcpip" {
+			go server.handleDirectTCPIP(newChannel)
+			continue
+		}
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions and local forwards are supported")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go server.handleSession(channel, requests)
+	}
+}
+
+func (server *Server) handleSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	pty := false
+	signaled := make(chan struct{})
+	var signalOnce sync.Once
+	for request := range requests {
+		switch request.Type {
+		case "pty-req":
+			pty = true
+			var ptyReq struct {
+				Term   string
+				Cols   uint32
+				Rows   uint32
+				Width  uint32
+				Height uint32
+				Modes  string
+			}
+			if ssh.Unmarshal(request.Payload, &ptyReq) == nil {
+				server.recordWindow(ptyReq.Cols, ptyReq.Rows)
+			}
+			request.Reply(true, nil)
+		case "window-change":
+			var window struct {
+				Cols   uint32
+				Rows   uint32
+				Width  uint32
+				Height uint32
+			}
+			if ssh.Unmarshal(request.Payload, &window) == nil {
+				server.recordWindow(window.Cols, window.Rows)
+			}
+			request.Reply(true, nil)
+		case "env":
+			request.Reply(true, nil)
+		case "signal":
+			server.mutex.Lock()
+			server.signals = append(server.signals, parsePayload(request.Payload))
+			server.mutex.Unlock()
+			signalOnce.Do(func() { close(signaled) })
+			request.Reply(true, nil)
+		case "subsystem":
+			if parsePayload(request.Payload) != "sftp" {
+				request.Reply(false, nil)
+				continue
+			}
+			request.Reply(true, nil)
+			sftpServer := sftp.NewRequestServer(channel, server.fs)
+			sftpServer.Serve()
+			sftpServer.Close()
+			return
+		case "exec":
+			request.Reply(true, nil)
+			command := parsePayload(request.Payload)
+			// keep serving requests such as signals while the command runs.
+			go func() {
+				sendExitStatus(channel, server.run(command, channel, pty, signaled))
+				channel.Close()
+			}()
+		case "shell":
+			request.Reply(true, nil)
+			// the shell echoes its input until the client closes stdin.
+			go func() {
+				io.Copy(channel, channel)
+				sendExitStatus(channel, 0)
+				channel.Close()
+			}()
+		default:
+			if request.WantReply {
+				request.Reply(false, nil)
+			}
+		}
+	}
+}
+
+func (server *Server) recordWindow(cols, rows uint32) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	server.windows = append(server.windows, WindowSize{Cols: int(cols), Rows: int(rows)})
+}
+
+// handleDirectTCPIP serves a local forward by dialing the requested address from the test process.
+func (server *Server) handleDirectTCPIP(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, "invalid forward request")
+		return
+	}
+	remote, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer remote.Close()
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, channel)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(channel, remote)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func (server *Server) run(command string, channel ssh.Channel, pty bool, signaled <-chan struct{}) int {
+	server.mutex.Lock()
+	server.commands = append(server.commands, command)
+	handlers := server.handlers
+	server.mutex.Unlock()
+	exec := &Exec{Command: command, Stdin: channel, Stdout: channel, Stderr: channel.Stderr(), Pty: pty, Signaled: signaled, Server: server}
+	if pty {
+		exec.Stderr = channel
+	}
+	return server.runHandlers(handlers, exec)
+}
+
+// dispatch runs command with the streams of exec, for builtins that wrap another command.
+func (server *Server) dispatch(exec *Exec, command string) int {
+	server.mutex.Lock()
+	handlers := server.handlers
+	server.mutex.Unlock()
+	inner := *exec
+	inner.Command = command
+	return server.runHandlers(handlers, &inner)
+}
+
+func (server *Server) runHandlers(handlers []handler, exec *Exec) int {
+	for _, h := range handlers {
+		if match := h.pattern.FindStringSubmatch(exec.Command); match != nil {
+			exec.Match = match
+			return h.fn(exec)
+		}
+	}
+	fmt.Fprintf(exec.Stderr, "sshtest: unexpected command: %s\n", exec.Command)
+	return 127
+}
+
+func parsePayload(payload []byte) string {
+	if len(payload) < 4 {
+		return ""
+	}
+	length := binary.BigEndian.Uint32(payload)
+	if int(length) > len(payload)-4 {
+		return ""
+	}
+	return string(payload[4 : 4+length])
+}
+
+func sendExitStatus(channel ssh.Channel, status int) {
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(status))
+	channel.SendRequest("exit-status", false, payload)
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"github.com/spf13/viper"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/utils/sshtest"
+	"testing"
+)
+
+// newTestServer starts a fake SSH server and configures the executors for it, no files are written outside t.TempDir.
+func newTestServer(t *testing.T) (*sshtest.Server, entity.Host) {
+	t.Helper()
+	viper.Set("ssh.host_key_mode", HostKeyModeInsecure)
+	viper.Set("audit.record_sessions", false)
+	server := sshtest.NewServer(t)
+	return server, server.Host()
+}
+
+func newTestExecutor(t *testing.T) (*sshtest.Server, *SSHExecutor) {
+	t.Helper()
+	server, host := newTestServer(t)
+	executor := NewExecutor(host)
+	if executor == nil {
+		t.Fatalf("failed to connect to %s:%d", host.Address, host.Port)
+	}
+	t.Cleanup(func() { executor.Connection.Close() })
+	return server, executor
+}
+
+func newTestOSClient(t *testing.T) (*sshtest.Server, *OSClient) {
+	t.Helper()
+	server, executor := newTestExecutor(t)
+	server.Handle(`@@facts:hostname`, testFactsOutput, 0)
+	t.Cleanup(func() { InvalidateFacts(executor.Host) })
+	return server, NewOSClient(OSConf{}, *executor, *NewLocalExecutor())
+}
@@ -0,0 +1,313 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	SyncActionCreate    = "create"
+	SyncActionUpdate    = "update"
+	SyncActionDelete    = "delete"
+	SyncActionUnchanged = "unchanged"
+)
+
+// syncBatchSize bounds how many paths are passed to a single remote command.
+const syncBatchSize = 200
+
+// SyncOptions controls SyncDirectory, Transfer applies to every uploaded file.
+type SyncOptions struct {
+	Delete   bool
+	Transfer TransferOptions
+}
+
+type FileSyncAction struct {
+	Path   string
+	Action string
+	Size   int64
+	Error  string `json:",omitempty"`
+}
+
+type SyncResult struct {
+	Created   int
+	Updated   int
+	Deleted   int
+	Unchanged int
+	Actions   []FileSyncAction
+}
+
+type localSyncFile struct {
+	path string
+	size int64
+}
+
+type remoteSyncFile struct {
+	size     int64
+	checksum string
+}
+
+// SyncDirectory makes destDir on the remote host match srcDir, only files that differ in size or checksum are uploaded.
+// With opts.Delete remote files missing from srcDir are removed.
+func (executor *SSHExecutor) SyncDirectory(srcDir, destDir string, opts SyncOptions) (*SyncResult, error) {
+	if srcDir == "" || destDir == "" {
+		return nil, errors.New("source and destination directories are required")
+	}
+	if opts.Delete && path.Clean(destDir) == "/" {
+		return nil, errors.New("refusing to delete extraneous files below /")
+	}
+	local, err := listLocalFiles(srcDir)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to list %s: %s", srcDir, err.Error())
+		return nil, err
+	}
+	destDir = path.Clean(destDir)
+	remote, err := executor.listRemoteFiles(destDir)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to list %s on %s: %s", destDir, executor.Host.Address, err.Error())
+		return nil, err
+	}
+
+	var sameSize []string
+	for rel, file := range local {
+		if remoteFile, ok := remote[rel]; ok && remoteFile.size == file.size {
+			sameSize = append(sameSize, rel)
+		}
+	}
+	if err := executor.remoteChecksums(destDir, sameSize, remote); err != nil {
+		logger.GetLogger().Errorf("Failed to checksum %s on %s: %s", destDir, executor.Host.Address, err.Error())
+		return nil, err
+	}
+
+	result := &SyncResult{}
+	var failed []string
+	for _, rel := range sortedKeys(local) {
+		file := local[rel]
+		action := FileSyncAction{Path: rel, Size: file.size}
+		remoteFile, exists := remote[rel]
+		switch {
+		case !exists:
+			action.Action = SyncActionCreate
+		case remoteFile.size != file.size:
+			action.Action = SyncActionUpdate
+		default:
+			checksum, err := localSHA256(file.path)
+			if err != nil {
+				return nil, err
+			}
+			if checksum == remoteFile.checksum {
+				action.Action = SyncActionUnchanged
+				result.Unchanged++
+				result.Actions = append(result.Actions, action)
+				continue
+			}
+			action.Action = SyncActionUpdate
+		}
+		if err := executor.UploadFile(file.path, path.Join(destDir, rel), opts.Transfer); err != nil {
+			action.Error = err.Error()
+			failed = append(failed, rel)
+		} else if action.Action == SyncActionCreate {
+			result.Created++
+		} else {
+			result.Updated++
+		}
+		result.Actions = append(result.Actions, action)
+	}
+
+	if opts.Delete {
+		var extraneous []string
+		for rel := range remote {
+			if _, ok := local[rel]; !ok {
+				extraneous = append(extraneous, rel)
+			}
+		}
+		sort.Strings(extraneous)
+		var deleted []string
+		for _, batch := range chunkStrings(extraneous, syncBatchSize) {
+			action := FileSyncAction{Action: SyncActionDelete}
+			err := executor.ExecuteCommandWithoutReturn(executor.Become(fmt.Sprintf("cd %s && rm -f -- %s", ShellQuote(destDir), quoteAll(batch))))
+			for _, rel := range batch {
+				action.Path = rel
+				action.Size = remote[rel].size
+				if err != nil {
+					action.Error = err.Error()
+					failed = append(failed, rel)
+				} else {
+					result.Deleted++
+					deleted = append(deleted, rel)
+				}
+				result.Actions = append(result.Actions, action)
+			}
+		}
+		executor.removeEmptyDirs(destDir, deleted)
+	}
+
+	if len(failed) > 0 {
+		return result, fmt.Errorf("failed to sync %d files to %s: %s", len(failed), executor.Host.Address, strings.Join(failed, ", "))
+	}
+	return result, nil
+}
+
+// listLocalFiles returns the regular files below dir keyed by their slash separated relative path.
+func listLocalFiles(dir string) (map[string]localSyncFile, error) {
+	files := make(map[string]localSyncFile)
+	err := filepath.WalkDir(dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			if !entry.IsDir() {
+				logger.GetLogger().Warnf("Skipping %s, only regular files are synced", file)
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = localSyncFile{path: file, size: info.Size()}
+		return nil
+	})
+	return files, err
+}
+
+// removeEmptyDirs removes the directories below dir that deleting files left empty, a failure only leaves them behind.
+func (executor *SSHExecutor) removeEmptyDirs(dir string, files []string) {
+	ancestors := make(map[string]struct{})
+	var leaves []string
+	for _, parent := range parentDirs(files) {
+		if _, ok := ancestors[parent]; ok {
+			continue
+		}
+		leaves = append(leaves, parent)
+		for ancestor := path.Dir(parent); ancestor != "."; ancestor = path.Dir(ancestor) {
+			ancestors[ancestor] = struct{}{}
+		}
+	}
+	// rmdir -p walks up from every leaf and stops at the first directory that is not empty
+	for _, batch := range chunkStrings(leaves, syncBatchSize) {
+		command := fmt.Sprintf("cd %s && rmdir -p --ignore-fail-on-non-empty -- %s", ShellQuote(dir), quoteAll(batch))
+		if err := executor.ExecuteCommandWithoutReturn(executor.Become(command)); err != nil {
+			logger.GetLogger().Warnf("Failed to remove empty directories below %s on %s: %s", dir, executor.Host.Address, err.Error())
+		}
+	}
+}
+
+// parentDirs returns the distinct parent directories of files, a directory comes before its parents.
+func parentDirs(files []string) []string {
+	seen := make(map[string]struct{})
+	var dirs []string
+	for _, file := range files {
+		parent := path.Dir(file)
+		if parent == "." {
+			continue
+		}
+		if _, ok := seen[parent]; !ok {
+			seen[parent] = struct{}{}
+			dirs = append(dirs, parent)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	return dirs
+}
+
+// listRemoteFiles returns the regular files below dir with their sizes, a missing dir yields no files.
+// Entries are NUL terminated so that any file name survives the listing.
+func (executor *SSHExecutor) listRemoteFiles(dir string) (map[string]*remoteSyncFile, error) {
+	command := fmt.Sprintf("[ -d %[1]s ] || exit 0; cd %[1]s && find . -type f -printf '%%s\\t%%P\\0'", ShellQuote(dir))
+	output, err := executor.ExecuteShortCommand(executor.Become(command))
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*remoteSyncFile)
+	for _, line := range strings.Split(output, "\x00") {
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		files[fields[1]] = &remoteSyncFile{size: size}
+	}
+	return files, nil
+}
+
+// remoteChecksums fills in the SHA-256 of the given files, relative to dir.
+// sha256sum -z ends every line with NUL instead of escaping names that hold a backslash or a newline.
+func (executor *SSHExecutor) remoteChecksums(dir string, files []string, remote map[string]*remoteSyncFile) error {
+	for _, batch := range chunkStrings(files, syncBatchSize) {
+		command := fmt.Sprintf("cd %s && sha256sum -z -- %s", ShellQuote(dir), quoteAll(batch))
+		output, err := executor.ExecuteShortCommand(executor.Become(command))
+		if err != nil {
+			return err
+		}
+		for _, line := range strings.Split(output, "\x00") {
+			fields := strings.SplitN(line, "  ", 2)
+			if len(fields) != 2 {
+				continue
+			}
+			if file, ok := remote[fields[1]]; ok {
+				file.checksum = fields[0]
+			}
+		}
+	}
+	return nil
+}
+
+func localSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func sortedKeys(files map[string]localSyncFile) []string {
+	keys := make([]string, 0, len(files))
+	for key := range files {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func chunkStrings(items []string, size int) [][]string {
+	var chunks [][]string
+	for len(items) > size {
+		chunks = append(chunks, items[:size])
+		items = items[size:]
+	}
+	if len(items) > 0 {
+		chunks = append(chunks, items)
+	}
+	return chunks
+}
+
+func quoteAll(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = ShellQuote(item)
+	}
+	return strings.Join(quoted, " ")
+}
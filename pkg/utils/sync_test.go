@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/whoisfisher/mykubespray/pkg/utils/sshtest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// splitShellWords splits the arguments quoted by ShellQuote.
+func splitShellWords(line string) []string {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; c {
+		case ' ':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case '\'', '"':
+			end := strings.IndexByte(line[i+1:], c)
+			word.WriteString(line[i+1 : i+1+end])
+			i += end + 1
+			inWord = true
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
+
+// handleSyncCommands answers the listing, checksum and delete commands of SyncDirectory from the in-memory filesystem.
+func handleSyncCommands(server *sshtest.Server) {
+	var walk func(root, dir string, exec *sshtest.Exec)
+	walk = func(root, dir string, exec *sshtest.Exec) {
+		infos, _ := server.ReadDir(dir)
+		for _, info := range infos {
+			name := path.Join(dir, info.Name())
+			if info.IsDir() {
+				walk(root, name, exec)
+				continue
+			}
+			fmt.Fprintf(exec.Stdout, "%d\t%s\x00", info.Size(), strings.TrimPrefix(name, root+"/"))
+		}
+	}
+	server.HandleFunc(`^\[ -d '([^']*)' \] \|\| exit 0; cd '[^']*' && find \. -type f -printf '%s\\t%P\\0'$`, func(exec *sshtest.Exec) int {
+		if _, err := server.Stat(exec.Match[1]); err == nil {
+			walk(exec.Match[1], exec.Match[1], exec)
+		}
+		return 0
+	})
+	server.HandleFunc(`^cd '([^']*)' && sha256sum -z -- (.*)$`, func(exec *sshtest.Exec) int {
+		for _, file := range splitShellWords(exec.Match[2]) {
+			data, err := server.ReadFile(path.Join(exec.Match[1], file))
+			if err != nil {
+				return 1
+			}
+			sum := sha256.Sum256(data)
+			fmt.Fprintf(exec.Stdout, "%s  %s\x00", hex.EncodeToString(sum[:]), file)
+		}
+		return 0
+	})
+	server.HandleFunc(`^cd '([^']*)' && rm -f -- (.*)$`, func(exec *sshtest.Exec) int {
+		for _, file := range splitShellWords(exec.Match[2]) {
+			server.Remove(path.Join(exec.Match[1], file))
+		}
+		return 0
+	})
+	server.HandleFunc(`^cd '([^']*)' && rmdir -p --ignore-fail-on-non-empty -- (.*)$`, func(exec *sshtest.Exec) int {
+		for _, dir := range splitShellWords(exec.Match[2]) {
+			for ; dir != "."; dir = path.Dir(dir) {
+				if err := server.Remove(path.Join(exec.Match[1], dir)); err != nil {
+					break
+				}
+			}
+		}
+		return 0
+	})
+}
+
+func writeLocalFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSyncDirectory(t *testing.T) {
+	server, executor := newTestExecutor(t)
+	handleSyncCommands(server)
+	src := t.TempDir()
+	writeLocalFiles(t, src, map[string]string{
+		"app.conf":          "port = 80\n",
+		`back\slash.conf`:   "escaped\n",
+		"conf.d/site.conf":  "server a\n",
+		"conf.d/new/x.conf": "created\n",
+	})
+	remote := map[string]string{
+		"/srv/app/app.conf":          "port = 80\n",
+		`/srv/app/back\slash.conf`:   "escaped\n",
+		"/srv/app/conf.d/site.conf":  "server b\n",
+		"/srv/app/conf.d/stale.conf": "stale\n",
+		"/srv/app/old/gone/x.conf":   "deleted\n",
+	}
+	for name, content := range remote {
+		if err := server.WriteFile(name, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := executor.SyncDirectory(src, "/srv/app", SyncOptions{Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Created != 1 || result.Updated != 1 || result.Unchanged != 2 || result.Deleted != 2 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	actions := map[string]string{}
+	for _, action := range result.Actions {
+		actions[action.Path] = action.Action
+	}
+	expected := map[string]string{
+		"app.conf":          SyncActionUnchanged,
+		`back\slash.conf`:   SyncActionUnchanged,
+		"conf.d/site.conf":  SyncActionUpdate,
+		"conf.d/new/x.conf": SyncActionCreate,
+		"conf.d/stale.conf": SyncActionDelete,
+		"old/gone/x.conf":   SyncActionDelete,
+	}
+	for name, action := range expected {
+		if actions[name] != action {
+			t.Errorf("expected %s to be %s, got %q", name, action, actions[name])
+		}
+	}
+	if data, _ := server.ReadFile("/srv/app/conf.d/site.conf"); string(data) != "server a\n" {
+		t.Fatalf("the changed file was not uploaded: %q", data)
+	}
+	if data, _ := server.ReadFile("/srv/app/conf.d/new/x.conf"); string(data) != "created\n" {
+		t.Fatalf("the new file was not uploaded: %q", data)
+	}
+	if _, err := server.Stat("/srv/app/conf.d/stale.conf"); err == nil {
+		t.Fatal("the extraneous file was not deleted")
+	}
+	if _, err := server.Stat("/srv/app/old"); err == nil {
+		t.Fatal("expected the directories emptied by the delete to be removed")
+	}
+	if _, err := server.Stat("/srv/app/conf.d"); err != nil {
+		t.Fatalf("a directory that still holds files was removed: %v", err)
+	}
+
+	result, err = executor.SyncDirectory(src, "/srv/app", SyncOptions{Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Unchanged != 4 || result.Created+result.Updated+result.Deleted != 0 {
+		t.Fatalf("expected a second sync to change nothing, got %+v", result)
+	}
+}
@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"sync"
+)
+
+const (
+	defaultTerminalCols = 120
+	defaultTerminalRows = 40
+)
+
+// Terminal is an interactive login shell on a PTY, output of the shell is read from Read.
+type Terminal struct {
+	executor  *SSHExecutor
+	session   *ssh.Session
+	release   func()
+	stdin     io.WriteCloser
+	output    *io.PipeReader
+	audit     *commandAudit
+	done      chan struct{}
+	err       error
+	closeOnce sync.Once
+}
+
+// OpenTerminal dials host on its own connection so a long lived shell never holds a pooled session slot.
+func OpenTerminal(host entity.Host, cols, rows int) (*Terminal, error) {
+	connection, err := NewConnection(host)
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to connect to %s: %s", host.Address, err.Error())
+		return nil, err
+	}
+	executor := &SSHExecutor{Connection: *connection, Host: host}
+	terminal, err := executor.OpenTerminal(cols, rows)
+	if err != nil {
+		executor.Connection.Close()
+		return nil, err
+	}
+	return terminal, nil
+}
+
+// OpenTerminal starts a login shell on a new session of the executor's connection.
+func (executor *SSHExecutor) OpenTerminal(cols, rows int) (*Terminal, error) {
+	if cols <= 0 || rows <= 0 {
+		cols, rows = defaultTerminalCols, defaultTerminalRows
+	}
+	session, release, err := executor.newSession(context.Background())
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to create SSH session: %s", err.Error())
+		return nil, err
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty("xterm-256color", rows, cols, modes); err != nil {
+		session.Close()
+		release()
+		logger.GetLogger().Errorf("Failed to request PTY on %s: %s", executor.Host.Address, err.Error())
+		return nil, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		release()
+		return nil, err
+	}
+	audit := executor.startAudit(AuditKindTerminal, "interactive shell", cols, rows)
+	reader, writer := io.Pipe()
+	session.Stdout = audit.wrap(writer)
+	session.Stderr = session.Stdout
+	if err := session.Shell(); err != nil {
+		session.Close()
+		release()
+		audit.finish(err)
+		logger.GetLogger().Errorf("Failed to start shell on %s: %s", executor.Host.Address, err.Error())
+		return nil, err
+	}
+	terminal := &Terminal{executor: executor, session: session, release: release, stdin: stdin, output: reader, audit: audit, done: make(chan struct{})}
+	go func() {
+		terminal.err = session.Wait()
+		audit.finish(terminal.err)
+		writer.Close()
+		close(terminal.done)
+	}()
+	return terminal, nil
+}
+
+// Read returns shell output, it returns io.EOF once the shell has exited.
+func (terminal *Terminal) Read(p []byte) (int, error) {
+	return terminal.output.Read(p)
+}
+
+// Write sends keystrokes to the shell, they are recorded as input events of the cast.
+func (terminal *Terminal) Write(p []byte) (int, error) {
+	n, err := terminal.stdin.Write(p)
+	if n > 0 {
+		terminal.audit.input(p[:n])
+	}
+	return n, err
+}
+
+// Resize changes the PTY window size.
+func (terminal *Terminal) Resize(cols, rows int) error {
+	if cols <= 0 || rows <= 0 {
+		return errors.New("invalid terminal size")
+	}
+	terminal.audit.resize(cols, rows)
+	return terminal.session.WindowChange(rows, cols)
+}
+
+// Done is closed once the shell has exited.
+func (terminal *Terminal) Done() <-chan struct{} {
+	return terminal.done
+}
+
+// Err returns why the shell exited, it is only valid after Done is closed.
+func (terminal *Terminal) Err() error {
+	var exitMissing *ssh.ExitMissingError
+	if errors.As(terminal.err, &exitMissing) {
+		return nil
+	}
+	return terminal.err
+}
+
+// Close ends the shell and the connection it was opened on.
+func (terminal *Terminal) Close() error {
+	terminal.closeOnce.Do(func() {
+		terminal.stdin.Close()
+		terminal.session.Close()
+		terminal.output.Close()
+		terminal.release()
+		terminal.executor.Connection.Close()
+	})
+	return nil
+}
@@ -0,0 +1,63 @@
+package utils
+
+import (
+	"github.com/whoisfisher/mykubespray/pkg/utils/sshtest"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTerminalRoundTrip(t *testing.T) {
+	_, executor := newTestExecutor(t)
+	terminal, err := executor.OpenTerminal(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer terminal.Close()
+	for _, input := range []string{"echo hello\r", "exit\r"} {
+		if _, err := terminal.Write([]byte(input)); err != nil {
+			t.Fatal(err)
+		}
+		output := make([]byte, len(input))
+		if _, err := io.ReadFull(terminal, output); err != nil || string(output) != input {
+			t.Fatalf("expected the shell to echo %q, got %q, %v", input, output, err)
+		}
+	}
+	terminal.stdin.Close()
+	select {
+	case <-terminal.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the shell to exit once stdin is closed")
+	}
+	if err := terminal.Err(); err != nil {
+		t.Fatalf("expected a clean exit, got %v", err)
+	}
+	if _, err := terminal.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected EOF after the shell exited, got %v", err)
+	}
+}
+
+func TestTerminalResize(t *testing.T) {
+	server, executor := newTestExecutor(t)
+	terminal, err := executor.OpenTerminal(80, 24)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer terminal.Close()
+	if err := terminal.Resize(0, 10); err == nil {
+		t.Fatal("expected an invalid size to be rejected")
+	}
+	if err := terminal.Resize(132, 43); err != nil {
+		t.Fatal(err)
+	}
+	// window-change is not acknowledged, wait for the server to see it
+	expected := []sshtest.WindowSize{{Cols: 80, Rows: 24}, {Cols: 132, Rows: 43}}
+	deadline := time.Now().Add(5 * time.Second)
+	for !reflect.DeepEqual(server.WindowSizes(), expected) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected window sizes %+v, got %+v", expected, server.WindowSizes())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,314 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"github.com/whoisfisher/mykubespray/pkg/entity"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Tunnel is an SSH local-forward: connections accepted on a loopback port are forwarded to Remote through the pooled connection of Host.
+type Tunnel struct {
+	key      string
+	host     entity.Host
+	remote   string
+	pool     *SSHExecutorPool
+	listener net.Listener
+
+	mutex     sync.Mutex
+	active    int
+	total     int
+	healthy   bool
+	lastError string
+	lastUsed  time.Time
+	closed    bool
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// TunnelStat describes a tunnel held by an SSHExecutorPool.
+type TunnelStat struct {
+	Key         string    `json:"key"`
+	Host        string    `json:"host"`
+	Remote      string    `json:"remote"`
+	Local       string    `json:"local"`
+	ActiveConns int       `json:"active_conns"`
+	TotalConns  int       `json:"total_conns"`
+	Healthy     bool      `json:"healthy"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastUsed    time.Time `json:"last_used"`
+}
+
+// tunnelKey includes the credentials of host like poolKey, a tunnel is only shared with callers that could open it.
+func tunnelKey(host entity.Host, remote string) string {
+	return poolKey(host) + "->" + remote
+}
+
+// LocalForward returns the tunnel to remote through host, opening it on first use.
+// Tunnels are shared by every caller asking for the same host, credentials and remote. A tunnel without
+// forwarded connections for ssh.idle_timeout is closed, so callers ask again instead of keeping its address.
+func (pool *SSHExecutorPool) LocalForward(host entity.Host, remote string) (*Tunnel, error) {
+	if _, _, err := net.SplitHostPort(remote); err != nil {
+		return nil, fmt.Errorf("invalid tunnel remote address %q: %w", remote, err)
+	}
+	key := tunnelKey(host, remote)
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	if pool.connections == nil {
+		return nil, errors.New("ssh executor pool is closed")
+	}
+	if tunnel, exists := pool.tunnels[key]; exists {
+		// handing out the tunnel counts as a use, checkTunnels decides under the same lock
+		tunnel.mutex.Lock()
+		tunnel.lastUsed = time.Now()
+		tunnel.mutex.Unlock()
+		return tunnel, nil
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to listen for tunnel %s: %s", key, err.Error())
+		return nil, err
+	}
+	tunnel := &Tunnel{key: key, host: host, remote: remote, pool: pool, listener: listener, healthy: true, lastUsed: time.Now(), conns: make(map[net.Conn]struct{})}
+	if pool.tunnels == nil {
+		pool.tunnels = make(map[string]*Tunnel)
+	}
+	pool.tunnels[key] = tunnel
+	tunnel.wg.Add(1)
+	go tunnel.serve()
+	logger.GetLogger().Infof("Forwarding %s to %s through %s", listener.Addr(), remote, host.Address)
+	return tunnel, nil
+}
+
+// LocalAddr returns the loopback address the tunnel listens on.
+func (tunnel *Tunnel) LocalAddr() string {
+	return tunnel.listener.Addr().String()
+}
+
+// Remote returns the address connections are forwarded to.
+func (tunnel *Tunnel) Remote() string {
+	return tunnel.remote
+}
+
+// Healthy reports whether the last forward or probe reached the remote address.
+func (tunnel *Tunnel) Healthy() bool {
+	tunnel.mutex.Lock()
+	defer tunnel.mutex.Unlock()
+	return tunnel.healthy
+}
+
+// Stat returns the current state of the tunnel.
+func (tunnel *Tunnel) Stat() TunnelStat {
+	tunnel.mutex.Lock()
+	defer tunnel.mutex.Unlock()
+	return TunnelStat{
+		Key:         tunnel.key,
+		Host:        tunnel.host.Address,
+		Remote:      tunnel.remote,
+		Local:       tunnel.listener.Addr().String(),
+		ActiveConns: tunnel.active,
+		TotalConns:  tunnel.total,
+		Healthy:     tunnel.healthy,
+		LastError:   tunnel.lastError,
+		LastUsed:    tunnel.lastUsed,
+	}
+}
+
+// Check dials the remote address through the tunnel host and records the outcome, it does not count as a use.
+func (tunnel *Tunnel) Check() error {
+	conn, release, err := tunnel.dial()
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	release()
+	return nil
+}
+
+// Close stops accepting connections and ends the forwarded ones.
+func (tunnel *Tunnel) Close() error {
+	tunnel.mutex.Lock()
+	if tunnel.closed {
+		tunnel.mutex.Unlock()
+		return nil
+	}
+	tunnel.closed = true
+	for conn := range tunnel.conns {
+		conn.Close()
+	}
+	tunnel.mutex.Unlock()
+	err := tunnel.listener.Close()
+	tunnel.wg.Wait()
+	return err
+}
+
+func (tunnel *Tunnel) serve() {
+	defer tunnel.wg.Done()
+	for {
+		local, err := tunnel.listener.Accept()
+		if err != nil {
+			return
+		}
+		tunnel.mutex.Lock()
+		if tunnel.closed {
+			tunnel.mutex.Unlock()
+			local.Close()
+			return
+		}
+		tunnel.conns[local] = struct{}{}
+		tunnel.mutex.Unlock()
+		tunnel.wg.Add(1)
+		go func() {
+			defer tunnel.wg.Done()
+			tunnel.forward(local)
+		}()
+	}
+}
+
+// dial opens a channel to the remote address, redialing the pooled connection once when it is broken.
+// The returned func must be called once the channel is closed, it lets the pool evict the connection again.
+func (tunnel *Tunnel) dial() (net.Conn, func(), error) {
+	executor, err := tunnel.pool.GetSSHExecutor(tunnel.host)
+	if err != nil {
+		tunnel.record(err)
+		return nil, nil, err
+	}
+	connection := &executor.Connection
+	client := connection.currentClient()
+	remote, err := client.Dial("tcp", tunnel.remote)
+	if err != nil && connection.canRedial() {
+		var rejected *ssh.OpenChannelError
+		// a rejected channel means the transport works and the remote address does not.
+		if !errors.As(err, &rejected) {
+			logger.GetLogger().Warnf("Tunnel %s failed, redialing: %s", tunnel.key, err.Error())
+			if client, err = connection.reconnect(client); err == nil {
+				remote, err = client.Dial("tcp", tunnel.remote)
+			}
+		}
+	}
+	if err != nil {
+		tunnel.record(err)
+		return nil, nil, err
+	}
+	tunnel.record(nil)
+	return remote, connection.hold(), nil
+}
+
+func (tunnel *Tunnel) forward(local net.Conn) {
+	defer func() {
+		local.Close()
+		tunnel.mutex.Lock()
+		delete(tunnel.conns, local)
+		tunnel.mutex.Unlock()
+	}()
+	remote, release, err := tunnel.dial()
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to forward %s to %s through %s: %s", tunnel.LocalAddr(), tunnel.remote, tunnel.host.Address, err.Error())
+		return
+	}
+	defer release()
+	defer remote.Close()
+	tunnel.mutex.Lock()
+	tunnel.active++
+	tunnel.total++
+	tunnel.lastUsed = time.Now()
+	tunnel.mutex.Unlock()
+	defer func() {
+		tunnel.mutex.Lock()
+		tunnel.active--
+		tunnel.lastUsed = time.Now()
+		tunnel.mutex.Unlock()
+	}()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(remote, local)
+		remote.Close()
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(local, remote)
+		local.Close()
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+}
+
+func (tunnel *Tunnel) record(err error) {
+	tunnel.mutex.Lock()
+	defer tunnel.mutex.Unlock()
+	if err != nil {
+		tunnel.healthy = false
+		tunnel.lastError = err.Error()
+		return
+	}
+	tunnel.healthy = true
+	tunnel.lastError = ""
+}
+
+// idle reports whether the tunnel forwarded nothing for timeout.
+func (tunnel *Tunnel) idle(timeout time.Duration) bool {
+	tunnel.mutex.Lock()
+	defer tunnel.mutex.Unlock()
+	return tunnel.active == 0 && time.Since(tunnel.lastUsed) > timeout
+}
+
+// maintainTunnels runs apart from maintain, so slow probes do not hold back the connection keepalives.
+func (pool *SSHExecutorPool) maintainTunnels() {
+	ticker := time.NewTicker(pool.keepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-pool.done:
+			return
+		case <-ticker.C:
+			pool.checkTunnels()
+		}
+	}
+}
+
+// checkTunnels closes the idle tunnels and probes the others in parallel, so a broken one is reported before it is used.
+func (pool *SSHExecutorPool) checkTunnels() {
+	var wg sync.WaitGroup
+	for _, tunnel := range pool.tunnelList() {
+		pool.mutex.Lock()
+		idle := pool.tunnels[tunnel.key] == tunnel && tunnel.idle(pool.idleTimeout)
+		if idle {
+			delete(pool.tunnels, tunnel.key)
+		}
+		pool.mutex.Unlock()
+		if idle {
+			logger.GetLogger().Infof("Closing idle tunnel %s", tunnel.key)
+			tunnel.Close()
+			continue
+		}
+		wg.Add(1)
+		go func(tunnel *Tunnel) {
+			defer wg.Done()
+			if err := tunnel.Check(); err != nil {
+				logger.GetLogger().Warnf("Tunnel %s is unhealthy: %s", tunnel.key, err.Error())
+			}
+		}(tunnel)
+	}
+	wg.Wait()
+}
+
+func (pool *SSHExecutorPool) tunnelList() []*Tunnel {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	tunnels := make([]*Tunnel, 0, len(pool.tunnels))
+	for _, tunnel := range pool.tunnels {
+		tunnels = append(tunnels, tunnel)
+	}
+	sort.Slice(tunnels, func(i, j int) bool {
+		return tunnels[i].key < tunnels[j].key
+	})
+	return tunnels
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestLocalForwardReachesRemoteThroughHost(t *testing.T) {
+	_, host := newTestServer(t)
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer apiServer.Close()
+	pool := NewSSHExecutorPool()
+	defer pool.Close()
+
+	tunnel, err := pool.LocalForward(host, apiServer.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("local forward failed: %v", err)
+	}
+	if again, _ := pool.LocalForward(host, apiServer.Listener.Addr().String()); again != tunnel {
+		t.Fatalf("expected the tunnel to be shared")
+	}
+	resp, err := http.Get("http://" + tunnel.LocalAddr() + "/healthz")
+	if err != nil {
+		t.Fatalf("request through tunnel failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "ok" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	stats := pool.Stats()
+	if len(stats.Tunnels) != 1 || !stats.Tunnels[0].Healthy || stats.Tunnels[0].TotalConns != 1 {
+		t.Fatalf("unexpected tunnel stats %+v", stats.Tunnels)
+	}
+}
+
+func TestTunnelCheckReportsUnreachableRemote(t *testing.T) {
+	_, host := newTestServer(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := listener.Addr().String()
+	listener.Close()
+	pool := NewSSHExecutorPool()
+	defer pool.Close()
+
+	tunnel, err := pool.LocalForward(host, remote)
+	if err != nil {
+		t.Fatalf("local forward failed: %v", err)
+	}
+	if err := tunnel.Check(); err == nil || tunnel.Healthy() {
+		t.Fatalf("expected the tunnel to be unhealthy")
+	}
+	if stat := tunnel.Stat(); stat.LastError == "" {
+		t.Fatalf("expected the error to be reported, got %+v", stat)
+	}
+}
+
+func TestTunnelsAreKeyedByCredentialsAndClosedWhenIdle(t *testing.T) {
+	_, host := newTestServer(t)
+	apiServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer apiServer.Close()
+	pool := NewSSHExecutorPool()
+	defer pool.Close()
+	remote := apiServer.Listener.Addr().String()
+
+	tunnel, err := pool.LocalForward(host, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrong := host
+	wrong.Password = "wrong"
+	other, err := pool.LocalForward(wrong, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == tunnel {
+		t.Fatal("expected other credentials not to share the tunnel")
+	}
+	if err := other.Check(); err == nil {
+		t.Fatal("expected the tunnel with wrong credentials to fail")
+	}
+
+	pool.idleTimeout = 20 * time.Millisecond
+	time.Sleep(30 * time.Millisecond)
+	if err := tunnel.Check(); err != nil {
+		t.Fatal(err)
+	}
+	// probes do not count as a use
+	pool.checkTunnels()
+	if stats := pool.Stats(); len(stats.Tunnels) != 0 {
+		t.Fatalf("expected idle tunnels to be closed, got %+v", stats.Tunnels)
+	}
+	if _, err := net.Dial("tcp", tunnel.LocalAddr()); err == nil {
+		t.Fatal("expected the idle tunnel to stop listening")
+	}
+	if again, err := pool.LocalForward(host, remote); err != nil || again == tunnel {
+		t.Fatalf("expected a new tunnel after the idle one was closed, got %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package vault
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"github.com/whoisfisher/mykubespray/pkg/logger"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultKeyEnv is read when vault.key_env is not configured.
+const DefaultKeyEnv = "MYKUBESPRAY_VAULT_KEYS"
+
+const prefix = "vault:v"
+
+var (
+	ErrNoMasterKey = errors.New("no vault master key configured, set vault.key_file or " + DefaultKeyEnv)
+	ErrUnknownKey  = errors.New("value is encrypted with a key version that is not loaded")
+	ErrNotSealed   = errors.New("value is not encrypted by the vault")
+)
+
+// Keyring holds the versioned AES-256 master keys, new values are sealed with the highest version.
+type Keyring struct {
+	keys    map[int][]byte
+	current int
+}
+
+// ParseKeyring reads keys separated by newlines or commas, each as "<version>:<base64 key>".
+// A single key without a version is version 1, empty lines and lines starting with # are ignored.
+func ParseKeyring(text string) (*Keyring, error) {
+	keyring := &Keyring{keys: make(map[int][]byte)}
+	entries := strings.FieldsFunc(text, func(r rune) bool {
+		return r == '\n' || r == ','
+	})
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		version, encoded := 1, entry
+		if index := strings.Index(entry, ":"); index > 0 {
+			v, err := strconv.Atoi(entry[:index])
+			if err != nil || v < 1 {
+				return nil, fmt.Errorf("invalid vault key version %q", entry[:index])
+			}
+			version, encoded = v, entry[index+1:]
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("vault key %d is not base64: %w", version, err)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("vault key %d must be 32 bytes, got %d", version, len(key))
+		}
+		if _, exists := keyring.keys[version]; exists {
+			return nil, fmt.Errorf("vault key %d is defined twice", version)
+		}
+		keyring.keys[version] = key
+		if version > keyring.current {
+			keyring.current = version
+		}
+	}
+	if len(keyring.keys) == 0 {
+		return nil, ErrNoMasterKey
+	}
+	return keyring, nil
+}
+
+// CurrentVersion returns the key version new values are sealed with.
+func (keyring *Keyring) CurrentVersion() int {
+	return keyring.current
+}
+
+// Seal encrypts plaintext with the current key as "vault:v<version>:<base64 nonce and ciphertext>".
+func (keyring *Keyring) Seal(plaintext []byte) (string, error) {
+	gcm, err := newGCM(keyring.keys[keyring.current])
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	return fmt.Sprintf("%s%d:%s", prefix, keyring.current, base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// Open decrypts a value produced by Seal with any loaded key version.
+func (keyring *Keyring) Open(value string) ([]byte, error) {
+	version, encoded, err := split(value)
+	if err != nil {
+		return nil, err
+	}
+	key, exists := keyring.keys[version]
+	if !exists {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownKey, version)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("corrupt vault value: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("corrupt vault value: too short")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt vault value with key %d: %w", version, err)
+	}
+	return plaintext, nil
+}
+
+// Version returns the key version of a sealed value.
+func Version(value string) (int, bool) {
+	version, _, err := split(value)
+	return version, err == nil
+}
+
+// IsSealed reports whether value was produced by Seal.
+func IsSealed(value string) bool {
+	_, ok := Version(value)
+	return ok
+}
+
+func split(value string) (int, string, error) {
+	if !strings.HasPrefix(value, prefix) {
+		return 0, "", ErrNotSealed
+	}
+	rest := value[len(prefix):]
+	index := strings.Index(rest, ":")
+	if index <= 0 {
+		return 0, "", ErrNotSealed
+	}
+	version, err := strconv.Atoi(rest[:index])
+	if err != nil {
+		return 0, "", ErrNotSealed
+	}
+	return version, rest[index+1:], nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+var (
+	mutex   sync.Mutex
+	keyring *Keyring
+)
+
+// loadKeyring reads the keys from vault.key_file, or from the environment variable named by vault.key_env when no file is set.
+func loadKeyring() (*Keyring, error) {
+	if file := viper.GetString("vault.key_file"); file != "" {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("%w: %s does not exist", ErrNoMasterKey, file)
+			}
+			return nil, err
+		}
+		return ParseKeyring(string(content))
+	}
+	env := viper.GetString("vault.key_env")
+	if env == "" {
+		env = DefaultKeyEnv
+	}
+	return ParseKeyring(os.Getenv(env))
+}
+
+// Default returns the process wide keyring, it is loaded on first use.
+func Default() (*Keyring, error) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if keyring != nil {
+		return keyring, nil
+	}
+	loaded, err := loadKeyring()
+	if err != nil {
+		return nil, err
+	}
+	keyring = loaded
+	return keyring, nil
+}
+
+// Reload re-reads the keys, a newly added version becomes the current one.
+func Reload() (*Keyring, error) {
+	loaded, err := loadKeyring()
+	if err != nil {
+		logger.GetLogger().Errorf("Failed to reload vault keys: %s", err.Error())
+		return nil, err
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	keyring = loaded
+	logger.GetLogger().Infof("Vault keys reloaded, current key version %d", keyring.current)
+	return keyring, nil
+}
+
+// Encrypt seals plaintext with the default keyring.
+func Encrypt(plaintext []byte) (string, error) {
+	keyring, err := Default()
+	if err != nil {
+		return "", err
+	}
+	return keyring.Seal(plaintext)
+}
+
+// Decrypt opens a value sealed with the default keyring.
+func Decrypt(value string) ([]byte, error) {
+	keyring, err := Default()
+	if err != nil {
+		return nil, err
+	}
+	return keyring.Open(value)
+}
+
+// EncryptString seals a secret, an empty secret stays empty.
+func EncryptString(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	return Encrypt([]byte(plaintext))
+}
+
+// DecryptString opens a secret sealed by EncryptString.
+func DecryptString(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	plaintext, err := Decrypt(value)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
@@ -0,0 +1,84 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func newKey(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestKeyringSealOpen(t *testing.T) {
+	keyring, err := ParseKeyring(newKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := keyring.Seal([]byte("s3cret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, ok := Version(sealed); !ok || version != 1 {
+		t.Fatalf("unexpected version of %q", sealed)
+	}
+	plaintext, err := keyring.Open(sealed)
+	if err != nil || !bytes.Equal(plaintext, []byte("s3cret")) {
+		t.Fatalf("open returned %q, %v", plaintext, err)
+	}
+	if _, err := keyring.Open(sealed[:len(sealed)-4] + "AAAA"); err == nil {
+		t.Fatal("expected tampered value to fail")
+	}
+	if _, err := keyring.Open("s3cret"); !errors.Is(err, ErrNotSealed) {
+		t.Fatalf("expected ErrNotSealed, got %v", err)
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	first, second := newKey(t), newKey(t)
+	old, err := ParseKeyring("1:" + first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := old.Seal([]byte("password"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated, err := ParseKeyring(fmt.Sprintf("# keys\n1:%s\n2:%s\n", first, second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.CurrentVersion() != 2 {
+		t.Fatalf("expected current version 2, got %d", rotated.CurrentVersion())
+	}
+	plaintext, err := rotated.Open(sealed)
+	if err != nil || string(plaintext) != "password" {
+		t.Fatalf("old value not readable after rotation: %q, %v", plaintext, err)
+	}
+	resealed, err := rotated.Seal(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version, _ := Version(resealed); version != 2 {
+		t.Fatalf("expected version 2, got %d", version)
+	}
+	if _, err := old.Open(resealed); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("expected ErrUnknownKey, got %v", err)
+	}
+}
+
+func TestParseKeyringErrors(t *testing.T) {
+	for _, text := range []string{"", "# only a comment", "1:short", "x:" + newKey(t), "1:" + newKey(t) + ",1:" + newKey(t)} {
+		if _, err := ParseKeyring(text); err == nil {
+			t.Errorf("expected %q to be rejected", text)
+		}
+	}
+}